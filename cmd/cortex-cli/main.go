@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/hex"
+	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,10 +12,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"cortex/internal/crypt"
 	"cortex/internal/embeddings"
 )
 
@@ -76,7 +78,19 @@ func main() {
 	case "benchmark-embeddings":
 		err = cmdBenchmarkEmbeddings(cmdArgs)
 	case "api-key":
-		err = cmdAPIKey(cmdArgs)
+		err = cmdAPIKey(client, cmdArgs)
+	case "audit":
+		err = cmdAudit(client, cmdArgs)
+	case "encryption-key":
+		err = cmdEncryptionKey(cmdArgs)
+	case "encryption":
+		err = cmdEncryption(client, cmdArgs)
+	case "verify":
+		err = cmdVerify(client, cmdArgs)
+	case "encrypt-file":
+		err = cmdCryptFile(cmdArgs, true)
+	case "decrypt-file":
+		err = cmdCryptFile(cmdArgs, false)
 	case "entity-add":
 		err = cmdEntityAdd(client, cmdArgs)
 	case "entity-get":
@@ -97,12 +111,28 @@ func main() {
 		err = cmdWebhookCreate(client, cmdArgs)
 	case "webhook-list":
 		err = cmdWebhookList(client)
+	case "webhook-update":
+		err = cmdWebhookUpdate(client, cmdArgs)
+	case "webhook-events":
+		err = cmdWebhookEvents(client)
 	case "webhook-delete":
 		err = cmdWebhookDelete(client, cmdArgs)
+	case "webhook-deliveries":
+		err = cmdWebhookDeliveries(client, cmdArgs)
+	case "webhook-redeliver":
+		err = cmdWebhookRedeliver(client, cmdArgs)
+	case "events-stream":
+		err = cmdEventsStream(client, cmdArgs)
+	case "changes":
+		err = cmdChanges(client, cmdArgs)
 	case "export":
 		err = cmdExport(client, cmdArgs)
 	case "import":
 		err = cmdImport(client, cmdArgs)
+	case "export-ndjson":
+		err = cmdExportNDJSON(client, cmdArgs)
+	case "import-ndjson":
+		err = cmdImportNDJSON(client, cmdArgs)
 	case "backup":
 		err = cmdBackup(client, cmdArgs)
 	case "restore":
@@ -119,6 +149,14 @@ func main() {
 		err = cmdMerge(client, cmdArgs)
 	case "find-similar":
 		err = cmdFindSimilar(client, cmdArgs)
+	case "reembed":
+		err = cmdReembed(client, cmdArgs)
+	case "relation-close":
+		err = cmdRelationClose(client, cmdArgs)
+	case "graph-traverse":
+		err = cmdGraphTraverse(client, cmdArgs)
+	case "graph-claim-legacy":
+		err = cmdGraphClaimLegacy(client, cmdArgs)
 	case "help", "-h", "--help":
 		printHelp(os.Args[0])
 		os.Exit(0)
@@ -153,34 +191,74 @@ Befehle:
   query <text> [limit] [threshold] [seedIds] [metadataFilter] - Suche (limit=5, threshold=0.2, seedIds z.B. 1,2,3, metadataFilter z.B. '{"typ":"persönlich"}')
   delete <id>                - Löscht ein Memory
   stats                     - Zeigt Statistiken
-  entity-add <entity> <key> <value> - Fact zu einer Entity hinzufügen
+  entity-add <entity> <key> <value> - Fact zu einer Entity des Tenants hinzufügen
   entity-get <entity>      - Entity mit allen Fakten abrufen
-  relation-add <from> <to> <type> - Relation zwischen Entities anlegen
-  relation-get <from>      - Alle Relations von einer Entity abrufen
+  relation-add <from> <to> <type> [--valid-from RFC3339] [--valid-to RFC3339] [--supersede] - Relation anlegen
+                             (--supersede schließt andere offene Relations gleichen Typs, z.B. Jobwechsel)
+  relation-get <from> [--as-of RFC3339] [--history] - Relations einer Entity abrufen (Standard: aktuell gültige)
+  relation-close <from> <to> <type> [--at RFC3339] - Gültigkeit einer Relation beenden
+                             (entity-*/relation-* akzeptieren --app-id <id> und --user-id <id>)
+  graph-traverse <entity> [--depth 2] [--types a,b] [--direction out|in|both] [--dfs] [--at RFC3339] - Graph ab einer Entity durchlaufen
+  graph-claim-legacy [--app-id <id>] [--user-id <id>] - Entities/Relations ohne Tenant (Altbestand) dem Tenant zuordnen
   context-create <agentId> [memoryType] [payload] - Agent-Context anlegen (memoryType: episodic|semantic|procedural|working)
   context-list [agentId]    - Agent-Contexts auflisten
   context-get <id>          - Ein Agent-Context abrufen
   generate-embeddings [batchSize] - Embeddings für Memories nachziehen (Standard: 10, Max: 100)
   benchmark [count]         - Performance-Benchmark (Standard: 20 Requests)
   benchmark-embeddings [count] [service] - Benchmark Embedding-Generierung (count=50, service=local|gte|both)
-  api-key <create|delete|show> [env_file] - API-Key verwalten (Standard: .env im Projekt)
+  api-key create [--name <n>] [--apps a,b] [--scopes read,write,admin] [--expires 720h|RFC3339] - API-Key anlegen
+                             (Standard-Scopes: read,write; ohne --apps für alle Apps; benötigt Admin-Scope)
+  api-key list              - API-Keys auflisten (Präfix, Scopes, Apps, Ablauf, zuletzt benutzt)
+  api-key delete <id>       - API-Key widerrufen
+  audit [output_file] [--op backup.*,memory.delete] [--actor <name>] [--actor-key <id>] [--app <id>] [--user <id>]
+        [--outcome success|denied|failure] [--since 24h|RFC3339] [--until RFC3339] [--limit <n>] [--format ndjson|csv]
+                             - Audit-Log sensibler Operationen exportieren (neueste zuerst, alle Seiten; benötigt Admin-Scope)
+  encryption-key create <keyfile> - Schlüssel für verschlüsselte Backups und Exporte anlegen
+  encryption status         - Status der Feldverschlüsselung (Master-Key, Tenant-Keys, noch unverschlüsselte Werte)
+  encryption rotate [--all] - Datenschlüssel des Tenants (--all: aller Tenants) rotieren und alle Werte neu verschlüsseln
+  verify [--tenant] [--sign] - Datenbank, Content-Hashes und Signaturen aller Memories/Versionen prüfen
+                             (--tenant: nur der Tenant aus --app-id/--user-id, --sign: unsignierte Zeilen signieren)
+  encrypt-file <in> <out>   - Datei lokal verschlüsseln
+  decrypt-file <in> <out>   - Verschlüsselte Datei (Export, Backup) lokal entschlüsseln
+                             (--encrypt/--decrypt/*-file: Schlüssel aus --keyfile <pfad>, CORTEX_ENCRYPTION_KEYFILE
+                             oder CORTEX_ENCRYPTION_PASSPHRASE)
   bundle-create [name]      - Bundle anlegen
   bundle-list               - Bundles auflisten
   bundle-get <id>           - Bundle abrufen
   bundle-delete <id>        - Bundle löschen
   webhook-create <url> [events] [secret] - Webhook anlegen (events: kommagetrennt)
   webhook-list              - Webhooks auflisten
+  webhook-update <id> [--events e1,e2] [--url <url>] [--secret <s>] [--active true|false] - Webhook ändern (z.B. neue Events abonnieren)
+  webhook-events            - Event-Katalog anzeigen (auch memory.* und * als Abo möglich)
   webhook-delete <id>       - Webhook löschen
-  export [output_file]      - Daten exportieren (stdout wenn keine Datei)
-  import <path|-] [overwrite] - Daten importieren (- = stdin)
-  backup [path]             - Datenbank-Backup erstellen
-  restore <path>            - Datenbank aus Backup wiederherstellen
+  webhook-deliveries <id> [status] - Zustellungen eines Webhooks inkl. Versuche (status: pending, delivered, dead)
+  webhook-redeliver <id> <delivery_id> - Zustellung erneut senden (z.B. aus dem Dead-Letter)
+  events-stream [types] [last_event_id] - Events des Tenants live anzeigen (SSE, z.B. memory.*; ab last_event_id nachladen)
+  changes [since] [limit] [kind] - Änderungsprotokoll des Tenants ab Sequenznummer (kind z.B. memory,relation)
+  export [output_file] [--encrypt] - Daten exportieren (stdout wenn keine Datei; --encrypt verschlüsselt lokal)
+  import <path|-> [overwrite] [--remap] [--conflict <s>] [--dry-run] [--decrypt] - Daten importieren (- = stdin);
+                             --remap importiert mit neuen IDs in den Tenant von --app-id/--user-id,
+                             --conflict skip|overwrite|merge|duplicate bei gleichem Inhalt (Memories: Content-Hash)
+  export-ndjson [output_file] [updated_since] [--encrypt] - Export als NDJSON streamen (inkl. Versionen, Entities, Relations, Contexts;
+                             updated_since RFC3339 = nur Änderungen seitdem plus Löschungen)
+  import-ndjson <path|-> [overwrite] [--remap] [--conflict <s>] [--dry-run] [--decrypt] - NDJSON-Export streamend importieren
+                             (Fortschritt und Fehler je Zeile; --remap/--conflict wie bei import; --dry-run prüft nur)
+  backup [path] [--encrypt] - Online-Backup erstellen (ohne Pfad: Backup-Verzeichnis mit Manifest und
+                             ggf. Kompression, sonst Pfad relativ zum Datenbankverzeichnis;
+                             --encrypt verschlüsselt mit dem Schlüssel des Servers)
+  backup list               - Backups im Backup-Verzeichnis auflisten (neueste zuerst)
+  backup verify [file]      - Checksumme und Integrität eines Backups (oder aller) prüfen
+  backup prune [--dry-run]  - Geplante Backups nach Aufbewahrungsregel löschen (CORTEX_BACKUP_KEEP_*)
+  restore <path>            - Datenbank im laufenden Betrieb aus Backup wiederherstellen (auch .gz/.zst/.enc)
+                             (geprüft per integrity_check; rollback_path der Antwort macht es rückgängig)
   analytics [days]          - Analytik abrufen (Standard: 30 Tage)
   seeds-list [limit] [offset] - Memories auflisten (Pagination)
   cleanup [--dry-run]       - Cleanup manuell triggern
   history <id>            - Memory Version History abrufen
   merge <target> <source>  - Memories zusammenführen
   find-similar [--threshold 0.9] [--limit 10] - Ähnliche Memories finden
+  reembed [--batch-size 32] [--no-wait] - Memories des Tenants mit dem aktuellen Embedding-Modell neu einbetten (mit Fortschritt)
+  reembed status | cancel <job-id> - Re-Embed-Status anzeigen bzw. Job abbrechen
   help                      - Zeigt diese Hilfe
 
 Umgebungsvariablen:
@@ -188,6 +266,8 @@ Umgebungsvariablen:
   CORTEX_APP_ID    - App-ID (Standard: %s)
   CORTEX_USER_ID   - User-ID (Standard: %s)
   CORTEX_API_KEY   - Optional: API-Key für Auth (nur für Produktion; lokale Installation benötigt keinen)
+  CORTEX_ENCRYPTION_KEYFILE    - Optional: Schlüsseldatei für --encrypt/--decrypt
+  CORTEX_ENCRYPTION_PASSPHRASE - Optional: Passphrase statt Schlüsseldatei
 
 Flags (überschreiben Env):
   -url <url>    - API Base URL
@@ -206,26 +286,52 @@ Beispiele:
   %[1]s entity-get carsten
   %[1]s relation-add carsten typescript programmiert
   %[1]s relation-get carsten
+  %[1]s entity-get carsten --app-id myapp --user-id alice
+  %[1]s relation-add carsten firma-b arbeitet_bei --supersede
+  %[1]s relation-get carsten --as-of 2025-01-01T00:00:00Z
+  %[1]s graph-traverse project:cortex --depth 2 --types works_on,knows
+  %[1]s graph-claim-legacy --app-id openclaw --user-id default
   %[1]s context-create "my-agent" episodic '{}'
   %[1]s context-list "my-agent"
   %[1]s context-get 1
   %[1]s generate-embeddings 100
   %[1]s benchmark 50
   %[1]s benchmark-embeddings 100 local
-  %[1]s api-key create
-  %[1]s api-key show
+  %[1]s api-key create --name admin --scopes read,write,admin
+  %[1]s api-key create --name agent --apps openclaw --expires 720h
+  %[1]s api-key list
+  %[1]s audit --since 24h --outcome denied
+  %[1]s audit audit.csv --op backup.*,data.import --format csv
+  %[1]s encryption-key create ~/.cortex.key
+  %[1]s encryption status
+  %[1]s encryption rotate --all
+  %[1]s verify
+  %[1]s verify --tenant --app-id openclaw --user-id default
   %[1]s bundle-create "Coffee Preferences"
   %[1]s bundle-list
   %[1]s export backup.json
   %[1]s import backup.json true
-  %[1]s backup /path/to/backup.db
-  %[1]s restore /path/to/backup.db
+  %[1]s export-ndjson export.ndjson
+  %[1]s export-ndjson delta.ndjson 2025-01-01T00:00:00Z
+  %[1]s import-ndjson export.ndjson --dry-run
+  %[1]s export-ndjson export.ndjson.enc --encrypt --keyfile ~/.cortex.key
+  %[1]s import-ndjson export.ndjson.enc --decrypt --keyfile ~/.cortex.key
+  %[1]s import-ndjson export.ndjson --remap --conflict merge --app-id app2 --user-id u2
+  %[1]s backup backups/cortex-backup.db
+  %[1]s backup list
+  %[1]s backup verify cortex-backup-20250101-030000.db.gz
+  %[1]s backup prune --dry-run
+  %[1]s backup --encrypt
+  %[1]s decrypt-file cortex-backup-20250101-030000.db.gz.enc cortex-backup.db.gz
+  %[1]s restore backups/cortex-backup.db
   %[1]s analytics 7
   %[1]s seeds-list 20 0
   %[1]s cleanup --dry-run
   %[1]s history 1
   %[1]s merge 1 2 3
   %[1]s find-similar --threshold 0.9 --limit 10
+  %[1]s reembed --batch-size 64
+  %[1]s reembed status
 `, prog, defaultBaseURL, defaultAppID, defaultUserID)
 }
 
@@ -268,6 +374,31 @@ func (c *cliClient) do(method, path string, body interface{}) ([]byte, int, erro
 	return data, resp.StatusCode, nil
 }
 
+// stream sends a request and returns the response with unread body (for streamed exports/imports).
+// Non-2xx responses are returned as error.
+func (c *cliClient) stream(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
 func cmdHealth(client *cliClient) error {
 	data, code, err := client.do(http.MethodGet, "/health", nil)
 	if err != nil {
@@ -802,9 +933,73 @@ func cmdBenchmarkEmbeddings(args []string) error {
 	return nil
 }
 
+// withTenantFlags übernimmt --app-id/--user-id aus den Befehls-Argumenten (überschreiben globale Flags/Env)
+// und liefert die übrigen Argumente.
+func withTenantFlags(client *cliClient, args []string) []string {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--app-id", "-app-id":
+			if i+1 < len(args) {
+				client.appID = args[i+1]
+				i++
+				continue
+			}
+		case "--user-id", "-user-id":
+			if i+1 < len(args) {
+				client.userID = args[i+1]
+				i++
+				continue
+			}
+		}
+		rest = append(rest, args[i])
+	}
+	return rest
+}
+
+// splitFlags trennt --flags von Positionsargumenten. Flags in valueFlags erwarten einen Wert,
+// alle anderen --flags gelten als gesetzt ("true").
+func splitFlags(args []string, valueFlags ...string) (rest []string, flags map[string]string, err error) {
+	flags = make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			rest = append(rest, args[i])
+			continue
+		}
+		name := strings.TrimPrefix(args[i], "--")
+		if !slices.Contains(valueFlags, name) {
+			flags[name] = "true"
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, nil, fmt.Errorf("--%s erwartet einen Wert", name)
+		}
+		flags[name] = args[i+1]
+		i++
+	}
+	return rest, flags, nil
+}
+
+// splitList trennt eine kommagetrennte Liste und entfernt Leerzeichen und leere Einträge
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// tenantQuery liefert appId/externalUserId als Query-String
+func (c *cliClient) tenantQuery() string {
+	return "appId=" + url.QueryEscape(c.appID) + "&externalUserId=" + url.QueryEscape(c.userID)
+}
+
 func cmdEntityAdd(client *cliClient, args []string) error {
+	args = withTenantFlags(client, args)
 	if len(args) < 3 {
-		return fmt.Errorf("Verwendung: entity-add <entity> <key> <value>")
+		return fmt.Errorf("Verwendung: entity-add <entity> <key> <value> [--app-id <id>] [--user-id <id>]")
 	}
 	entity := args[0]
 	key := args[1]
@@ -825,7 +1020,7 @@ func cmdEntityAdd(client *cliClient, args []string) error {
 		"key":   key,
 		"value": valueAny,
 	}
-	path := "/entities?entity=" + url.QueryEscape(entity)
+	path := "/entities?entity=" + url.QueryEscape(entity) + "&" + client.tenantQuery()
 	data, code, err := client.do(http.MethodPost, path, body)
 	if err != nil {
 		return err
@@ -838,12 +1033,16 @@ func cmdEntityAdd(client *cliClient, args []string) error {
 }
 
 func cmdEntityGet(client *cliClient, args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("Verwendung: entity-get <entity>")
+	args, flags, err := splitFlags(withTenantFlags(client, args), "as-of")
+	if err != nil || len(args) < 1 {
+		return fmt.Errorf("Verwendung: entity-get <entity> [--as-of RFC3339] [--app-id <id>] [--user-id <id>]")
 	}
 	entity := args[0]
 
-	path := "/entities?name=" + url.QueryEscape(entity)
+	path := "/entities?name=" + url.QueryEscape(entity) + "&" + client.tenantQuery()
+	if asOf := flags["as-of"]; asOf != "" {
+		path += "&asOf=" + url.QueryEscape(asOf)
+	}
 	data, code, err := client.do(http.MethodGet, path, nil)
 	if err != nil {
 		return err
@@ -859,17 +1058,29 @@ func cmdEntityGet(client *cliClient, args []string) error {
 }
 
 func cmdRelationAdd(client *cliClient, args []string) error {
-	if len(args) < 3 {
-		return fmt.Errorf("Verwendung: relation-add <from> <to> <type>")
+	args, flags, err := splitFlags(withTenantFlags(client, args), "valid-from", "valid-to")
+	if err != nil || len(args) < 3 {
+		return fmt.Errorf("Verwendung: relation-add <from> <to> <type> [--valid-from RFC3339] [--valid-to RFC3339] [--supersede] [--app-id <id>] [--user-id <id>]")
 	}
 	from := args[0]
 	to := args[1]
 	relType := args[2]
 
 	body := map[string]any{
-		"from": from,
-		"to":   to,
-		"type": relType,
+		"appId":          client.appID,
+		"externalUserId": client.userID,
+		"from":           from,
+		"to":             to,
+		"type":           relType,
+	}
+	if v := flags["valid-from"]; v != "" {
+		body["validFrom"] = v
+	}
+	if v := flags["valid-to"]; v != "" {
+		body["validTo"] = v
+	}
+	if flags["supersede"] == "true" {
+		body["supersede"] = true
 	}
 	data, code, err := client.do(http.MethodPost, "/relations", body)
 	if err != nil {
@@ -883,12 +1094,19 @@ func cmdRelationAdd(client *cliClient, args []string) error {
 }
 
 func cmdRelationGet(client *cliClient, args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("Verwendung: relation-get <from>")
+	args, flags, err := splitFlags(withTenantFlags(client, args), "as-of")
+	if err != nil || len(args) < 1 {
+		return fmt.Errorf("Verwendung: relation-get <from> [--as-of RFC3339] [--history] [--app-id <id>] [--user-id <id>]")
 	}
 	from := args[0]
 
-	path := "/relations?entity=" + url.QueryEscape(from)
+	path := "/relations?entity=" + url.QueryEscape(from) + "&" + client.tenantQuery()
+	if asOf := flags["as-of"]; asOf != "" {
+		path += "&asOf=" + url.QueryEscape(asOf)
+	}
+	if flags["history"] == "true" {
+		path += "&history=true"
+	}
 	data, code, err := client.do(http.MethodGet, path, nil)
 	if err != nil {
 		return err
@@ -900,6 +1118,96 @@ func cmdRelationGet(client *cliClient, args []string) error {
 	return nil
 }
 
+// cmdRelationClose beendet die Gültigkeit einer offenen Relation (Historie bleibt erhalten)
+func cmdRelationClose(client *cliClient, args []string) error {
+	args, flags, err := splitFlags(withTenantFlags(client, args), "at")
+	if err != nil || len(args) < 3 {
+		return fmt.Errorf("Verwendung: relation-close <from> <to> <type> [--at RFC3339] [--app-id <id>] [--user-id <id>]")
+	}
+	body := map[string]any{
+		"appId":          client.appID,
+		"externalUserId": client.userID,
+		"from":           args[0],
+		"to":             args[1],
+		"type":           args[2],
+	}
+	if at := flags["at"]; at != "" {
+		body["at"] = at
+	}
+	data, code, err := client.do(http.MethodPost, "/relations/close", body)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusNotFound {
+		return fmt.Errorf("Keine offene Relation '%s' von '%s' zu '%s'", args[2], args[0], args[1])
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Schließen der Relation (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// cmdGraphTraverse durchläuft den Graph ab einer Entity (BFS/DFS) und gibt Knoten, Kanten und Pfade aus
+func cmdGraphTraverse(client *cliClient, args []string) error {
+	args = withTenantFlags(client, args)
+	usage := fmt.Errorf("Verwendung: graph-traverse <entity> [--depth 2] [--types a,b] [--direction out|in|both] [--dfs] [--at RFC3339] [--limit 100]")
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		return usage
+	}
+	params := url.Values{}
+	params.Set("start", args[0])
+	params.Set("appId", client.appID)
+	params.Set("externalUserId", client.userID)
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "--dfs":
+			params.Set("strategy", "dfs")
+		case "--depth", "--types", "--direction", "--at", "--limit":
+			if i+1 >= len(args) {
+				return usage
+			}
+			params.Set(strings.TrimPrefix(args[i], "--"), args[i+1])
+			i++
+		default:
+			return usage
+		}
+	}
+
+	data, code, err := client.do(http.MethodGet, "/graph/traverse?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Durchlaufen des Graphen (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// cmdGraphClaimLegacy ordnet Entities/Relations aus der Zeit vor dem Tenant-Scoping dem Tenant zu
+func cmdGraphClaimLegacy(client *cliClient, args []string) error {
+	withTenantFlags(client, args)
+	data, code, err := client.do(http.MethodPost, "/admin/graph/claim-legacy?"+client.tenantQuery(), nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Übernehmen des Altbestands (HTTP %d): %s", code, string(data))
+	}
+	var counts struct {
+		Entities       int64 `json:"entities"`
+		MergedEntities int64 `json:"mergedEntities"`
+		Relations      int64 `json:"relations"`
+	}
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return fmt.Errorf("Ungültige Antwort: %w", err)
+	}
+	fmt.Printf("Tenant %s/%s: %d Entities übernommen, %d zusammengeführt, %d Relations übernommen\n",
+		client.appID, client.userID, counts.Entities, counts.MergedEntities, counts.Relations)
+	return nil
+}
+
 func cmdBundleCreate(client *cliClient, args []string) error {
 	name := "Unnamed Bundle"
 	if len(args) >= 1 {
@@ -1035,6 +1343,94 @@ func cmdWebhookList(client *cliClient) error {
 	return nil
 }
 
+func cmdWebhookUpdate(client *cliClient, args []string) error {
+	args, flags, err := splitFlags(args, "events", "url", "secret", "active")
+	if err != nil || len(args) < 1 || len(flags) == 0 {
+		return fmt.Errorf("Verwendung: webhook-update <id> [--events e1,e2] [--url <url>] [--secret <s>] [--active true|false]")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("id muss eine positive Ganzzahl sein")
+	}
+	body := map[string]any{}
+	if v, ok := flags["events"]; ok {
+		events := strings.Split(v, ",")
+		for i := range events {
+			events[i] = strings.TrimSpace(events[i])
+		}
+		body["events"] = events
+	}
+	if v, ok := flags["url"]; ok {
+		body["url"] = v
+	}
+	if v, ok := flags["secret"]; ok {
+		body["secret"] = v
+	}
+	if v, ok := flags["active"]; ok {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("--active erwartet true oder false")
+		}
+		body["active"] = active
+	}
+	path := "/webhooks/" + strconv.FormatInt(id, 10) + "?appId=" + url.QueryEscape(client.appID)
+	data, code, err := client.do(http.MethodPatch, path, body)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusNotFound {
+		return fmt.Errorf("Webhook nicht gefunden (ID: %d)", id)
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Ändern des Webhooks (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func cmdWebhookEvents(client *cliClient) error {
+	data, code, err := client.do(http.MethodGet, "/webhooks/events", nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Abrufen des Event-Katalogs (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func cmdEventsStream(client *cliClient, args []string) error {
+	params := url.Values{"appId": {client.appID}, "externalUserId": {client.userID}}
+	if len(args) > 0 && args[0] != "" {
+		params.Set("types", args[0])
+	}
+	req, err := http.NewRequest(http.MethodGet, client.baseURL+"/events/stream?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if client.apiKey != "" {
+		req.Header.Set("X-API-Key", client.apiKey)
+	}
+	if len(args) > 1 {
+		req.Header.Set("Last-Event-ID", args[1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Fehler beim Öffnen des Event-Streams (HTTP %d): %s", resp.StatusCode, string(data))
+	}
+	// Läuft bis Strg+C oder Verbindungsabbruch
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
 func cmdWebhookDelete(client *cliClient, args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("Verwendung: webhook-delete <id>")
@@ -1058,7 +1454,70 @@ func cmdWebhookDelete(client *cliClient, args []string) error {
 	return nil
 }
 
+func cmdWebhookDeliveries(client *cliClient, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Verwendung: webhook-deliveries <id> [status]")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("id muss eine positive Ganzzahl sein")
+	}
+	path := "/webhooks/" + strconv.FormatInt(id, 10) + "/deliveries?appId=" + url.QueryEscape(client.appID)
+	if len(args) >= 2 && args[1] != "" {
+		path += "&status=" + url.QueryEscape(args[1])
+	}
+	data, code, err := client.do(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusNotFound {
+		return fmt.Errorf("Webhook nicht gefunden (ID: %d)", id)
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Abrufen der Zustellungen (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func cmdWebhookRedeliver(client *cliClient, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Verwendung: webhook-redeliver <id> <delivery_id>")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("id muss eine positive Ganzzahl sein")
+	}
+	deliveryID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || deliveryID <= 0 {
+		return fmt.Errorf("delivery_id muss eine positive Ganzzahl sein")
+	}
+	path := fmt.Sprintf("/webhooks/%d/deliveries/%d/redeliver?appId=%s", id, deliveryID, url.QueryEscape(client.appID))
+	data, code, err := client.do(http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusNotFound {
+		return fmt.Errorf("Webhook oder Zustellung nicht gefunden: %s", strings.TrimSpace(string(data)))
+	}
+	if code != http.StatusAccepted {
+		return fmt.Errorf("Fehler beim erneuten Zustellen (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
 func cmdExport(client *cliClient, args []string) error {
+	args, flags, err := splitFlags(args, "keyfile")
+	if err != nil {
+		return err
+	}
+	var key *crypt.Key
+	if flags["encrypt"] == "true" {
+		if key, err = cliKey(flags); err != nil {
+			return err
+		}
+	}
 	path := "/export?appId=" + url.QueryEscape(client.appID) + "&externalUserId=" + url.QueryEscape(client.userID)
 	data, code, err := client.do(http.MethodGet, path, nil)
 	if err != nil {
@@ -1067,23 +1526,35 @@ func cmdExport(client *cliClient, args []string) error {
 	if code != http.StatusOK {
 		return fmt.Errorf("Fehler beim Export (HTTP %d): %s", code, string(data))
 	}
+	if key != nil {
+		var buf bytes.Buffer
+		enc, err := crypt.NewWriter(&buf, key)
+		if err != nil {
+			return fmt.Errorf("Fehler beim Verschlüsseln: %w", err)
+		}
+		enc.Write(data)
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("Fehler beim Verschlüsseln: %w", err)
+		}
+		data = buf.Bytes()
+	}
 	if len(args) >= 1 && args[0] != "" && args[0] != "-" {
 		if err := os.WriteFile(args[0], data, 0644); err != nil {
 			return fmt.Errorf("Fehler beim Schreiben der Datei: %w", err)
 		}
 		fmt.Printf("Export nach %s geschrieben\n", args[0])
 	} else {
-		fmt.Print(string(data))
+		os.Stdout.Write(data)
 	}
 	return nil
 }
 
 func cmdImport(client *cliClient, args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("Verwendung: import <path|-> [overwrite]. overwrite=true überschreibt vorhandene Daten")
+	args, flags, err := splitFlags(withTenantFlags(client, args), "conflict", "keyfile")
+	if err != nil || len(args) < 1 {
+		return fmt.Errorf("Verwendung: import <path|-> [overwrite] [--remap] [--conflict skip|overwrite|merge|duplicate] [--dry-run] [--decrypt]. overwrite=true überschreibt vorhandene Daten")
 	}
 	var raw []byte
-	var err error
 	if args[0] == "-" {
 		raw, err = io.ReadAll(os.Stdin)
 	} else {
@@ -1092,20 +1563,29 @@ func cmdImport(client *cliClient, args []string) error {
 	if err != nil {
 		return fmt.Errorf("Fehler beim Lesen: %w", err)
 	}
-	overwrite := false
-	if len(args) >= 2 && strings.ToLower(args[1]) == "true" {
-		overwrite = true
-	}
-	apiPath := "/import?appId=" + url.QueryEscape(client.appID) + "&externalUserId=" + url.QueryEscape(client.userID)
-	if overwrite {
-		apiPath += "&overwrite=true"
+	if crypt.IsEncrypted(raw) {
+		if flags["decrypt"] != "true" {
+			return fmt.Errorf("Datei ist verschlüsselt: mit --decrypt importieren")
+		}
+		key, err := cliKey(flags)
+		if err != nil {
+			return err
+		}
+		dec, err := crypt.NewReader(bytes.NewReader(raw), key)
+		if err == nil {
+			raw, err = io.ReadAll(dec)
+		}
+		if err != nil {
+			return fmt.Errorf("Fehler beim Entschlüsseln: %w", err)
+		}
 	}
+	params := importParams(client, args, flags)
 	// Body is raw JSON (export format)
 	var body map[string]any
 	if err := json.Unmarshal(raw, &body); err != nil {
 		return fmt.Errorf("Ungültiges Export-JSON: %w", err)
 	}
-	data, code, err := client.do(http.MethodPost, apiPath, body)
+	data, code, err := client.do(http.MethodPost, "/import?"+params.Encode(), body)
 	if err != nil {
 		return err
 	}
@@ -1116,10 +1596,161 @@ func cmdImport(client *cliClient, args []string) error {
 	return nil
 }
 
+// importParams baut die Query für import/import-ndjson: [overwrite] als zweites Argument,
+// --remap (in den Tenant des CLI importieren), --conflict <strategie>, --dry-run.
+func importParams(client *cliClient, args []string, flags map[string]string) url.Values {
+	params := url.Values{"appId": {client.appID}, "externalUserId": {client.userID}}
+	if len(args) >= 2 && strings.ToLower(args[1]) == "true" {
+		params.Set("overwrite", "true")
+	}
+	if flags["remap"] == "true" {
+		params.Set("remap", "true")
+	}
+	if flags["conflict"] != "" {
+		params.Set("conflict", flags["conflict"])
+	}
+	if flags["dry-run"] == "true" {
+		params.Set("dryRun", "true")
+	}
+	return params
+}
+
+func cmdExportNDJSON(client *cliClient, args []string) error {
+	args, flags, err := splitFlags(args, "keyfile")
+	if err != nil {
+		return err
+	}
+	var key *crypt.Key
+	if flags["encrypt"] == "true" {
+		if key, err = cliKey(flags); err != nil {
+			return err
+		}
+	}
+	params := url.Values{"appId": {client.appID}, "externalUserId": {client.userID}, "format": {"ndjson"}}
+	if len(args) >= 2 && args[1] != "" {
+		params.Set("updatedSince", args[1])
+	}
+	resp, err := client.stream(http.MethodGet, "/export?"+params.Encode(), "", nil)
+	if err != nil {
+		return fmt.Errorf("Fehler beim Export: %w", err)
+	}
+	defer resp.Body.Close()
+
+	toStdout := len(args) < 1 || args[0] == "" || args[0] == "-"
+	f := os.Stdout
+	if !toStdout {
+		if f, err = os.Create(args[0]); err != nil {
+			return fmt.Errorf("Fehler beim Schreiben der Datei: %w", err)
+		}
+	}
+	var out io.Writer = f
+	var enc *crypt.Writer
+	if key != nil {
+		if enc, err = crypt.NewWriter(f, key); err != nil {
+			return fmt.Errorf("Fehler beim Verschlüsseln: %w", err)
+		}
+		out = enc
+	}
+	n, err := io.Copy(out, resp.Body)
+	if enc != nil && err == nil {
+		err = enc.Close()
+	}
+	if toStdout {
+		return err
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Fehler beim Schreiben der Datei: %w", err)
+	}
+	fmt.Printf("Export nach %s geschrieben (%d Bytes)\n", args[0], n)
+	return nil
+}
+
+func cmdImportNDJSON(client *cliClient, args []string) error {
+	rest, flags, err := splitFlags(withTenantFlags(client, args), "conflict", "keyfile")
+	if err != nil || len(rest) < 1 {
+		return fmt.Errorf("Verwendung: import-ndjson <path|-> [overwrite] [--remap] [--conflict skip|overwrite|merge|duplicate] [--dry-run] [--decrypt]. overwrite=true behält IDs und überschreibt vorhandene Daten")
+	}
+	var in io.Reader = os.Stdin
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return fmt.Errorf("Fehler beim Lesen: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	in, encrypted := crypt.Peek(in)
+	if encrypted {
+		if flags["decrypt"] != "true" {
+			return fmt.Errorf("Datei ist verschlüsselt: mit --decrypt importieren")
+		}
+		key, err := cliKey(flags)
+		if err != nil {
+			return err
+		}
+		if in, err = crypt.NewReader(in, key); err != nil {
+			return fmt.Errorf("Fehler beim Entschlüsseln: %w", err)
+		}
+	}
+	params := importParams(client, rest, flags)
+
+	resp, err := client.stream(http.MethodPost, "/import?"+params.Encode(), "application/x-ndjson", in)
+	if err != nil {
+		return fmt.Errorf("Fehler beim Import: %w", err)
+	}
+	defer resp.Body.Close()
+	// Antwort zeilenweise: progress/error während des Imports, zuletzt result
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	var failed bool
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		fmt.Println(string(line))
+		var msg struct {
+			Type  string `json:"type"`
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(line, &msg) == nil && msg.Type == "result" && msg.Error != "" {
+			failed = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if failed {
+		return fmt.Errorf("Import abgebrochen")
+	}
+	return nil
+}
+
 func cmdBackup(client *cliClient, args []string) error {
-	path := "/backup"
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return cmdBackupList(client)
+		case "verify":
+			return cmdBackupVerify(client, args[1:])
+		case "prune":
+			return cmdBackupPrune(client, args[1:])
+		}
+	}
+	args, flags, err := splitFlags(args)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
 	if len(args) >= 1 && args[0] != "" {
-		path += "?path=" + url.QueryEscape(args[0])
+		params.Set("path", args[0])
+	}
+	if flags["encrypt"] == "true" {
+		params.Set("encrypt", "true")
+	}
+	path := "/backup"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
 	}
 	data, code, err := client.do(http.MethodPost, path, nil)
 	if err != nil {
@@ -1132,6 +1763,61 @@ func cmdBackup(client *cliClient, args []string) error {
 	return nil
 }
 
+func cmdBackupList(client *cliClient) error {
+	data, code, err := client.do(http.MethodGet, "/backups", nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Auflisten der Backups (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// cmdBackupVerify prüft ein Backup (oder alle) und schlägt fehl, wenn eines beschädigt ist
+func cmdBackupVerify(client *cliClient, args []string) error {
+	path := "/backups/verify"
+	if len(args) >= 1 && args[0] != "" {
+		path += "?file=" + url.QueryEscape(args[0])
+	}
+	data, code, err := client.do(http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Prüfen der Backups (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	var res struct {
+		OK bool `json:"ok"`
+	}
+	if err := json.Unmarshal(data, &res); err == nil && !res.OK {
+		return fmt.Errorf("mindestens ein Backup ist beschädigt")
+	}
+	return nil
+}
+
+func cmdBackupPrune(client *cliClient, args []string) error {
+	_, flags, err := splitFlags(args)
+	if err != nil {
+		return err
+	}
+	path := "/backups/prune"
+	if flags["dry-run"] == "true" {
+		path += "?dryRun=true"
+	}
+	data, code, err := client.do(http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Aufräumen der Backups (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
 func cmdRestore(client *cliClient, args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("Verwendung: restore <path>")
@@ -1168,6 +1854,31 @@ func cmdAnalytics(client *cliClient, args []string) error {
 	return nil
 }
 
+func cmdChanges(client *cliClient, args []string) error {
+	params := url.Values{"appId": {client.appID}, "externalUserId": {client.userID}}
+	if len(args) >= 1 {
+		if _, err := strconv.ParseInt(args[0], 10, 64); err != nil {
+			return fmt.Errorf("Verwendung: changes [since] [limit] [kind]")
+		}
+		params.Set("since", args[0])
+	}
+	if len(args) >= 2 {
+		params.Set("limit", args[1])
+	}
+	if len(args) >= 3 {
+		params.Set("kind", args[2])
+	}
+	data, code, err := client.do(http.MethodGet, "/changes?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler beim Abrufen des Änderungsprotokolls (HTTP %d): %s", code, string(data))
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
 func cmdSeedsList(client *cliClient, args []string) error {
 	limit := 50
 	offset := 0
@@ -1193,142 +1904,378 @@ func cmdSeedsList(client *cliClient, args []string) error {
 	return nil
 }
 
-func cmdAPIKey(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("Verwendung: api-key <create|delete|show> [env_file]")
+// cliKey lädt den Schlüssel für --encrypt/--decrypt: --keyfile <pfad>, sonst
+// CORTEX_ENCRYPTION_KEYFILE bzw. CORTEX_ENCRYPTION_PASSPHRASE
+func cliKey(flags map[string]string) (*crypt.Key, error) {
+	if path := flags["keyfile"]; path != "" {
+		return crypt.KeyFromFile(path)
+	}
+	key, err := crypt.KeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("kein Schlüssel: --keyfile <pfad> angeben oder CORTEX_ENCRYPTION_KEYFILE/CORTEX_ENCRYPTION_PASSPHRASE setzen")
 	}
+	return key, nil
+}
 
-	cmd := args[0]
-	envFile := ""
-	if len(args) >= 2 {
-		envFile = args[1]
-	} else {
-		// Suche .env im aktuellen Verzeichnis oder Projekt-Root
-		if _, err := os.Stat(".env"); err == nil {
-			envFile = ".env"
-		} else {
-			// Versuche Projekt-Root zu finden
-			cwd, _ := os.Getwd()
-			projectRoot := cwd
-			for {
-				if _, err := os.Stat(filepath.Join(projectRoot, ".env")); err == nil {
-					envFile = filepath.Join(projectRoot, ".env")
-					break
-				}
-				parent := filepath.Dir(projectRoot)
-				if parent == projectRoot {
-					envFile = ".env"
-					break
-				}
-				projectRoot = parent
-			}
+func cmdEncryptionKey(args []string) error {
+	if len(args) < 2 || args[0] != "create" {
+		return fmt.Errorf("Verwendung: encryption-key create <keyfile>")
+	}
+	if err := crypt.GenerateKeyFile(args[1]); err != nil {
+		return fmt.Errorf("Fehler beim Anlegen des Schlüssels: %w", err)
+	}
+	fmt.Printf("Schlüssel nach %s geschrieben (nur für den Besitzer lesbar)\n", args[1])
+	fmt.Printf("Für den Server: CORTEX_ENCRYPTION_KEYFILE=%s (Backups/Exporte)\n", args[1])
+	fmt.Printf("oder als Master-Key der Feldverschlüsselung: CORTEX_MASTER_KEYFILE=%s\n", args[1])
+	return nil
+}
+
+// cmdEncryption zeigt den Status der Feldverschlüsselung bzw. rotiert Tenant-Keys
+func cmdEncryption(client *cliClient, args []string) error {
+	args = withTenantFlags(client, args)
+	args, flags, err := splitFlags(args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || (args[0] != "status" && args[0] != "rotate") {
+		return fmt.Errorf("Verwendung: encryption status | encryption rotate [--all] [--app-id <id>] [--user-id <id>]")
+	}
+	if args[0] == "status" {
+		data, code, err := client.do(http.MethodGet, "/admin/encryption", nil)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Abrufen des Verschlüsselungsstatus (HTTP %d): %s", code, string(data))
 		}
+		fmt.Println(string(data))
+		return nil
 	}
 
-	const varName = "CORTEX_API_KEY"
-	const keyPrefix = "ck_"
+	path := "/admin/encryption/rotate?" + client.tenantQuery()
+	if flags["all"] == "true" {
+		path = "/admin/encryption/rotate?all=true"
+	}
+	data, code, err := client.do(http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler bei der Key-Rotation (HTTP %d): %s", code, string(data))
+	}
+	var result struct {
+		Rotations []struct {
+			AppID          string   `json:"app_id"`
+			ExternalUserID string   `json:"external_user_id"`
+			KeyID          string   `json:"key_id"`
+			Reencrypted    int64    `json:"reencrypted"`
+			RetiredKeys    []string `json:"retired_keys"`
+		} `json:"rotations"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("Ungültige Antwort: %w", err)
+	}
+	for _, r := range result.Rotations {
+		fmt.Printf("Tenant %s/%s: neuer Key %s, %d Werte neu verschlüsselt, %d alte Keys gelöscht\n",
+			r.AppID, r.ExternalUserID, r.KeyID, r.Reencrypted, len(r.RetiredKeys))
+	}
+	return nil
+}
 
-	switch cmd {
-	case "create":
-		// Generiere Key
-		keyBytes := make([]byte, 32)
-		if _, err := rand.Read(keyBytes); err != nil {
-			return fmt.Errorf("Fehler beim Generieren des Keys: %w", err)
+// cmdVerify startet die Integritätsprüfung (/admin/verify) und listet beschädigte oder veränderte Zeilen
+func cmdVerify(client *cliClient, args []string) error {
+	args = withTenantFlags(client, args)
+	_, flags, err := splitFlags(args)
+	if err != nil {
+		return fmt.Errorf("Verwendung: verify [--tenant] [--sign] [--app-id <id>] [--user-id <id>]")
+	}
+	var params []string
+	if flags["tenant"] == "true" {
+		params = append(params, client.tenantQuery())
+	}
+	if flags["sign"] == "true" {
+		params = append(params, "sign=true")
+	}
+	path := "/admin/verify?" + strings.Join(params, "&")
+	data, code, err := client.do(http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Fehler bei der Integritätsprüfung (HTTP %d): %s", code, string(data))
+	}
+	var report struct {
+		DatabaseOK     bool     `json:"database_ok"`
+		DatabaseErrors []string `json:"database_errors"`
+		Memories       int64    `json:"memories"`
+		Versions       int64    `json:"versions"`
+		SignaturesKey  string   `json:"signatures_key"`
+		Unsigned       int64    `json:"unsigned"`
+		Signed         int64    `json:"signed"`
+		Issues         []struct {
+			Record         string `json:"record"`
+			ID             int64  `json:"id"`
+			AppID          string `json:"app_id"`
+			ExternalUserID string `json:"external_user_id"`
+			Problem        string `json:"problem"`
+			Error          string `json:"error"`
+		} `json:"issues"`
+		IssuesTruncated bool `json:"issues_truncated"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("Ungültige Antwort: %w", err)
+	}
+	fmt.Printf("Geprüft: %d Memories, %d Versionen\n", report.Memories, report.Versions)
+	if report.SignaturesKey != "" {
+		fmt.Printf("Signaturen (Key %s): %d unsigniert, %d neu signiert\n", report.SignaturesKey, report.Unsigned, report.Signed)
+	}
+	for _, line := range report.DatabaseErrors {
+		fmt.Printf("Datenbank: %s\n", line)
+	}
+	for _, issue := range report.Issues {
+		fmt.Printf("%s %d (%s/%s): %s", issue.Record, issue.ID, issue.AppID, issue.ExternalUserID, issue.Problem)
+		if issue.Error != "" {
+			fmt.Printf(" - %s", issue.Error)
 		}
-		key := keyPrefix + hex.EncodeToString(keyBytes)
+		fmt.Println()
+	}
+	if report.IssuesTruncated {
+		fmt.Println("(weitere Probleme nicht aufgeführt)")
+	}
+	if !report.DatabaseOK || len(report.Issues) > 0 {
+		return fmt.Errorf("Integritätsprüfung fehlgeschlagen: %d Probleme", len(report.Issues)+len(report.DatabaseErrors))
+	}
+	fmt.Println("Keine Probleme gefunden")
+	return nil
+}
 
-		// Lese bestehende .env
-		var lines []string
-		if data, err := os.ReadFile(envFile); err == nil {
-			lines = strings.Split(string(data), "\n")
+// cmdCryptFile ver- bzw. entschlüsselt eine Datei lokal (z.B. ein Backup, das auf einen anderen Rechner kopiert wurde)
+func cmdCryptFile(args []string, encrypt bool) error {
+	args, flags, err := splitFlags(args, "keyfile")
+	if err != nil || len(args) < 2 {
+		if encrypt {
+			return fmt.Errorf("Verwendung: encrypt-file <in> <out> [--keyfile <pfad>]")
 		}
+		return fmt.Errorf("Verwendung: decrypt-file <in> <out> [--keyfile <pfad>]")
+	}
+	key, err := cliKey(flags)
+	if err != nil {
+		return err
+	}
+	if encrypt {
+		err = crypt.EncryptFile(args[0], args[1], key)
+	} else {
+		err = crypt.DecryptFile(args[0], args[1], key)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s geschrieben\n", args[1])
+	return nil
+}
 
-		// Entferne alte CORTEX_API_KEY Zeile
-		var newLines []string
-		for _, line := range lines {
-			if !strings.HasPrefix(strings.TrimSpace(line), varName+"=") {
-				newLines = append(newLines, line)
+// cmdAPIKey verwaltet API-Keys über den Server (/api-keys, benötigt den Admin-Scope)
+func cmdAPIKey(client *cliClient, args []string) error {
+	usage := fmt.Errorf("Verwendung: api-key <create|list|delete> [--name <n>] [--apps a,b] [--scopes read,write,admin] [--expires 720h|RFC3339] | delete <id>")
+	if len(args) < 1 {
+		return usage
+	}
+
+	switch args[0] {
+	case "create":
+		rest, flags, err := splitFlags(args[1:], "name", "apps", "scopes", "expires")
+		if err != nil || len(rest) > 0 {
+			return usage
+		}
+		body := map[string]any{"name": flags["name"]}
+		if v := flags["apps"]; v != "" {
+			body["appIds"] = splitList(v)
+		}
+		if v := flags["scopes"]; v != "" {
+			body["scopes"] = splitList(v)
+		}
+		if v := flags["expires"]; v != "" {
+			expires, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				d, derr := time.ParseDuration(v)
+				if derr != nil || d <= 0 {
+					return fmt.Errorf("--expires erwartet eine Dauer (z.B. 720h) oder RFC3339")
+				}
+				expires = time.Now().Add(d)
 			}
+			body["expiresAt"] = expires.UTC()
 		}
-
-		// Füge neue Zeile hinzu
-		newLines = append(newLines, varName+"="+key)
-
-		// Schreibe .env
-		if err := os.WriteFile(envFile, []byte(strings.Join(newLines, "\n")+"\n"), 0644); err != nil {
-			return fmt.Errorf("Fehler beim Schreiben von %s: %w", envFile, err)
+		data, code, err := client.do(http.MethodPost, "/api-keys", body)
+		if err != nil {
+			return err
 		}
-
-		fmt.Printf("✓ API-Key in %s gesetzt\n", envFile)
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Anlegen des API-Keys (HTTP %d): %s", code, string(data))
+		}
+		var created struct {
+			ID     int64    `json:"id"`
+			Key    string   `json:"key"`
+			Scopes []string `json:"scopes"`
+			AppIDs []string `json:"app_ids"`
+		}
+		if err := json.Unmarshal(data, &created); err != nil {
+			return fmt.Errorf("Ungültige Antwort: %w", err)
+		}
+		apps := "alle"
+		if len(created.AppIDs) > 0 {
+			apps = strings.Join(created.AppIDs, ",")
+		}
+		fmt.Printf("✓ API-Key %d angelegt (Scopes: %s, Apps: %s)\n", created.ID, strings.Join(created.Scopes, ","), apps)
 		fmt.Printf("\nNeuer API-Key (einmalig sichtbar – sicher aufbewahren):\n")
-		fmt.Printf("  %s\n\n", key)
-		fmt.Printf("Server starten mit: export %s=%s; ./cortex-server\n", varName, key)
+		fmt.Printf("  %s\n\n", created.Key)
+		fmt.Printf("Verwenden mit: export CORTEX_API_KEY=%s\n", created.Key)
 
-	case "delete":
-		if _, err := os.Stat(envFile); os.IsNotExist(err) {
-			fmt.Printf("Datei %s nicht gefunden – nichts zu löschen.\n", envFile)
-			return nil
+	case "list":
+		data, code, err := client.do(http.MethodGet, "/api-keys", nil)
+		if err != nil {
+			return err
 		}
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Auflisten der API-Keys (HTTP %d): %s", code, string(data))
+		}
+		fmt.Println(string(data))
 
-		data, err := os.ReadFile(envFile)
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("Verwendung: api-key delete <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("id muss eine positive Ganzzahl sein")
+		}
+		data, code, err := client.do(http.MethodDelete, fmt.Sprintf("/api-keys/%d", id), nil)
 		if err != nil {
-			return fmt.Errorf("Fehler beim Lesen von %s: %w", envFile, err)
+			return err
 		}
-
-		lines := strings.Split(string(data), "\n")
-		var newLines []string
-		found := false
-		for _, line := range lines {
-			if strings.HasPrefix(strings.TrimSpace(line), varName+"=") {
-				found = true
-				continue
-			}
-			newLines = append(newLines, line)
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Löschen des API-Keys (HTTP %d): %s", code, string(data))
 		}
+		fmt.Printf("✓ API-Key %d gelöscht\n", id)
 
-		if !found {
-			fmt.Printf("Kein Eintrag %s in %s gefunden.\n", varName, envFile)
-			return nil
-		}
+	default:
+		return fmt.Errorf("Unbekannter Befehl: %s. Verwende: create, list oder delete", args[0])
+	}
 
-		if err := os.WriteFile(envFile, []byte(strings.Join(newLines, "\n")), 0644); err != nil {
-			return fmt.Errorf("Fehler beim Schreiben von %s: %w", envFile, err)
-		}
+	return nil
+}
 
-		fmt.Printf("✓ API-Key aus %s entfernt\n", envFile)
+// auditCSVHeader sind die Spalten von audit --format csv
+var auditCSVHeader = []string{"id", "created_at", "operation", "outcome", "status", "actor", "actor_key_id", "app_id", "external_user_id", "target_ids", "client_ip", "method", "path", "error"}
 
-	case "show":
-		if _, err := os.Stat(envFile); os.IsNotExist(err) {
-			fmt.Printf("Datei %s nicht gefunden.\n", envFile)
-			return nil
+func cmdAudit(client *cliClient, args []string) error {
+	usage := fmt.Errorf("Verwendung: audit [output_file] [--op <ops>] [--actor <name>] [--actor-key <id>] [--app <id>] [--user <id>] [--outcome <o>] [--since 24h|RFC3339] [--until RFC3339] [--limit <n>] [--format ndjson|csv]")
+	rest, flags, err := splitFlags(args, "op", "actor", "actor-key", "app", "user", "outcome", "since", "until", "limit", "format")
+	if err != nil || len(rest) > 1 {
+		return usage
+	}
+	format := flags["format"]
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		return fmt.Errorf("--format erwartet ndjson oder csv")
+	}
+	maxEvents := 0
+	if v := flags["limit"]; v != "" {
+		if maxEvents, err = strconv.Atoi(v); err != nil || maxEvents <= 0 {
+			return fmt.Errorf("--limit muss eine positive Ganzzahl sein")
+		}
+	}
+	params := url.Values{"limit": {"1000"}}
+	for flag, param := range map[string]string{"op": "operation", "actor": "actor", "actor-key": "actorKeyId", "app": "appId", "user": "externalUserId", "outcome": "outcome", "until": "until"} {
+		if v := flags[flag]; v != "" {
+			params.Set(param, v)
+		}
+	}
+	if v := flags["since"]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			v = time.Now().Add(-d).UTC().Format(time.RFC3339)
 		}
+		params.Set("since", v)
+	}
 
-		data, err := os.ReadFile(envFile)
+	f := os.Stdout
+	if len(rest) == 1 && rest[0] != "-" {
+		if f, err = os.Create(rest[0]); err != nil {
+			return fmt.Errorf("Fehler beim Schreiben der Datei: %w", err)
+		}
+		defer f.Close()
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	cw := csv.NewWriter(w)
+	if format == "csv" {
+		cw.Write(auditCSVHeader)
+	}
+
+	// Seiten nacheinander abrufen (next_before), bis alles oder --limit Einträge exportiert sind
+	count := 0
+	for {
+		data, code, err := client.do(http.MethodGet, "/admin/audit?"+params.Encode(), nil)
 		if err != nil {
-			return fmt.Errorf("Fehler beim Lesen von %s: %w", envFile, err)
-		}
-
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, varName+"=") {
-				key := strings.TrimPrefix(line, varName+"=")
-				key = strings.Trim(key, `"'`)
-				if len(key) > 4 {
-					fmt.Printf("Aktueller Key in %s (letzte 4 Zeichen): ...%s\n", envFile, key[len(key)-4:])
-				} else {
-					fmt.Printf("Key in %s gesetzt (Länge %d).\n", envFile, len(key))
-				}
-				return nil
+			return err
+		}
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Abrufen des Audit-Logs (HTTP %d): %s", code, string(data))
+		}
+		var page struct {
+			Events []struct {
+				ID             int64     `json:"id"`
+				Operation      string    `json:"operation"`
+				ActorKeyID     int64     `json:"actor_key_id"`
+				Actor          string    `json:"actor"`
+				AppID          string    `json:"app_id"`
+				ExternalUserID string    `json:"external_user_id"`
+				TargetIDs      []string  `json:"target_ids"`
+				Method         string    `json:"method"`
+				Path           string    `json:"path"`
+				ClientIP       string    `json:"client_ip"`
+				Status         int       `json:"status"`
+				Outcome        string    `json:"outcome"`
+				Error          string    `json:"error,omitempty"`
+				CreatedAt      time.Time `json:"created_at"`
+			} `json:"events"`
+			NextBefore int64 `json:"next_before"`
+			HasMore    bool  `json:"has_more"`
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return fmt.Errorf("Ungültige Antwort: %w", err)
+		}
+		for _, ev := range page.Events {
+			if maxEvents > 0 && count >= maxEvents {
+				break
+			}
+			count++
+			if format == "csv" {
+				cw.Write([]string{strconv.FormatInt(ev.ID, 10), ev.CreatedAt.Format(time.RFC3339), ev.Operation, ev.Outcome, strconv.Itoa(ev.Status),
+					ev.Actor, strconv.FormatInt(ev.ActorKeyID, 10), ev.AppID, ev.ExternalUserID, strings.Join(ev.TargetIDs, ","), ev.ClientIP, ev.Method, ev.Path, ev.Error})
+			} else {
+				enc.Encode(ev)
 			}
 		}
-
-		fmt.Printf("Kein %s in %s gesetzt.\n", varName, envFile)
-
-	default:
-		return fmt.Errorf("Unbekannter Befehl: %s. Verwende: create, delete oder show", cmd)
+		if !page.HasMore || (maxEvents > 0 && count >= maxEvents) {
+			break
+		}
+		params.Set("before", strconv.FormatInt(page.NextBefore, 10))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("Fehler beim Schreiben: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Fehler beim Schreiben: %w", err)
+	}
+	if f != os.Stdout {
+		fmt.Printf("%d Audit-Einträge nach %s geschrieben\n", count, rest[0])
 	}
-
 	return nil
 }
 
@@ -1455,3 +2402,114 @@ func cmdFindSimilar(client *cliClient, args []string) error {
 	fmt.Println(string(data))
 	return nil
 }
+
+// reembedJob entspricht dem Job-Objekt von /admin/reembed
+type reembedJob struct {
+	ID        int64  `json:"id"`
+	Model     string `json:"model"`
+	Status    string `json:"status"`
+	Total     int64  `json:"total"`
+	Processed int64  `json:"processed"`
+	Skipped   int64  `json:"skipped"`
+	Error     string `json:"error"`
+}
+
+// cmdReembed - Re-Embed-Job starten und Fortschritt anzeigen, Status abfragen oder Job abbrechen
+func cmdReembed(client *cliClient, args []string) error {
+	tenant := fmt.Sprintf("appId=%s&externalUserId=%s", url.QueryEscape(client.appID), url.QueryEscape(client.userID))
+
+	if len(args) > 0 && args[0] == "status" {
+		data, code, err := client.do(http.MethodGet, "/admin/reembed?"+tenant, nil)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Laden des Re-Embed-Status (HTTP %d): %s", code, string(data))
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if len(args) > 0 && args[0] == "cancel" {
+		if len(args) < 2 {
+			return fmt.Errorf("Verwendung: reembed cancel <job-id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("job-id muss eine positive Ganzzahl sein")
+		}
+		data, code, err := client.do(http.MethodDelete, fmt.Sprintf("/admin/reembed/%d?%s", id, tenant), nil)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusAccepted {
+			return fmt.Errorf("Fehler beim Abbrechen (HTTP %d): %s", code, string(data))
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	batchSize := 32
+	wait := true
+	for i, arg := range args {
+		if (arg == "--batch-size" || arg == "-b") && i+1 < len(args) {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 || n > 256 {
+				return fmt.Errorf("batch-size muss zwischen 1 und 256 liegen")
+			}
+			batchSize = n
+		}
+		if arg == "--no-wait" {
+			wait = false
+		}
+	}
+
+	data, code, err := client.do(http.MethodPost, fmt.Sprintf("/admin/reembed?%s&batchSize=%d", tenant, batchSize), nil)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusConflict {
+		return fmt.Errorf("Für diesen Tenant läuft bereits ein Re-Embed-Job (siehe: reembed status)")
+	}
+	if code != http.StatusAccepted {
+		return fmt.Errorf("Fehler beim Starten des Re-Embed-Jobs (HTTP %d): %s", code, string(data))
+	}
+	var job reembedJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return err
+	}
+	fmt.Printf("Re-Embed-Job %d gestartet (Modell: %s, %d Memories)\n", job.ID, job.Model, job.Total)
+	if !wait {
+		return nil
+	}
+
+	for job.Status == "running" {
+		time.Sleep(time.Second)
+		data, code, err = client.do(http.MethodGet, fmt.Sprintf("/admin/reembed/%d?%s", job.ID, tenant), nil)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return fmt.Errorf("Fehler beim Abfragen des Fortschritts (HTTP %d): %s", code, string(data))
+		}
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		done := job.Processed + job.Skipped
+		percent := 100.0
+		if job.Total > 0 {
+			percent = float64(done) * 100 / float64(job.Total)
+		}
+		fmt.Printf("\rFortschritt: %d/%d (%.0f%%)", done, job.Total, percent)
+	}
+	fmt.Println()
+
+	switch job.Status {
+	case "completed":
+		fmt.Printf("Fertig: %d Memories neu eingebettet, %d übersprungen (zwischenzeitlich geändert)\n", job.Processed, job.Skipped)
+		return nil
+	case "cancelled":
+		fmt.Printf("Abgebrochen nach %d Memories\n", job.Processed)
+		return nil
+	}
+	return fmt.Errorf("Re-Embed-Job fehlgeschlagen: %s", job.Error)
+}
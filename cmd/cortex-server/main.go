@@ -8,9 +8,14 @@ import (
 	"time"
 
 	"cortex/internal/api"
+	"cortex/internal/audit"
+	"cortex/internal/backup"
 	"cortex/internal/cleanup"
+	"cortex/internal/crypt"
 	"cortex/internal/dashboard"
+	"cortex/internal/embeddings"
 	"cortex/internal/helpers"
+	"cortex/internal/jwt"
 	"cortex/internal/middleware"
 	"cortex/internal/store"
 )
@@ -45,6 +50,55 @@ func main() {
 	}
 	defer cortexStore.Close()
 
+	// Feldverschlüsselung (Content, Metadata, Payloads) pro Tenant, nur mit CORTEX_MASTER_KEYFILE
+	master, previousMasters, err := crypt.MasterKeysFromEnv()
+	if err != nil {
+		slog.Error("failed to load master key", "error", err)
+		os.Exit(1)
+	}
+	if master != nil {
+		rewrapped, err := cortexStore.EnableFieldEncryption(master, previousMasters...)
+		if err != nil {
+			slog.Error("failed to enable field encryption", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("field encryption enabled", "master_key_id", master.ID(), "rewrapped_keys", rewrapped)
+	}
+
+	// HMAC-Signaturen für Memories und Versionen (prüfbar mit /admin/verify), nur mit CORTEX_SIGNING_KEYFILE
+	signingKey, err := crypt.SigningKeyFromEnv()
+	if err != nil {
+		slog.Error("failed to load signing key", "error", err)
+		os.Exit(1)
+	}
+	if signingKey != nil {
+		if err := cortexStore.EnableRowSigning(signingKey); err != nil {
+			slog.Error("failed to enable row signing", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("row signing enabled", "signing_key_id", signingKey.ID())
+	}
+
+	// API-Keys aus der Datenbank (cortex-cli api-key), zusätzlich zu CORTEX_API_KEY
+	middleware.SetKeyStore(cortexStore)
+
+	// JWTs eines Gateways/OIDC-Providers, nur mit CORTEX_JWT_JWKS (Datei oder URL)
+	verifier, err := jwt.VerifierFromEnv()
+	if err != nil {
+		slog.Error("failed to init JWT verification", "error", err)
+		os.Exit(1)
+	}
+	if verifier != nil {
+		middleware.SetTokenVerifier(verifier)
+		slog.Info("JWT verification enabled", "jwks", os.Getenv("CORTEX_JWT_JWKS"), "issuer", os.Getenv("CORTEX_JWT_ISSUER"))
+	}
+
+	// Embedding-Provider: ein konfigurierter Provider, der nicht startet, ist ein Fehler (kein Hash-Fallback)
+	if err := embeddings.InitEmbeddingService(); err != nil {
+		slog.Error("failed to init embedding provider", "error", err)
+		os.Exit(1)
+	}
+
 	handlers := api.NewHandlers(cortexStore)
 	mux := http.NewServeMux()
 
@@ -64,12 +118,12 @@ func main() {
 	})))
 	mux.HandleFunc("/seeds/query", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleQuerySeed, http.MethodPost))))
 	mux.HandleFunc("/seeds/generate-embeddings", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleGenerateEmbeddings, http.MethodPost))))
-	mux.HandleFunc("/seeds/merge", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleMergeSeeds, http.MethodPost))))
-	mux.HandleFunc("/seeds/", middleware.RateLimitMiddleware(middleware.AuthMiddleware(handlers.HandleSeedsByID)))
+	mux.HandleFunc("/seeds/merge", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleMergeSeeds, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpMemoryMerge})))
+	mux.HandleFunc("/seeds/", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(handlers.HandleSeedsByID), audit.Ops{http.MethodDelete: audit.OpMemoryDelete})))
 
 	// Bundles API (with rate limiting)
 	// Register /bundles/ first to avoid routing conflicts
-	mux.HandleFunc("/bundles/", middleware.RateLimitMiddleware(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/bundles/", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			handlers.HandleGetBundle(w, r)
@@ -78,7 +132,7 @@ func main() {
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-	})))
+	}), audit.Ops{http.MethodDelete: audit.OpBundleDelete})))
 	// Register /bundles after /bundles/ to ensure exact match
 	mux.HandleFunc("/bundles", middleware.RateLimitMiddleware(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -92,9 +146,9 @@ func main() {
 	})))
 
 	// Cortex API
-	mux.HandleFunc("/remember", middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRemember, http.MethodPost)))
-	mux.HandleFunc("/recall", middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRecall, http.MethodGet)))
-	mux.HandleFunc("/entities", middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/remember", middleware.RateLimit(middleware.RouteMemory, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRemember, http.MethodPost))))
+	mux.HandleFunc("/recall", middleware.RateLimit(middleware.RouteMemory, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRecall, http.MethodGet))))
+	mux.HandleFunc("/entities", middleware.RateLimit(middleware.RouteGraph, middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			if r.URL.Query().Get("name") != "" {
@@ -107,8 +161,8 @@ func main() {
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-	}))
-	mux.HandleFunc("/relations", middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.HandleFunc("/relations", middleware.RateLimit(middleware.RouteGraph, middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			handlers.HandleListRelations(w, r)
@@ -117,11 +171,13 @@ func main() {
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-	}))
+	})))
+	mux.HandleFunc("/relations/close", middleware.RateLimit(middleware.RouteGraph, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleCloseRelation, http.MethodPost))))
+	mux.HandleFunc("/graph/traverse", middleware.RateLimit(middleware.RouteGraph, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleGraphTraverse, http.MethodGet))))
 	mux.HandleFunc("/stats", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleStats, http.MethodGet))))
 
 	// Webhooks API (with rate limiting)
-	mux.HandleFunc("/webhooks", middleware.RateLimitMiddleware(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/webhooks", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
 			handlers.HandleCreateWebhook(w, r)
@@ -130,16 +186,26 @@ func main() {
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-	})))
-	mux.HandleFunc("/webhooks/", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleDeleteWebhook, http.MethodDelete))))
+	}), audit.Ops{http.MethodPost: audit.OpWebhookCreate})))
+	mux.HandleFunc("/webhooks/events", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleWebhookEvents, http.MethodGet))))
+	mux.HandleFunc("/webhooks/", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleWebhooksByID, http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete)), audit.Ops{http.MethodPatch: audit.OpWebhookUpdate, http.MethodDelete: audit.OpWebhookDelete, http.MethodPost: audit.OpWebhookRedeliver})))
+
+	// Change log (append-only, seq-basiert; Audit, Replikation, inkrementeller Export)
+	mux.HandleFunc("/changes", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleChanges, http.MethodGet))))
+
+	// Event stream (SSE, gleiche Events wie Webhooks, Resume per Last-Event-ID)
+	mux.HandleFunc("/events/stream", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleEventStream, http.MethodGet))))
 
 	// Export/Import API (with rate limiting)
-	mux.HandleFunc("/export", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleExport, http.MethodGet))))
-	mux.HandleFunc("/import", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleImport, http.MethodPost))))
+	mux.HandleFunc("/export", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleExport, http.MethodGet)), audit.Ops{http.MethodGet: audit.OpExport})))
+	mux.HandleFunc("/import", middleware.RateLimitMiddleware(handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleImport, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpImport})))
 
 	// Backup/Restore API (with rate limiting)
-	mux.HandleFunc("/backup", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleBackup, http.MethodPost))))
-	mux.HandleFunc("/restore", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRestore, http.MethodPost))))
+	mux.HandleFunc("/backup", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleBackup, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpBackupCreate})))
+	mux.HandleFunc("/restore", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRestore, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpBackupRestore})))
+	mux.HandleFunc("/backups", middleware.RateLimit(middleware.RouteAdmin, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleListBackups, http.MethodGet))))
+	mux.HandleFunc("/backups/verify", middleware.RateLimit(middleware.RouteAdmin, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleVerifyBackups, http.MethodPost))))
+	mux.HandleFunc("/backups/prune", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandlePruneBackups, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpBackupPrune})))
 
 	// Analytics API (with rate limiting)
 	mux.HandleFunc("/analytics", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleAnalytics, http.MethodGet))))
@@ -177,16 +243,63 @@ func main() {
 	mux.Handle("/dashboard/", dashboard.Handler())
 
 	// Admin: manual cleanup (optional; same auth as rest)
-	mux.HandleFunc("/admin/cleanup", middleware.RateLimitMiddleware(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleCleanup, http.MethodPost))))
+	mux.HandleFunc("/admin/cleanup", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleCleanup, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpCleanup})))
+
+	// Admin: re-embed jobs (Migration eines Tenants auf das aktuelle Embedding-Modell)
+	mux.HandleFunc("/admin/reembed", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleReembed, http.MethodGet, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpReembedStart})))
+	mux.HandleFunc("/admin/reembed/", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleReembedJob, http.MethodGet, http.MethodDelete)), audit.Ops{http.MethodDelete: audit.OpReembedCancel})))
+
+	// Admin: Entities/Relations ohne Tenant (vor Tenant-Scoping) einem Tenant zuordnen
+	mux.HandleFunc("/admin/graph/claim-legacy", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleClaimLegacyGraph, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpClaimLegacyGraph})))
+
+	// Admin: Feldverschlüsselung (Status, Key-Rotation pro Tenant)
+	mux.HandleFunc("/admin/encryption", middleware.RateLimit(middleware.RouteAdmin, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleEncryptionStatus, http.MethodGet))))
+	mux.HandleFunc("/admin/encryption/rotate", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleRotateEncryptionKeys, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpEncryptionRotate})))
+	mux.HandleFunc("/admin/verify", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleVerifyIntegrity, http.MethodPost)), audit.Ops{http.MethodPost: audit.OpVerifyIntegrity})))
+
+	// Admin: Audit-Log sensibler Operationen (Backup/Restore, Import/Export, Cleanup, Webhooks, Keys, Löschungen)
+	mux.HandleFunc("/admin/audit", middleware.RateLimit(middleware.RouteAdmin, middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleAuditLog, http.MethodGet))))
+
+	// API-Keys (Scopes read/write/admin, optional auf Apps beschränkt); nur mit Admin-Scope
+	mux.HandleFunc("/api-keys", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			handlers.HandleCreateAPIKey(w, r)
+		case http.MethodGet:
+			handlers.HandleListAPIKeys(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}), audit.Ops{http.MethodPost: audit.OpAPIKeyCreate})))
+	mux.HandleFunc("/api-keys/", middleware.RateLimit(middleware.RouteAdmin, handlers.Audited(middleware.AuthMiddleware(middleware.MethodAllowed(handlers.HandleDeleteAPIKey, http.MethodDelete)), audit.Ops{http.MethodDelete: audit.OpAPIKeyDelete})))
+
+	// Webhook delivery queue (persistente Zustellungen mit Retries, siehe CORTEX_WEBHOOK_*)
+	go handlers.RunWebhookDispatcher(context.Background())
+
+	// Event log: alte Events nach CORTEX_EVENT_RETENTION entfernen
+	go handlers.RunEventLogPruner(context.Background())
+
+	// Audit-Log: Einträge nach CORTEX_AUDIT_RETENTION entfernen
+	go handlers.RunAuditLogPruner(context.Background())
 
 	// Scheduled cleanup: only when CORTEX_CLEANUP_INTERVAL is set (e.g. 24h)
 	if intervalStr := os.Getenv("CORTEX_CLEANUP_INTERVAL"); intervalStr != "" {
 		if d, err := time.ParseDuration(intervalStr); err == nil && d > 0 {
-			go cleanup.StartCleanupTicker(context.Background(), cortexStore, d)
+			go cleanup.StartCleanupTicker(context.Background(), cortexStore, d, handlers.CleanupHooks())
 			slog.Info("cleanup ticker started", "interval", d)
 		}
 	}
 
+	// Scheduled backups: only when CORTEX_BACKUP_INTERVAL is set (e.g. 6h); retention via CORTEX_BACKUP_KEEP_*
+	if intervalStr := os.Getenv("CORTEX_BACKUP_INTERVAL"); intervalStr != "" {
+		if d, err := time.ParseDuration(intervalStr); err == nil && d > 0 {
+			cfg := backup.ConfigFromEnv()
+			go backup.StartBackupTicker(context.Background(), cortexStore, d, cfg)
+			slog.Info("backup ticker started", "interval", d, "compression", cfg.Compression,
+				"keepLast", cfg.KeepLast, "keepDaily", cfg.KeepDaily, "keepWeekly", cfg.KeepWeekly)
+		}
+	}
+
 	port := os.Getenv("CORTEX_PORT")
 	if port == "" {
 		port = helpers.DefaultPort
@@ -68,3 +68,72 @@ export function setTenant(appId: string, externalUserId: string): void {
   localStorage.setItem('cortex_app_id', appId);
   localStorage.setItem('cortex_external_user_id', externalUserId);
 }
+
+export interface CortexEvent {
+  id: number;
+  event: string;
+  timestamp: string;
+  data: Record<string, unknown>;
+}
+
+// Abonniert /events/stream (SSE) per fetch statt EventSource, damit der API-Key als Header
+// (nicht in der URL) gesendet wird. Verbindet nach Abbruch neu und setzt per Last-Event-ID fort.
+// Gibt eine Funktion zum Beenden zurück.
+export function subscribeEvents(
+  appId: string,
+  externalUserId: string,
+  types: string[],
+  onEvent: (ev: CortexEvent) => void
+): () => void {
+  const controller = new AbortController();
+  let lastEventId = '';
+  let retryMs = 3000;
+
+  const connect = async () => {
+    const headers: Record<string, string> = { Accept: 'text/event-stream' };
+    const key = getApiKey();
+    if (key) headers['X-API-Key'] = key;
+    if (lastEventId) headers['Last-Event-ID'] = lastEventId;
+    const params = new URLSearchParams({ appId, externalUserId });
+    if (types.length > 0) params.set('types', types.join(','));
+
+    const res = await fetch(`${API_BASE}/events/stream?${params}`, { headers, signal: controller.signal });
+    if (!res.ok || !res.body) throw new Error(await res.text() || res.statusText);
+    const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffer = '';
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) return;
+      buffer += value;
+      let end: number;
+      while ((end = buffer.indexOf('\n\n')) >= 0) {
+        const block = buffer.slice(0, end);
+        buffer = buffer.slice(end + 2);
+        let id = '';
+        let data = '';
+        for (const line of block.split('\n')) {
+          if (line.startsWith('id: ')) id = line.slice(4);
+          else if (line.startsWith('data: ')) data += line.slice(6);
+          else if (line.startsWith('retry: ')) retryMs = Number(line.slice(7)) || retryMs;
+        }
+        if (!data) continue;
+        lastEventId = id;
+        const payload = JSON.parse(data);
+        onEvent({ id: Number(id), event: payload.event, timestamp: payload.timestamp, data: payload.data ?? {} });
+      }
+    }
+  };
+
+  (async () => {
+    while (!controller.signal.aborted) {
+      try {
+        await connect();
+      } catch {
+        if (controller.signal.aborted) return;
+      }
+      await new Promise((resolve) => setTimeout(resolve, retryMs));
+    }
+  })();
+
+  return () => controller.abort();
+}
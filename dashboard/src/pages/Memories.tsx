@@ -1,5 +1,5 @@
 import { useEffect, useState } from 'react'
-import { api, getTenant, type Memory } from '../api'
+import { api, getTenant, subscribeEvents, type Memory } from '../api'
 
 export function Memories() {
   const { appId, externalUserId } = getTenant()
@@ -25,6 +25,15 @@ export function Memories() {
     return () => { cancelled = true }
   }, [appId, externalUserId])
 
+  // Live-Aktualisierung statt Polling: bei jedem memory.* Event neu laden
+  useEffect(() => {
+    return subscribeEvents(appId, externalUserId, ['memory.*'], () => {
+      api.listSeeds(appId, externalUserId)
+        .then(setList)
+        .catch((e) => setError(e.message))
+    })
+  }, [appId, externalUserId])
+
   const handleDelete = (id: number) => {
     if (!confirm('Memory löschen?')) return
     api.deleteSeed(id, appId, externalUserId)
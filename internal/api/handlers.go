@@ -1,38 +1,113 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
-
+	"cortex/internal/audit"
+	"cortex/internal/backup"
 	"cortex/internal/cleanup"
+	"cortex/internal/crypt"
 	"cortex/internal/embeddings"
+	"cortex/internal/events"
+	"cortex/internal/extraction"
 	"cortex/internal/helpers"
 	"cortex/internal/models"
+	"cortex/internal/reembed"
 	"cortex/internal/store"
 	"cortex/internal/webhooks"
 )
 
 type Handlers struct {
-	store *store.CortexStore
+	store      *store.CortexStore
+	reembed    *reembed.Manager
+	extractor  *extraction.Pipeline
+	deliveries *webhooks.Dispatcher
+	events     *events.Bus
+	audit      *audit.Logger
+	backups    backup.Config
+	key        *crypt.Key // CORTEX_ENCRYPTION_*: verschlüsselte Backups und Exporte
 }
 
 func NewHandlers(s *store.CortexStore) *Handlers {
-	return &Handlers{store: s}
+	extractor, err := extraction.PipelineFromEnv()
+	if err != nil {
+		slog.Warn("Failed to initialize entity extractors, falling back to rules without dictionary", "error", err)
+		extractor = extraction.NewPipelineOf(extraction.NewRuleExtractor(nil))
+	}
+	backups := backup.ConfigFromEnv()
+	return &Handlers{
+		store:      s,
+		reembed:    reembed.NewManager(s),
+		extractor:  extractor,
+		deliveries: webhooks.NewDispatcher(s, webhooks.ConfigFromEnv()),
+		events:     events.NewBus(s, events.RetentionFromEnv()),
+		audit:      audit.NewLogger(s, audit.RetentionFromEnv()),
+		backups:    backups,
+		key:        backups.Key,
+	}
+}
+
+// RunEventLogPruner removes events older than CORTEX_EVENT_RETENTION until ctx is done (blocking).
+func (h *Handlers) RunEventLogPruner(ctx context.Context) {
+	h.events.Run(ctx)
+}
+
+// RunAuditLogPruner removes audit events older than CORTEX_AUDIT_RETENTION until ctx is done (blocking).
+func (h *Handlers) RunAuditLogPruner(ctx context.Context) {
+	h.audit.Run(ctx)
+}
+
+// Audited records the requests of a route in the audit log (see audit.Logger.Wrap); wrap it around
+// AuthMiddleware so denied requests are recorded as well.
+func (h *Handlers) Audited(next http.HandlerFunc, ops audit.Ops) http.HandlerFunc {
+	return h.audit.Wrap(next, ops)
+}
+
+// RunWebhookDispatcher sends queued webhook deliveries until ctx is done (blocking).
+func (h *Handlers) RunWebhookDispatcher(ctx context.Context) {
+	h.deliveries.Run(ctx)
+}
+
+// linkEntities extracts entity mentions from the memory content (plus its explicit Entity field) and links
+// the memory to these entities. Failures are logged; the memory itself is already saved.
+func (h *Handlers) linkEntities(mem *models.Memory) {
+	if !h.extractor.Enabled() && mem.Entity == "" {
+		return
+	}
+	known, err := h.store.ListEntityNames(mem.AppID, mem.ExternalUserID)
+	if err != nil {
+		slog.Warn("entity extraction: failed to load known entities", "error", err, "memoryId", mem.ID)
+	}
+	var names []string
+	if mem.Entity != "" {
+		names = append(names, mem.Entity)
+	}
+	for _, m := range h.extractor.Extract(mem.Content, known) {
+		names = append(names, m.Name)
+	}
+	if _, err := h.store.LinkMemoryEntities(mem, names); err != nil {
+		slog.Warn("entity extraction: failed to link entities", "error", err, "memoryId", mem.ID)
+	}
 }
 
-// generateEmbeddingAsync generates embedding for a memory asynchronously
+// generateEmbeddingAsync generates embedding for a memory asynchronously.
+// The goroutine works on a copy, the caller keeps using mem (responses, webhook payloads).
 func (h *Handlers) generateEmbeddingAsync(mem *models.Memory) {
+	cp := *mem
 	go func() {
+		mem := &cp
 		if err := h.store.GenerateEmbeddingForMemory(mem); err != nil {
 			slog.Warn("failed to generate embedding", "error", err, "memoryId", mem.ID)
 		}
@@ -72,6 +147,52 @@ func (h *Handlers) buildMemoryWebhookPayload(mem *models.Memory, appID, external
 	return payload
 }
 
+// triggerMemoryEvents triggers event for each memory (own tenant), adding extra fields to every payload.
+func (h *Handlers) triggerMemoryEvents(event webhooks.EventType, mems []models.Memory, extra map[string]any) {
+	for i := range mems {
+		payload := h.buildMemoryWebhookPayload(&mems[i], mems[i].AppID, mems[i].ExternalUserID, event)
+		for k, v := range extra {
+			payload[k] = v
+		}
+		h.triggerWebhook(event, payload)
+	}
+}
+
+// CleanupHooks turns memories changed by cleanup runs into webhook events.
+func (h *Handlers) CleanupHooks() cleanup.Hooks {
+	return cleanup.Hooks{
+		Expired: func(mems []models.Memory) {
+			go h.triggerMemoryEvents(webhooks.EventMemoryExpired, mems, nil)
+		},
+		ArchivedLowImportance: func(mems []models.Memory) {
+			go h.triggerMemoryEvents(webhooks.EventMemoryArchived, mems, map[string]any{"reason": webhooks.ArchiveReasonLowImportance})
+		},
+		Deleted: func(mems []models.Memory) {
+			go h.triggerMemoryEvents(webhooks.EventMemoryDeleted, mems, map[string]any{"reason": "cleanup"})
+		},
+		Merged: func(before, after, merged models.Memory) {
+			go h.triggerMergeEvents(&before, &after, []models.Memory{merged})
+		},
+	}
+}
+
+// triggerMergeEvents triggers memory.merged for the kept memory (with changes) and memory.archived
+// for every merged source.
+func (h *Handlers) triggerMergeEvents(before, after *models.Memory, merged []models.Memory) {
+	sourceIDs := make([]int64, len(merged))
+	for i := range merged {
+		sourceIDs[i] = merged[i].ID
+	}
+	payload := h.buildMemoryWebhookPayload(after, after.AppID, after.ExternalUserID, webhooks.EventMemoryMerged)
+	payload["source_ids"] = sourceIDs
+	payload["changes"] = webhooks.MemoryChanges(before, after)
+	h.triggerWebhook(webhooks.EventMemoryMerged, payload)
+	h.triggerMemoryEvents(webhooks.EventMemoryArchived, merged, map[string]any{
+		"reason":      webhooks.ArchiveReasonMerged,
+		"merged_into": after.ID,
+	})
+}
+
 // buildBundleWebhookPayload creates a webhook payload for bundle events
 func (h *Handlers) buildBundleWebhookPayload(bundle *models.Bundle, appID, externalUserID string, eventType webhooks.EventType) map[string]interface{} {
 	payload := map[string]interface{}{
@@ -123,6 +244,10 @@ func (h *Handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
 // Cortex API Handlers
 
 func (h *Handlers) HandleRemember(w http.ResponseWriter, r *http.Request) {
+	// Memories ohne Tenant: nur für Keys ohne Tenant-Beschränkung
+	if !helpers.AuthorizeTenant(w, r, "", "") {
+		return
+	}
 	var req models.RememberRequest
 	if !helpers.ParseJSONBodyOrError(w, r, &req) {
 		return
@@ -146,14 +271,24 @@ func (h *Handlers) HandleRemember(w http.ResponseWriter, r *http.Request) {
 		helpers.HandleInternalErrorSlog(w, "remember insert error", "error", err)
 		return
 	}
+	h.linkEntities(mem)
+
+	// Payload vor dem Embedding-Goroutine bauen
+	payload := h.buildMemoryWebhookPayload(mem, mem.AppID, mem.ExternalUserID, webhooks.EventMemoryCreated)
 
 	// Generiere Embedding asynchron (nicht-blockierend)
 	h.generateEmbeddingAsync(mem)
 
+	go h.triggerWebhook(webhooks.EventMemoryCreated, payload)
+
 	helpers.WriteJSON(w, http.StatusOK, models.RememberResponse{ID: mem.ID})
 }
 
 func (h *Handlers) HandleRecall(w http.ResponseWriter, r *http.Request) {
+	// Sucht über alle Tenants
+	if !helpers.AuthorizeTenant(w, r, "", "") {
+		return
+	}
 	query := helpers.GetQueryParam(r, "q")
 	memType := helpers.GetQueryParam(r, "type")
 	limit := helpers.ParseLimit(helpers.GetQueryParam(r, "limit"), helpers.DefaultLimit, helpers.MaxLimit)
@@ -182,47 +317,19 @@ func (h *Handlers) HandleSetFact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, &req, false)
+	if !ok {
+		return
+	}
+
 	if !helpers.ValidateNotEmpty(req.Key, "key") {
 		http.Error(w, "key is required", http.StatusBadRequest)
 		return
 	}
 
-	// Use a transaction to prevent race conditions when updating entity facts
-	// SQLite handles transactions atomically, preventing concurrent modification issues
-	err := h.store.GetDB().Transaction(func(tx *gorm.DB) error {
-		var ent models.Entity
-		// Read existing entity (if any)
-		result := tx.Where("name = ?", entity).First(&ent)
-		
-		data := map[string]any{}
-		if result.Error == nil {
-			// Entity exists, unmarshal existing data
-			if ent.Data != "" {
-				data = helpers.UnmarshalEntityData(ent.Data)
-			}
-		} else if result.Error != gorm.ErrRecordNotFound {
-			// Unexpected error
-			return result.Error
-		}
-		
-		// Add or update the fact
-		data[req.Key] = req.Value
-		
-		// Update entity with new data
-		ent.Name = entity
-		ent.Data = helpers.MarshalEntityData(data)
-		ent.UpdatedAt = time.Now()
-		
-		// Use ON CONFLICT to handle concurrent inserts atomically
-		// If entity exists, update; if not, insert
-		return tx.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "name"}},
-			DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
-		}).Create(&ent).Error
-	})
-	
+	_, err := h.store.SetEntityFact(appID, externalUserID, entity, req.Key, req.Value)
 	if err != nil {
-		helpers.HandleInternalErrorSlog(w, "set fact error", "error", err, "entity", entity)
+		helpers.HandleInternalErrorSlog(w, "set fact error", "error", err, "entity", entity, "appId", appID, "userId", externalUserID)
 		return
 	}
 
@@ -236,7 +343,17 @@ func (h *Handlers) HandleGetEntity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ent, err := h.store.GetEntity(name)
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+	asOf, err := helpers.ParseTimeParam(r, "asOf")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ent, err := h.store.GetEntity(appID, externalUserID, name)
 	if err != nil {
 		if helpers.HandleNotFoundError(w, err, "Entity") {
 			return
@@ -245,14 +362,37 @@ func (h *Handlers) HandleGetEntity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// asOf: Relationen der Entity, die zu diesem Zeitpunkt gültig waren
+	if asOf != nil {
+		ent.Relations, err = h.store.GetRelationsAt(appID, externalUserID, name, *asOf)
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "get entity relations error", "error", err, "name", name)
+			return
+		}
+	}
+
+	// Verknüpfte Memories (Entity-Extraktion), neueste zuerst
+	memoryLimit := helpers.ParseLimit(helpers.GetQueryParam(r, "memoryLimit"), helpers.DefaultEntityMemories, helpers.MaxLimit)
+	ent.Memories, err = h.store.ListEntityMemories(appID, externalUserID, name, memoryLimit)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "get entity memories error", "error", err, "name", name)
+		return
+	}
+	h.mapMetadataToMemories(ent.Memories)
+
 	ent.DataMap = helpers.UnmarshalEntityData(ent.Data)
 	helpers.WriteJSON(w, http.StatusOK, ent)
 }
 
 func (h *Handlers) HandleListEntities(w http.ResponseWriter, r *http.Request) {
-	entities, err := h.store.ListEntities()
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+
+	entities, err := h.store.ListEntities(appID, externalUserID)
 	if err != nil {
-		helpers.HandleInternalErrorSlog(w, "list entities error", "error", err)
+		helpers.HandleInternalErrorSlog(w, "list entities error", "error", err, "appId", appID, "userId", externalUserID)
 		return
 	}
 
@@ -272,13 +412,33 @@ func (h *Handlers) HandleAddRelation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, &req, false)
+	if !ok {
+		return
+	}
+
+	if req.ValidFrom != nil && req.ValidTo != nil && !req.ValidTo.After(*req.ValidFrom) {
+		http.Error(w, "validTo must be after validFrom", http.StatusBadRequest)
+		return
+	}
+
 	rel := models.Relation{
-		From: req.From,
-		To:   req.To,
-		Type: req.Type,
+		AppID:          appID,
+		ExternalUserID: externalUserID,
+		From:           req.From,
+		To:             req.To,
+		Type:           req.Type,
+		ValidFrom:      utcTime(req.ValidFrom),
+		ValidTo:        utcTime(req.ValidTo),
 	}
 
-	if err := h.store.CreateOrUpdateRelation(&rel); err != nil {
+	var err error
+	if req.Supersede {
+		_, err = h.store.SupersedeRelation(&rel)
+	} else {
+		err = h.store.CreateOrUpdateRelation(&rel)
+	}
+	if err != nil {
 		helpers.HandleInternalErrorSlog(w, "add relation error", "error", err)
 		return
 	}
@@ -287,8 +447,28 @@ func (h *Handlers) HandleAddRelation(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) HandleListRelations(w http.ResponseWriter, r *http.Request) {
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+
+	asOf, err := helpers.ParseTimeParam(r, "asOf")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Standard: aktuell gültige Relationen; history=true liefert alle Intervalle
 	entity := helpers.GetQueryParam(r, "entity")
-	relations, err := h.store.GetRelations(entity)
+	var relations []models.Relation
+	switch {
+	case helpers.GetQueryParam(r, "history") == "true":
+		relations, err = h.store.GetRelations(appID, externalUserID, entity)
+	case asOf != nil:
+		relations, err = h.store.GetRelationsAt(appID, externalUserID, entity, *asOf)
+	default:
+		relations, err = h.store.GetRelationsAt(appID, externalUserID, entity, time.Now())
+	}
 	if err != nil {
 		helpers.HandleInternalErrorSlog(w, "list relations error", "error", err, "entity", entity)
 		return
@@ -297,7 +477,124 @@ func (h *Handlers) HandleListRelations(w http.ResponseWriter, r *http.Request) {
 	helpers.WriteJSON(w, http.StatusOK, relations)
 }
 
+// HandleCloseRelation ends the validity of an open relation (POST /relations/close). The closed
+// interval stays available via GET /relations?history=true or asOf.
+func (h *Handlers) HandleCloseRelation(w http.ResponseWriter, r *http.Request) {
+	var req models.CloseRelationRequest
+	if !helpers.ParseJSONBodyOrError(w, r, &req) {
+		return
+	}
+	appID, externalUserID, ok := helpers.ValidateTenantParamsWithFields(w, r, &req, map[string]string{"from": req.From, "to": req.To, "type": req.Type}, false)
+	if !ok {
+		return
+	}
+
+	at := time.Now().UTC()
+	if req.At != nil {
+		at = req.At.UTC()
+	}
+	rel, err := h.store.CloseRelation(appID, externalUserID, req.From, req.To, req.Type, at)
+	if err != nil {
+		if helpers.HandleNotFoundError(w, err, "Open relation") {
+			return
+		}
+		helpers.HandleInternalErrorSlog(w, "close relation error", "error", err, "from", req.From, "to", req.To, "type", req.Type)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, rel)
+}
+
+// intersectIDs restricts ids to allowed; an empty ids list means "all" and yields allowed.
+func intersectIDs(ids, allowed []int64) []int64 {
+	if len(ids) == 0 {
+		return allowed
+	}
+	set := make(map[int64]bool, len(allowed))
+	for _, id := range allowed {
+		set[id] = true
+	}
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if set[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// utcTime normalises optional request timestamps to UTC before they are stored.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// HandleGraphTraverse walks the relations of a tenant from one entity (GET /graph/traverse).
+// Query: start (required), depth, types (comma-separated), direction (out|in|both), strategy (bfs|dfs),
+// at (RFC3339, default now), limit (max nodes).
+func (h *Handlers) HandleGraphTraverse(w http.ResponseWriter, r *http.Request) {
+	start := helpers.GetQueryParam(r, "start")
+	appID, externalUserID, ok := helpers.ValidateTenantParamsWithFields(w, r, nil, map[string]string{"start": start}, true)
+	if !ok {
+		return
+	}
+
+	opts := store.TraverseOptions{
+		Start:     start,
+		MaxDepth:  store.DefaultTraverseDepth,
+		Direction: helpers.GetQueryParam(r, "direction"),
+		Strategy:  helpers.GetQueryParam(r, "strategy"),
+		MaxNodes:  helpers.ParseLimit(helpers.GetQueryParam(r, "limit"), store.DefaultTraverseNodes, store.MaxTraverseNodes),
+	}
+	if depthStr := helpers.GetQueryParam(r, "depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 1 || depth > store.MaxTraverseDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 1 and %d", store.MaxTraverseDepth), http.StatusBadRequest)
+			return
+		}
+		opts.MaxDepth = depth
+	}
+	if types := helpers.GetQueryParam(r, "types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				opts.Types = append(opts.Types, t)
+			}
+		}
+	}
+	at, err := helpers.ParseTimeParam(r, "at")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.At = at
+	switch opts.Direction {
+	case "", store.DirectionOut, store.DirectionIn, store.DirectionBoth:
+	default:
+		http.Error(w, "direction must be out, in or both", http.StatusBadRequest)
+		return
+	}
+	switch opts.Strategy {
+	case "", store.TraverseBFS, store.TraverseDFS:
+	default:
+		http.Error(w, "strategy must be bfs or dfs", http.StatusBadRequest)
+		return
+	}
+
+	graph, err := h.store.TraverseGraph(appID, externalUserID, opts)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "graph traverse error", "error", err, "start", start, "appId", appID, "userId", externalUserID)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, graph)
+}
+
 func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
+	// Zählt über alle Tenants
+	if !helpers.AuthorizeTenant(w, r, "", "") {
+		return
+	}
 	stats, err := h.store.GetStats()
 	if err != nil {
 		helpers.HandleInternalErrorSlog(w, "stats error", "error", err)
@@ -321,8 +618,32 @@ func (h *Handlers) HandleStoreSeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Das Bundle muss zum selben Tenant gehören
+	if req.BundleID != nil {
+		if _, err := h.store.GetBundle(*req.BundleID, appID, externalUserID); helpers.IsNotFoundError(err) {
+			http.Error(w, "forbidden: bundle does not belong to the tenant", http.StatusForbidden)
+			return
+		} else if err != nil {
+			helpers.HandleInternalErrorSlog(w, "store seed bundle error", "error", err, "bundleId", *req.BundleID)
+			return
+		}
+	}
+
 	mem := models.NewMemoryFromStoreSeedRequest(&req, appID, externalUserID)
 
+	// Exakte Duplikate (gleicher Content-Hash) optional nicht erneut speichern
+	if req.Dedupe {
+		existing, err := h.store.FindMemoryByContent(appID, externalUserID, mem.Content)
+		if err == nil {
+			helpers.WriteJSON(w, http.StatusOK, models.StoreSeedResponse{ID: existing.ID, Message: "Memory already exists", ContentHash: existing.ContentHash, Duplicate: true})
+			return
+		}
+		if !helpers.IsNotFoundError(err) {
+			helpers.HandleInternalErrorSlog(w, "store seed dedupe error", "error", err, "appId", appID, "userId", externalUserID)
+			return
+		}
+	}
+
 	if err := h.store.CreateMemory(mem); err != nil {
 		helpers.HandleInternalErrorSlog(w, "store seed error", "error", err, "appId", appID, "userId", externalUserID)
 		return
@@ -332,11 +653,12 @@ func (h *Handlers) HandleStoreSeed(w http.ResponseWriter, r *http.Request) {
 	if err := h.store.GenerateEmbeddingForMemory(mem); err != nil {
 		slog.Warn("embedding on store failed, memory still saved", "error", err, "memoryId", mem.ID)
 	}
+	h.linkEntities(mem)
 
 	// Trigger webhook asynchron
 	go h.triggerWebhook(webhooks.EventMemoryCreated, h.buildMemoryWebhookPayload(mem, appID, externalUserID, webhooks.EventMemoryCreated))
 
-	helpers.WriteJSON(w, http.StatusOK, helpers.NewSuccessResponse(mem.ID, "Memory stored successfully"))
+	helpers.WriteJSON(w, http.StatusOK, models.StoreSeedResponse{ID: mem.ID, Message: "Memory stored successfully", ContentHash: mem.ContentHash})
 }
 
 // HandleListSeeds returns a paginated list of memories for the tenant (GET /seeds).
@@ -347,6 +669,9 @@ func (h *Handlers) HandleListSeeds(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing required query parameter: appId and externalUserId", http.StatusBadRequest)
 		return
 	}
+	if !helpers.AuthorizeTenant(w, r, appID, externalUserID) {
+		return
+	}
 	limit := helpers.ParseLimit(helpers.GetQueryParam(r, "limit"), 50, 100)
 	offset := 0
 	if s := helpers.GetQueryParam(r, "offset"); s != "" {
@@ -370,15 +695,8 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Query-Parameter haben Priorität (Neutron-kompatibel), Fallback zu Body
-	appID, externalUserID := helpers.ExtractTenantParams(r, &req)
-
-	fields := map[string]string{
-		"appId":          appID,
-		"externalUserId": externalUserID,
-		"query":          req.Query,
-	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required field: "+field, http.StatusBadRequest)
+	appID, externalUserID, ok := helpers.ValidateTenantParamsWithFields(w, r, &req, map[string]string{"query": req.Query}, false)
+	if !ok {
 		return
 	}
 
@@ -399,6 +717,30 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 		metadataFilter = map[string]any{}
 	}
 
+	// Optional: nur mit der Entity verknüpfte Memories (Schnittmenge mit seedIds)
+	if req.Entity != "" {
+		linked, err := h.store.MemoryIDsForEntity(appID, externalUserID, req.Entity)
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "query seed entity filter error", "error", err, "entity", req.Entity)
+			return
+		}
+		seedIDs = intersectIDs(seedIDs, linked)
+		if len(seedIDs) == 0 {
+			helpers.WriteJSON(w, http.StatusOK, []models.QuerySeedResult{})
+			return
+		}
+	}
+
+	switch req.Mode {
+	case "", models.SearchModeSemantic:
+	case models.SearchModeKeyword, models.SearchModeHybrid:
+		h.handleRankedQuerySeed(w, &req, appID, externalUserID, limit, seedIDs, metadataFilter)
+		return
+	default:
+		http.Error(w, "invalid mode: must be semantic, keyword or hybrid", http.StatusBadRequest)
+		return
+	}
+
 	// Versuche semantische Suche, fallback zu Textsuche (bei Fehler oder 0 Treffern)
 	memories, err := h.store.SearchMemoriesByTenantSemanticAndBundle(appID, externalUserID, req.Query, req.BundleID, limit, seedIDs, metadataFilter, false)
 	if err != nil || len(memories) == 0 {
@@ -417,19 +759,14 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 
 	// Generiere Query-Embedding für Similarity-Berechnung
 	embeddingService := embeddings.GetEmbeddingService()
-	queryEmbedding, err := embeddingService.GenerateEmbedding(req.Query, "text/plain")
+	queryEmbedding, err := embeddings.GenerateEmbeddingContext(r.Context(), embeddingService, req.Query, "text/plain")
 	if err != nil {
 		slog.Warn("failed to generate query embedding, using text similarity", "error", err)
 	}
+	queryModel := embeddings.ModelIDOf(embeddingService)
 
 	// Threshold 0-1: only return results with similarity >= threshold (Neutron-compatible; 0 = no filter)
-	threshold := req.Threshold
-	if threshold < 0 {
-		threshold = 0
-	}
-	if threshold > 1 {
-		threshold = 1
-	}
+	threshold := clampThreshold(req.Threshold)
 
 	results := make([]models.QuerySeedResult, 0, len(memories))
 	for _, mem := range memories {
@@ -437,7 +774,7 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 
 		// Berechne echte Similarity wenn möglich
 		similarity := helpers.DefaultSimilarity
-		if queryEmbedding != nil && mem.Embedding != "" {
+		if queryEmbedding != nil && mem.EmbeddingCompatible(queryModel, len(queryEmbedding)) {
 			memEmbedding, err := embeddings.DecodeVector(mem.Embedding)
 			if err == nil {
 				similarity = embeddings.CosineSimilarity(queryEmbedding, memEmbedding)
@@ -454,11 +791,12 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 		}
 
 		results = append(results, models.QuerySeedResult{
-			ID:         mem.ID,
-			Content:    mem.Content,
-			Metadata:   metadata,
-			CreatedAt:  mem.CreatedAt,
-			Similarity: similarity,
+			ID:          mem.ID,
+			Content:     mem.Content,
+			ContentHash: mem.ContentHash,
+			Metadata:    metadata,
+			CreatedAt:   mem.CreatedAt,
+			Similarity:  similarity,
 		})
 	}
 
@@ -475,11 +813,12 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			results = append(results, models.QuerySeedResult{
-				ID:         mem.ID,
-				Content:    mem.Content,
-				Metadata:   metadata,
-				CreatedAt:  mem.CreatedAt,
-				Similarity: sim,
+				ID:          mem.ID,
+				Content:     mem.Content,
+				ContentHash: mem.ContentHash,
+				Metadata:    metadata,
+				CreatedAt:   mem.CreatedAt,
+				Similarity:  sim,
 			})
 		}
 	}
@@ -487,6 +826,59 @@ func (h *Handlers) HandleQuerySeed(w http.ResponseWriter, r *http.Request) {
 	helpers.WriteJSON(w, http.StatusOK, results)
 }
 
+func clampThreshold(threshold float64) float64 {
+	return max(0, min(1, threshold))
+}
+
+// handleRankedQuerySeed beantwortet /seeds/query im Modus keyword (BM25) oder hybrid (BM25 + Vektor, RRF).
+// Threshold bezieht sich hier auf den fusionierten Score.
+func (h *Handlers) handleRankedQuerySeed(w http.ResponseWriter, req *models.QuerySeedRequest, appID, externalUserID string, limit int, seedIDs []int64, metadataFilter map[string]any) {
+	opts := store.HybridOptions{KeywordWeight: 1}
+	if req.Mode == models.SearchModeHybrid {
+		opts.VectorWeight = 1
+		if req.Weights != nil {
+			if req.Weights.Vector < 0 || req.Weights.Keyword < 0 || req.Weights.Vector+req.Weights.Keyword == 0 {
+				http.Error(w, "invalid weights: must be >= 0 and not both 0", http.StatusBadRequest)
+				return
+			}
+			opts.VectorWeight, opts.KeywordWeight = req.Weights.Vector, req.Weights.Keyword
+		}
+	}
+
+	ranked, err := h.store.SearchMemoriesHybrid(appID, externalUserID, req.Query, req.BundleID, limit, seedIDs, metadataFilter, false, opts)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "query seed error", "error", err, "appId", appID, "userId", externalUserID, "query", req.Query, "mode", req.Mode)
+		return
+	}
+
+	threshold := clampThreshold(req.Threshold)
+	results := make([]models.QuerySeedResult, 0, len(ranked))
+	for _, r := range ranked {
+		if threshold > 0 && r.Score < threshold {
+			continue
+		}
+		similarity := r.Similarity
+		if similarity == 0 {
+			// Kein (kompatibles) Embedding: Text-basierte Similarity
+			similarity = helpers.DefaultSimilarity
+			if strings.Contains(strings.ToLower(r.Content), strings.ToLower(req.Query)) {
+				similarity = helpers.TextMatchSimilarity
+			}
+		}
+		results = append(results, models.QuerySeedResult{
+			ID:          r.ID,
+			Content:     r.Content,
+			ContentHash: r.ContentHash,
+			Metadata:    helpers.UnmarshalMetadata(r.Metadata),
+			CreatedAt:   r.CreatedAt,
+			Similarity:  similarity,
+			Score:       r.Score,
+		})
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, results)
+}
+
 // HandleGenerateEmbeddings generiert Embeddings für alle Memories ohne Embedding
 func (h *Handlers) HandleGenerateEmbeddings(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -494,6 +886,10 @@ func (h *Handlers) HandleGenerateEmbeddings(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Arbeitet über alle Tenants
+	if !helpers.AuthorizeTenant(w, r, "", "") {
+		return
+	}
 	batchSize := helpers.ParseLimit(helpers.GetQueryParam(r, "batchSize"), 10, 100)
 
 	if err := h.store.BatchGenerateEmbeddings(batchSize); err != nil {
@@ -517,10 +913,8 @@ func (h *Handlers) HandleSeedsByID(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	appID, externalUserID := helpers.ExtractTenantParams(r, nil)
-	fields := map[string]string{"appId": appID, "externalUserId": externalUserID}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required query parameter: "+field, http.StatusBadRequest)
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
 		return
 	}
 	if isHistory {
@@ -548,7 +942,7 @@ func (h *Handlers) HandleGetSeed(w http.ResponseWriter, r *http.Request, id int6
 	if h.handleStoreOperationWithNotFound(w, err, "Memory", "get seed", "id", id, "appId", appID, "userId", externalUserID) {
 		return
 	}
-	mem.Embedding = ""
+	mem.Embedding = nil
 	h.mapMetadataToMemories([]models.Memory{*mem})
 	helpers.WriteJSON(w, http.StatusOK, mem)
 }
@@ -569,6 +963,7 @@ func (h *Handlers) HandleUpdateSeed(w http.ResponseWriter, r *http.Request, id i
 	if h.handleStoreOperationWithNotFound(w, err, "Memory", "update seed", "id", id, "appId", appID, "userId", externalUserID) {
 		return
 	}
+	before := *mem
 	if req.Content != nil {
 		mem.Content = *req.Content
 	}
@@ -589,6 +984,13 @@ func (h *Handlers) HandleUpdateSeed(w http.ResponseWriter, r *http.Request, id i
 		if err := h.store.GenerateEmbeddingForMemory(mem); err != nil {
 			slog.Warn("embedding on update failed", "error", err, "memoryId", mem.ID)
 		}
+		h.linkEntities(mem)
+	}
+	if changes := webhooks.MemoryChanges(&before, mem); len(changes) > 0 {
+		payload := h.buildMemoryWebhookPayload(mem, appID, externalUserID, webhooks.EventMemoryUpdated)
+		payload["changes"] = changes
+		payload["updated_at"] = mem.UpdatedAt
+		go h.triggerWebhook(webhooks.EventMemoryUpdated, payload)
 	}
 	helpers.WriteJSON(w, http.StatusOK, mem)
 }
@@ -635,6 +1037,10 @@ func (h *Handlers) HandleMergeSeeds(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	entry := audit.FromContext(r.Context())
+	entry.SetTenant(appID, externalUserID)
+	entry.AddTargets(req.TargetID)
+	entry.AddTargets(req.SourceIDs...)
 	if req.TargetID == 0 || len(req.SourceIDs) == 0 {
 		http.Error(w, "targetId and sourceIds (non-empty) are required", http.StatusBadRequest)
 		return
@@ -645,13 +1051,24 @@ func (h *Handlers) HandleMergeSeeds(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	before, err := h.store.GetMemoryByIDAndTenant(req.TargetID, appID, externalUserID, false)
+	if h.handleStoreOperationWithNotFound(w, err, "Memory", "merge seeds", "targetId", req.TargetID, "appId", appID, "userId", externalUserID) {
+		return
+	}
 	merged := make([]int64, 0, len(req.SourceIDs))
+	archived := make([]models.Memory, 0, len(req.SourceIDs))
 	for _, srcID := range req.SourceIDs {
 		if err := h.store.MergeMemories(req.TargetID, srcID, appID, externalUserID); err != nil {
 			helpers.HandleInternalErrorSlog(w, "merge seeds error", "error", err, "targetId", req.TargetID, "sourceId", srcID)
 			return
 		}
 		merged = append(merged, srcID)
+		if src, err := h.store.GetMemoryByIDAndTenant(srcID, appID, externalUserID, true); err == nil {
+			archived = append(archived, *src)
+		}
+	}
+	if after, err := h.store.GetMemoryByIDAndTenant(req.TargetID, appID, externalUserID, false); err == nil {
+		go h.triggerMergeEvents(before, after, archived)
 	}
 	helpers.WriteJSON(w, http.StatusOK, map[string]any{
 		"targetId": req.TargetID,
@@ -666,7 +1083,7 @@ func (h *Handlers) HandleCleanup(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("dryRun") == "true" || r.URL.Query().Get("dryRun") == "1" {
 		cfg.DryRun = true
 	}
-	stats, err := cleanup.RunCleanup(r.Context(), h.store, cfg)
+	stats, err := cleanup.RunCleanupWithHooks(r.Context(), h.store, cfg, h.CleanupHooks())
 	if err != nil {
 		helpers.HandleInternalErrorSlog(w, "cleanup error", "error", err)
 		return
@@ -680,6 +1097,21 @@ func (h *Handlers) HandleCleanup(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// HandleClaimLegacyGraph assigns entities and relations without tenant (pre-tenant schema) to the
+// tenant given by appId/externalUserId (POST /admin/graph/claim-legacy).
+func (h *Handlers) HandleClaimLegacyGraph(w http.ResponseWriter, r *http.Request) {
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+	counts, err := h.store.ClaimLegacyGraph(appID, externalUserID)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "claim legacy graph error", "error", err, "appId", appID, "userId", externalUserID)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, counts)
+}
+
 // Bundle API Handlers
 
 func (h *Handlers) HandleCreateBundle(w http.ResponseWriter, r *http.Request) {
@@ -712,14 +1144,8 @@ func (h *Handlers) HandleCreateBundle(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) HandleListBundles(w http.ResponseWriter, r *http.Request) {
-	appID, externalUserID := helpers.ExtractTenantParams(r, nil)
-
-	fields := map[string]string{
-		"appId":          appID,
-		"externalUserId": externalUserID,
-	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required query parameter: "+field, http.StatusBadRequest)
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
 		return
 	}
 
@@ -741,14 +1167,8 @@ func (h *Handlers) HandleGetBundle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appID, externalUserID := helpers.ExtractTenantParams(r, nil)
-
-	fields := map[string]string{
-		"appId":          appID,
-		"externalUserId": externalUserID,
-	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required query parameter: "+field, http.StatusBadRequest)
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
 		return
 	}
 
@@ -766,14 +1186,8 @@ func (h *Handlers) HandleDeleteBundle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appID, externalUserID := helpers.ExtractTenantParams(r, nil)
-
-	fields := map[string]string{
-		"appId":          appID,
-		"externalUserId": externalUserID,
-	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required query parameter: "+field, http.StatusBadRequest)
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
 		return
 	}
 
@@ -801,14 +1215,20 @@ func (h *Handlers) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.Events) == 0 {
-		http.Error(w, "events are required", http.StatusBadRequest)
+	events, err := webhooks.ValidateEvents(req.Events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Webhooks gelten für alle Nutzer einer App (ohne appId: für alle Apps)
+	audit.FromContext(r.Context()).SetTenant(req.AppID, "")
+	if !helpers.AuthorizeTenant(w, r, req.AppID, "") {
 		return
 	}
 
 	webhook := models.Webhook{
 		URL:    req.URL,
-		Events: strings.Join(req.Events, ","),
+		Events: strings.Join(events, ","),
 		Secret: req.Secret,
 		AppID:  req.AppID,
 		Active: true,
@@ -818,12 +1238,16 @@ func (h *Handlers) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
 		helpers.HandleInternalErrorSlog(w, "create webhook error", "error", err)
 		return
 	}
+	audit.FromContext(r.Context()).AddTargets(webhook.ID)
 
-	helpers.WriteJSON(w, http.StatusOK, webhook.ToWebhookResponse(strings.Join(req.Events, ",")))
+	helpers.WriteJSON(w, http.StatusOK, webhook.ToWebhookResponse(webhook.Events))
 }
 
 func (h *Handlers) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
 	appID := helpers.GetQueryParam(r, "appId")
+	if !helpers.AuthorizeTenant(w, r, appID, "") {
+		return
+	}
 
 	webhookList, err := h.store.ListWebhooks(appID)
 	if err != nil {
@@ -837,7 +1261,10 @@ func (h *Handlers) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
 	helpers.WriteJSON(w, http.StatusOK, responses)
 }
 
-func (h *Handlers) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+// HandleWebhooksByID routes PATCH/DELETE /webhooks/:id, GET /webhooks/:id/deliveries,
+// GET /webhooks/:id/deliveries/:deliveryId und POST /webhooks/:id/deliveries/:deliveryId/redeliver
+func (h *Handlers) HandleWebhooksByID(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/"), "/")
 	id, ok := helpers.ExtractAndParseID(w, r.URL.Path, "/webhooks/")
 	if !ok {
 		return
@@ -847,54 +1274,402 @@ func (h *Handlers) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing required query parameter: appId", http.StatusBadRequest)
 		return
 	}
-	wh, err := h.store.GetWebhookByIDAndApp(id, appID)
-	if h.handleStoreOperationWithNotFound(w, err, "Webhook", "delete webhook", "id", id, "appId", appID) {
+	if !helpers.AuthorizeTenant(w, r, appID, "") {
 		return
 	}
-	if err := h.store.DeleteWebhook(wh.ID); err != nil {
-		helpers.HandleInternalErrorSlog(w, "delete webhook error", "error", err, "id", wh.ID)
+	wh, err := h.store.GetWebhookByIDAndApp(id, appID)
+	if h.handleStoreOperationWithNotFound(w, err, "Webhook", "get webhook", "id", id, "appId", appID) {
 		return
 	}
-	helpers.WriteJSON(w, http.StatusOK, helpers.NewSuccessResponse(wh.ID, "Webhook deleted successfully"))
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodPatch:
+			h.HandleUpdateWebhook(w, r, wh)
+		case http.MethodDelete:
+			h.HandleDeleteWebhook(w, r, wh)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case len(parts) == 2 && parts[1] == "deliveries":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.HandleListWebhookDeliveries(w, r, wh)
+	case (len(parts) == 3 || len(parts) == 4 && parts[3] == "redeliver") && parts[1] == "deliveries":
+		deliveryID, err := helpers.ParseID(parts[2])
+		if err != nil {
+			http.Error(w, "invalid delivery id format", http.StatusBadRequest)
+			return
+		}
+		switch {
+		case len(parts) == 3 && r.Method == http.MethodGet:
+			h.HandleGetWebhookDelivery(w, r, wh, deliveryID)
+		case len(parts) == 4 && r.Method == http.MethodPost:
+			h.HandleRedeliverWebhook(w, r, wh, deliveryID)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
 }
 
-// Export/Import API Handlers
+// HandleWebhookEvents returns the event catalogue (GET /webhooks/events).
+func (h *Handlers) HandleWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, webhooks.Catalogue)
+}
 
-func (h *Handlers) HandleExport(w http.ResponseWriter, r *http.Request) {
-	appID, externalUserID := helpers.ExtractTenantParams(r, nil)
+// HandleChanges returns the change log after a sequence number (GET /changes?since=<seq>).
+// Query: since (default 0), limit, optional appId / externalUserId and kind (comma-separated, e.g.
+// memory,relation). Consumers follow the log by passing next_since as since of the next request.
+func (h *Handlers) HandleChanges(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if v := helpers.GetQueryParam(r, "since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid since: must be a sequence number >= 0", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	limit := helpers.ParseLimit(helpers.GetQueryParam(r, "limit"), helpers.DefaultChangesLimit, helpers.MaxChangesLimit)
 
-	fields := map[string]string{
-		"appId":          appID,
-		"externalUserId": externalUserID,
+	filter := store.ChangeFilter{
+		AppID:          helpers.GetQueryParam(r, "appId"),
+		ExternalUserID: helpers.GetQueryParam(r, "externalUserId"),
 	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required query parameter: "+field, http.StatusBadRequest)
+	if !helpers.AuthorizeTenant(w, r, filter.AppID, filter.ExternalUserID) {
 		return
 	}
+	if v := helpers.GetQueryParam(r, "kind"); v != "" {
+		for _, kind := range strings.Split(v, ",") {
+			kind = strings.TrimSpace(kind)
+			if !slices.Contains(models.ChangeKinds, kind) {
+				http.Error(w, fmt.Sprintf("invalid kind %q (allowed: %s)", kind, strings.Join(models.ChangeKinds, ", ")), http.StatusBadRequest)
+				return
+			}
+			filter.Kinds = append(filter.Kinds, kind)
+		}
+	}
 
-	includeArchived := r.URL.Query().Get("includeArchived") == "true" || r.URL.Query().Get("includeArchived") == "1"
-	exportData, err := h.store.ExportAll(appID, externalUserID, includeArchived)
+	feed, err := h.store.ListChangesSince(since, filter, limit)
 	if err != nil {
-		helpers.HandleInternalErrorSlog(w, "export error", "error", err, "appId", appID, "userId", externalUserID)
+		helpers.HandleInternalErrorSlog(w, "changes error", "error", err, "since", since)
 		return
 	}
+	helpers.WriteJSON(w, http.StatusOK, feed)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	safeAppID := helpers.SanitizeFilenameForHeader(appID)
-	safeUserID := helpers.SanitizeFilenameForHeader(externalUserID)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"cortex-export-%s-%s-%s.json\"", safeAppID, safeUserID, time.Now().Format("20060102-150405")))
+// Event stream settings
+const (
+	eventStreamHeartbeat = 15 * time.Second // keep-alive comment on idle streams (proxies close idle connections)
+	eventStreamRetryMs   = 3000             // reconnect delay suggested to clients
+	eventReplayPageSize  = 500              // events read from the log per replay query
+)
+
+// HandleEventStream streams the events of a tenant as Server-Sent Events (GET /events/stream).
+// Query: appId, externalUserId, optional types (comma-separated, e.g. memory.*). Every event carries
+// its log ID; a client that reconnects with Last-Event-ID (header, or lastEventId query parameter for
+// clients that cannot set headers) first gets the missed events from the event log, then live events.
+func (h *Handlers) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+
+	var types []string
+	if t := r.URL.Query().Get("types"); t != "" {
+		var err error
+		if types, err = webhooks.ValidateEvents(strings.Split(t, ",")); err != nil {
+			http.Error(w, "invalid types: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("lastEventId")
+	}
+	var lastID int64
+	if resume != "" {
+		id, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Erst abonnieren, dann nachladen: Events zwischen Replay und Live gehen nicht verloren
+	// (Duplikate werden über die ID übersprungen)
+	sub := h.events.Subscribe(appID, externalUserID, types)
+	defer h.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetryMs)
+	flusher.Flush()
+
+	if resume != "" {
+		for {
+			page, err := h.events.Since(appID, externalUserID, types, lastID, eventReplayPageSize)
+			if err != nil {
+				slog.Error("event stream: replay failed", "appId", appID, "userId", externalUserID, "error", err)
+				return
+			}
+			for i := range page {
+				if err := writeSSEEvent(w, &page[i]); err != nil {
+					return
+				}
+				lastID = page[i].ID
+			}
+			flusher.Flush()
+			if len(page) < eventReplayPageSize {
+				break
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, open := <-sub.C:
+			if !open {
+				// Zu langsam: Verbindung beenden, Client setzt mit Last-Event-ID fort
+				return
+			}
+			if ev.ID <= lastID {
+				continue
+			}
+			if err := writeSSEEvent(w, &ev); err != nil {
+				return
+			}
+			lastID = ev.ID
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes one logged event in SSE format (id, event type, JSON payload on one line).
+func writeSSEEvent(w io.Writer, ev *models.Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Payload)
+	return err
+}
+
+// HandleUpdateWebhook changes url, events, secret or active state of a webhook, e.g. to subscribe
+// an existing webhook to further events.
+func (h *Handlers) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request, wh *models.Webhook) {
+	var req models.UpdateWebhookRequest
+	if !helpers.ParseJSONBodyOrError(w, r, &req) {
+		return
+	}
+	if req.URL != nil {
+		if err := helpers.ValidateWebhookURL(*req.URL); err != nil {
+			http.Error(w, "invalid webhook url: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		wh.URL = *req.URL
+	}
+	if req.Events != nil {
+		events, err := webhooks.ValidateEvents(req.Events)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wh.Events = strings.Join(events, ",")
+	}
+	if req.Secret != nil {
+		wh.Secret = *req.Secret
+	}
+	if req.Active != nil {
+		wh.Active = *req.Active
+	}
+	if err := h.store.UpdateWebhook(wh); err != nil {
+		helpers.HandleInternalErrorSlog(w, "update webhook error", "error", err, "id", wh.ID)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, wh.ToWebhookResponse(wh.Events))
+}
+
+func (h *Handlers) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request, wh *models.Webhook) {
+	if err := h.store.DeleteWebhook(wh.ID); err != nil {
+		helpers.HandleInternalErrorSlog(w, "delete webhook error", "error", err, "id", wh.ID)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.NewSuccessResponse(wh.ID, "Webhook deleted successfully"))
+}
+
+// HandleListWebhookDeliveries returns the deliveries of a webhook with their attempt history.
+// Query: status (pending, delivered, dead), limit.
+func (h *Handlers) HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request, wh *models.Webhook) {
+	status := helpers.GetQueryParam(r, "status")
+	switch status {
+	case "", models.DeliveryStatusPending, models.DeliveryStatusDelivered, models.DeliveryStatusDead:
+	default:
+		http.Error(w, "invalid status (pending, delivered, dead)", http.StatusBadRequest)
+		return
+	}
+	limit := helpers.ParseLimit(helpers.GetQueryParam(r, "limit"), helpers.DefaultLimit*5, helpers.MaxLimit)
+	deliveries, err := h.store.ListWebhookDeliveries(wh.ID, status, limit)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "list webhook deliveries error", "error", err, "webhookId", wh.ID)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, deliveries)
+}
+
+// HandleGetWebhookDelivery returns one delivery with its attempt history.
+func (h *Handlers) HandleGetWebhookDelivery(w http.ResponseWriter, r *http.Request, wh *models.Webhook, deliveryID int64) {
+	delivery, err := h.store.GetWebhookDelivery(wh.ID, deliveryID)
+	if h.handleStoreOperationWithNotFound(w, err, "Delivery", "get webhook delivery", "webhookId", wh.ID, "deliveryId", deliveryID) {
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, delivery)
+}
+
+// HandleRedeliverWebhook queues a delivered or dead-lettered delivery again (same payload, fresh retry budget).
+func (h *Handlers) HandleRedeliverWebhook(w http.ResponseWriter, r *http.Request, wh *models.Webhook, deliveryID int64) {
+	delivery, err := h.deliveries.Redeliver(wh.ID, deliveryID)
+	if errors.Is(err, webhooks.ErrNotRedeliverable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if h.handleStoreOperationWithNotFound(w, err, "Delivery", "redeliver webhook", "webhookId", wh.ID, "deliveryId", deliveryID) {
+		return
+	}
+	helpers.WriteJSON(w, http.StatusAccepted, delivery)
+}
+
+// Export/Import API Handlers
+
+// ndjsonContentType is the media type of streamed exports and imports (one JSON record per line).
+const ndjsonContentType = "application/x-ndjson"
+
+func (h *Handlers) HandleExport(w http.ResponseWriter, r *http.Request) {
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+
+	includeArchived := r.URL.Query().Get("includeArchived") == "true" || r.URL.Query().Get("includeArchived") == "1"
+	encrypt := helpers.GetQueryParam(r, "encrypt") == "true" || helpers.GetQueryParam(r, "encrypt") == "1"
+	if encrypt && h.key == nil {
+		http.Error(w, crypt.ErrNoKey.Error(), http.StatusBadRequest)
+		return
+	}
+	if helpers.GetQueryParam(r, "format") == "ndjson" {
+		h.exportNDJSON(w, r, appID, externalUserID, includeArchived, encrypt)
+		return
+	}
+	exportData, err := h.store.ExportAll(appID, externalUserID, includeArchived)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "export error", "error", err, "appId", appID, "userId", externalUserID)
+		return
+	}
+
+	safeAppID := helpers.SanitizeFilenameForHeader(appID)
+	safeUserID := helpers.SanitizeFilenameForHeader(externalUserID)
+	filename := fmt.Sprintf("cortex-export-%s-%s-%s.json", safeAppID, safeUserID, time.Now().Format("20060102-150405"))
+	if encrypt {
+		enc := h.encryptedAttachment(w, filename)
+		if enc == nil {
+			return
+		}
+		if err := json.NewEncoder(enc).Encode(exportData); err == nil {
+			err = enc.Close()
+		}
+		if err != nil {
+			slog.Error("encrypted export error", "error", err, "appId", appID, "userId", externalUserID)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 	helpers.WriteJSON(w, http.StatusOK, exportData)
 }
 
+// encryptedAttachment starts an encrypted download named filename.enc and returns the writer for
+// the plaintext; Close writes the last chunk. On error the response is sent and nil returned.
+func (h *Handlers) encryptedAttachment(w http.ResponseWriter, filename string) *crypt.Writer {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s%s\"", filename, crypt.Extension))
+	w.WriteHeader(http.StatusOK)
+	enc, err := crypt.NewWriter(w, h.key)
+	if err != nil {
+		slog.Error("encrypted export error", "error", err)
+		return nil
+	}
+	return enc
+}
+
 func (h *Handlers) HandleImport(w http.ResponseWriter, r *http.Request) {
-	appID, externalUserID := helpers.ExtractTenantParams(r, nil)
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
 
-	fields := map[string]string{
-		"appId":          appID,
-		"externalUserId": externalUserID,
+	overwrite := helpers.GetQueryParam(r, "overwrite") == "true"
+	remap := helpers.GetQueryParam(r, "remap") == "true"
+	conflict := helpers.GetQueryParam(r, "conflict")
+	if conflict != "" && !slices.Contains(store.ConflictStrategies, conflict) {
+		http.Error(w, "invalid conflict strategy (use one of: "+strings.Join(store.ConflictStrategies, ", ")+")", http.StatusBadRequest)
+		return
+	}
+	if overwrite && (remap || conflict != "") {
+		http.Error(w, "overwrite keeps the IDs of the import and cannot be combined with remap or conflict", http.StatusBadRequest)
+		return
+	}
+	// Ohne remap behalten die Datensätze die Tenants der Importdatei
+	if !remap && helpers.PrincipalFromContext(r.Context()).Restricted() {
+		http.Error(w, "forbidden: keys limited to tenants can only import with remap=true", http.StatusForbidden)
+		return
+	}
+	opts := store.ImportOptions{
+		KeepIDs:  overwrite,
+		Conflict: conflict,
+		DryRun:   helpers.GetQueryParam(r, "dryRun") == "true" || helpers.GetQueryParam(r, "dryRun") == "1",
+	}
+	if remap {
+		// Alle Datensätze landen im Tenant der Anfrage
+		opts.AppID, opts.ExternalUserID = appID, externalUserID
+	}
+	// Verschlüsselte Exporte (siehe encrypt=true bei /export) mit dem Server-Key entschlüsseln
+	body, encrypted := crypt.Peek(r.Body)
+	if encrypted {
+		dec, err := crypt.NewReader(body, h.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = dec
 	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required query parameter: "+field, http.StatusBadRequest)
+	r.Body = io.NopCloser(body)
+	if isNDJSONRequest(r) {
+		h.importNDJSON(w, r, appID, externalUserID, opts)
+		return
+	}
+	if remap || conflict != "" || opts.DryRun {
+		h.importRemapped(w, r, appID, externalUserID, opts)
 		return
 	}
 
@@ -903,13 +1678,20 @@ func (h *Handlers) HandleImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	overwrite := helpers.GetQueryParam(r, "overwrite") == "true"
-
 	if err := h.store.ImportData(&exportData, overwrite); err != nil {
 		helpers.HandleInternalErrorSlog(w, "import error", "error", err, "appId", appID, "userId", externalUserID)
 		return
 	}
 
+	go h.triggerWebhook(webhooks.EventImportCompleted, map[string]interface{}{
+		"app_id":           appID,
+		"external_user_id": externalUserID,
+		"memories":         len(exportData.Memories),
+		"bundles":          len(exportData.Bundles),
+		"webhooks":         len(exportData.Webhooks),
+		"overwrite":        overwrite,
+	})
+
 	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"message":   "Import completed successfully",
 		"memories":  len(exportData.Memories),
@@ -919,41 +1701,218 @@ func (h *Handlers) HandleImport(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// exportNDJSON streams the tenant as NDJSON (see store.ExportNDJSON), optionally only what changed
+// since updatedSince. Once the first line is sent the status cannot change anymore: on errors the
+// stream ends without end record.
+func (h *Handlers) exportNDJSON(w http.ResponseWriter, r *http.Request, appID, externalUserID string, includeArchived, encrypt bool) {
+	updatedSince, err := helpers.ParseTimeParam(r, "updatedSince")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	safeAppID := helpers.SanitizeFilenameForHeader(appID)
+	safeUserID := helpers.SanitizeFilenameForHeader(externalUserID)
+	filename := fmt.Sprintf("cortex-export-%s-%s-%s.ndjson", safeAppID, safeUserID, time.Now().Format("20060102-150405"))
+	var out io.Writer = w
+	if encrypt {
+		enc := h.encryptedAttachment(w, filename)
+		if enc == nil {
+			return
+		}
+		// Ohne abschließenden Chunk ist die Datei beim Entschlüsseln als abgeschnitten erkennbar
+		defer func() {
+			if err == nil {
+				if err := enc.Close(); err != nil {
+					slog.Error("ndjson export error", "error", err, "appId", appID, "userId", externalUserID)
+				}
+			}
+		}()
+		out = enc
+	} else {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	}
+	_, err = h.store.ExportNDJSON(out, store.ExportOptions{
+		AppID:           appID,
+		ExternalUserID:  externalUserID,
+		IncludeArchived: includeArchived,
+		UpdatedSince:    updatedSince,
+	})
+	if err != nil {
+		slog.Error("ndjson export error", "error", err, "appId", appID, "userId", externalUserID)
+		audit.FromContext(r.Context()).Fail(err)
+	}
+}
+
+// isNDJSONRequest reports whether an import body is NDJSON (Content-Type or format=ndjson).
+func isNDJSONRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), ndjsonContentType) || helpers.GetQueryParam(r, "format") == "ndjson"
+}
+
+// importNDJSON imports an NDJSON body record by record (see store.ImportNDJSON). The response is
+// NDJSON as well: progress and error lines while the body is read (if the connection supports full
+// duplex), then one result line with the report.
+func (h *Handlers) importNDJSON(w http.ResponseWriter, r *http.Request, appID, externalUserID string, opts store.ImportOptions) {
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	enc := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+	// Zwischenstände nur, wenn während des Lesens geantwortet werden darf (HTTP/1 braucht Full Duplex)
+	if rc.EnableFullDuplex() == nil {
+		opts.OnProgress = func(report store.ImportReport) {
+			report.Errors = nil
+			enc.Encode(importProgressLine{Type: "progress", ImportReport: &report})
+			rc.Flush()
+		}
+		opts.OnError = func(e store.ImportError) {
+			enc.Encode(importErrorLine{Type: "error", ImportError: e})
+			rc.Flush()
+		}
+	}
+
+	report, err := h.store.ImportNDJSON(r.Body, opts)
+	result := importProgressLine{Type: "result", ImportReport: report}
+	if err != nil {
+		slog.Error("ndjson import error", "error", err, "appId", appID, "userId", externalUserID)
+		result.Error = err.Error()
+		audit.FromContext(r.Context()).Fail(err)
+	}
+	enc.Encode(result)
+
+	if !opts.DryRun && report.Lines > 0 {
+		h.triggerImportCompleted(appID, externalUserID, "ndjson", report, opts)
+	}
+}
+
+// importRemapped imports a JSON export through the record pipeline of the NDJSON import (new IDs,
+// tenant rewrite, conflict strategies; see store.ImportExportData) and responds with the report.
+func (h *Handlers) importRemapped(w http.ResponseWriter, r *http.Request, appID, externalUserID string, opts store.ImportOptions) {
+	var exportData store.ExportData
+	if !helpers.ParseJSONBodyOrError(w, r, &exportData) {
+		return
+	}
+
+	report, err := h.store.ImportExportData(&exportData, opts)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "import error", "error", err, "appId", appID, "userId", externalUserID)
+		return
+	}
+	if !opts.DryRun {
+		h.triggerImportCompleted(appID, externalUserID, "json", report, opts)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Import completed",
+		"report":  report,
+		"remap":   opts.AppID != "",
+	})
+}
+
+func (h *Handlers) triggerImportCompleted(appID, externalUserID, format string, report *store.ImportReport, opts store.ImportOptions) {
+	go h.triggerWebhook(webhooks.EventImportCompleted, map[string]interface{}{
+		"app_id":           appID,
+		"external_user_id": externalUserID,
+		"format":           format,
+		"imported":         report.Imported,
+		"skipped":          report.Skipped,
+		"failed":           report.Failed,
+		"overwrite":        opts.KeepIDs,
+		"remap":            opts.AppID != "",
+		"conflict":         opts.Conflict,
+	})
+}
+
+// importProgressLine is a progress or result line of the NDJSON import response.
+type importProgressLine struct {
+	Type string `json:"type"`
+	*store.ImportReport
+	Error string `json:"error,omitempty"` // import aborted (stream unreadable or commit failed)
+}
+
+// importErrorLine reports a failed record of the NDJSON import response.
+type importErrorLine struct {
+	Type string `json:"type"`
+	store.ImportError
+}
+
 // Backup/Restore API Handlers
 
+// HandleBackup creates a backup. Without path it goes into the backup directory with compression and
+// manifest (see backup.Create, CORTEX_BACKUP_*); with path a plain database file is written there.
+// encrypt=true encrypts with the server key (also the default with CORTEX_BACKUP_ENCRYPT).
 func (h *Handlers) HandleBackup(w http.ResponseWriter, r *http.Request) {
 	backupPath := helpers.GetQueryParam(r, "path")
+	cfg := h.backups
+	if v := helpers.GetQueryParam(r, "encrypt"); v != "" {
+		cfg.Encrypt = v == "true" || v == "1"
+	}
+	if cfg.Encrypt && h.key == nil {
+		http.Error(w, crypt.ErrNoKey.Error(), http.StatusBadRequest)
+		return
+	}
 	if backupPath == "" {
-		// Default: Speichern im Backup-Unterordner neben der Datenbank (z. B. ~/.openclaw/backups/)
-		dbPath, err := h.store.GetDatabasePath()
+		m, err := backup.Create(h.store, cfg, backup.TriggerManual)
 		if err != nil {
-			helpers.HandleInternalErrorSlog(w, "get database path error", "error", err)
+			helpers.HandleInternalErrorSlog(w, "backup error", "error", err)
 			return
 		}
-		backupDir := filepath.Join(filepath.Dir(dbPath), "backups")
-		if err := os.MkdirAll(backupDir, 0o755); err != nil {
-			helpers.HandleInternalErrorSlog(w, "create backup dir error", "error", err, "dir", backupDir)
+		dir, err := backup.Directory(h.store, h.backups)
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "backup dir error", "error", err)
+			return
+		}
+		helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"message":  "Backup created successfully",
+			"path":     filepath.Join(dir, m.File),
+			"manifest": m,
+		})
+		return
+	}
+	if err := helpers.ValidateBackupPath(backupPath); err != nil {
+		http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Relative Pfade werden relativ zum Datenbankverzeichnis aufgelöst (wie bei Restore)
+	currentPath, err := h.store.GetDatabasePath()
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "get database path error", "error", err)
+		return
+	}
+	backupPath = filepath.Join(filepath.Dir(currentPath), backupPath)
+	if h.store.FileExists(backupPath) {
+		http.Error(w, "backup file already exists", http.StatusConflict)
+		return
+	}
+
+	if !cfg.Encrypt {
+		if err := h.store.BackupDatabase(backupPath); err != nil {
+			helpers.HandleInternalErrorSlog(w, "backup error", "error", err, "path", backupPath)
 			return
 		}
-		backupPath = filepath.Join(backupDir, fmt.Sprintf("cortex-backup-%s.db", time.Now().Format("20060102-150405")))
 	} else {
-		if err := helpers.ValidateBackupPath(backupPath); err != nil {
-			http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
+		plainPath := backupPath + ".plain"
+		err := h.store.BackupDatabase(plainPath)
+		if err == nil {
+			err = crypt.EncryptFile(plainPath, backupPath, h.key)
+		}
+		os.Remove(plainPath)
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "backup error", "error", err, "path", backupPath)
 			return
 		}
 	}
 
-	if err := h.store.BackupDatabase(backupPath); err != nil {
-		helpers.HandleInternalErrorSlog(w, "backup error", "error", err, "path", backupPath)
-		return
-	}
-
 	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "Backup created successfully",
-		"path":    backupPath,
+		"message":   "Backup created successfully",
+		"path":      backupPath,
+		"encrypted": cfg.Encrypt,
 	})
 }
 
+// HandleRestore replaces the live database with a backup (see store.RestoreDatabase). The backup is
+// verified before anything changes; the response names a snapshot of the previous state that can be
+// restored the same way to roll back.
 func (h *Handlers) HandleRestore(w http.ResponseWriter, r *http.Request) {
 	backupPath := helpers.GetQueryParam(r, "path")
 	if backupPath == "" {
@@ -980,21 +1939,323 @@ func (h *Handlers) HandleRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Note: Restore requires server restart. We'll just copy the file
-	// and inform the user that a restart is needed.
-	if err := h.store.CopyFile(backupPath, currentPath); err != nil {
+	// Komprimierte und verschlüsselte Backups vorher entpacken
+	restorePath := backupPath
+	if backup.IsPacked(backupPath) {
+		restorePath = currentPath + ".unpacked"
+		if err := backup.Unpack(backupPath, restorePath, h.key); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		defer os.Remove(restorePath)
+	}
+
+	result, err := h.store.RestoreDatabase(restorePath)
+	if result != nil {
+		result.BackupPath = backupPath
+	}
+	switch {
+	case errors.Is(err, store.ErrInvalidBackup):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, store.ErrDatabaseBusy):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
 		helpers.HandleInternalErrorSlog(w, "restore error", "error", err, "backupPath", backupPath, "currentPath", currentPath)
 		return
 	}
 
+	// Ohne Serverpfade: die Empfänger-URLs liegen außerhalb des Servers
+	go h.triggerWebhook(webhooks.EventBackupRestored, map[string]interface{}{
+		"backup": filepath.Base(result.BackupPath),
+	})
+
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "Restore completed successfully",
+		"backup_path":   result.BackupPath,
+		"restored_to":   result.RestoredTo,
+		"rollback_path": result.RollbackPath,
+	})
+}
+
+// HandleListBackups lists the backup directory, newest first (GET /backups).
+func (h *Handlers) HandleListBackups(w http.ResponseWriter, r *http.Request) {
+	dir, err := backup.Directory(h.store, h.backups)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "backup dir error", "error", err)
+		return
+	}
+	infos, err := backup.List(dir)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "list backups error", "error", err)
+		return
+	}
+	if infos == nil {
+		infos = []backup.Info{}
+	}
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"dir":     dir,
+		"backups": infos,
+	})
+}
+
+// HandleVerifyBackups checks checksum and integrity of one backup (?file=) or of all backups in the
+// backup directory (POST /backups/verify). ok is false if any backup failed.
+func (h *Handlers) HandleVerifyBackups(w http.ResponseWriter, r *http.Request) {
+	dir, err := backup.Directory(h.store, h.backups)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "backup dir error", "error", err)
+		return
+	}
+	var files []string
+	if file := helpers.GetQueryParam(r, "file"); file != "" {
+		files = append(files, file)
+	} else {
+		infos, err := backup.List(dir)
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "list backups error", "error", err)
+			return
+		}
+		for _, info := range infos {
+			files = append(files, info.File)
+		}
+	}
+
+	results := []*backup.VerifyResult{}
+	ok := true
+	for _, file := range files {
+		res, err := backup.Verify(dir, file, h.key)
+		switch {
+		case errors.Is(err, backup.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ok = ok && res.IntegrityOK
+		results = append(results, res)
+	}
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":      ok,
+		"results": results,
+	})
+}
+
+// HandlePruneBackups applies the retention policy to the backup directory (POST /backups/prune).
+// Query: dryRun=true lists what would be deleted.
+func (h *Handlers) HandlePruneBackups(w http.ResponseWriter, r *http.Request) {
+	dryRun := helpers.GetQueryParam(r, "dryRun") == "true" || helpers.GetQueryParam(r, "dryRun") == "1"
+	dir, err := backup.Directory(h.store, h.backups)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "backup dir error", "error", err)
+		return
+	}
+	removed, err := backup.Prune(dir, h.backups, dryRun)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "prune backups error", "error", err)
+		return
+	}
+	if removed == nil {
+		removed = []string{}
+	}
 	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{
-		"message":     "Restore completed successfully. Server restart required to use restored database.",
-		"backup_path": backupPath,
-		"restored_to": currentPath,
-		"warning":     "Server must be restarted for changes to take effect",
+		"dryRun":     dryRun,
+		"removed":    removed,
+		"keepLast":   h.backups.KeepLast,
+		"keepDaily":  h.backups.KeepDaily,
+		"keepWeekly": h.backups.KeepWeekly,
 	})
 }
 
+// HandleEncryptionStatus handles GET /admin/encryption: state of the field-level encryption at rest.
+func (h *Handlers) HandleEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.store.EncryptionStatus()
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "encryption status error", "error", err)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, status)
+}
+
+// HandleRotateEncryptionKeys handles POST /admin/encryption/rotate?appId=&externalUserId= (one
+// tenant) or ?all=true (all tenants): new data key, re-encryption of all values, old keys deleted.
+func (h *Handlers) HandleRotateEncryptionKeys(w http.ResponseWriter, r *http.Request) {
+	if !h.store.FieldEncryptionEnabled() {
+		http.Error(w, store.ErrEncryptionDisabled.Error(), http.StatusConflict)
+		return
+	}
+	all := helpers.GetQueryParam(r, "all")
+	if all == "true" || all == "1" {
+		rotations, err := h.store.RotateAllTenantKeys()
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "rotate encryption keys error", "error", err, "rotated", len(rotations))
+			return
+		}
+		helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{"rotations": rotations})
+		return
+	}
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+	rotation, err := h.store.RotateTenantKey(appID, externalUserID)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "rotate encryption key error", "error", err, "appId", appID, "userId", externalUserID)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{"rotations": []*store.KeyRotation{rotation}})
+}
+
+// HandleVerifyIntegrity handles POST /admin/verify: checks the database and the content hashes (and
+// signatures, with CORTEX_SIGNING_KEYFILE) of all memories and versions, optionally of one tenant
+// (?appId=&externalUserId=). ?sign=true signs rows that have no signature yet.
+func (h *Handlers) HandleVerifyIntegrity(w http.ResponseWriter, r *http.Request) {
+	opts := store.VerifyOptions{
+		AppID:          helpers.GetQueryParam(r, "appId"),
+		ExternalUserID: helpers.GetQueryParam(r, "externalUserId"),
+	}
+	if (opts.AppID == "") != (opts.ExternalUserID == "") {
+		http.Error(w, "appId and externalUserId must be given together", http.StatusBadRequest)
+		return
+	}
+	sign := helpers.GetQueryParam(r, "sign")
+	opts.Sign = sign == "true" || sign == "1"
+	if opts.Sign && !h.store.RowSigningEnabled() {
+		http.Error(w, store.ErrSigningDisabled.Error(), http.StatusConflict)
+		return
+	}
+	report, err := h.store.VerifyIntegrity(opts)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "verify integrity error", "error", err, "appId", opts.AppID, "userId", opts.ExternalUserID)
+		return
+	}
+	if len(report.Issues) > 0 || !report.DatabaseOK {
+		slog.Warn("integrity check found problems", "issues", len(report.Issues), "database_ok", report.DatabaseOK)
+	}
+	helpers.WriteJSON(w, http.StatusOK, report)
+}
+
+// API Key Handlers
+
+// HandleCreateAPIKey creates an API key (POST /api-keys). The key is only returned in this response.
+// Without CORTEX_API_KEY the first key must have the admin scope, otherwise nobody could manage keys.
+func (h *Handlers) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if !helpers.ParseJSONBodyOrError(w, r, &req) {
+		return
+	}
+	if os.Getenv("CORTEX_API_KEY") == "" && !slices.Contains(req.Scopes, models.ScopeAdmin) {
+		has, err := h.store.HasAPIKeys()
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "list api keys error", "error", err)
+			return
+		}
+		if !has {
+			http.Error(w, "the first API key needs the admin scope (or set CORTEX_API_KEY)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, row, err := h.store.CreateAPIKey(req.Name, req.AppIDs, req.Scopes, req.ExpiresAt)
+	if errors.Is(err, store.ErrAPIKeyRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "create api key error", "error", err)
+		return
+	}
+	audit.FromContext(r.Context()).AddTargets(row.ID)
+	slog.Info("api key created", "id", row.ID, "name", row.Name, "prefix", row.Prefix, "scopes", row.Scopes, "app_ids", row.AppIDs)
+
+	resp := row.ToAPIKeyResponse()
+	resp.Key = key
+	helpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+// HandleListAPIKeys lists the API keys without the keys themselves (GET /api-keys).
+func (h *Handlers) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.ListAPIKeys()
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "list api keys error", "error", err)
+		return
+	}
+	responses := mapToResponses(keys, func(k models.APIKey) models.APIKeyResponse {
+		return k.ToAPIKeyResponse()
+	})
+	helpers.WriteJSON(w, http.StatusOK, responses)
+}
+
+// HandleDeleteAPIKey revokes an API key (DELETE /api-keys/:id).
+func (h *Handlers) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := helpers.ExtractAndParseID(w, r.URL.Path, "/api-keys/")
+	if !ok {
+		return
+	}
+	err := h.store.DeleteAPIKey(id)
+	if h.handleStoreOperationWithNotFound(w, err, "API key", "delete api key", "id", id) {
+		return
+	}
+	slog.Info("api key deleted", "id", id)
+	helpers.WriteJSON(w, http.StatusOK, helpers.NewSuccessResponse(id, "API key deleted successfully"))
+}
+
+// HandleAuditLog returns the audit log of sensitive operations, newest first (GET /admin/audit).
+// Query: operation (comma-separated, e.g. backup.*,memory.delete), actorKeyId, actor, appId,
+// externalUserId, outcome (success, denied, failure), since/until (RFC3339), limit. Older pages are
+// fetched by passing next_before as before.
+func (h *Handlers) HandleAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter := store.AuditFilter{
+		Actor:          helpers.GetQueryParam(r, "actor"),
+		AppID:          helpers.GetQueryParam(r, "appId"),
+		ExternalUserID: helpers.GetQueryParam(r, "externalUserId"),
+		Outcome:        helpers.GetQueryParam(r, "outcome"),
+	}
+	if v := helpers.GetQueryParam(r, "operation"); v != "" {
+		for _, op := range strings.Split(v, ",") {
+			if op = strings.TrimSpace(op); op != "" {
+				filter.Operations = append(filter.Operations, op)
+			}
+		}
+	}
+	switch filter.Outcome {
+	case "", models.AuditOutcomeSuccess, models.AuditOutcomeDenied, models.AuditOutcomeFailure:
+	default:
+		http.Error(w, "invalid outcome (use success, denied or failure)", http.StatusBadRequest)
+		return
+	}
+	var before int64
+	for name, target := range map[string]*int64{"actorKeyId": &filter.ActorKeyID, "before": &before} {
+		if v := helpers.GetQueryParam(r, name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name+": must be an id >= 0", http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+	var err error
+	if filter.Since, err = helpers.ParseTimeParam(r, "since"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = helpers.ParseTimeParam(r, "until"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := helpers.ParseLimit(helpers.GetQueryParam(r, "limit"), helpers.DefaultAuditLimit, helpers.MaxAuditLimit)
+
+	page, err := h.store.ListAuditEvents(filter, before, limit)
+	if err != nil {
+		helpers.HandleInternalErrorSlog(w, "audit log error", "error", err)
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, page)
+}
+
 // Analytics API Handlers
 
 func (h *Handlers) HandleAnalytics(w http.ResponseWriter, r *http.Request) {
@@ -1009,6 +2270,14 @@ func (h *Handlers) HandleAnalytics(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Ohne vollständigen Tenant: globale Analytik, nur für Keys ohne Tenant-Beschränkung
+	if appID == "" || externalUserID == "" {
+		appID, externalUserID = "", ""
+	}
+	if !helpers.AuthorizeTenant(w, r, appID, externalUserID) {
+		return
+	}
+
 	var analytics *store.AnalyticsData
 	var err error
 
@@ -1028,12 +2297,16 @@ func (h *Handlers) HandleAnalytics(w http.ResponseWriter, r *http.Request) {
 	helpers.WriteJSON(w, http.StatusOK, analytics)
 }
 
-// triggerWebhook triggers webhooks for a given event
+// triggerWebhook records an event in the event log (live stream, see HandleEventStream) and queues
+// it for the subscribed webhooks of its app; events without an app only go to global webhooks
 func (h *Handlers) triggerWebhook(event webhooks.EventType, data map[string]interface{}) {
-	// Get app_id from data if available
-	appID := ""
-	if id, ok := data["app_id"].(string); ok {
-		appID = id
+	// Get tenant from data if available
+	appID, _ := data["app_id"].(string)
+	externalUserID, _ := data["external_user_id"].(string)
+
+	ev, err := h.events.Publish(string(event), appID, externalUserID, data)
+	if err != nil {
+		slog.Warn("failed to record event", "event", event, "error", err)
 	}
 
 	// Get active webhooks
@@ -1042,30 +2315,21 @@ func (h *Handlers) triggerWebhook(event webhooks.EventType, data map[string]inte
 		slog.Warn("failed to list webhooks", "error", err)
 		return
 	}
-
-	// Convert to webhook configs
-	configs := make([]webhooks.WebhookConfig, 0, len(webhookList))
-	for _, wh := range webhookList {
-		if !wh.Active {
-			continue
-		}
-
-		// Parse events
-		events := strings.Split(wh.Events, ",")
-		eventTypes := make([]webhooks.EventType, 0, len(events))
-		for _, e := range events {
-			eventTypes = append(eventTypes, webhooks.EventType(strings.TrimSpace(e)))
-		}
-
-		configs = append(configs, webhooks.WebhookConfig{
-			URL:    wh.URL,
-			Secret: wh.Secret,
-			Events: eventTypes,
-		})
+	// Events ohne Tenant (Administration, z.B. backup.restored) gehen nur an globale Webhooks
+	if appID == "" {
+		webhookList = slices.DeleteFunc(webhookList, func(wh models.Webhook) bool { return wh.AppID != "" })
 	}
 
-	// Deliver webhooks asynchronously
-	webhooks.DeliverWebhooksAsync(configs, event, data)
+	// Persist one delivery per subscribed webhook; the dispatcher sends them (with retries).
+	// Webhooks are delivered even if the event could not be logged.
+	if ev != nil {
+		_, err = h.deliveries.EnqueueEvent(webhookList, ev)
+	} else {
+		_, err = h.deliveries.Enqueue(webhookList, event, data)
+	}
+	if err != nil {
+		slog.Warn("failed to enqueue webhook deliveries", "event", event, "error", err)
+	}
 }
 
 // HandleCreateAgentContext creates an agent context (Neutron-compatible)
@@ -1074,13 +2338,8 @@ func (h *Handlers) HandleCreateAgentContext(w http.ResponseWriter, r *http.Reque
 	if !helpers.ParseJSONBodyOrError(w, r, &req) {
 		return
 	}
-	appID, externalUserID := helpers.ExtractTenantParams(r, &req.TenantRequest)
-	fields := map[string]string{
-		"appId": appID, "externalUserId": externalUserID,
-		"agentId": req.AgentID, "memoryType": req.MemoryType,
-	}
-	if field, ok := helpers.ValidateRequired(fields); !ok {
-		http.Error(w, "missing required field: "+field, http.StatusBadRequest)
+	appID, externalUserID, ok := helpers.ValidateTenantParamsWithFields(w, r, &req.TenantRequest, map[string]string{"agentId": req.AgentID, "memoryType": req.MemoryType}, false)
+	if !ok {
 		return
 	}
 	memType := strings.ToLower(strings.TrimSpace(req.MemoryType))
@@ -1107,6 +2366,15 @@ func (h *Handlers) HandleCreateAgentContext(w http.ResponseWriter, r *http.Reque
 		helpers.HandleInternalErrorSlog(w, "create agent context error", "error", err)
 		return
 	}
+	go h.triggerWebhook(webhooks.EventContextCreated, map[string]interface{}{
+		"id":               ctx.ID,
+		"app_id":           appID,
+		"external_user_id": externalUserID,
+		"agent_id":         ctx.AgentID,
+		"memory_type":      ctx.MemoryType,
+		"tags":             req.Tags,
+		"created_at":       ctx.CreatedAt,
+	})
 	helpers.WriteJSON(w, http.StatusCreated, map[string]any{
 		"id":         ctx.ID,
 		"message":    "Agent context created",
@@ -1122,6 +2390,9 @@ func (h *Handlers) HandleListAgentContexts(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "missing required query parameter: appId and externalUserId", http.StatusBadRequest)
 		return
 	}
+	if !helpers.AuthorizeTenant(w, r, appID, externalUserID) {
+		return
+	}
 	agentID := helpers.GetQueryParam(r, "agentId")
 	memoryType := helpers.GetQueryParam(r, "memoryType")
 	tags := helpers.GetQueryParam(r, "tags")
@@ -1170,6 +2441,9 @@ func (h *Handlers) HandleGetAgentContext(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "missing required query parameter: appId and externalUserId", http.StatusBadRequest)
 		return
 	}
+	if !helpers.AuthorizeTenant(w, r, appID, externalUserID) {
+		return
+	}
 	ctx, err := h.store.GetAgentContextByIDAndTenant(id, appID, externalUserID)
 	if err != nil {
 		if helpers.HandleNotFoundError(w, err, "Agent context") {
@@ -1201,3 +2475,72 @@ func (h *Handlers) HandleGetAgentContext(w http.ResponseWriter, r *http.Request)
 		UpdatedAt:      ctx.UpdatedAt,
 	})
 }
+
+// Re-Embed API Handlers
+
+// HandleReembed routes GET/POST /admin/reembed (Jobs eines Tenants / neuen Job starten)
+func (h *Handlers) HandleReembed(w http.ResponseWriter, r *http.Request) {
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		batchSize := helpers.ParseLimit(helpers.GetQueryParam(r, "batchSize"), reembed.DefaultBatchSize, 256)
+		job, err := h.reembed.Start(appID, externalUserID, batchSize)
+		if errors.Is(err, reembed.ErrJobRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "start reembed error", "error", err, "appId", appID, "userId", externalUserID)
+			return
+		}
+		helpers.WriteJSON(w, http.StatusAccepted, job)
+	case http.MethodGet:
+		modelID := embeddings.ModelIDOf(embeddings.GetEmbeddingService())
+		pending, err := h.store.CountMemoriesNeedingReembed(appID, externalUserID, modelID)
+		if err != nil {
+			helpers.HandleInternalErrorSlog(w, "reembed status error", "error", err, "appId", appID, "userId", externalUserID)
+			return
+		}
+		helpers.WriteJSON(w, http.StatusOK, map[string]any{
+			"model":   modelID,
+			"pending": pending,
+			"jobs":    h.reembed.List(appID, externalUserID),
+		})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleReembedJob routes GET /admin/reembed/:id (Fortschritt) und DELETE /admin/reembed/:id (abbrechen)
+func (h *Handlers) HandleReembedJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := helpers.ExtractAndParseID(w, r.URL.Path, "/admin/reembed/")
+	if !ok {
+		return
+	}
+	appID, externalUserID, ok := helpers.ValidateTenantParams(w, r, nil, true)
+	if !ok {
+		return
+	}
+	job, found := h.reembed.Get(id)
+	if !found || job.AppID != appID || job.ExternalUserID != externalUserID {
+		http.Error(w, "reembed job not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		helpers.WriteJSON(w, http.StatusOK, job)
+	case http.MethodDelete:
+		if !h.reembed.Cancel(id) {
+			http.Error(w, "reembed job is not running", http.StatusConflict)
+			return
+		}
+		helpers.WriteJSON(w, http.StatusAccepted, helpers.NewSuccessResponse(id, "Reembed job cancelling"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
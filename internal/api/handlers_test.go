@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cortex/internal/crypt"
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+	"cortex/internal/store"
+	"cortex/internal/webhooks"
+)
+
+// setupTenantTest returns handlers on a fresh store with a memory, a bundle, an agent context and a
+// webhook in each of app1/alice and app2/bob.
+func setupTenantTest(t *testing.T) (*Handlers, map[string]int64) {
+	t.Helper()
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	ids := make(map[string]int64)
+	for _, tenant := range [][2]string{{"app1", "alice"}, {"app2", "bob"}} {
+		mem := &models.Memory{Content: "Notiz von " + tenant[1], AppID: tenant[0], ExternalUserID: tenant[1], Importance: 5}
+		if err := s.CreateMemory(mem); err != nil {
+			t.Fatal(err)
+		}
+		bundle := &models.Bundle{Name: "b", AppID: tenant[0], ExternalUserID: tenant[1]}
+		if err := s.CreateBundle(bundle); err != nil {
+			t.Fatal(err)
+		}
+		ctx := &models.AgentContext{AppID: tenant[0], ExternalUserID: tenant[1], AgentID: "agent", MemoryType: "episodic"}
+		if err := s.CreateAgentContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		wh := &models.Webhook{URL: "https://example.com/hook", Events: "memory.created", AppID: tenant[0], Active: true}
+		if err := s.CreateWebhook(wh); err != nil {
+			t.Fatal(err)
+		}
+		ids["memory:"+tenant[0]] = mem.ID
+		ids["bundle:"+tenant[0]] = bundle.ID
+		ids["context:"+tenant[0]] = ctx.ID
+		ids["webhook:"+tenant[0]] = wh.ID
+	}
+	return NewHandlers(s), ids
+}
+
+// call runs handler for a request of principal p (nil: without authentication).
+func call(handler http.HandlerFunc, p *helpers.Principal, method, target string, body any) int {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	if p != nil {
+		req = req.WithContext(helpers.WithPrincipal(req.Context(), p))
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w.Code
+}
+
+func TestTenantEnforcement(t *testing.T) {
+	h, ids := setupTenantTest(t)
+	app1 := &helpers.Principal{Name: "app1-key", AppIDs: []string{"app1"}, Scopes: []string{"read", "write"}}
+	alice := &helpers.Principal{Name: "alice", AppIDs: []string{"app1"}, ExternalUserID: "alice", Scopes: []string{"read", "write"}}
+	own, other := "appId=app1&externalUserId=alice", "appId=app2&externalUserId=bob"
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		p       *helpers.Principal
+		method  string
+		target  string
+		body    any
+		want    int
+	}{
+		// Seeds
+		{"store seed own", h.HandleStoreSeed, app1, "POST", "/seeds", map[string]any{"appId": "app1", "externalUserId": "alice", "content": "x"}, http.StatusOK},
+		{"store seed body tenant", h.HandleStoreSeed, app1, "POST", "/seeds", map[string]any{"appId": "app2", "externalUserId": "bob", "content": "x"}, http.StatusForbidden},
+		{"store seed query tenant", h.HandleStoreSeed, app1, "POST", "/seeds?" + other, map[string]any{"content": "x"}, http.StatusForbidden},
+		{"store seed other user", h.HandleStoreSeed, alice, "POST", "/seeds", map[string]any{"appId": "app1", "externalUserId": "carol", "content": "x"}, http.StatusForbidden},
+		{"store seed foreign bundle", h.HandleStoreSeed, nil, "POST", "/seeds", map[string]any{"appId": "app1", "externalUserId": "alice", "content": "x", "bundleId": ids["bundle:app2"]}, http.StatusForbidden},
+		{"query seeds", h.HandleQuerySeed, app1, "POST", "/seeds/query", map[string]any{"appId": "app2", "externalUserId": "bob", "query": "Notiz"}, http.StatusForbidden},
+		{"list seeds", h.HandleListSeeds, app1, "GET", "/seeds?" + other, nil, http.StatusForbidden},
+		{"get seed own", h.HandleSeedsByID, alice, "GET", fmt.Sprintf("/seeds/%d?%s", ids["memory:app1"], own), nil, http.StatusOK},
+		{"get seed other", h.HandleSeedsByID, app1, "GET", fmt.Sprintf("/seeds/%d?%s", ids["memory:app2"], other), nil, http.StatusForbidden},
+		{"delete seed other", h.HandleSeedsByID, app1, "DELETE", fmt.Sprintf("/seeds/%d?%s", ids["memory:app2"], other), nil, http.StatusForbidden},
+		{"recall all tenants", h.HandleRecall, app1, "GET", "/recall?q=Notiz", nil, http.StatusForbidden},
+		{"stats all tenants", h.HandleStats, app1, "GET", "/stats", nil, http.StatusForbidden},
+
+		// Bundles
+		{"create bundle other", h.HandleCreateBundle, app1, "POST", "/bundles", map[string]any{"appId": "app2", "externalUserId": "bob", "name": "x"}, http.StatusForbidden},
+		{"list bundles own", h.HandleListBundles, app1, "GET", "/bundles?" + own, nil, http.StatusOK},
+		{"list bundles other", h.HandleListBundles, app1, "GET", "/bundles?" + other, nil, http.StatusForbidden},
+		{"get bundle other", h.HandleGetBundle, app1, "GET", fmt.Sprintf("/bundles/%d?%s", ids["bundle:app2"], other), nil, http.StatusForbidden},
+		{"delete bundle other", h.HandleDeleteBundle, app1, "DELETE", fmt.Sprintf("/bundles/%d?%s", ids["bundle:app2"], other), nil, http.StatusForbidden},
+
+		// Agent contexts
+		{"create context other", h.HandleCreateAgentContext, app1, "POST", "/agent-contexts", map[string]any{"appId": "app2", "externalUserId": "bob", "agentId": "a", "memoryType": "episodic"}, http.StatusForbidden},
+		{"list contexts other", h.HandleListAgentContexts, app1, "GET", "/agent-contexts?" + other, nil, http.StatusForbidden},
+		{"get context own", h.HandleGetAgentContext, app1, "GET", fmt.Sprintf("/agent-contexts/%d?%s", ids["context:app1"], own), nil, http.StatusOK},
+		{"get context other", h.HandleGetAgentContext, app1, "GET", fmt.Sprintf("/agent-contexts/%d?%s", ids["context:app2"], other), nil, http.StatusForbidden},
+
+		// Export / Import
+		{"export own", h.HandleExport, app1, "GET", "/export?" + own, nil, http.StatusOK},
+		{"export other", h.HandleExport, app1, "GET", "/export?" + other, nil, http.StatusForbidden},
+		{"import other", h.HandleImport, app1, "POST", "/import?remap=true&" + other, map[string]any{}, http.StatusForbidden},
+		{"import without remap", h.HandleImport, app1, "POST", "/import?" + own, map[string]any{}, http.StatusForbidden},
+
+		// Analytics
+		{"analytics own", h.HandleAnalytics, alice, "GET", "/analytics?" + own, nil, http.StatusOK},
+		{"analytics other", h.HandleAnalytics, app1, "GET", "/analytics?" + other, nil, http.StatusForbidden},
+		{"analytics global", h.HandleAnalytics, app1, "GET", "/analytics?appId=app1", nil, http.StatusForbidden},
+		{"analytics global unrestricted", h.HandleAnalytics, &helpers.Principal{Scopes: []string{"read"}}, "GET", "/analytics", nil, http.StatusOK},
+		{"analytics global without auth", h.HandleAnalytics, nil, "GET", "/analytics", nil, http.StatusOK},
+
+		// Webhooks (pro App)
+		{"create webhook other app", h.HandleCreateWebhook, app1, "POST", "/webhooks", map[string]any{"url": "https://example.com/x", "events": []string{"memory.created"}, "appId": "app2"}, http.StatusForbidden},
+		{"create webhook all apps", h.HandleCreateWebhook, app1, "POST", "/webhooks", map[string]any{"url": "https://example.com/x", "events": []string{"memory.created"}}, http.StatusForbidden},
+		{"create webhook user key", h.HandleCreateWebhook, alice, "POST", "/webhooks", map[string]any{"url": "https://example.com/x", "events": []string{"memory.created"}, "appId": "app1"}, http.StatusForbidden},
+		{"list webhooks own", h.HandleListWebhooks, app1, "GET", "/webhooks?appId=app1", nil, http.StatusOK},
+		{"list webhooks all", h.HandleListWebhooks, app1, "GET", "/webhooks", nil, http.StatusForbidden},
+		{"delete webhook other", h.HandleWebhooksByID, app1, "DELETE", fmt.Sprintf("/webhooks/%d?appId=app2", ids["webhook:app2"]), nil, http.StatusForbidden},
+		{"deliveries own", h.HandleWebhooksByID, app1, "GET", fmt.Sprintf("/webhooks/%d/deliveries?appId=app1", ids["webhook:app1"]), nil, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := call(tc.handler, tc.p, tc.method, tc.target, tc.body); got != tc.want {
+				t.Errorf("%s %s: expected %d, got %d", tc.method, tc.target, tc.want, got)
+			}
+		})
+	}
+
+	// Verbotene Zugriffe ändern nichts
+	if _, err := h.store.GetMemoryByIDAndTenant(ids["memory:app2"], "app2", "bob", false); err != nil {
+		t.Errorf("memory of app2 must still exist: %v", err)
+	}
+	if _, err := h.store.GetBundle(ids["bundle:app2"], "app2", "bob"); err != nil {
+		t.Errorf("bundle of app2 must still exist: %v", err)
+	}
+}
+
+func TestRememberPayloadWithEncryption(t *testing.T) {
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	master, err := crypt.NewKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EnableFieldEncryption(master); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlers(s)
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		if code := call(h.HandleRemember, nil, http.MethodPost, "/remember", map[string]any{"content": fmt.Sprintf("Notiz %d", i)}); code != http.StatusOK {
+			t.Fatalf("remember: status %d", code)
+		}
+	}
+
+	// Events werden asynchron geschrieben
+	var events []models.Event
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if events, err = s.ListEventsSince("openclaw", "default", 0, []string{"memory.created"}, 100); err == nil && len(events) == n {
+			break
+		}
+	}
+	if len(events) != n {
+		t.Fatalf("expected %d events, got %d (%v)", n, len(events), err)
+	}
+	for _, ev := range events {
+		var payload struct {
+			Data struct {
+				Content string `json:"content"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(payload.Data.Content, "Notiz ") {
+			t.Errorf("payload content must be plaintext, got %q", payload.Data.Content)
+		}
+	}
+}
+
+func TestAdminEventsOnlyReachGlobalWebhooks(t *testing.T) {
+	h, ids := setupTenantTest(t)
+	global := &models.Webhook{URL: "https://example.com/admin", Events: "*", Active: true}
+	if err := h.store.CreateWebhook(global); err != nil {
+		t.Fatal(err)
+	}
+	tenant, _ := h.store.GetWebhook(ids["webhook:app1"])
+	tenant.Events = "*"
+	if err := h.store.UpdateWebhook(tenant); err != nil {
+		t.Fatal(err)
+	}
+
+	h.triggerWebhook(webhooks.EventBackupRestored, map[string]interface{}{"backup": "cortex-backup.db"})
+
+	if got, _ := h.store.ListWebhookDeliveries(tenant.ID, "", 10); len(got) != 0 {
+		t.Errorf("app webhook must not receive admin events, got %d deliveries", len(got))
+	}
+	got, err := h.store.ListWebhookDeliveries(global.ID, "", 10)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("global webhook: expected 1 delivery, got %d (%v)", len(got), err)
+	}
+	if strings.Contains(got[0].Payload, "/") {
+		t.Errorf("payload must not contain server paths: %s", got[0].Payload)
+	}
+}
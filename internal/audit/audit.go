@@ -0,0 +1,271 @@
+// Package audit records sensitive operations (backup/restore, import/export, cleanup, webhook and key
+// management, deletes) in the audit_events table: actor, operation, tenant, targets, client IP and
+// outcome. Logger.Wrap records a route; handlers add what only they know via FromContext.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+// Operations (AuditEvent.Operation)
+const (
+	OpBackupCreate     = "backup.create"
+	OpBackupRestore    = "backup.restore"
+	OpBackupPrune      = "backup.prune"
+	OpExport           = "data.export"
+	OpImport           = "data.import"
+	OpCleanup          = "admin.cleanup"
+	OpReembedStart     = "admin.reembed"
+	OpReembedCancel    = "admin.reembed_cancel"
+	OpClaimLegacyGraph = "admin.claim_legacy_graph"
+	OpEncryptionRotate = "admin.encryption_rotate"
+	OpVerifyIntegrity  = "admin.verify"
+	OpWebhookCreate    = "webhook.create"
+	OpWebhookUpdate    = "webhook.update"
+	OpWebhookDelete    = "webhook.delete"
+	OpWebhookRedeliver = "webhook.redeliver"
+	OpMemoryDelete     = "memory.delete"
+	OpMemoryMerge      = "memory.merge"
+	OpBundleDelete     = "bundle.delete"
+	OpAPIKeyCreate     = "api_key.create"
+	OpAPIKeyDelete     = "api_key.delete"
+)
+
+// DefaultRetention is how long audit events are kept.
+const DefaultRetention = 90 * 24 * time.Hour
+
+// anonymousActor is the actor of requests without authentication (local/dev mode).
+const anonymousActor = "anonymous"
+
+// maxErrorLength limits the error message stored per event.
+const maxErrorLength = 500
+
+// RetentionFromEnv returns CORTEX_AUDIT_RETENTION (duration, 0 = keep forever), default DefaultRetention.
+func RetentionFromEnv() time.Duration {
+	v := os.Getenv("CORTEX_AUDIT_RETENTION")
+	if v == "" {
+		return DefaultRetention
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Warn("invalid CORTEX_AUDIT_RETENTION, using default", "value", v, "default", DefaultRetention)
+		return DefaultRetention
+	}
+	return d
+}
+
+// Ops maps the HTTP methods of a route to the operation recorded for them; other methods are not
+// recorded.
+type Ops map[string]string
+
+// Entry is the audit event of the current request. Handlers set tenant and targets that are not in
+// the query or path; all methods are no-ops on nil (request not audited).
+type Entry struct {
+	actor                 *helpers.Principal
+	appID, externalUserID string
+	tenantSet             bool
+	targets               []string
+	err                   string
+}
+
+type entryKey struct{}
+
+// FromContext returns the audit entry of a request, nil if the route is not audited.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(entryKey{}).(*Entry)
+	return e
+}
+
+// SetTenant sets the tenant of the operation (default: appId/externalUserId query parameters).
+func (e *Entry) SetTenant(appID, externalUserID string) {
+	if e != nil {
+		e.appID, e.externalUserID, e.tenantSet = appID, externalUserID, true
+	}
+}
+
+// AddTargets adds the IDs of affected objects (default: numeric segments of the path).
+func (e *Entry) AddTargets(ids ...int64) {
+	if e == nil {
+		return
+	}
+	for _, id := range ids {
+		e.targets = append(e.targets, strconv.FormatInt(id, 10))
+	}
+}
+
+// Fail marks the operation as failed although the response status is 200 (streamed responses).
+func (e *Entry) Fail(err error) {
+	if e != nil && err != nil {
+		e.err = err.Error()
+	}
+}
+
+// Logger writes audit events to the store and prunes them after the retention.
+type Logger struct {
+	store     *store.CortexStore
+	retention time.Duration
+}
+
+// NewLogger creates an audit logger on the store. retention <= 0 keeps events forever.
+func NewLogger(s *store.CortexStore, retention time.Duration) *Logger {
+	return &Logger{store: s, retention: retention}
+}
+
+// Wrap records the requests of a route whose method is in ops. It wraps AuthMiddleware, so requests
+// the middleware rejects (bad credentials, missing scope, foreign app) are recorded as denied; the
+// actor is the principal the middleware authenticated. Failures to write the audit log are logged,
+// never returned to the client.
+func (l *Logger) Wrap(next http.HandlerFunc, ops Ops) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, ok := ops[r.Method]
+		if !ok {
+			next(w, r)
+			return
+		}
+		entry := &Entry{}
+		rec := &statusRecorder{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), entryKey{}, entry)
+		ctx = helpers.WithPrincipalObserver(ctx, func(p *helpers.Principal) { entry.actor = p })
+		next(rec, r.WithContext(ctx))
+		l.Record(l.event(op, r, entry, rec))
+	}
+}
+
+// Record appends ev to the audit log.
+func (l *Logger) Record(ev *models.AuditEvent) {
+	if err := l.store.AppendAuditEvent(ev); err != nil {
+		slog.Error("audit log: append failed", "error", err, "operation", ev.Operation, "actor", ev.Actor)
+		return
+	}
+	slog.Info("audit", "operation", ev.Operation, "actor", ev.Actor, "outcome", ev.Outcome, "appId", ev.AppID, "userId", ev.ExternalUserID, "targets", ev.TargetIDs)
+}
+
+func (l *Logger) event(op string, r *http.Request, entry *Entry, rec *statusRecorder) *models.AuditEvent {
+	ev := &models.AuditEvent{
+		Operation: op,
+		Actor:     anonymousActor,
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		ClientIP:  helpers.ClientIP(r),
+		Status:    rec.Status(),
+		Targets:   entry.targets,
+		CreatedAt: time.Now(),
+	}
+	p := entry.actor
+	if p == nil {
+		p = helpers.PrincipalFromContext(r.Context())
+	}
+	if p != nil {
+		ev.Actor, ev.ActorKeyID = p.Name, p.KeyID
+	}
+	ev.AppID, ev.ExternalUserID = entry.appID, entry.externalUserID
+	if !entry.tenantSet {
+		ev.AppID, ev.ExternalUserID = helpers.GetQueryParam(r, "appId"), helpers.GetQueryParam(r, "externalUserId")
+	}
+	if len(ev.Targets) == 0 {
+		ev.Targets = pathIDs(r.URL.Path)
+	}
+
+	switch {
+	case ev.Status == http.StatusUnauthorized || ev.Status == http.StatusForbidden:
+		ev.Outcome = models.AuditOutcomeDenied
+	case ev.Status >= http.StatusBadRequest || entry.err != "":
+		ev.Outcome = models.AuditOutcomeFailure
+	default:
+		ev.Outcome = models.AuditOutcomeSuccess
+	}
+	ev.Error = entry.err
+	if ev.Error == "" && ev.Status >= http.StatusBadRequest {
+		ev.Error = strings.TrimSpace(rec.body.String())
+	}
+	if len(ev.Error) > maxErrorLength {
+		ev.Error = ev.Error[:maxErrorLength]
+	}
+	return ev
+}
+
+// pathIDs returns the numeric segments of a path (e.g. 12 and 5 of /webhooks/12/deliveries/5).
+func pathIDs(path string) []string {
+	var ids []string
+	for _, seg := range strings.Split(path, "/") {
+		if _, err := strconv.ParseInt(seg, 10, 64); err == nil {
+			ids = append(ids, seg)
+		}
+	}
+	return ids
+}
+
+// Run prunes audit events older than the retention every hour until ctx is done.
+func (l *Logger) Run(ctx context.Context) {
+	if l.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := l.store.PruneAuditEvents(time.Now().Add(-l.retention)); err != nil {
+			slog.Error("audit log: prune failed", "error", err)
+		} else if n > 0 {
+			slog.Info("audit log: pruned old events", "count", n, "retention", l.retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusRecorder captures the status and, for errors, the start of the body (the error message).
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   strings.Builder
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status >= http.StatusBadRequest && r.body.Len() < maxErrorLength {
+		r.body.Write(b[:min(len(b), maxErrorLength-r.body.Len())])
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Status returns the response status (200 if the handler wrote nothing).
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Flush passes through to the underlying writer (streamed NDJSON import/export).
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/middleware"
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+func setupLogger(t *testing.T) (*Logger, *store.CortexStore) {
+	t.Helper()
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCortexStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return NewLogger(s, DefaultRetention), s
+}
+
+func TestWrap(t *testing.T) {
+	l, s := setupLogger(t)
+	admin := &helpers.Principal{Name: "ops", KeyID: 4, Scopes: []string{models.ScopeAdmin}}
+
+	handler := l.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("case") {
+		case "denied":
+			http.Error(w, "forbidden: tenant not allowed for this key", http.StatusForbidden)
+		case "annotated":
+			entry := FromContext(r.Context())
+			entry.SetTenant("app2", "")
+			entry.AddTargets(7, 8)
+		case "stream":
+			w.Write([]byte(`{"type":"result"}`))
+			FromContext(r.Context()).Fail(errors.New("commit failed"))
+		}
+	}, Ops{http.MethodDelete: OpMemoryDelete, http.MethodPost: OpImport})
+
+	run := func(method, target string, p *helpers.Principal) {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "192.0.2.1:5555"
+		if p != nil {
+			req = req.WithContext(helpers.WithPrincipal(req.Context(), p))
+		}
+		handler(httptest.NewRecorder(), req)
+	}
+	run(http.MethodDelete, "/seeds/12?appId=app1&externalUserId=alice", admin)
+	run(http.MethodGet, "/seeds/12?appId=app1&externalUserId=alice", admin) // nicht auditiert
+	run(http.MethodDelete, "/seeds/13?appId=app1&externalUserId=bob&case=denied", nil)
+	run(http.MethodPost, "/import?appId=app1&case=annotated", admin)
+	run(http.MethodPost, "/import?case=stream", admin)
+
+	page, err := s.ListAuditEvents(store.AuditFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 4 {
+		t.Fatalf("expected 4 audit events, got %d", len(page.Events))
+	}
+	stream, annotated, denied, deleted := page.Events[0], page.Events[1], page.Events[2], page.Events[3]
+
+	if deleted.Operation != OpMemoryDelete || deleted.Actor != "ops" || deleted.ActorKeyID != 4 || deleted.AppID != "app1" ||
+		deleted.ExternalUserID != "alice" || !slices.Equal(deleted.Targets, []string{"12"}) || deleted.ClientIP != "192.0.2.1" ||
+		deleted.Status != http.StatusOK || deleted.Outcome != models.AuditOutcomeSuccess || deleted.Path != "/seeds/12?appId=app1&externalUserId=alice" {
+		t.Errorf("delete: %+v", deleted)
+	}
+	if denied.Actor != "anonymous" || denied.Outcome != models.AuditOutcomeDenied || denied.Error != "forbidden: tenant not allowed for this key" {
+		t.Errorf("denied: %+v", denied)
+	}
+	if annotated.Operation != OpImport || annotated.AppID != "app2" || !slices.Equal(annotated.Targets, []string{"7", "8"}) {
+		t.Errorf("annotated: %+v", annotated)
+	}
+	if stream.Status != http.StatusOK || stream.Outcome != models.AuditOutcomeFailure || stream.Error != "commit failed" {
+		t.Errorf("stream: %+v", stream)
+	}
+
+	// Ohne Wrap ist der Eintrag nil und alle Methoden sind No-ops
+	var entry *Entry
+	entry.SetTenant("a", "b")
+	entry.AddTargets(1)
+	entry.Fail(errors.New("x"))
+}
+
+func TestWrapRecordsAuthDenials(t *testing.T) {
+	l, s := setupLogger(t)
+	reader, readerKey, err := s.CreateAPIKey("reader", nil, []string{models.ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app2, _, err := s.CreateAPIKey("app2", []string{"app2"}, []string{models.ScopeRead, models.ScopeWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware.SetKeyStore(s)
+	defer middleware.SetKeyStore(nil)
+
+	// Wie in cmd/cortex-server: Audit außerhalb der Authentifizierung
+	var reached bool
+	handler := l.Wrap(middleware.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}), Ops{http.MethodPost: OpBackupRestore, http.MethodDelete: OpMemoryDelete})
+	run := func(method, target, key string) {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-API-Key", key)
+		handler(httptest.NewRecorder(), req)
+	}
+	run(http.MethodPost, "/restore", reader)
+	run(http.MethodPost, "/restore", "wrong")
+	run(http.MethodDelete, "/seeds/5?appId=app1&externalUserId=alice", app2)
+	if reached {
+		t.Fatal("denied requests must not reach the handler")
+	}
+
+	page, err := s.ListAuditEvents(store.AuditFilter{}, 0, 10)
+	if err != nil || len(page.Events) != 3 {
+		t.Fatalf("expected 3 audit events, got %d (%v)", len(page.Events), err)
+	}
+	foreignApp, badKey, noScope := page.Events[0], page.Events[1], page.Events[2]
+	if noScope.Operation != OpBackupRestore || noScope.Outcome != models.AuditOutcomeDenied || noScope.Status != http.StatusForbidden ||
+		noScope.Actor != "reader" || noScope.ActorKeyID != readerKey.ID {
+		t.Errorf("read-scoped key on /restore: %+v", noScope)
+	}
+	if badKey.Outcome != models.AuditOutcomeDenied || badKey.Status != http.StatusUnauthorized || badKey.Actor != "anonymous" {
+		t.Errorf("invalid key: %+v", badKey)
+	}
+	if foreignApp.Operation != OpMemoryDelete || foreignApp.Outcome != models.AuditOutcomeDenied || foreignApp.Actor != "app2" || foreignApp.AppID != "app1" {
+		t.Errorf("foreign app: %+v", foreignApp)
+	}
+}
+
+func TestRetentionFromEnv(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":        DefaultRetention,
+		"720h":    720 * time.Hour,
+		"0":       0,
+		"invalid": DefaultRetention,
+		"-1h":     DefaultRetention,
+	}
+	for v, want := range cases {
+		t.Setenv("CORTEX_AUDIT_RETENTION", v)
+		if got := RetentionFromEnv(); got != want {
+			t.Errorf("CORTEX_AUDIT_RETENTION=%q: expected %v, got %v", v, want, got)
+		}
+	}
+}
@@ -0,0 +1,581 @@
+// Package backup creates scheduled database backups with checksum manifests and prunes them by a
+// retention policy (keep last N plus daily/weekly grandfather-father-son generations). Backups can be
+// compressed and encrypted (see package crypt); the file name shows both: cortex-backup-….db.gz.enc.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cortex/internal/crypt"
+	"cortex/internal/store"
+)
+
+// Compression formats (Config.Compression)
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd" // needs the zstd binary in PATH
+)
+
+// Triggers of a backup (Manifest.Trigger). Only scheduled backups are pruned.
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+// manifestSuffix is appended to the backup file name for its manifest.
+const manifestSuffix = ".manifest.json"
+
+// ErrNotFound is returned for a backup file that does not exist in the directory.
+var ErrNotFound = errors.New("backup not found")
+
+// Config holds backup configuration.
+type Config struct {
+	// Dir is the backup directory; relative paths are relative to the database directory.
+	// Empty: backups/ next to the database.
+	Dir string
+	// Compression: "" (none), "gzip" or "zstd"
+	Compression string
+	// KeepLast: the newest N scheduled backups are always kept
+	KeepLast int
+	// KeepDaily: the newest scheduled backup of each of the last N days (that have backups) is kept
+	KeepDaily int
+	// KeepWeekly: the newest scheduled backup of each of the last N ISO weeks (that have backups) is kept
+	KeepWeekly int
+	// Encrypt: encrypt backups with Key (after compression)
+	Encrypt bool
+	// Key decrypts encrypted backups (Verify, Unpack) and is required for Encrypt
+	Key *crypt.Key
+}
+
+// Manifest describes a backup file; it is stored next to it as <file>.manifest.json.
+type Manifest struct {
+	File        string    `json:"file"` // file name in the backup directory
+	CreatedAt   time.Time `json:"created_at"`
+	Trigger     string    `json:"trigger"`
+	Compression string    `json:"compression,omitempty"`
+	Encrypted   bool      `json:"encrypted,omitempty"`
+	Size        int64     `json:"size"`   // bytes of the stored (compressed) file
+	SHA256      string    `json:"sha256"` // of the stored file
+}
+
+// Info is one entry of List: a backup with manifest, or a plain database file without (backups with
+// an explicit path, pre-restore snapshots).
+type Info struct {
+	Manifest
+	HasManifest bool `json:"has_manifest"`
+}
+
+// VerifyResult is the result of Verify for one backup.
+type VerifyResult struct {
+	File        string `json:"file"`
+	ChecksumOK  bool   `json:"checksum_ok"` // false without manifest
+	IntegrityOK bool   `json:"integrity_ok"`
+	Error       string `json:"error,omitempty"`
+}
+
+// DefaultConfig keeps the last 7 backups, one per day for 7 days and one per week for 4 weeks, uncompressed.
+func DefaultConfig() Config {
+	return Config{
+		KeepLast:   7,
+		KeepDaily:  7,
+		KeepWeekly: 4,
+	}
+}
+
+// ConfigFromEnv returns Config from environment variables.
+// CORTEX_BACKUP_DIR=/var/backups/cortex, CORTEX_BACKUP_COMPRESSION=gzip|zstd,
+// CORTEX_BACKUP_KEEP_LAST=7, CORTEX_BACKUP_KEEP_DAILY=7, CORTEX_BACKUP_KEEP_WEEKLY=4,
+// CORTEX_BACKUP_ENCRYPT=true (key from CORTEX_ENCRYPTION_KEYFILE or CORTEX_ENCRYPTION_PASSPHRASE)
+func ConfigFromEnv() Config {
+	c := DefaultConfig()
+	c.Dir = os.Getenv("CORTEX_BACKUP_DIR")
+	c.Encrypt = os.Getenv("CORTEX_BACKUP_ENCRYPT") == "true" || os.Getenv("CORTEX_BACKUP_ENCRYPT") == "1"
+	key, err := crypt.KeyFromEnv()
+	if err != nil {
+		slog.Error("backup: encryption key not loaded", "error", err)
+	}
+	c.Key = key
+	if v := os.Getenv("CORTEX_BACKUP_COMPRESSION"); v != "" {
+		switch v = strings.ToLower(v); v {
+		case CompressionGzip, CompressionZstd:
+			c.Compression = v
+		case "none":
+		default:
+			slog.Warn("unknown CORTEX_BACKUP_COMPRESSION, backups are not compressed", "value", v)
+		}
+	}
+	for env, dst := range map[string]*int{
+		"CORTEX_BACKUP_KEEP_LAST":   &c.KeepLast,
+		"CORTEX_BACKUP_KEEP_DAILY":  &c.KeepDaily,
+		"CORTEX_BACKUP_KEEP_WEEKLY": &c.KeepWeekly,
+	} {
+		if v := os.Getenv(env); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				*dst = n
+			}
+		}
+	}
+	return c
+}
+
+// Directory resolves cfg.Dir for the store and creates it.
+func Directory(s *store.CortexStore, cfg Config) (string, error) {
+	defaultDir, err := s.BackupDir()
+	if err != nil {
+		return "", err
+	}
+	if cfg.Dir == "" {
+		return defaultDir, nil
+	}
+	dir := cfg.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(defaultDir), dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	return dir, nil
+}
+
+// Create writes a backup of the store into the backup directory (online snapshot, see
+// store.BackupDatabase), compresses and encrypts it and writes its manifest.
+func Create(s *store.CortexStore, cfg Config, trigger string) (*Manifest, error) {
+	if cfg.Encrypt && cfg.Key == nil {
+		return nil, crypt.ErrNoKey
+	}
+	dir, err := Directory(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("cortex-backup-%s.db", now.Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	final := name + extension(cfg.Compression)
+	if cfg.Encrypt {
+		final += crypt.Extension
+	}
+	if _, err := os.Stat(filepath.Join(dir, final)); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", final)
+	}
+	if err := s.BackupDatabase(path); err != nil {
+		return nil, err
+	}
+	if cfg.Compression != CompressionNone {
+		compressed, err := compressFile(path, cfg.Compression)
+		os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+		path = compressed
+	}
+	if cfg.Encrypt {
+		err := crypt.EncryptFile(path, path+crypt.Extension, cfg.Key)
+		os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt backup: %w", err)
+		}
+		path += crypt.Extension
+	}
+	name = filepath.Base(path)
+
+	m := &Manifest{File: name, CreatedAt: now.UTC(), Trigger: trigger, Compression: cfg.Compression, Encrypted: cfg.Encrypt}
+	if m.Size, m.SHA256, err = checksum(path); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	if err := writeManifest(dir, m); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return m, nil
+}
+
+// List returns the backups in dir, newest first.
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	manifests := make(map[string]Manifest)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), manifestSuffix) {
+			continue
+		}
+		m, err := readManifest(filepath.Join(dir, e.Name()))
+		if err != nil {
+			slog.Warn("backup: unreadable manifest", "file", e.Name(), "error", err)
+			continue
+		}
+		manifests[m.File] = *m
+	}
+	var infos []Info
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isBackupFile(name) {
+			continue
+		}
+		if m, ok := manifests[name]; ok {
+			infos = append(infos, Info{Manifest: m, HasManifest: true})
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, Info{Manifest: Manifest{
+			File:        name,
+			CreatedAt:   fi.ModTime().UTC(),
+			Compression: compressionOf(name),
+			Encrypted:   strings.HasSuffix(name, crypt.Extension),
+			Size:        fi.Size(),
+		}})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.After(infos[j].CreatedAt) })
+	return infos, nil
+}
+
+// Verify checks a backup in dir: the checksum against its manifest (if any) and the database
+// integrity of the unpacked file (store.VerifyDatabaseFile). Encrypted backups need key.
+func Verify(dir, file string, key *crypt.Key) (*VerifyResult, error) {
+	path, err := resolve(dir, file)
+	if err != nil {
+		return nil, err
+	}
+	res := &VerifyResult{File: file}
+	if m, err := readManifest(path + manifestSuffix); err == nil {
+		size, sum, err := checksum(path)
+		if err != nil {
+			return nil, err
+		}
+		res.ChecksumOK = size == m.Size && sum == m.SHA256
+		if !res.ChecksumOK {
+			res.Error = "checksum mismatch"
+			return res, nil
+		}
+	}
+
+	dbPath := path
+	if IsPacked(path) {
+		dbPath = path + ".verify"
+		defer os.Remove(dbPath)
+		if err := Unpack(path, dbPath, key); err != nil {
+			res.Error = err.Error()
+			return res, nil
+		}
+	}
+	if err := store.VerifyDatabaseFile(dbPath); err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.IntegrityOK = true
+	return res, nil
+}
+
+// Prune deletes scheduled backups in dir that the retention policy does not keep (backup file and
+// manifest) and returns their names. With dryRun nothing is deleted. A policy without any Keep*
+// setting keeps everything.
+func Prune(dir string, cfg Config, dryRun bool) ([]string, error) {
+	if cfg.KeepLast <= 0 && cfg.KeepDaily <= 0 && cfg.KeepWeekly <= 0 {
+		return nil, nil
+	}
+	infos, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []Info
+	for _, info := range infos {
+		if info.HasManifest && info.Trigger == TriggerScheduled {
+			scheduled = append(scheduled, info)
+		}
+	}
+	keep := retain(scheduled, cfg)
+	var removed []string
+	for _, info := range scheduled {
+		if keep[info.File] {
+			continue
+		}
+		if !dryRun {
+			path := filepath.Join(dir, info.File)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+			if err := os.Remove(path + manifestSuffix); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		removed = append(removed, info.File)
+	}
+	return removed, nil
+}
+
+// retain returns the files to keep from backups (newest first): the newest KeepLast, and the newest
+// backup of each of the newest KeepDaily days and KeepWeekly ISO weeks.
+func retain(backups []Info, cfg Config) map[string]bool {
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for i, b := range backups {
+		if i < cfg.KeepLast {
+			keep[b.File] = true
+		}
+		t := b.CreatedAt.Local()
+		if day := t.Format("2006-01-02"); !days[day] && len(days) < cfg.KeepDaily {
+			days[day] = true
+			keep[b.File] = true
+		}
+		year, week := t.ISOWeek()
+		if key := fmt.Sprintf("%d-W%02d", year, week); !weeks[key] && len(weeks) < cfg.KeepWeekly {
+			weeks[key] = true
+			keep[b.File] = true
+		}
+	}
+	return keep
+}
+
+// StartBackupTicker creates a scheduled backup every interval and prunes the backup directory
+// afterwards. It blocks until ctx is cancelled. Call in a goroutine.
+func StartBackupTicker(ctx context.Context, s *store.CortexStore, interval time.Duration, cfg Config) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			RunScheduled(s, cfg)
+		}
+	}
+}
+
+// RunScheduled creates one scheduled backup and prunes; errors are logged.
+func RunScheduled(s *store.CortexStore, cfg Config) {
+	m, err := Create(s, cfg, TriggerScheduled)
+	if err != nil {
+		slog.Error("scheduled backup failed", "error", err)
+		return
+	}
+	slog.Info("scheduled backup created", "file", m.File, "size", m.Size)
+	dir, err := Directory(s, cfg)
+	if err != nil {
+		slog.Error("backup prune failed", "error", err)
+		return
+	}
+	removed, err := Prune(dir, cfg, false)
+	if err != nil {
+		slog.Error("backup prune failed", "error", err)
+	}
+	if len(removed) > 0 {
+		slog.Info("old backups pruned", "count", len(removed))
+	}
+}
+
+// Magic numbers of the compression formats; Unpack detects the format by content, not by name.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Unpack writes the database contained in a backup file to dst: decrypted with key (if encrypted)
+// and decompressed (if compressed).
+func Unpack(src, dst string, key *crypt.Key) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = unpack(in, out, key)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to unpack %s: %w", filepath.Base(src), err)
+	}
+	return nil
+}
+
+func unpack(in io.Reader, out io.Writer, key *crypt.Key) error {
+	r, encrypted := crypt.Peek(in)
+	if encrypted {
+		cr, err := crypt.NewReader(r, key)
+		if err != nil {
+			return err
+		}
+		r = cr
+	}
+	br := bufio.NewReader(r)
+	prefix, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(prefix, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, zr)
+		return err
+	case bytes.HasPrefix(prefix, zstdMagic):
+		return runZstd(br, out, "-d")
+	}
+	_, err := io.Copy(out, br)
+	return err
+}
+
+// IsPacked reports whether the backup file at path is compressed or encrypted, i.e. needs Unpack
+// before it can be opened as database.
+func IsPacked(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	prefix := make([]byte, 8)
+	n, _ := io.ReadFull(f, prefix)
+	prefix = prefix[:n]
+	return crypt.IsEncrypted(prefix) || bytes.HasPrefix(prefix, gzipMagic) || bytes.HasPrefix(prefix, zstdMagic)
+}
+
+func compressFile(path, compression string) (string, error) {
+	dst := path + extension(compression)
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	switch compression {
+	case CompressionGzip:
+		zw := gzip.NewWriter(out)
+		if _, err = io.Copy(zw, in); err == nil {
+			err = zw.Close()
+		}
+	case CompressionZstd:
+		err = runZstd(in, out, "-q")
+	default:
+		err = fmt.Errorf("unknown compression %q", compression)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", fmt.Errorf("failed to compress backup: %w", err)
+	}
+	return dst, nil
+}
+
+// runZstd pipes in through the zstd binary (args e.g. "-d" to decompress).
+func runZstd(in io.Reader, out io.Writer, args ...string) error {
+	cmd := exec.Command("zstd", append(args, "-c")...)
+	cmd.Stdin, cmd.Stdout = in, out
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("zstd: %w %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func extension(compression string) string {
+	switch compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+func compressionOf(name string) string {
+	name = strings.TrimSuffix(name, crypt.Extension)
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return CompressionGzip
+	case strings.HasSuffix(name, ".zst"):
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+func isBackupFile(name string) bool {
+	name = strings.TrimSuffix(name, crypt.Extension)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zst")
+	return strings.HasSuffix(name, ".db")
+}
+
+// resolve returns the path of a backup file in dir; file must be a plain name.
+func resolve(dir, file string) (string, error) {
+	if file == "" || file != filepath.Base(file) || !isBackupFile(file) {
+		return "", fmt.Errorf("invalid backup file name %q", file)
+	}
+	path := filepath.Join(dir, file)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, file)
+	}
+	return path, nil
+}
+
+func checksum(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, m.File+manifestSuffix)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
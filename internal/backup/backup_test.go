@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"cortex/internal/crypt"
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+func setupStore(t *testing.T) *store.CortexStore {
+	t.Helper()
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCortexStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := s.CreateMemory(&models.Memory{Content: "m", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	return s
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CORTEX_BACKUP_COMPRESSION", "GZIP")
+	t.Setenv("CORTEX_BACKUP_KEEP_LAST", "3")
+	t.Setenv("CORTEX_BACKUP_KEEP_WEEKLY", "-1")
+	c := ConfigFromEnv()
+	if c.Compression != CompressionGzip || c.KeepLast != 3 || c.KeepDaily != 7 || c.KeepWeekly != 4 {
+		t.Fatalf("unexpected config %+v", c)
+	}
+}
+
+func TestCreateListVerify(t *testing.T) {
+	s := setupStore(t)
+	key, _ := crypt.NewKey([]byte("0123456789abcdef0123456789abcdef"))
+	compressions := []string{CompressionNone, CompressionGzip}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		compressions = append(compressions, CompressionZstd)
+	}
+	for _, c := range compressions {
+		for _, encrypt := range []bool{false, true} {
+			t.Run(fmt.Sprintf("compression=%s/encrypt=%v", c, encrypt), func(t *testing.T) {
+				cfg := Config{Dir: t.TempDir(), Compression: c, Encrypt: encrypt, Key: key}
+				m, err := Create(s, cfg, TriggerManual)
+				if err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+				path := filepath.Join(cfg.Dir, m.File)
+				if m.SHA256 == "" || m.Size == 0 || m.Compression != c || m.Encrypted != encrypt ||
+					IsPacked(path) != (encrypt || c != CompressionNone) || crypt.IsEncryptedFile(path) != encrypt {
+					t.Fatalf("unexpected manifest %+v", m)
+				}
+				infos, err := List(cfg.Dir)
+				if err != nil || len(infos) != 1 || !infos[0].HasManifest || infos[0].File != m.File {
+					t.Fatalf("List: %v %+v", err, infos)
+				}
+				res, err := Verify(cfg.Dir, m.File, key)
+				if err != nil || !res.ChecksumOK || !res.IntegrityOK {
+					t.Fatalf("Verify: %v %+v", err, res)
+				}
+				if encrypt {
+					if res, _ := Verify(cfg.Dir, m.File, nil); !res.ChecksumOK || res.IntegrityOK {
+						t.Errorf("encrypted backup without key: checksum only, got %+v", res)
+					}
+				}
+
+				unpacked := filepath.Join(t.TempDir(), "restore.db")
+				if err := Unpack(path, unpacked, key); err != nil {
+					t.Fatalf("Unpack: %v", err)
+				}
+				if err := store.VerifyDatabaseFile(unpacked); err != nil {
+					t.Fatalf("unpacked backup: %v", err)
+				}
+
+				// Beschädigte Datei: Checksumme passt nicht mehr
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Write([]byte("x"))
+				f.Close()
+				if res, _ := Verify(cfg.Dir, m.File, key); res.ChecksumOK || res.IntegrityOK || res.Error == "" {
+					t.Errorf("corrupted backup must fail verification: %+v", res)
+				}
+			})
+		}
+	}
+	if _, err := Create(s, Config{Dir: t.TempDir(), Encrypt: true}, TriggerManual); err == nil {
+		t.Error("encryption without key must fail")
+	}
+
+	dir := t.TempDir()
+	if _, err := Verify(dir, "../test.db", nil); err == nil {
+		t.Error("paths outside the backup directory must be rejected")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plain.db"), []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := Verify(dir, "plain.db", nil); err != nil || res.IntegrityOK || res.ChecksumOK {
+		t.Errorf("file without manifest must be checked for integrity only: %v %+v", err, res)
+	}
+}
+
+func TestPruneRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.Local) // Mittwoch
+	// Stündliche Backups heute, dann eines pro Tag für 30 Tage
+	var all []string
+	add := func(at time.Time, trigger string) string {
+		name := "cortex-backup-" + at.Format("20060102-150405") + ".db"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeManifest(dir, &Manifest{File: name, CreatedAt: at.UTC(), Trigger: trigger, Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	for h := 0; h < 5; h++ {
+		all = append(all, add(now.Add(-time.Duration(h)*time.Hour), TriggerScheduled))
+	}
+	for d := 1; d <= 30; d++ {
+		all = append(all, add(now.AddDate(0, 0, -d), TriggerScheduled))
+	}
+	manual := add(now.AddDate(0, 0, -60), TriggerManual)
+	if err := os.WriteFile(filepath.Join(dir, "cortex-pre-restore-x.db"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{KeepLast: 3, KeepDaily: 5, KeepWeekly: 3}
+	wouldRemove, err := Prune(dir, cfg, true)
+	if err != nil {
+		t.Fatalf("Prune(dry run): %v", err)
+	}
+	if infos, _ := List(dir); len(infos) != len(all)+2 {
+		t.Fatalf("dry run must not delete, %d files left", len(infos))
+	}
+	removed, err := Prune(dir, cfg, false)
+	if err != nil || !slices.Equal(removed, wouldRemove) {
+		t.Fatalf("Prune: %v removed=%v dry=%v", err, removed, wouldRemove)
+	}
+
+	infos, _ := List(dir)
+	var kept []string
+	for _, info := range infos {
+		kept = append(kept, info.File)
+	}
+	// 3 neueste (heute), Tage: heute + 4 Vortage (bis So 15.3.), Wochen: diese und Vorwoche sind
+	// schon drin, dazu So 8.3. als neuestes der vorletzten Woche
+	want := []string{all[0], all[1], all[2], all[5], all[6], all[7], all[8], all[14]}
+	for _, w := range want {
+		if !slices.Contains(kept, w) {
+			t.Errorf("%s must be kept (kept: %v)", w, kept)
+		}
+	}
+	if !slices.Contains(kept, manual) || !slices.Contains(kept, "cortex-pre-restore-x.db") {
+		t.Error("manual backups and files without manifest must never be pruned")
+	}
+	if len(kept) != len(want)+2 {
+		t.Errorf("kept %d files, want %d: %v", len(kept), len(want)+2, kept)
+	}
+	if _, err := os.Stat(filepath.Join(dir, removed[0]+manifestSuffix)); !os.IsNotExist(err) {
+		t.Error("manifest of a pruned backup must be removed")
+	}
+
+	if removed, _ := Prune(dir, Config{}, false); len(removed) != 0 {
+		t.Error("a policy without keep settings must not delete anything")
+	}
+}
@@ -31,6 +31,19 @@ type Config struct {
 	LowImportanceThreshold int
 }
 
+// Hooks are notified about the memories a cleanup run changed (e.g. to emit webhook events).
+// All fields are optional.
+type Hooks struct {
+	// Expired: memories archived because expires_at was reached
+	Expired func(mems []models.Memory)
+	// ArchivedLowImportance: memories archived because of low importance
+	ArchivedLowImportance func(mems []models.Memory)
+	// Deleted: archived memories permanently deleted
+	Deleted func(mems []models.Memory)
+	// Merged: keep before and after the merge, merged = the now archived source
+	Merged func(before, after, merged models.Memory)
+}
+
 // Stats holds cleanup run statistics.
 type Stats struct {
 	ArchivedByExpiry   int64
@@ -96,17 +109,25 @@ func ConfigFromEnv() Config {
 
 // RunCleanup runs one cleanup pass: TTL archive, optional delete archived, optional merge similar, optional archive low-importance.
 func RunCleanup(ctx context.Context, s *store.CortexStore, cfg Config) (Stats, error) {
+	return RunCleanupWithHooks(ctx, s, cfg, Hooks{})
+}
+
+// RunCleanupWithHooks is RunCleanup that reports changed memories to hooks.
+func RunCleanupWithHooks(ctx context.Context, s *store.CortexStore, cfg Config, hooks Hooks) (Stats, error) {
 	var stats Stats
 	now := time.Now()
 
 	if cfg.ArchiveByExpiry && !cfg.DryRun {
-		n, err := s.ArchiveMemoriesByExpiry(now)
+		archived, err := s.ArchiveExpiredMemories(now)
 		if err != nil {
 			return stats, err
 		}
-		stats.ArchivedByExpiry = n
-		if n > 0 {
-			slog.Info("cleanup: archived by expiry", "count", n)
+		stats.ArchivedByExpiry = int64(len(archived))
+		if len(archived) > 0 {
+			slog.Info("cleanup: archived by expiry", "count", len(archived))
+			if hooks.Expired != nil {
+				hooks.Expired(archived)
+			}
 		}
 	} else if cfg.ArchiveByExpiry && cfg.DryRun {
 		// Count only: would need a separate store method; skip for dry run or do a raw count
@@ -115,13 +136,16 @@ func RunCleanup(ctx context.Context, s *store.CortexStore, cfg Config) (Stats, e
 
 	if cfg.DeleteArchivedOlderThan > 0 && !cfg.DryRun {
 		cutoff := now.Add(-cfg.DeleteArchivedOlderThan)
-		n, err := s.DeleteArchivedOlderThan(cutoff)
+		deleted, err := s.DeleteArchivedMemoriesOlderThan(cutoff)
 		if err != nil {
 			return stats, err
 		}
-		stats.DeletedArchived = n
-		if n > 0 {
-			slog.Info("cleanup: deleted archived older than", "count", n, "cutoff", cutoff)
+		stats.DeletedArchived = int64(len(deleted))
+		if len(deleted) > 0 {
+			slog.Info("cleanup: deleted archived older than", "count", len(deleted), "cutoff", cutoff)
+			if hooks.Deleted != nil {
+				hooks.Deleted(deleted)
+			}
 		}
 	}
 
@@ -149,11 +173,22 @@ func RunCleanup(ctx context.Context, s *store.CortexStore, cfg Config) (Stats, e
 				continue
 			}
 			for _, p := range pairs {
+				var before *models.Memory
+				if hooks.Merged != nil {
+					before, _ = s.GetMemoryByIDAndTenant(p[0], t.AppID, t.ExternalUserID, false)
+				}
 				if err := s.MergeMemories(p[0], p[1], t.AppID, t.ExternalUserID); err != nil {
 					slog.Warn("cleanup: merge failed", "keep", p[0], "merge", p[1], "error", err)
 					continue
 				}
 				stats.MergedPairs++
+				if before != nil {
+					after, errKeep := s.GetMemoryByIDAndTenant(p[0], t.AppID, t.ExternalUserID, false)
+					merged, errMerged := s.GetMemoryByIDAndTenant(p[1], t.AppID, t.ExternalUserID, true)
+					if errKeep == nil && errMerged == nil {
+						hooks.Merged(*before, *after, *merged)
+					}
+				}
 			}
 		}
 		if stats.MergedPairs > 0 {
@@ -169,24 +204,25 @@ func RunCleanup(ctx context.Context, s *store.CortexStore, cfg Config) (Stats, e
 		if thresh > 10 {
 			thresh = 10
 		}
-		res := s.GetDB().Model(&models.Memory{}).
-			Where("status = ? AND importance < ?", models.MemoryStatusActive, thresh).
-			Update("status", models.MemoryStatusArchived)
-		if res.Error != nil {
-			return stats, res.Error
+		archived, err := s.ArchiveLowImportanceMemories(thresh)
+		if err != nil {
+			return stats, err
 		}
-		stats.ArchivedLowImport = res.RowsAffected
+		stats.ArchivedLowImport = int64(len(archived))
 		if stats.ArchivedLowImport > 0 {
 			slog.Info("cleanup: archived low importance", "count", stats.ArchivedLowImport, "threshold", thresh)
+			if hooks.ArchivedLowImportance != nil {
+				hooks.ArchivedLowImportance(archived)
+			}
 		}
 	}
 
 	return stats, nil
 }
 
-// StartCleanupTicker runs RunCleanup every interval (from CORTEX_CLEANUP_INTERVAL, default 24h).
+// StartCleanupTicker runs RunCleanupWithHooks every interval (from CORTEX_CLEANUP_INTERVAL, default 24h).
 // It blocks until ctx is cancelled. Call in a goroutine.
-func StartCleanupTicker(ctx context.Context, s *store.CortexStore, interval time.Duration) {
+func StartCleanupTicker(ctx context.Context, s *store.CortexStore, interval time.Duration, hooks Hooks) {
 	if interval <= 0 {
 		if v := os.Getenv("CORTEX_CLEANUP_INTERVAL"); v != "" {
 			if d, err := time.ParseDuration(v); err == nil {
@@ -205,7 +241,7 @@ func StartCleanupTicker(ctx context.Context, s *store.CortexStore, interval time
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			_, err := RunCleanup(ctx, s, cfg)
+			_, err := RunCleanupWithHooks(ctx, s, cfg, hooks)
 			if err != nil {
 				slog.Error("cleanup ticker failed", "error", err)
 			}
@@ -171,6 +171,59 @@ func TestRunCleanup_ArchiveLowImportance(t *testing.T) {
 	}
 }
 
+func TestRunCleanupWithHooks(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+
+	expired := time.Now().Add(-time.Minute)
+	exp := &models.Memory{Content: "Expired", AppID: "app1", ExternalUserID: "user1", Importance: 5, ExpiresAt: &expired}
+	low := &models.Memory{Content: "Low importance", AppID: "app1", ExternalUserID: "user1", Importance: 1}
+	keep := &models.Memory{Content: "Keep", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	for _, m := range []*models.Memory{exp, low, keep} {
+		if err := s.CreateMemory(m); err != nil {
+			t.Fatalf("CreateMemory: %v", err)
+		}
+	}
+
+	var expiredIDs, lowIDs []int64
+	hooks := Hooks{
+		Expired: func(mems []models.Memory) {
+			for _, m := range mems {
+				expiredIDs = append(expiredIDs, m.ID)
+			}
+		},
+		ArchivedLowImportance: func(mems []models.Memory) {
+			for _, m := range mems {
+				if m.Status != models.MemoryStatusArchived {
+					t.Errorf("hook got status %q, want archived", m.Status)
+				}
+				lowIDs = append(lowIDs, m.ID)
+			}
+		},
+	}
+	cfg := DefaultConfig()
+	cfg.ArchiveLowImportance = true
+	cfg.LowImportanceThreshold = 2
+	if _, err := RunCleanupWithHooks(context.Background(), s, cfg, hooks); err != nil {
+		t.Fatalf("RunCleanupWithHooks: %v", err)
+	}
+	if len(expiredIDs) != 1 || expiredIDs[0] != exp.ID {
+		t.Errorf("Expired hook got %v, want [%d]", expiredIDs, exp.ID)
+	}
+	if len(lowIDs) != 1 || lowIDs[0] != low.ID {
+		t.Errorf("ArchivedLowImportance hook got %v, want [%d]", lowIDs, low.ID)
+	}
+
+	// Zweiter Lauf: nichts mehr zu tun, Hooks werden nicht aufgerufen
+	expiredIDs, lowIDs = nil, nil
+	if _, err := RunCleanupWithHooks(context.Background(), s, cfg, hooks); err != nil {
+		t.Fatalf("RunCleanupWithHooks: %v", err)
+	}
+	if expiredIDs != nil || lowIDs != nil {
+		t.Errorf("hooks called without changes: %v %v", expiredIDs, lowIDs)
+	}
+}
+
 func TestRunCleanup_DeleteArchivedOlderThan(t *testing.T) {
 	s := setupTestStore(t)
 	defer s.Close()
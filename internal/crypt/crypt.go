@@ -0,0 +1,413 @@
+// Package crypt encrypts backups and exports with AES-256-GCM. Data is split into chunks that are
+// sealed one by one (nonce = chunk counter plus a last-chunk flag), so files of any size can be
+// streamed and truncation, reordering or tampering is detected. The key comes from a keyfile or is
+// derived from a passphrase (PBKDF2-HMAC-SHA256, salt and iterations in the header).
+//
+// Format: "CRTXENC1" | kind (1 byte) | iterations (uint32) | salt (16 bytes) | chunks.
+// The header is authenticated as additional data of every chunk.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Extension is appended to the names of encrypted files.
+const Extension = ".enc"
+
+const (
+	magic      = "CRTXENC1"
+	headerSize = len(magic) + 1 + 4 + saltSize
+	saltSize   = 16
+	keySize    = 32
+	chunkSize  = 64 * 1024
+
+	kindKey        = 1
+	kindPassphrase = 2
+)
+
+// defaultPassphraseIterations is the PBKDF2 work factor for new files.
+const defaultPassphraseIterations = 600_000
+
+// maxPassphraseIterations caps the work factor read from a file header, so an uploaded file cannot
+// keep the server busy deriving its key.
+const maxPassphraseIterations = 4 * defaultPassphraseIterations
+
+// passphraseIterations is the work factor used for new files (tests lower it).
+var passphraseIterations = defaultPassphraseIterations
+
+var (
+	// ErrDecrypt is returned for a wrong key and for corrupted or truncated data.
+	ErrDecrypt = errors.New("decryption failed: wrong key or corrupted data")
+	// ErrNotEncrypted is returned by NewReader for data without the encryption header.
+	ErrNotEncrypted = errors.New("data is not encrypted")
+	// ErrNoKey is returned when encrypted data is processed without a configured key.
+	ErrNoKey = errors.New("no encryption key configured (CORTEX_ENCRYPTION_KEYFILE or CORTEX_ENCRYPTION_PASSPHRASE)")
+)
+
+// Key is either a 256-bit key (from a keyfile) or a passphrase.
+type Key struct {
+	raw        []byte
+	passphrase string
+}
+
+// NewKey returns a Key for 32 raw bytes.
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(raw))
+	}
+	return &Key{raw: bytes.Clone(raw)}, nil
+}
+
+// PassphraseKey returns a Key that derives the file key from passphrase.
+func PassphraseKey(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	return &Key{passphrase: passphrase}, nil
+}
+
+// KeyFromFile reads a keyfile: 32 raw bytes, or the key as base64 or hex text (see GenerateKeyFile).
+func KeyFromFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	if len(data) == keySize {
+		return NewKey(data)
+	}
+	text := strings.TrimSpace(string(data))
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil && len(raw) == keySize {
+		return NewKey(raw)
+	}
+	if raw, err := hex.DecodeString(text); err == nil && len(raw) == keySize {
+		return NewKey(raw)
+	}
+	return nil, fmt.Errorf("keyfile %s must contain a %d-byte key (raw, base64 or hex)", path, keySize)
+}
+
+// KeyFromEnv returns the key configured by CORTEX_ENCRYPTION_KEYFILE (path to a keyfile) or else
+// CORTEX_ENCRYPTION_PASSPHRASE; nil if neither is set.
+func KeyFromEnv() (*Key, error) {
+	if path := os.Getenv("CORTEX_ENCRYPTION_KEYFILE"); path != "" {
+		return KeyFromFile(path)
+	}
+	if p := os.Getenv("CORTEX_ENCRYPTION_PASSPHRASE"); p != "" {
+		return PassphraseKey(p)
+	}
+	return nil, nil
+}
+
+// GenerateKeyFile writes a new random key as base64 to path (mode 0600); an existing file is not
+// overwritten.
+func GenerateKeyFile(path string) error {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, base64.StdEncoding.EncodeToString(raw))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fileKey derives the key of one file from its header.
+func (k *Key) fileKey(kind byte, iterations uint32, salt []byte) ([]byte, error) {
+	switch {
+	case kind == kindKey && k.raw != nil:
+		mac := hmac.New(sha256.New, k.raw)
+		mac.Write([]byte("cortex-file-key"))
+		mac.Write(salt)
+		return mac.Sum(nil), nil
+	case kind == kindPassphrase && k.passphrase != "":
+		if iterations == 0 {
+			return nil, ErrDecrypt
+		}
+		if iterations > maxPassphraseIterations {
+			return nil, fmt.Errorf("%w: %d PBKDF2 iterations exceed the maximum of %d", ErrDecrypt, iterations, maxPassphraseIterations)
+		}
+		return pbkdf2([]byte(k.passphrase), salt, int(iterations)), nil
+	case kind == kindKey:
+		return nil, errors.New("data was encrypted with a keyfile, not a passphrase")
+	case kind == kindPassphrase:
+		return nil, errors.New("data was encrypted with a passphrase, not a keyfile")
+	}
+	return nil, fmt.Errorf("unknown key kind %d", kind)
+}
+
+// pbkdf2 is PBKDF2-HMAC-SHA256 with a 32-byte output (one block).
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	out := bytes.Clone(u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
+
+// IsEncrypted reports whether data starts with the encryption header.
+func IsEncrypted(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(magic))
+}
+
+// IsEncryptedFile reports whether the file at path is encrypted.
+func IsEncryptedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	prefix := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, prefix); err != nil {
+		return false
+	}
+	return IsEncrypted(prefix)
+}
+
+// Peek reports whether the data of r is encrypted without consuming it; use the returned reader
+// instead of r.
+func Peek(r io.Reader) (io.Reader, bool) {
+	br := bufio.NewReader(r)
+	prefix, _ := br.Peek(len(magic))
+	return br, IsEncrypted(prefix)
+}
+
+type chunker struct {
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+	nonce   [12]byte
+}
+
+func newChunker(key []byte, header []byte) (*chunker, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &chunker{aead: aead, header: header}, nil
+}
+
+// next returns the nonce of the next chunk: counter (big endian) and 1 in the last byte for the last chunk.
+func (c *chunker) next(last bool) []byte {
+	binary.BigEndian.PutUint64(c.nonce[3:11], c.counter)
+	c.nonce[11] = 0
+	if last {
+		c.nonce[11] = 1
+	}
+	c.counter++
+	return c.nonce[:]
+}
+
+// Writer encrypts everything written to it; Close writes the last chunk and must be called.
+type Writer struct {
+	w      io.Writer
+	c      *chunker
+	buf    []byte
+	closed bool
+}
+
+// NewWriter writes the header to w and returns a Writer that encrypts with k.
+func NewWriter(w io.Writer, k *Key) (*Writer, error) {
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	salt := header[headerSize-saltSize:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kind, iterations := byte(kindKey), uint32(0)
+	if k.raw == nil {
+		kind, iterations = kindPassphrase, uint32(passphraseIterations)
+	}
+	header[len(magic)] = kind
+	binary.BigEndian.PutUint32(header[len(magic)+1:], iterations)
+	key, err := k.fileKey(kind, iterations, salt)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newChunker(key, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, c: c, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed crypt.Writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// Ein voller Puffer wird erst geschrieben, wenn weitere Daten kommen: der letzte Chunk braucht das Flag
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *Writer) flush(last bool) error {
+	sealed := w.c.aead.Seal(nil, w.c.next(last), w.buf, w.c.header)
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Reader decrypts data written by Writer.
+type Reader struct {
+	r    *bufio.Reader
+	c    *chunker
+	buf  []byte // entschlüsselt, noch nicht gelesen
+	in   []byte
+	done bool
+}
+
+// NewReader reads the header from r and returns a Reader that decrypts with k. Data without header
+// yields ErrNotEncrypted.
+func NewReader(r io.Reader, k *Key) (*Reader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+64)
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil || !IsEncrypted(header) {
+		return nil, ErrNotEncrypted
+	}
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	key, err := k.fileKey(header[len(magic)], binary.BigEndian.Uint32(header[len(magic)+1:]), header[headerSize-saltSize:])
+	if err != nil {
+		return nil, err
+	}
+	c, err := newChunker(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: br, c: c, in: make([]byte, chunkSize+c.aead.Overhead())}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) nextChunk() error {
+	n, err := io.ReadFull(r.r, r.in)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// Voller Chunk: der letzte, wenn danach nichts mehr kommt
+		if _, perr := r.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+	plain, oerr := r.c.aead.Open(r.in[:0:0], r.c.next(last), r.in[:n], r.c.header)
+	if oerr != nil {
+		return ErrDecrypt
+	}
+	r.buf = plain
+	r.done = last
+	return nil
+}
+
+// EncryptFile encrypts src into dst (created, must not exist).
+func EncryptFile(src, dst string, k *Key) error {
+	return transformFile(src, dst, func(in io.Reader, out io.Writer) error {
+		w, err := NewWriter(out, k)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// DecryptFile decrypts src into dst (created, must not exist).
+func DecryptFile(src, dst string, k *Key) error {
+	return transformFile(src, dst, func(in io.Reader, out io.Writer) error {
+		r, err := NewReader(in, k)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, r)
+		return err
+	})
+}
+
+func transformFile(src, dst string, fn func(io.Reader, io.Writer) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	err = fn(in, out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,296 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	passphraseIterations = 1000
+}
+
+func encrypt(t *testing.T, plain []byte, k *Key) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, k)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	// In ungleichen Stücken schreiben, damit Chunk-Grenzen nicht mit Write-Grenzen zusammenfallen
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 10_000)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, k *Key) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), k)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundtrip(t *testing.T) {
+	raw := make([]byte, keySize)
+	rand.Read(raw)
+	key, _ := NewKey(raw)
+	pass, _ := PassphraseKey("correct horse")
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		for name, k := range map[string]*Key{"key": key, "passphrase": pass} {
+			data := encrypt(t, plain, k)
+			if !IsEncrypted(data) || bytes.Contains(data, plain[:min(size, 64)]) && size >= 16 {
+				t.Fatalf("%s/%d: output must be encrypted", name, size)
+			}
+			got, err := decrypt(data, k)
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Fatalf("%s/%d: roundtrip failed: %v", name, size, err)
+			}
+		}
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	key, _ := NewKey(bytes.Repeat([]byte{1}, keySize))
+	other, _ := NewKey(bytes.Repeat([]byte{2}, keySize))
+	pass, _ := PassphraseKey("secret")
+	wrongPass, _ := PassphraseKey("Secret")
+	plain := bytes.Repeat([]byte("cortex memory "), 3*chunkSize/14)
+	data := encrypt(t, plain, key)
+	passData := encrypt(t, plain, pass)
+
+	tampered := bytes.Clone(data)
+	tampered[headerSize+chunkSize+100] ^= 1
+	header := bytes.Clone(data)
+	header[headerSize-1] ^= 1 // Salt
+	// Ganze Chunks abschneiden: ohne Last-Flag-Prüfung fiele das nicht auf
+	truncated := data[:headerSize+2*(chunkSize+16)]
+
+	cases := map[string]struct {
+		data []byte
+		key  *Key
+	}{
+		"wrong key":              {data, other},
+		"wrong passphrase":       {passData, wrongPass},
+		"passphrase for keyfile": {data, pass},
+		"tampered chunk":         {tampered, key},
+		"tampered header":        {header, key},
+		"truncated":              {truncated, key},
+		"trailing garbage":       {append(bytes.Clone(data), 0), key},
+	}
+	for name, c := range cases {
+		if _, err := decrypt(c.data, c.key); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	// Iterationszahl aus dem (ungeprüften) Header wird vor der Ableitung begrenzt
+	costly := bytes.Clone(passData)
+	binary.BigEndian.PutUint32(costly[len(magic)+1:], 0xFFFFFFFF)
+	start := time.Now()
+	if _, err := decrypt(costly, pass); !errors.Is(err, ErrDecrypt) || time.Since(start) > time.Second {
+		t.Errorf("huge iteration count: expected fast ErrDecrypt, got %v after %v", err, time.Since(start))
+	}
+	if _, err := decrypt(plain, key); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("plaintext: expected ErrNotEncrypted, got %v", err)
+	}
+	if _, err := decrypt(data, nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("no key: expected ErrNoKey, got %v", err)
+	}
+}
+
+func TestKeyFileAndFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "cortex.key")
+	if err := GenerateKeyFile(keyPath); err != nil {
+		t.Fatalf("GenerateKeyFile: %v", err)
+	}
+	if err := GenerateKeyFile(keyPath); err == nil {
+		t.Error("an existing keyfile must not be overwritten")
+	}
+	if fi, _ := os.Stat(keyPath); fi.Mode().Perm() != 0o600 {
+		t.Errorf("keyfile mode = %v, want 0600", fi.Mode().Perm())
+	}
+	t.Setenv("CORTEX_ENCRYPTION_KEYFILE", keyPath)
+	key, err := KeyFromEnv()
+	if err != nil || key == nil {
+		t.Fatalf("KeyFromEnv: %v", err)
+	}
+	hexPath := filepath.Join(dir, "hex.key")
+	os.WriteFile(hexPath, []byte("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff\n"), 0o600)
+	if _, err := KeyFromFile(hexPath); err != nil {
+		t.Errorf("hex keyfile: %v", err)
+	}
+	shortPath := filepath.Join(dir, "short.key")
+	os.WriteFile(shortPath, []byte("too short"), 0o600)
+	if _, err := KeyFromFile(shortPath); err == nil {
+		t.Error("a short keyfile must be rejected")
+	}
+
+	src := filepath.Join(dir, "plain.db")
+	os.WriteFile(src, []byte("SQLite format 3\x00 data"), 0o644)
+	enc := src + Extension
+	if err := EncryptFile(src, enc, key); err != nil {
+		t.Fatalf("EncryptFile: %v", err)
+	}
+	if !IsEncryptedFile(enc) || IsEncryptedFile(src) {
+		t.Error("IsEncryptedFile mismatch")
+	}
+	out := filepath.Join(dir, "out.db")
+	if err := DecryptFile(enc, out, key); err != nil {
+		t.Fatalf("DecryptFile: %v", err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "SQLite format 3\x00 data" {
+		t.Errorf("decrypted content = %q", got)
+	}
+	other, _ := PassphraseKey("x")
+	failed := filepath.Join(dir, "failed.db")
+	if err := DecryptFile(enc, failed, other); err == nil {
+		t.Error("decrypting with the wrong key must fail")
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Error("a failed decryption must not leave a file")
+	}
+}
+
+func TestSealField(t *testing.T) {
+	id, dek, err := NewDataKey()
+	if err != nil || len(id) != 16 || len(dek) != keySize {
+		t.Fatalf("NewDataKey: %v %q %d", err, id, len(dek))
+	}
+	sealed, err := SealField(id, dek, "geheim", "memories.content")
+	if err != nil || !IsSealed(sealed) || strings.Contains(sealed, "geheim") {
+		t.Fatalf("SealField: %v %q", err, sealed)
+	}
+	if again, _ := SealField(id, dek, "geheim", "memories.content"); again == sealed {
+		t.Error("sealing must use a random nonce")
+	}
+	if got, ok := FieldKeyID(sealed); !ok || got != id {
+		t.Errorf("FieldKeyID = %q %v", got, ok)
+	}
+	if plain, err := OpenField(dek, sealed, "memories.content"); err != nil || plain != "geheim" {
+		t.Fatalf("OpenField: %v %q", err, plain)
+	}
+	if _, err := OpenField(dek, sealed, "memories.metadata"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("other column: expected ErrDecrypt, got %v", err)
+	}
+	_, other, _ := NewDataKey()
+	if _, err := OpenField(other, sealed, "memories.content"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong key: expected ErrDecrypt, got %v", err)
+	}
+	if _, err := OpenField(dek, "geheim", "memories.content"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("plaintext: expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+func TestPBKDF2KnownAnswers(t *testing.T) {
+	// RFC 7914 §11 und RFC 6070-Eingaben mit SHA-256 (erster 32-Byte-Block)
+	cases := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+	for _, tc := range cases {
+		if got := hex.EncodeToString(pbkdf2([]byte(tc.password), []byte(tc.salt), tc.iterations)); got != tc.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, expected %s", tc.password, tc.salt, tc.iterations, got, tc.want)
+		}
+	}
+}
+
+func TestFieldHash(t *testing.T) {
+	id, dek, _ := NewDataKey()
+	h := FieldHash(id, dek, "geheim")
+	if h != FieldHash(id, dek, "geheim") || h == FieldHash(id, dek, "geheim ") {
+		t.Error("hash must be deterministic and depend on the value")
+	}
+	if got, ok := FieldHashKeyID(h); !ok || got != id {
+		t.Errorf("FieldHashKeyID = %q %v", got, ok)
+	}
+	sum := sha256.Sum256([]byte("geheim"))
+	if strings.Contains(h, hex.EncodeToString(sum[:])) {
+		t.Error("hash must not be the plain SHA-256")
+	}
+	_, other, _ := NewDataKey()
+	if FieldHash(id, other, "geheim") == h {
+		t.Error("hash must depend on the data key")
+	}
+	if _, ok := FieldHashKeyID(hex.EncodeToString(sum[:])); ok {
+		t.Error("plain SHA-256 has no key ID")
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	master, _ := NewKey(bytes.Repeat([]byte{1}, keySize))
+	other, _ := NewKey(bytes.Repeat([]byte{2}, keySize))
+	if master.ID() == "" || master.ID() == other.ID() {
+		t.Fatalf("key IDs must identify the key: %q %q", master.ID(), other.ID())
+	}
+	id, dek, _ := NewDataKey()
+	wrapped, err := master.WrapKey(id, dek)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	if got, err := master.UnwrapKey(id, wrapped); err != nil || !bytes.Equal(got, dek) {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if _, err := other.UnwrapKey(id, wrapped); err == nil {
+		t.Error("unwrapping with another master key must fail")
+	}
+	if _, err := master.UnwrapKey("0000000000000000", wrapped); err == nil {
+		t.Error("the key ID must be authenticated")
+	}
+	pass, _ := PassphraseKey("secret")
+	if _, err := pass.WrapKey(id, dek); !errors.Is(err, ErrNotMasterKey) {
+		t.Errorf("passphrase master key: expected ErrNotMasterKey, got %v", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	key, _ := NewKey(bytes.Repeat([]byte{1}, keySize))
+	other, _ := NewKey(bytes.Repeat([]byte{2}, keySize))
+	sig, err := key.Sign("memory", "app", "hallo")
+	if err != nil || !strings.HasPrefix(sig, SignaturePrefix+key.ID()+":") {
+		t.Fatalf("Sign: %v %q", err, sig)
+	}
+	if ok, err := key.VerifySignature(sig, "memory", "app", "hallo"); err != nil || !ok {
+		t.Fatalf("VerifySignature: %v %v", ok, err)
+	}
+	if ok, _ := key.VerifySignature(sig, "memory", "app", "hallo!"); ok {
+		t.Error("changed field must not verify")
+	}
+	if ok, _ := key.VerifySignature(sig, "memory", "apph", "allo"); ok {
+		t.Error("bytes moved between fields must not verify")
+	}
+	if _, err := other.VerifySignature(sig, "memory", "app", "hallo"); !errors.Is(err, ErrSignatureKey) {
+		t.Errorf("other key: expected ErrSignatureKey, got %v", err)
+	}
+	if _, err := key.VerifySignature("kaputt", "memory"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("malformed signature: expected ErrDecrypt, got %v", err)
+	}
+	pass, _ := PassphraseKey("secret")
+	if _, err := pass.Sign("x"); !errors.Is(err, ErrNotMasterKey) {
+		t.Errorf("passphrase key: expected ErrNotMasterKey, got %v", err)
+	}
+}
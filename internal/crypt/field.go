@@ -0,0 +1,212 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Field encryption (envelope encryption of single database values): every value is sealed with
+// AES-256-GCM under a data key (DEK) of its tenant; DEKs are stored wrapped with the master key.
+//
+// Format of a sealed value: "enc:v1:" | key ID | ":" | base64(nonce | ciphertext). The additional data
+// names the column ("memories.content"), so a value cannot be moved to another column unnoticed.
+
+// FieldPrefix marks values sealed by SealField.
+const FieldPrefix = "enc:v1:"
+
+// ErrNotMasterKey is returned when a passphrase is used as master key; wrapping needs a keyfile.
+var ErrNotMasterKey = errors.New("master key must come from a keyfile, not a passphrase")
+
+// NewDataKey returns a new random data key and its ID (16 hex characters).
+func NewDataKey() (id string, key []byte, err error) {
+	buf := make([]byte, 8+keySize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(buf[:8]), buf[8:], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain with a random nonce; the result is nonce | ciphertext.
+func seal(key, plain, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out, plain, aad), nil
+}
+
+func open(key, data, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// SealField encrypts a field value with the data key keyID/dek; column is authenticated as additional data.
+func SealField(keyID string, dek []byte, plaintext, column string) (string, error) {
+	data, err := seal(dek, []byte(plaintext), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return FieldPrefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// IsSealed reports whether value was sealed by SealField.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, FieldPrefix)
+}
+
+// FieldKeyID returns the ID of the data key a sealed value was encrypted with.
+func FieldKeyID(value string) (string, bool) {
+	rest, ok := strings.CutPrefix(value, FieldPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, ":")
+	return id, ok && id != ""
+}
+
+// OpenField decrypts a value sealed by SealField for the same column.
+func OpenField(dek []byte, value, column string) (string, error) {
+	rest, ok := strings.CutPrefix(value, FieldPrefix)
+	if !ok {
+		return "", ErrNotEncrypted
+	}
+	_, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", ErrDecrypt
+	}
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	plain, err := open(dek, data, []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// FieldHashPrefix marks hashes created by FieldHash.
+const FieldHashPrefix = "hash:v1:"
+
+// FieldHash returns a keyed hash of a field value under the data key keyID/dek: HMAC-SHA256 under a
+// subkey of the data key. Equal values of a tenant have equal hashes, but unlike a plain SHA-256 the
+// hash cannot be checked against guessed values without the key.
+//
+// Format: "hash:v1:" | key ID | ":" | hex(mac).
+func FieldHash(keyID string, dek []byte, value string) string {
+	sub := hmac.New(sha256.New, dek)
+	sub.Write([]byte("cortex-field-hash"))
+	mac := hmac.New(sha256.New, sub.Sum(nil))
+	mac.Write([]byte(value))
+	return FieldHashPrefix + keyID + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// FieldHashKeyID returns the ID of the data key a FieldHash was computed with.
+func FieldHashKeyID(hash string) (string, bool) {
+	rest, ok := strings.CutPrefix(hash, FieldHashPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, ":")
+	return id, ok && id != ""
+}
+
+// derive returns a subkey of a keyfile key for purpose.
+func (k *Key) derive(purpose string) ([]byte, error) {
+	if k.raw == nil {
+		return nil, ErrNotMasterKey
+	}
+	mac := hmac.New(sha256.New, k.raw)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+// ID returns a fingerprint of a keyfile key (16 hex characters), stored next to wrapped data keys
+// to find the master key they were wrapped with. Empty for passphrase keys.
+func (k *Key) ID() string {
+	id, err := k.derive("cortex-key-id")
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id[:8])
+}
+
+// WrapKey encrypts the data key keyID/dek with k (the key ID is authenticated).
+func (k *Key) WrapKey(keyID string, dek []byte) ([]byte, error) {
+	kek, err := k.derive("cortex-wrap-key")
+	if err != nil {
+		return nil, err
+	}
+	return seal(kek, dek, []byte(keyID))
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+func (k *Key) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	kek, err := k.derive("cortex-wrap-key")
+	if err != nil {
+		return nil, err
+	}
+	dek, err := open(kek, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+	if len(dek) != keySize {
+		return nil, ErrDecrypt
+	}
+	return dek, nil
+}
+
+// MasterKeysFromEnv returns the master key for field encryption (CORTEX_MASTER_KEYFILE) and the
+// previous master keys (CORTEX_MASTER_KEYFILE_PREVIOUS, comma-separated paths) still needed to unwrap
+// data keys after a master key change. current is nil if no master key is configured.
+func MasterKeysFromEnv() (current *Key, previous []*Key, err error) {
+	path := os.Getenv("CORTEX_MASTER_KEYFILE")
+	if path == "" {
+		return nil, nil, nil
+	}
+	if current, err = KeyFromFile(path); err != nil {
+		return nil, nil, err
+	}
+	for _, p := range strings.Split(os.Getenv("CORTEX_MASTER_KEYFILE_PREVIOUS"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		k, err := KeyFromFile(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("previous master key: %w", err)
+		}
+		previous = append(previous, k)
+	}
+	return current, previous, nil
+}
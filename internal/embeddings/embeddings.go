@@ -1,6 +1,8 @@
 package embeddings
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"math"
 	"os"
@@ -30,6 +32,34 @@ type EmbeddingService interface {
 	GenerateEmbeddingsBatch(contents []string, contentType string) ([][]float32, error)
 }
 
+// ModelIdentifier wird von Services implementiert, die ihr Modell benennen können.
+// Die Modell-ID wird pro Memory gespeichert, damit Vektoren verschiedener Modelle nicht verglichen werden.
+type ModelIdentifier interface {
+	ModelID() string
+}
+
+// ContextEmbedder wird von Services implementiert, deren Aufrufe (Requests, Wartezeiten zwischen
+// Wiederholungen) mit dem Context abbrechen.
+type ContextEmbedder interface {
+	GenerateEmbeddingContext(ctx context.Context, content string, contentType string) ([]float32, error)
+}
+
+// GenerateEmbeddingContext generiert ein Embedding und bricht ab, sobald ctx endet (falls der Service das unterstützt)
+func GenerateEmbeddingContext(ctx context.Context, service EmbeddingService, content string, contentType string) ([]float32, error) {
+	if ce, ok := service.(ContextEmbedder); ok {
+		return ce.GenerateEmbeddingContext(ctx, content, contentType)
+	}
+	return service.GenerateEmbedding(content, contentType)
+}
+
+// ModelIDOf liefert die Modell-ID eines Services ("" wenn unbekannt)
+func ModelIDOf(service EmbeddingService) string {
+	if mi, ok := service.(ModelIdentifier); ok {
+		return mi.ModelID()
+	}
+	return ""
+}
+
 // LocalEmbeddingService - Lokaler Embedding-Service ohne externe Abhängigkeiten
 // Verwendet einen verbesserten Hash-basierten Ansatz für semantische Ähnlichkeit
 type LocalEmbeddingService struct {
@@ -145,6 +175,11 @@ func (l *LocalEmbeddingService) GenerateEmbeddingsBatch(contents []string, conte
 	return embeddings, nil
 }
 
+// ModelID liefert die Modell-ID des Hash-basierten Services
+func (l *LocalEmbeddingService) ModelID() string {
+	return fmt.Sprintf("local-hash-%d", l.dimension)
+}
+
 // hashString erstellt einen Hash-Wert aus einem String
 func (l *LocalEmbeddingService) hashString(s string) uint32 {
 	var hash uint32 = 2166136261 // FNV-1a Basis
@@ -223,6 +258,11 @@ func (g *GTEEmbeddingService) GenerateEmbeddingsBatch(contents []string, content
 	return embeddings, nil
 }
 
+// ModelID liefert die Modell-ID von GTE-Small
+func (g *GTEEmbeddingService) ModelID() string {
+	return "gte-small"
+}
+
 // Close schließt das Modell (für Cleanup)
 func (g *GTEEmbeddingService) Close() error {
 	g.mu.Lock()
@@ -250,35 +290,65 @@ func resetEmbeddingService() {
 }
 
 // GetEmbeddingService gibt den verfügbaren Embedding-Service zurück
-// Versucht zuerst gte-go zu laden (falls CORTEX_EMBEDDING_MODEL_PATH gesetzt),
-// sonst Fallback auf Hash-basierten Service
+// Provider über CORTEX_EMBEDDING_PROVIDER (local, gte, openai oder per RegisterProvider registriert);
+// ohne Angabe gte falls CORTEX_EMBEDDING_MODEL_PATH gesetzt ist, sonst Hash-basierter Service.
+// Schlägt ein explizit konfigurierter Provider fehl, liefert der Service bei jedem Aufruf dessen Fehler
+// (keine Vektoren aus einem anderen Raum unter demselben Index). Nur das automatisch gewählte gte fällt
+// auf den Hash-basierten Service zurück; dessen Vektoren tragen die Modell-ID des Hash-Service und werden
+// von einem Re-Embed ersetzt.
 func GetEmbeddingService() EmbeddingService {
 	serviceMu.Lock()
 	defer serviceMu.Unlock()
 	serviceOnce.Do(func() {
-		modelPath := os.Getenv("CORTEX_EMBEDDING_MODEL_PATH")
-		if modelPath != "" {
-			// Versuche GTE-Service zu laden
-			gteService, err := NewGTEEmbeddingService(modelPath)
-			if err != nil {
-				slog.Warn("Failed to load GTE embedding model, falling back to hash-based service",
-					"error", err,
-					"modelPath", modelPath)
-				globalEmbeddingService = NewLocalEmbeddingService()
-			} else {
-				slog.Info("Using GTE-Small Embedding Service",
-					"modelPath", modelPath)
-				globalEmbeddingService = gteService
-			}
-		} else {
-			slog.Info("Using Local Hash-based Embedding Service",
-				"hint", "Set CORTEX_EMBEDDING_MODEL_PATH to use GTE-Small model")
+		name := providerNameFromEnv()
+		service, err := NewProvider(name)
+		switch {
+		case err != nil && os.Getenv("CORTEX_EMBEDDING_PROVIDER") != "":
+			slog.Error("Failed to initialize configured embedding provider, embeddings unavailable",
+				"provider", name,
+				"error", err)
+			globalEmbeddingService = unavailableEmbeddingService{err: fmt.Errorf("embedding provider %s: %w", name, err)}
+		case err != nil:
+			slog.Warn("Failed to initialize embedding provider, falling back to hash-based service",
+				"provider", name,
+				"model", NewLocalEmbeddingService().ModelID(),
+				"error", err,
+				"hint", "run a re-embed once the provider works")
 			globalEmbeddingService = NewLocalEmbeddingService()
+		case name == "local":
+			slog.Info("Using Local Hash-based Embedding Service",
+				"hint", "Set CORTEX_EMBEDDING_MODEL_PATH or CORTEX_EMBEDDING_PROVIDER for better embeddings")
+			globalEmbeddingService = service
+		default:
+			slog.Info("Using embedding provider", "provider", name)
+			globalEmbeddingService = service
 		}
 	})
 	return globalEmbeddingService
 }
 
+// InitEmbeddingService initialisiert den globalen Embedding-Service und liefert den Fehler eines
+// explizit konfigurierten Providers, der nicht starten kann (Server-Start).
+func InitEmbeddingService() error {
+	if u, ok := GetEmbeddingService().(unavailableEmbeddingService); ok {
+		return u.err
+	}
+	return nil
+}
+
+// unavailableEmbeddingService steht für einen konfigurierten Provider, der nicht initialisiert werden konnte
+type unavailableEmbeddingService struct {
+	err error
+}
+
+func (u unavailableEmbeddingService) GenerateEmbedding(string, string) ([]float32, error) {
+	return nil, u.err
+}
+
+func (u unavailableEmbeddingService) GenerateEmbeddingsBatch([]string, string) ([][]float32, error) {
+	return nil, u.err
+}
+
 // CosineSimilarity berechnet die Cosine-Ähnlichkeit zwischen zwei Vektoren
 func CosineSimilarity(a, b []float32) float64 {
 	if len(a) != len(b) {
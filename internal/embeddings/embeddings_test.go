@@ -1,8 +1,10 @@
 package embeddings
 
 import (
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -122,6 +124,111 @@ func TestDetectContentType(t *testing.T) {
 	}
 }
 
+func TestEncodeVectorEncodings(t *testing.T) {
+	original := Normalize([]float32{0.1, -0.2, 0.3, 0.4, -0.5, 0.05, 0, 1e-3})
+
+	tests := []struct {
+		enc       VectorEncoding
+		size      int
+		tolerance float64
+	}{
+		{VectorFloat32, 4, 0},
+		{VectorFloat16, 2, 1e-3},
+		{VectorInt8, 1, 1e-2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.enc.String(), func(t *testing.T) {
+			encoded, err := EncodeVectorAs(original, tt.enc, "gte-small")
+			if err != nil {
+				t.Fatalf("failed to encode vector: %v", err)
+			}
+			wantLen := vectorHeaderSize + len("gte-small") + tt.size*len(original)
+			if tt.enc == VectorInt8 {
+				wantLen += 4
+			}
+			if len(encoded) != wantLen {
+				t.Errorf("expected %d bytes, got %d", wantLen, len(encoded))
+			}
+
+			h, err := DecodeVectorHeader(encoded)
+			if err != nil {
+				t.Fatalf("failed to decode header: %v", err)
+			}
+			if h.Encoding != tt.enc || h.Dimension != len(original) || h.ModelID != "gte-small" {
+				t.Errorf("unexpected header: %+v", h)
+			}
+
+			decoded, err := DecodeVector(encoded)
+			if err != nil {
+				t.Fatalf("failed to decode vector: %v", err)
+			}
+			for i := range original {
+				if d := math.Abs(float64(decoded[i] - original[i])); d > tt.tolerance {
+					t.Errorf("index %d: expected %f, got %f", i, original[i], decoded[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeVectorLegacyJSON(t *testing.T) {
+	decoded, err := DecodeVector([]byte("[0.5, -0.25, 1]"))
+	if err != nil {
+		t.Fatalf("failed to decode legacy vector: %v", err)
+	}
+	if len(decoded) != 3 || decoded[0] != 0.5 || decoded[1] != -0.25 || decoded[2] != 1 {
+		t.Errorf("unexpected legacy vector: %v", decoded)
+	}
+
+	h, err := DecodeVectorHeader([]byte("[0.5, -0.25, 1]"))
+	if err != nil || h.Encoding != VectorJSON || h.Dimension != 3 {
+		t.Errorf("unexpected legacy header: %+v (err %v)", h, err)
+	}
+
+	if v, err := DecodeVector(nil); err != nil || v != nil {
+		t.Errorf("expected nil vector for empty input, got %v (err %v)", v, err)
+	}
+	if _, err := DecodeVector([]byte("garbage")); err == nil {
+		t.Error("expected error for invalid data")
+	}
+}
+
+func TestDecodeVectorCorruptHeader(t *testing.T) {
+	encoded, err := EncodeVectorAs([]float32{1, 2, 3}, VectorFloat16, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Riesige Dimension und unbekannte Encodings dürfen nicht zur Allokation führen
+	huge := append([]byte(nil), encoded...)
+	huge[4], huge[5], huge[6], huge[7] = 0xff, 0xff, 0xff, 0xff
+	unknown := append([]byte(nil), huge...)
+	unknown[3] = 0x7f
+	for name, data := range map[string][]byte{"dimension": huge, "encoding": unknown, "truncated": encoded[:len(encoded)-1]} {
+		if v, err := DecodeVector(data); err == nil || v != nil {
+			t.Errorf("%s: expected error, got %d elements", name, len(v))
+		}
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	DecodeVector(huge)
+	DecodeVector(unknown)
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("corrupt header should be rejected without allocating the vector, allocated %d bytes", n)
+	}
+}
+
+func TestFloat16Conversion(t *testing.T) {
+	for _, f := range []float32{0, 1, -1, 0.5, 65504, 6.1035156e-05, 5.9604645e-08} {
+		if got := float16ToFloat32(float32ToFloat16(f)); got != f {
+			t.Errorf("float16 round trip of %g: got %g", f, got)
+		}
+	}
+	if got := float16ToFloat32(float32ToFloat16(1e6)); !math.IsInf(float64(got), 1) {
+		t.Errorf("expected +Inf on overflow, got %g", got)
+	}
+}
+
 func TestLocalEmbeddingService(t *testing.T) {
 	service := NewLocalEmbeddingService()
 
@@ -0,0 +1,423 @@
+package embeddings
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// ErrDimensionMismatch is returned when a vector does not match the dimension of an index.
+var ErrDimensionMismatch = errors.New("vector dimension does not match index dimension")
+
+// HNSWConfig holds the tuning parameters of an HNSWIndex.
+type HNSWConfig struct {
+	// M is the number of neighbours per node on the upper layers (layer 0 uses 2*M).
+	M int
+	// EfConstruction is the candidate list size while inserting (higher = better recall, slower build).
+	EfConstruction int
+	// EfSearch is the minimum candidate list size while searching (raised to k if smaller).
+	EfSearch int
+}
+
+// DefaultHNSWConfig returns parameters that give >0.95 recall for typical embedding sizes (384–1024 dims).
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{M: 16, EfConstruction: 200, EfSearch: 64}
+}
+
+// SearchResult is a single hit returned by HNSWIndex.Search.
+type SearchResult struct {
+	ID         int64
+	Similarity float64
+}
+
+// HNSWIndex is an in-memory approximate nearest-neighbour index (Hierarchical Navigable Small World graph)
+// over cosine similarity. Vectors are L2-normalised on insert, so similarity reduces to a dot product.
+// Removed vectors are tombstoned and the graph is rebuilt once tombstones outnumber live nodes.
+// All methods are safe for concurrent use.
+type HNSWIndex struct {
+	mu        sync.RWMutex
+	cfg       HNSWConfig
+	maxM0     int
+	levelMult float64
+	rng       *rand.Rand
+
+	dim      int
+	nodes    []*hnswNode
+	ids      map[int64]int32 // memory ID -> node index (live nodes only)
+	entry    int32
+	maxLevel int
+	deleted  int
+}
+
+type hnswNode struct {
+	id      int64
+	vec     []float32
+	friends [][]int32 // per layer
+	deleted bool
+}
+
+// NewHNSWIndex creates an empty index. Zero values in cfg are replaced by DefaultHNSWConfig values.
+func NewHNSWIndex(cfg HNSWConfig) *HNSWIndex {
+	def := DefaultHNSWConfig()
+	if cfg.M <= 1 {
+		cfg.M = def.M
+	}
+	if cfg.EfConstruction <= 0 {
+		cfg.EfConstruction = def.EfConstruction
+	}
+	if cfg.EfSearch <= 0 {
+		cfg.EfSearch = def.EfSearch
+	}
+	return &HNSWIndex{
+		cfg:       cfg,
+		maxM0:     cfg.M * 2,
+		levelMult: 1 / math.Log(float64(cfg.M)),
+		rng:       rand.New(rand.NewSource(42)),
+		ids:       make(map[int64]int32),
+		entry:     -1,
+	}
+}
+
+// Len returns the number of live vectors in the index.
+func (h *HNSWIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.ids)
+}
+
+// Dimension returns the vector dimension of the index (0 while empty).
+func (h *HNSWIndex) Dimension() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.dim
+}
+
+// Contains reports whether id is present in the index.
+func (h *HNSWIndex) Contains(id int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.ids[id]
+	return ok
+}
+
+// Add inserts or replaces the vector for id.
+func (h *HNSWIndex) Add(id int64, vec []float32) error {
+	if len(vec) == 0 {
+		return ErrDimensionMismatch
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.ids) == 0 && h.deleted == 0 {
+		h.dim = len(vec)
+	}
+	if len(vec) != h.dim {
+		return ErrDimensionMismatch
+	}
+	if idx, ok := h.ids[id]; ok {
+		h.removeLocked(idx)
+	}
+	h.insertLocked(id, Normalize(vec))
+	h.maybeCompactLocked()
+	return nil
+}
+
+// Remove deletes id from the index. Returns false if id was not present.
+func (h *HNSWIndex) Remove(id int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	idx, ok := h.ids[id]
+	if !ok {
+		return false
+	}
+	h.removeLocked(idx)
+	h.maybeCompactLocked()
+	return true
+}
+
+// Search returns up to k nearest vectors to query, ordered by similarity (highest first).
+func (h *HNSWIndex) Search(query []float32, k int) ([]SearchResult, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.entry < 0 || len(h.ids) == 0 {
+		return nil, nil
+	}
+	if len(query) != h.dim {
+		return nil, ErrDimensionMismatch
+	}
+	q := Normalize(query)
+
+	cur := h.entry
+	curDist := h.distance(q, cur)
+	for l := h.maxLevel; l > 0; l-- {
+		cur, curDist = h.greedyClosest(q, cur, curDist, l)
+	}
+	// Tombstoned nodes still take part in traversal, so widen ef by their share.
+	ef := max(h.cfg.EfSearch, k)
+	if h.deleted > 0 {
+		ef += min(h.deleted, ef)
+	}
+	found := h.searchLayer(q, []distItem{{idx: cur, dist: curDist}}, ef, 0)
+
+	results := make([]SearchResult, 0, min(k, len(found)))
+	for _, it := range found {
+		n := h.nodes[it.idx]
+		if n.deleted {
+			continue
+		}
+		results = append(results, SearchResult{ID: n.id, Similarity: 1 - it.dist})
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (h *HNSWIndex) insertLocked(id int64, vec []float32) {
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	idx := int32(len(h.nodes))
+	node := &hnswNode{id: id, vec: vec, friends: make([][]int32, level+1)}
+	h.nodes = append(h.nodes, node)
+	h.ids[id] = idx
+
+	if h.entry < 0 {
+		h.entry = idx
+		h.maxLevel = level
+		return
+	}
+
+	cur := h.entry
+	curDist := h.distance(vec, cur)
+	for l := h.maxLevel; l > level; l-- {
+		cur, curDist = h.greedyClosest(vec, cur, curDist, l)
+	}
+	eps := []distItem{{idx: cur, dist: curDist}}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(vec, eps, h.cfg.EfConstruction, l)
+		neighbours := h.selectNeighbours(candidates, h.cfg.M)
+		node.friends[l] = make([]int32, 0, len(neighbours))
+		for _, nb := range neighbours {
+			node.friends[l] = append(node.friends[l], nb.idx)
+			h.link(nb.idx, idx, l)
+		}
+		eps = candidates
+	}
+	if level > h.maxLevel {
+		h.entry = idx
+		h.maxLevel = level
+	}
+}
+
+// link adds an edge from -> to on layer l and prunes from's neighbour list if it grew too large.
+func (h *HNSWIndex) link(from, to int32, l int) {
+	n := h.nodes[from]
+	if l >= len(n.friends) {
+		return
+	}
+	n.friends[l] = append(n.friends[l], to)
+	limit := h.cfg.M
+	if l == 0 {
+		limit = h.maxM0
+	}
+	if len(n.friends[l]) <= limit {
+		return
+	}
+	cands := make([]distItem, 0, len(n.friends[l]))
+	for _, f := range n.friends[l] {
+		cands = append(cands, distItem{idx: f, dist: h.distance(n.vec, f)})
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].dist < cands[j].dist })
+	kept := h.selectNeighbours(cands, limit)
+	n.friends[l] = n.friends[l][:0]
+	for _, c := range kept {
+		n.friends[l] = append(n.friends[l], c.idx)
+	}
+}
+
+// selectNeighbours applies the HNSW diversity heuristic to candidates (sorted by distance, closest first):
+// a candidate is kept only if it is closer to the base than to any already kept neighbour.
+// Remaining slots are filled with the closest discarded candidates.
+func (h *HNSWIndex) selectNeighbours(candidates []distItem, m int) []distItem {
+	if len(candidates) <= m {
+		return candidates
+	}
+	selected := make([]distItem, 0, m)
+	var discarded []distItem
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		good := true
+		for _, s := range selected {
+			if h.distanceBetween(c.idx, s.idx) < c.dist {
+				good = false
+				break
+			}
+		}
+		if good {
+			selected = append(selected, c)
+		} else {
+			discarded = append(discarded, c)
+		}
+	}
+	for _, d := range discarded {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, d)
+	}
+	return selected
+}
+
+func (h *HNSWIndex) greedyClosest(q []float32, cur int32, curDist float64, l int) (int32, float64) {
+	for changed := true; changed; {
+		changed = false
+		n := h.nodes[cur]
+		if l >= len(n.friends) {
+			break
+		}
+		for _, f := range n.friends[l] {
+			if d := h.distance(q, f); d < curDist {
+				cur, curDist = f, d
+				changed = true
+			}
+		}
+	}
+	return cur, curDist
+}
+
+// searchLayer runs the HNSW beam search on layer l and returns up to ef items sorted by distance.
+func (h *HNSWIndex) searchLayer(q []float32, entryPoints []distItem, ef int, l int) []distItem {
+	visited := make([]uint64, (len(h.nodes)+63)/64)
+	visit := func(i int32) bool {
+		w, b := i/64, uint64(1)<<(uint(i)%64)
+		if visited[w]&b != 0 {
+			return false
+		}
+		visited[w] |= b
+		return true
+	}
+
+	candidates := &minDistHeap{}
+	results := &maxDistHeap{}
+	for _, ep := range entryPoints {
+		if !visit(ep.idx) {
+			continue
+		}
+		heap.Push(candidates, ep)
+		heap.Push(results, ep)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(distItem)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		n := h.nodes[c.idx]
+		if l >= len(n.friends) {
+			continue
+		}
+		for _, f := range n.friends[l] {
+			if !visit(f) {
+				continue
+			}
+			d := h.distance(q, f)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, distItem{idx: f, dist: d})
+				heap.Push(results, distItem{idx: f, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := make([]distItem, results.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(results).(distItem)
+	}
+	return out
+}
+
+func (h *HNSWIndex) removeLocked(idx int32) {
+	n := h.nodes[idx]
+	if n.deleted {
+		return
+	}
+	n.deleted = true
+	delete(h.ids, n.id)
+	h.deleted++
+}
+
+// maybeCompactLocked rebuilds the graph from live nodes once tombstones dominate.
+func (h *HNSWIndex) maybeCompactLocked() {
+	if h.deleted < 64 || h.deleted < len(h.ids) {
+		return
+	}
+	old := h.nodes
+	h.nodes = make([]*hnswNode, 0, len(h.ids))
+	h.ids = make(map[int64]int32, len(h.ids))
+	h.entry = -1
+	h.maxLevel = 0
+	h.deleted = 0
+	for _, n := range old {
+		if !n.deleted {
+			h.insertLocked(n.id, n.vec)
+		}
+	}
+}
+
+func (h *HNSWIndex) distance(q []float32, idx int32) float64 {
+	return 1 - dot(q, h.nodes[idx].vec)
+}
+
+func (h *HNSWIndex) distanceBetween(a, b int32) float64 {
+	return 1 - dot(h.nodes[a].vec, h.nodes[b].vec)
+}
+
+func dot(a, b []float32) float64 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return float64(sum)
+}
+
+type distItem struct {
+	idx  int32
+	dist float64
+}
+
+type minDistHeap []distItem
+
+func (h minDistHeap) Len() int            { return len(h) }
+func (h minDistHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h minDistHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minDistHeap) Push(x interface{}) { *h = append(*h, x.(distItem)) }
+func (h *minDistHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+type maxDistHeap []distItem
+
+func (h maxDistHeap) Len() int            { return len(h) }
+func (h maxDistHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h maxDistHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxDistHeap) Push(x interface{}) { *h = append(*h, x.(distItem)) }
+func (h *maxDistHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
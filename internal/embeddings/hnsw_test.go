@@ -0,0 +1,137 @@
+package embeddings
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randomVectors(n, dim int, seed int64) [][]float32 {
+	rng := rand.New(rand.NewSource(seed))
+	out := make([][]float32, n)
+	for i := range out {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = rng.Float32()*2 - 1
+		}
+		out[i] = v
+	}
+	return out
+}
+
+func TestHNSWIndexRecall(t *testing.T) {
+	const n, dim, k = 2000, 64, 10
+	vectors := randomVectors(n, dim, 1)
+	idx := NewHNSWIndex(DefaultHNSWConfig())
+	for i, v := range vectors {
+		if err := idx.Add(int64(i+1), v); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if idx.Len() != n {
+		t.Fatalf("expected %d vectors, got %d", n, idx.Len())
+	}
+
+	queries := randomVectors(50, dim, 2)
+	var hits, total int
+	for _, q := range queries {
+		// Brute-force ground truth
+		type scored struct {
+			id  int64
+			sim float64
+		}
+		exact := make([]scored, n)
+		for i, v := range vectors {
+			exact[i] = scored{int64(i + 1), CosineSimilarity(q, v)}
+		}
+		sort.Slice(exact, func(i, j int) bool { return exact[i].sim > exact[j].sim })
+		want := make(map[int64]bool, k)
+		for _, e := range exact[:k] {
+			want[e.id] = true
+		}
+
+		res, err := idx.Search(q, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(res) != k {
+			t.Fatalf("expected %d results, got %d", k, len(res))
+		}
+		for i := 1; i < len(res); i++ {
+			if res[i].Similarity > res[i-1].Similarity {
+				t.Fatal("results not sorted by similarity")
+			}
+		}
+		for _, r := range res {
+			if want[r.ID] {
+				hits++
+			}
+		}
+		total += k
+	}
+	if recall := float64(hits) / float64(total); recall < 0.9 {
+		t.Errorf("recall too low: %.3f", recall)
+	}
+}
+
+func TestHNSWIndexAddRemove(t *testing.T) {
+	idx := NewHNSWIndex(HNSWConfig{})
+	vectors := randomVectors(300, 16, 3)
+	for i, v := range vectors {
+		idx.Add(int64(i+1), v)
+	}
+
+	// Exact match is found first
+	res, _ := idx.Search(vectors[41], 1)
+	if len(res) != 1 || res[0].ID != 42 {
+		t.Fatalf("expected id 42 as nearest neighbour, got %v", res)
+	}
+
+	// Removed IDs never show up again, also after compaction
+	for id := int64(1); id <= 200; id++ {
+		if !idx.Remove(id) {
+			t.Fatalf("Remove(%d) returned false", id)
+		}
+	}
+	if idx.Remove(1) {
+		t.Error("second Remove should return false")
+	}
+	if idx.Len() != 100 {
+		t.Errorf("expected 100 live vectors, got %d", idx.Len())
+	}
+	res, _ = idx.Search(vectors[41], 100)
+	for _, r := range res {
+		if r.ID <= 200 {
+			t.Errorf("removed id %d returned", r.ID)
+		}
+	}
+	if len(res) != 100 {
+		t.Errorf("expected all 100 live vectors, got %d", len(res))
+	}
+
+	// Replacing a vector moves the ID
+	idx.Add(250, vectors[0])
+	res, _ = idx.Search(vectors[0], 1)
+	if len(res) != 1 || res[0].ID != 250 {
+		t.Errorf("expected replaced id 250, got %v", res)
+	}
+	if idx.Len() != 100 {
+		t.Errorf("replace must not change size, got %d", idx.Len())
+	}
+}
+
+func TestHNSWIndexDimensionMismatch(t *testing.T) {
+	idx := NewHNSWIndex(DefaultHNSWConfig())
+	if res, err := idx.Search([]float32{1, 0}, 5); err != nil || res != nil {
+		t.Errorf("search on empty index: expected no results, got %v, %v", res, err)
+	}
+	if err := idx.Add(1, []float32{1, 0, 0}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := idx.Add(2, []float32{1, 0}); err != ErrDimensionMismatch {
+		t.Errorf("expected ErrDimensionMismatch on Add, got %v", err)
+	}
+	if _, err := idx.Search([]float32{1, 0}, 5); err != ErrDimensionMismatch {
+		t.Errorf("expected ErrDimensionMismatch on Search, got %v", err)
+	}
+}
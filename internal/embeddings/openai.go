@@ -0,0 +1,242 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAIConfig konfiguriert einen OpenAI-kompatiblen Embedding-Endpunkt (OpenAI, Ollama, llama.cpp, vLLM, ...)
+type OpenAIConfig struct {
+	BaseURL    string        // z.B. "http://localhost:11434/v1"; "/embeddings" wird angehängt
+	APIKey     string        // optional, als Bearer-Token gesendet
+	Model      string        // Modellname, z.B. "nomic-embed-text"
+	Dimensions int           // optional: gewünschte Dimension (nur wenn das Modell es unterstützt)
+	BatchSize  int           // max. Texte pro Request (Standard: 64)
+	Timeout    time.Duration // Timeout pro Request (Standard: 30s)
+	MaxRetries int           // Wiederholungen bei Netzwerkfehler, 429 und 5xx (0 = keine)
+	RetryDelay time.Duration // Basis-Wartezeit, verdoppelt sich pro Versuch (Standard: 500ms)
+}
+
+// maxRetryWait begrenzt die Wartezeit vor einer Wiederholung, auch wenn der Server per Retry-After länger verlangt
+const maxRetryWait = 30 * time.Second
+
+// OpenAIConfigFromEnv liest die Konfiguration aus CORTEX_EMBEDDING_* Umgebungsvariablen
+func OpenAIConfigFromEnv() OpenAIConfig {
+	cfg := OpenAIConfig{
+		BaseURL:    os.Getenv("CORTEX_EMBEDDING_URL"),
+		APIKey:     os.Getenv("CORTEX_EMBEDDING_API_KEY"),
+		Model:      os.Getenv("CORTEX_EMBEDDING_MODEL"),
+		MaxRetries: 3,
+	}
+	if v, err := strconv.Atoi(os.Getenv("CORTEX_EMBEDDING_DIMENSIONS")); err == nil {
+		cfg.Dimensions = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CORTEX_EMBEDDING_BATCH_SIZE")); err == nil {
+		cfg.BatchSize = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("CORTEX_EMBEDDING_TIMEOUT")); err == nil {
+		cfg.Timeout = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CORTEX_EMBEDDING_MAX_RETRIES")); err == nil {
+		cfg.MaxRetries = v
+	}
+	return cfg
+}
+
+// OpenAIEmbeddingService ruft einen OpenAI-kompatiblen /v1/embeddings Endpunkt auf
+type OpenAIEmbeddingService struct {
+	cfg      OpenAIConfig
+	endpoint string
+	client   *http.Client
+}
+
+// NewOpenAIEmbeddingService erstellt einen Embedding-Service für einen OpenAI-kompatiblen Server
+func NewOpenAIEmbeddingService(cfg OpenAIConfig) (*OpenAIEmbeddingService, error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("openai embeddings: base URL is required (CORTEX_EMBEDDING_URL)")
+	}
+	if cfg.Model == "" {
+		return nil, errors.New("openai embeddings: model is required (CORTEX_EMBEDDING_MODEL)")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 64
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = 500 * time.Millisecond
+	}
+
+	endpoint := strings.TrimRight(cfg.BaseURL, "/")
+	if !strings.HasSuffix(endpoint, "/embeddings") {
+		endpoint += "/embeddings"
+	}
+	return &OpenAIEmbeddingService{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// GenerateEmbedding generiert ein Embedding über den Remote-Endpunkt
+func (o *OpenAIEmbeddingService) GenerateEmbedding(content string, contentType string) ([]float32, error) {
+	return o.GenerateEmbeddingContext(context.Background(), content, contentType)
+}
+
+// GenerateEmbeddingContext generiert ein Embedding und bricht Request und Wartezeiten ab, sobald ctx endet
+func (o *OpenAIEmbeddingService) GenerateEmbeddingContext(ctx context.Context, content string, contentType string) ([]float32, error) {
+	embeddings, err := o.generateBatch(ctx, []string{content})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// GenerateEmbeddingsBatch generiert Embeddings in Batches von höchstens BatchSize Texten
+func (o *OpenAIEmbeddingService) GenerateEmbeddingsBatch(contents []string, contentType string) ([][]float32, error) {
+	return o.generateBatch(context.Background(), contents)
+}
+
+func (o *OpenAIEmbeddingService) generateBatch(ctx context.Context, contents []string) ([][]float32, error) {
+	result := make([][]float32, 0, len(contents))
+	for start := 0; start < len(contents); start += o.cfg.BatchSize {
+		end := min(start+o.cfg.BatchSize, len(contents))
+		batch, err := o.embedWithRetry(ctx, contents[start:end])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, batch...)
+	}
+	return result, nil
+}
+
+// ModelID liefert den konfigurierten Modellnamen (plus Dimension, falls explizit gesetzt)
+func (o *OpenAIEmbeddingService) ModelID() string {
+	if o.cfg.Dimensions > 0 {
+		return fmt.Sprintf("%s@%d", o.cfg.Model, o.cfg.Dimensions)
+	}
+	return o.cfg.Model
+}
+
+// retryableError markiert Fehler, bei denen ein erneuter Versuch sinnvoll ist
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+func (o *OpenAIEmbeddingService) embedWithRetry(ctx context.Context, inputs []string) ([][]float32, error) {
+	delay := o.cfg.RetryDelay
+	for attempt := 0; ; attempt++ {
+		result, err := o.embed(ctx, inputs)
+		if err == nil {
+			return result, nil
+		}
+		var re *retryableError
+		if !errors.As(err, &re) || attempt >= o.cfg.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		wait := delay
+		if re.retryAfter > 0 {
+			wait = re.retryAfter
+		}
+		wait = min(wait, maxRetryWait)
+		slog.Warn("embedding request failed, retrying", "error", err, "attempt", attempt+1, "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("openai embeddings: %w", ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (o *OpenAIEmbeddingService) embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	body, err := json.Marshal(openAIEmbeddingRequest{
+		Model:          o.cfg.Model,
+		Input:          inputs,
+		Dimensions:     o.cfg.Dimensions,
+		EncodingFormat: "float",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.cfg.APIKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, &retryableError{err: fmt.Errorf("openai embeddings: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("openai embeddings: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			re := &retryableError{err: err}
+			if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+				re.retryAfter = time.Duration(secs) * time.Second
+			}
+			return nil, re
+		}
+		return nil, err
+	}
+
+	var parsed openAIEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("openai embeddings: invalid response: %w", err)
+	}
+	if len(parsed.Data) != len(inputs) {
+		return nil, fmt.Errorf("openai embeddings: expected %d embeddings, got %d", len(inputs), len(parsed.Data))
+	}
+	// Reihenfolge über index herstellen (Server dürfen umsortieren)
+	sort.Slice(parsed.Data, func(i, j int) bool { return parsed.Data[i].Index < parsed.Data[j].Index })
+	result := make([][]float32, len(parsed.Data))
+	for i, d := range parsed.Data {
+		if len(d.Embedding) == 0 {
+			return nil, fmt.Errorf("openai embeddings: empty embedding at index %d", d.Index)
+		}
+		result[i] = d.Embedding
+	}
+	return result, nil
+}
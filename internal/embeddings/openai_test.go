@@ -0,0 +1,188 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeEmbeddingServer simuliert einen OpenAI-kompatiblen /v1/embeddings Endpunkt.
+// Das Embedding eines Inputs ist [len(input), index]; die Daten werden umgekehrt sortiert zurückgegeben.
+func fakeEmbeddingServer(t *testing.T, failFirst int, status int) (*httptest.Server, *int32, *[]int) {
+	t.Helper()
+	var calls int32
+	var batchSizes []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/v1/embeddings" || r.Method != http.MethodPost {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if int(n) <= failFirst {
+			http.Error(w, "temporarily unavailable", status)
+			return
+		}
+		var req openAIEmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "test-model" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		batchSizes = append(batchSizes, len(req.Input))
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		data := make([]item, len(req.Input))
+		for i, in := range req.Input {
+			data[len(req.Input)-1-i] = item{Index: i, Embedding: []float32{float32(len(in)), float32(i)}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls, &batchSizes
+}
+
+func TestOpenAIEmbeddingServiceBatching(t *testing.T) {
+	srv, calls, batchSizes := fakeEmbeddingServer(t, 0, 0)
+	service, err := NewOpenAIEmbeddingService(OpenAIConfig{BaseURL: srv.URL + "/v1/", APIKey: "secret", Model: "test-model", BatchSize: 2})
+	if err != nil {
+		t.Fatalf("NewOpenAIEmbeddingService failed: %v", err)
+	}
+
+	embeddings, err := service.GenerateEmbeddingsBatch([]string{"a", "bb", "ccc", "dddd", "eeeee"}, "text/plain")
+	if err != nil {
+		t.Fatalf("GenerateEmbeddingsBatch failed: %v", err)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 requests for 5 inputs with batch size 2, got %d", *calls)
+	}
+	if len(*batchSizes) != 3 || (*batchSizes)[0] != 2 || (*batchSizes)[2] != 1 {
+		t.Errorf("unexpected batch sizes: %v", *batchSizes)
+	}
+	for i, emb := range embeddings {
+		if int(emb[0]) != i+1 {
+			t.Errorf("embedding %d out of order: %v", i, emb)
+		}
+	}
+
+	single, err := service.GenerateEmbedding("hello", "text/plain")
+	if err != nil || len(single) != 2 || single[0] != 5 {
+		t.Errorf("unexpected single embedding %v (err %v)", single, err)
+	}
+}
+
+func TestOpenAIEmbeddingServiceRetry(t *testing.T) {
+	srv, calls, _ := fakeEmbeddingServer(t, 2, http.StatusServiceUnavailable)
+	service, _ := NewOpenAIEmbeddingService(OpenAIConfig{BaseURL: srv.URL + "/v1", APIKey: "secret", Model: "test-model", MaxRetries: 3, RetryDelay: time.Millisecond})
+
+	if _, err := service.GenerateEmbedding("retry me", "text/plain"); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", *calls)
+	}
+
+	// Zu wenige Retries: Fehler wird durchgereicht
+	srv, calls, _ = fakeEmbeddingServer(t, 5, http.StatusTooManyRequests)
+	service, _ = NewOpenAIEmbeddingService(OpenAIConfig{BaseURL: srv.URL + "/v1", APIKey: "secret", Model: "test-model", MaxRetries: 1, RetryDelay: time.Millisecond})
+	if _, err := service.GenerateEmbedding("x", "text/plain"); err == nil {
+		t.Error("expected error when retries are exhausted")
+	}
+	if *calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", *calls)
+	}
+
+	// Client-Fehler (4xx außer 429) werden nicht wiederholt
+	srv, calls, _ = fakeEmbeddingServer(t, 0, 0)
+	service, _ = NewOpenAIEmbeddingService(OpenAIConfig{BaseURL: srv.URL + "/v1", APIKey: "wrong", Model: "test-model", MaxRetries: 3, RetryDelay: time.Millisecond})
+	if _, err := service.GenerateEmbedding("x", "text/plain"); err == nil {
+		t.Error("expected error for unauthorized request")
+	}
+	if *calls != 1 {
+		t.Errorf("expected no retry on 401, got %d attempts", *calls)
+	}
+}
+
+func TestOpenAIEmbeddingServiceRetryAfterCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "86400")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	// Ein Tag Retry-After blockiert nicht über das Ende des Requests hinaus
+	service, _ := NewOpenAIEmbeddingService(OpenAIConfig{BaseURL: srv.URL, Model: "test-model", MaxRetries: 3})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := GenerateEmbeddingContext(ctx, service, "x", "text/plain")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("retry wait ignored the context, took %v", time.Since(start))
+	}
+}
+
+func TestOpenAIEmbeddingServiceTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	service, _ := NewOpenAIEmbeddingService(OpenAIConfig{BaseURL: srv.URL, Model: "test-model", Timeout: 20 * time.Millisecond})
+	start := time.Now()
+	if _, err := service.GenerateEmbedding("slow", "text/plain"); err == nil {
+		t.Error("expected timeout error")
+	}
+	if time.Since(start) > 150*time.Millisecond {
+		t.Errorf("timeout not applied, took %v", time.Since(start))
+	}
+}
+
+func TestGetEmbeddingServiceProvider(t *testing.T) {
+	srv, _, _ := fakeEmbeddingServer(t, 0, 0)
+	t.Cleanup(resetEmbeddingService)
+
+	resetEmbeddingService()
+	t.Setenv("CORTEX_EMBEDDING_PROVIDER", "openai")
+	t.Setenv("CORTEX_EMBEDDING_URL", srv.URL+"/v1")
+	t.Setenv("CORTEX_EMBEDDING_API_KEY", "secret")
+	t.Setenv("CORTEX_EMBEDDING_MODEL", "test-model")
+	if _, ok := GetEmbeddingService().(*OpenAIEmbeddingService); !ok {
+		t.Error("expected OpenAIEmbeddingService for CORTEX_EMBEDDING_PROVIDER=openai")
+	}
+
+	resetEmbeddingService()
+	t.Setenv("CORTEX_EMBEDDING_PROVIDER", "does-not-exist")
+	if err := InitEmbeddingService(); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	if _, err := GetEmbeddingService().GenerateEmbedding("text", "text/plain"); err == nil {
+		t.Error("expected no hash-based fallback for a configured provider")
+	}
+
+	// Konfigurierter openai-Provider ohne URL: Fehler statt Hash-Vektoren
+	resetEmbeddingService()
+	t.Setenv("CORTEX_EMBEDDING_PROVIDER", "openai")
+	t.Setenv("CORTEX_EMBEDDING_URL", "")
+	if err := InitEmbeddingService(); err == nil {
+		t.Error("expected error for openai provider without URL")
+	}
+
+	resetEmbeddingService()
+	RegisterProvider("custom-test", func() (EmbeddingService, error) { return NewLocalEmbeddingService(), nil })
+	t.Setenv("CORTEX_EMBEDDING_PROVIDER", "custom-test")
+	if _, ok := GetEmbeddingService().(*LocalEmbeddingService); !ok {
+		t.Error("expected registered custom provider to be used")
+	}
+}
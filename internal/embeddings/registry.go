@@ -0,0 +1,77 @@
+package embeddings
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderFactory erstellt einen EmbeddingService aus der Umgebung
+type ProviderFactory func() (EmbeddingService, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFactory{}
+)
+
+// Eingebaute Provider: local (Hash-basiert), gte (gte-go, CORTEX_EMBEDDING_MODEL_PATH),
+// openai (OpenAI-kompatibler /v1/embeddings Endpunkt, z.B. Ollama oder llama.cpp)
+func init() {
+	RegisterProvider("local", func() (EmbeddingService, error) {
+		return NewLocalEmbeddingService(), nil
+	})
+	RegisterProvider("gte", func() (EmbeddingService, error) {
+		modelPath := os.Getenv("CORTEX_EMBEDDING_MODEL_PATH")
+		if modelPath == "" {
+			return nil, fmt.Errorf("gte provider requires CORTEX_EMBEDDING_MODEL_PATH")
+		}
+		return NewGTEEmbeddingService(modelPath)
+	})
+	RegisterProvider("openai", func() (EmbeddingService, error) {
+		return NewOpenAIEmbeddingService(OpenAIConfigFromEnv())
+	})
+}
+
+// RegisterProvider registriert einen Embedding-Provider unter name (überschreibt vorhandene)
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[strings.ToLower(name)] = factory
+}
+
+// Providers liefert die Namen aller registrierten Provider (sortiert)
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider erstellt den Embedding-Service des Providers name
+func NewProvider(name string) (EmbeddingService, error) {
+	providersMu.RLock()
+	factory, ok := providers[strings.ToLower(name)]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+	return factory()
+}
+
+// providerNameFromEnv bestimmt den Provider: CORTEX_EMBEDDING_PROVIDER, sonst gte wenn
+// CORTEX_EMBEDDING_MODEL_PATH gesetzt ist, sonst local
+func providerNameFromEnv() string {
+	if name := os.Getenv("CORTEX_EMBEDDING_PROVIDER"); name != "" {
+		return name
+	}
+	if os.Getenv("CORTEX_EMBEDDING_MODEL_PATH") != "" {
+		return "gte"
+	}
+	return "local"
+}
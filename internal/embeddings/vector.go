@@ -1,9 +1,14 @@
 package embeddings
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"os"
+	"strings"
 )
 
 // Vector represents an embedding vector
@@ -24,27 +29,289 @@ func (v *Vector) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// EncodeVector encodes a vector to JSON string for storage
-func EncodeVector(v []float32) (string, error) {
-	data, err := json.Marshal(v)
+// VectorEncoding is the element encoding of a stored (binary) vector.
+type VectorEncoding uint8
+
+const (
+	// VectorJSON marks legacy JSON text vectors ("[0.1,0.2,...]"); only produced by DecodeVectorHeader.
+	VectorJSON    VectorEncoding = 0
+	VectorFloat32 VectorEncoding = 1 // 4 bytes per value, lossless
+	VectorFloat16 VectorEncoding = 2 // 2 bytes per value (IEEE 754 half precision)
+	VectorInt8    VectorEncoding = 3 // 1 byte per value + float32 scale (symmetric quantisation)
+)
+
+// Binary layout (little-endian):
+//
+//	[0:2]  magic "CV"
+//	[2]    format version (1)
+//	[3]    VectorEncoding
+//	[4:8]  uint32 dimension
+//	[8]    uint8 model ID length n
+//	[9:9+n] model ID (UTF-8)
+//	payload: float32 / float16 values, or float32 scale followed by int8 values
+const (
+	vectorMagic0        = 'C'
+	vectorMagic1        = 'V'
+	vectorFormatVersion = 1
+	vectorHeaderSize    = 9
+	maxModelIDLength    = 255
+)
+
+// VectorHeader describes a stored vector.
+type VectorHeader struct {
+	Encoding  VectorEncoding
+	Dimension int
+	ModelID   string
+}
+
+// String returns the configuration name of the encoding (float32, float16, int8, json).
+func (e VectorEncoding) String() string {
+	switch e {
+	case VectorFloat32:
+		return "float32"
+	case VectorFloat16:
+		return "float16"
+	case VectorInt8:
+		return "int8"
+	case VectorJSON:
+		return "json"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(e))
+}
+
+// ParseVectorEncoding parses float32, float16 or int8 (case-insensitive).
+func ParseVectorEncoding(s string) (VectorEncoding, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "float32", "f32":
+		return VectorFloat32, nil
+	case "float16", "f16", "half":
+		return VectorFloat16, nil
+	case "int8", "i8":
+		return VectorInt8, nil
+	}
+	return 0, fmt.Errorf("unknown vector encoding %q (expected float32, float16 or int8)", s)
+}
+
+// StorageEncoding returns the encoding used for new embeddings (CORTEX_EMBEDDING_ENCODING, default float32).
+func StorageEncoding() VectorEncoding {
+	enc, err := ParseVectorEncoding(os.Getenv("CORTEX_EMBEDDING_ENCODING"))
 	if err != nil {
-		return "", fmt.Errorf("failed to encode vector: %w", err)
+		return VectorFloat32
 	}
-	return string(data), nil
+	return enc
+}
+
+// EncodeVector encodes a vector to the compact binary storage format using StorageEncoding().
+func EncodeVector(v []float32) ([]byte, error) {
+	return EncodeVectorAs(v, StorageEncoding(), "")
+}
+
+// EncodeVectorAs encodes a vector with an explicit element encoding and optional model ID.
+func EncodeVectorAs(v []float32, enc VectorEncoding, modelID string) ([]byte, error) {
+	if len(modelID) > maxModelIDLength {
+		return nil, fmt.Errorf("failed to encode vector: model id longer than %d bytes", maxModelIDLength)
+	}
+	payload, ok := payloadSize(enc, len(v))
+	if !ok {
+		return nil, fmt.Errorf("failed to encode vector: unsupported encoding %s", enc)
+	}
+
+	buf := make([]byte, vectorHeaderSize+len(modelID)+payload)
+	buf[0], buf[1], buf[2], buf[3] = vectorMagic0, vectorMagic1, vectorFormatVersion, byte(enc)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(v)))
+	buf[8] = byte(len(modelID))
+	off := vectorHeaderSize + copy(buf[vectorHeaderSize:], modelID)
+
+	switch enc {
+	case VectorFloat32:
+		for _, f := range v {
+			binary.LittleEndian.PutUint32(buf[off:], math.Float32bits(f))
+			off += 4
+		}
+	case VectorFloat16:
+		for _, f := range v {
+			binary.LittleEndian.PutUint16(buf[off:], float32ToFloat16(f))
+			off += 2
+		}
+	case VectorInt8:
+		var maxAbs float32
+		for _, f := range v {
+			if a := float32(math.Abs(float64(f))); a > maxAbs {
+				maxAbs = a
+			}
+		}
+		scale := maxAbs / 127
+		binary.LittleEndian.PutUint32(buf[off:], math.Float32bits(scale))
+		off += 4
+		for _, f := range v {
+			var q float64
+			if scale > 0 {
+				q = math.Round(float64(f / scale))
+			}
+			buf[off] = byte(int8(math.Max(-127, math.Min(127, q))))
+			off++
+		}
+	}
+	return buf, nil
+}
+
+// payloadSize returns the size of the elements of a dim-dimensional vector in encoding enc (int8
+// stores its scale in front of them); ok is false for unknown encodings.
+func payloadSize(enc VectorEncoding, dim int) (size int, ok bool) {
+	switch enc {
+	case VectorFloat32:
+		return 4 * dim, true
+	case VectorFloat16:
+		return 2 * dim, true
+	case VectorInt8:
+		return 4 + dim, true
+	}
+	return 0, false
+}
+
+// isBinaryVector reports whether data starts with the binary vector magic.
+func isBinaryVector(data []byte) bool {
+	return len(data) >= vectorHeaderSize && data[0] == vectorMagic0 && data[1] == vectorMagic1
 }
 
-// DecodeVector decodes a vector from JSON string
-func DecodeVector(s string) ([]float32, error) {
-	if s == "" {
+// DecodeVectorHeader returns encoding, dimension and model ID of a stored vector.
+// Legacy JSON vectors are reported with Encoding VectorJSON.
+func DecodeVectorHeader(data []byte) (VectorHeader, error) {
+	if len(data) == 0 {
+		return VectorHeader{}, nil
+	}
+	if !isBinaryVector(data) {
+		v, err := decodeJSONVector(data)
+		if err != nil {
+			return VectorHeader{}, err
+		}
+		return VectorHeader{Encoding: VectorJSON, Dimension: len(v)}, nil
+	}
+	if data[2] != vectorFormatVersion {
+		return VectorHeader{}, fmt.Errorf("failed to decode vector: unsupported format version %d", data[2])
+	}
+	n := int(data[8])
+	if len(data) < vectorHeaderSize+n {
+		return VectorHeader{}, errors.New("failed to decode vector: truncated header")
+	}
+	return VectorHeader{
+		Encoding:  VectorEncoding(data[3]),
+		Dimension: int(binary.LittleEndian.Uint32(data[4:8])),
+		ModelID:   string(data[vectorHeaderSize : vectorHeaderSize+n]),
+	}, nil
+}
+
+// DecodeVector decodes a stored vector. Accepts the binary format as well as legacy JSON text
+// (as written by older versions and found in old exports/backups).
+func DecodeVector(data []byte) ([]float32, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if !isBinaryVector(data) {
+		return decodeJSONVector(data)
+	}
+	h, err := DecodeVectorHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	payload := data[vectorHeaderSize+len(h.ModelID):]
+	// Erst die Größe prüfen, dann allokieren: die Dimension im Header ist nicht vertrauenswürdig
+	size, ok := payloadSize(h.Encoding, h.Dimension)
+	if !ok {
+		return nil, fmt.Errorf("failed to decode vector: unsupported encoding %s", h.Encoding)
+	}
+	if len(payload) != size {
+		return nil, errors.New("failed to decode vector: payload size mismatch")
+	}
+	v := make([]float32, h.Dimension)
+	switch h.Encoding {
+	case VectorFloat32:
+		for i := range v {
+			v[i] = math.Float32frombits(binary.LittleEndian.Uint32(payload[4*i:]))
+		}
+	case VectorFloat16:
+		for i := range v {
+			v[i] = float16ToFloat32(binary.LittleEndian.Uint16(payload[2*i:]))
+		}
+	case VectorInt8:
+		scale := math.Float32frombits(binary.LittleEndian.Uint32(payload))
+		for i := range v {
+			v[i] = float32(int8(payload[4+i])) * scale
+		}
+	}
+	return v, nil
+}
+
+func decodeJSONVector(data []byte) ([]float32, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
 		return nil, nil
 	}
 	var v []float32
-	if err := json.Unmarshal([]byte(s), &v); err != nil {
+	if err := json.Unmarshal(data, &v); err != nil {
 		return nil, fmt.Errorf("failed to decode vector: %w", err)
 	}
 	return v, nil
 }
 
+// float32ToFloat16 converts to IEEE 754 half precision (round to nearest even).
+func float32ToFloat16(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int32(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case b&0x7fffffff == 0:
+		return sign
+	case exp >= 0x1f: // overflow, Inf or NaN
+		if b&0x7f800000 == 0x7f800000 && mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp <= 0: // subnormal or underflow
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		half := uint16(mant >> shift)
+		if rem := mant & (1<<shift - 1); rem > 1<<(shift-1) || (rem == 1<<(shift-1) && half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+	half := sign | uint16(exp)<<10 | uint16(mant>>13)
+	if rem := mant & 0x1fff; rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++ // may carry into the exponent, which is the correct rounding
+	}
+	return half
+}
+
+// float16ToFloat32 converts IEEE 754 half precision to float32.
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// subnormal: normalise
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		mant &= 0x3ff
+		return math.Float32frombits(sign | e<<23 | mant<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
 // Normalize normalizes a vector to unit length
 func Normalize(v []float32) []float32 {
 	var sum float64
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+// DefaultRetention is how long events stay in the log (and can be resumed via Last-Event-ID).
+const DefaultRetention = 7 * 24 * time.Hour
+
+// subscriptionBuffer is the number of events buffered per subscriber. A subscriber that falls further
+// behind is disconnected and has to resume with Last-Event-ID.
+const subscriptionBuffer = 256
+
+// Payload is the JSON stored per event and sent to webhooks and stream subscribers.
+type Payload struct {
+	Event     string         `json:"event"`
+	Timestamp string         `json:"timestamp"`
+	Data      map[string]any `json:"data"`
+}
+
+// Match reports whether a subscription pattern selects eventType: the exact type, "*" (all events)
+// or "<prefix>.*" (e.g. memory.* for all memory events).
+func Match(pattern, eventType string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, ".*"):
+		return strings.HasPrefix(eventType, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == eventType
+	}
+}
+
+// MatchAny reports whether one of the patterns selects eventType; no patterns select everything.
+func MatchAny(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if Match(p, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the live events of one tenant. C is closed when the subscriber is too slow
+// or unsubscribed.
+type Subscription struct {
+	AppID          string
+	ExternalUserID string
+	Types          []string
+	C              chan models.Event
+}
+
+// Bus records events in the persisted event log and fans them out to live subscribers (SSE streams).
+type Bus struct {
+	store     *store.CortexStore
+	retention time.Duration
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus creates an event bus on the store. retention <= 0 keeps events forever.
+func NewBus(s *store.CortexStore, retention time.Duration) *Bus {
+	return &Bus{store: s, retention: retention, subs: make(map[*Subscription]struct{})}
+}
+
+// RetentionFromEnv returns CORTEX_EVENT_RETENTION (duration, 0 = keep forever), default DefaultRetention.
+func RetentionFromEnv() time.Duration {
+	v := os.Getenv("CORTEX_EVENT_RETENTION")
+	if v == "" {
+		return DefaultRetention
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Warn("invalid CORTEX_EVENT_RETENTION, using default", "value", v, "default", DefaultRetention)
+		return DefaultRetention
+	}
+	return d
+}
+
+// Publish appends an event to the log and delivers it to matching subscribers. Publishing is
+// serialised, so subscribers see events in sequence order.
+func (b *Bus) Publish(eventType, appID, externalUserID string, data map[string]any) (*models.Event, error) {
+	now := time.Now()
+	payloadJSON, err := json.Marshal(Payload{
+		Event:     eventType,
+		Timestamp: now.UTC().Format(time.RFC3339),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event payload: %w", err)
+	}
+	ev := &models.Event{
+		Type:           eventType,
+		AppID:          appID,
+		ExternalUserID: externalUserID,
+		Payload:        string(payloadJSON),
+		CreatedAt:      now,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.store.AppendEvent(ev); err != nil {
+		return nil, err
+	}
+	for sub := range b.subs {
+		if sub.AppID != appID || sub.ExternalUserID != externalUserID || !MatchAny(sub.Types, eventType) {
+			continue
+		}
+		select {
+		case sub.C <- *ev:
+		default:
+			// Zu langsamer Subscriber: trennen, Client setzt per Last-Event-ID fort
+			slog.Warn("event stream subscriber too slow, disconnecting", "appId", appID, "userId", externalUserID)
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+	return ev, nil
+}
+
+// Subscribe registers a live subscriber for the tenant and event type patterns (empty = all).
+func (b *Bus) Subscribe(appID, externalUserID string, types []string) *Subscription {
+	sub := &Subscription{
+		AppID:          appID,
+		ExternalUserID: externalUserID,
+		Types:          types,
+		C:              make(chan models.Event, subscriptionBuffer),
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel (no-op if already removed).
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
+
+// Since returns logged events of the tenant after afterID (resume), in sequence order.
+func (b *Bus) Since(appID, externalUserID string, types []string, afterID int64, limit int) ([]models.Event, error) {
+	return b.store.ListEventsSince(appID, externalUserID, afterID, types, limit)
+}
+
+// Run prunes events older than the retention every hour until ctx is done.
+func (b *Bus) Run(ctx context.Context) {
+	if b.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := b.store.PruneEvents(time.Now().Add(-b.retention)); err != nil {
+			slog.Error("event log: prune failed", "error", err)
+		} else if n > 0 {
+			slog.Info("event log: pruned old events", "count", n, "retention", b.retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+func setupBus(t *testing.T) (*Bus, *store.CortexStore) {
+	t.Helper()
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCortexStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return NewBus(s, DefaultRetention), s
+}
+
+func receive(t *testing.T, sub *Subscription) models.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return models.Event{}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, event string
+		want           bool
+	}{
+		{"*", "bundle.created", true},
+		{"memory.*", "memory.updated", true},
+		{"memory.*", "bundle.created", false},
+		{"memory.created", "memory.created", true},
+		{"memory.created", "memory.deleted", false},
+		{"memory", "memory.created", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.event); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.event, got, c.want)
+		}
+	}
+	if !MatchAny(nil, "memory.created") {
+		t.Error("no patterns must match every event")
+	}
+	if MatchAny([]string{"bundle.*", "memory.deleted"}, "memory.created") {
+		t.Error("MatchAny matched an unselected event")
+	}
+}
+
+func TestPublishFiltersByTenantAndType(t *testing.T) {
+	bus, _ := setupBus(t)
+	sub := bus.Subscribe("app", "user1", []string{"memory.*"})
+	defer bus.Unsubscribe(sub)
+
+	bus.Publish("memory.created", "app", "user2", map[string]any{"id": 1})
+	bus.Publish("bundle.created", "app", "user1", map[string]any{"id": 2})
+	published, err := bus.Publish("memory.updated", "app", "user1", map[string]any{"id": 3})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	ev := receive(t, sub)
+	if ev.ID != published.ID || ev.Type != "memory.updated" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	var payload Payload
+	if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	if payload.Event != "memory.updated" || payload.Data["id"] != float64(3) || payload.Timestamp == "" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	select {
+	case ev := <-sub.C:
+		t.Fatalf("received event of another tenant or type: %+v", ev)
+	default:
+	}
+}
+
+func TestSinceResumesAfterID(t *testing.T) {
+	bus, _ := setupBus(t)
+	var ids []int64
+	for _, typ := range []string{"memory.created", "bundle.created", "memory.deleted", "memory.created"} {
+		ev, err := bus.Publish(typ, "app", "user1", nil)
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		ids = append(ids, ev.ID)
+	}
+	bus.Publish("memory.created", "other", "user1", nil)
+
+	all, err := bus.Since("app", "user1", nil, ids[0], 100)
+	if err != nil {
+		t.Fatalf("Since: %v", err)
+	}
+	if len(all) != 3 || all[0].ID != ids[1] || all[2].ID != ids[3] {
+		t.Fatalf("expected events after %d in order, got %+v", ids[0], all)
+	}
+
+	memory, _ := bus.Since("app", "user1", []string{"memory.*"}, 0, 100)
+	if len(memory) != 3 {
+		t.Fatalf("expected 3 memory events, got %d", len(memory))
+	}
+	exact, _ := bus.Since("app", "user1", []string{"memory.deleted", "bundle.created"}, 0, 100)
+	if len(exact) != 2 {
+		t.Fatalf("expected 2 events for exact types, got %d", len(exact))
+	}
+	page, _ := bus.Since("app", "user1", []string{"*"}, 0, 2)
+	if len(page) != 2 || page[1].ID != ids[1] {
+		t.Fatalf("expected first page of 2, got %+v", page)
+	}
+}
+
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	bus, _ := setupBus(t)
+	sub := bus.Subscribe("app", "user1", nil)
+	for i := 0; i <= subscriptionBuffer; i++ {
+		if _, err := bus.Publish("memory.created", "app", "user1", nil); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriptionBuffer {
+		t.Fatalf("expected %d buffered events before disconnect, got %d", subscriptionBuffer, n)
+	}
+	// Unsubscribe nach Trennung ist ein No-op
+	bus.Unsubscribe(sub)
+}
+
+func TestPruneEvents(t *testing.T) {
+	bus, s := setupBus(t)
+	bus.Publish("memory.created", "app", "user1", nil)
+	n, err := s.PruneEvents(time.Now().Add(time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("PruneEvents = %d, %v", n, err)
+	}
+	if left, _ := bus.Since("app", "user1", nil, 0, 10); len(left) != 0 {
+		t.Fatalf("expected empty log, got %d events", len(left))
+	}
+}
@@ -0,0 +1,131 @@
+// Package extraction finds entity mentions in memory content. Extractors are pluggable (RegisterExtractor);
+// the pipeline is configured via CORTEX_ENTITY_EXTRACTORS.
+package extraction
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mention is an entity found in a text. Name is the canonical entity name (e.g. "user:alice").
+type Mention struct {
+	Name   string `json:"name"`
+	Source string `json:"source"` // extractor that found the mention
+}
+
+// Extractor finds entity mentions in content. known contains the entity names that already exist in the
+// tenant, so extractors can link to them (dictionary matching).
+type Extractor interface {
+	Name() string
+	Extract(content string, known []string) []Mention
+}
+
+// ExtractorFactory erstellt einen Extractor aus der Umgebung
+type ExtractorFactory func() (Extractor, error)
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]ExtractorFactory{}
+)
+
+// Eingebauter Extractor: rules (Präfix-Namen, @Mentions, bekannte Entities, optionales Wörterbuch)
+func init() {
+	RegisterExtractor("rules", func() (Extractor, error) {
+		return NewRuleExtractorFromEnv()
+	})
+}
+
+// RegisterExtractor registriert einen Extractor unter name (überschreibt vorhandene)
+func RegisterExtractor(name string, factory ExtractorFactory) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[strings.ToLower(name)] = factory
+}
+
+// Extractors liefert die Namen aller registrierten Extractors (sortiert)
+func Extractors() []string {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	names := make([]string, 0, len(extractors))
+	for name := range extractors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pipeline runs several extractors and merges their mentions.
+type Pipeline struct {
+	extractors []Extractor
+}
+
+// NewPipelineOf builds a pipeline from extractor instances (in order).
+func NewPipelineOf(extractors ...Extractor) *Pipeline {
+	return &Pipeline{extractors: extractors}
+}
+
+// NewPipeline builds a pipeline from registered extractor names (in order).
+func NewPipeline(names ...string) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		extractorsMu.RLock()
+		factory, ok := extractors[name]
+		extractorsMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown entity extractor %q (available: %s)", name, strings.Join(Extractors(), ", "))
+		}
+		ex, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("entity extractor %q: %w", name, err)
+		}
+		p.extractors = append(p.extractors, ex)
+	}
+	return p, nil
+}
+
+// PipelineFromEnv builds the pipeline from CORTEX_ENTITY_EXTRACTORS (comma-separated, default "rules";
+// "none" disables extraction).
+func PipelineFromEnv() (*Pipeline, error) {
+	names := os.Getenv("CORTEX_ENTITY_EXTRACTORS")
+	switch strings.ToLower(strings.TrimSpace(names)) {
+	case "":
+		names = "rules"
+	case "none", "off":
+		return &Pipeline{}, nil
+	}
+	return NewPipeline(strings.Split(names, ",")...)
+}
+
+// Enabled reports whether the pipeline has at least one extractor.
+func (p *Pipeline) Enabled() bool {
+	return p != nil && len(p.extractors) > 0
+}
+
+// Extract runs all extractors; the result has one mention per entity name (first extractor wins).
+func (p *Pipeline) Extract(content string, known []string) []Mention {
+	if !p.Enabled() || strings.TrimSpace(content) == "" {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var mentions []Mention
+	for _, ex := range p.extractors {
+		for _, m := range ex.Extract(content, known) {
+			if m.Name == "" || seen[m.Name] {
+				continue
+			}
+			seen[m.Name] = true
+			if m.Source == "" {
+				m.Source = ex.Name()
+			}
+			mentions = append(mentions, m)
+		}
+	}
+	return mentions
+}
@@ -0,0 +1,88 @@
+package extraction
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mentionNames(mentions []Mention) []string {
+	names := make([]string, len(mentions))
+	for i, m := range mentions {
+		names[i] = m.Name
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRuleExtractorPatterns(t *testing.T) {
+	ex := NewRuleExtractor(nil)
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"user:alice arbeitet an project:cortex-v2.", []string{"user:alice", "project:cortex-v2"}},
+		{"Meeting mit @bob und @carol.m", []string{"user:bob", "user:carol.m"}},
+		{"Siehe https://example.com:8080/x und mailto:a@b.de", nil},
+		{"Schreib an alice@example.com um 10:30", nil},
+		{"user:alice und nochmal user:alice", []string{"user:alice"}},
+	}
+	for _, tt := range tests {
+		got := mentionNames(ex.Extract(tt.content, nil))
+		if !equalNames(got, tt.want) {
+			t.Errorf("Extract(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRuleExtractorKnownEntities(t *testing.T) {
+	ex := NewRuleExtractor(map[string]string{"Kaffeemaschine": "device:coffee-machine"})
+	known := []string{"user:alice", "project:cortex", "team:alice", "Berlin"}
+
+	got := mentionNames(ex.Extract("Cortex läuft in berlin; die Kaffeemaschine ist kaputt. Alice?", known))
+	// "alice" ist mehrdeutig (user:alice, team:alice) und wird daher nicht verlinkt
+	want := []string{"project:cortex", "Berlin", "device:coffee-machine"}
+	if !equalNames(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := mentionNames(ex.Extract("Cortexual ist kein Treffer", known)); len(got) != 0 {
+		t.Errorf("expected no partial word match, got %v", got)
+	}
+}
+
+func TestPipelineFromEnv(t *testing.T) {
+	dict := filepath.Join(t.TempDir(), "dict.txt")
+	os.WriteFile(dict, []byte("# Aliase\nKollegin = user:anna\nproject:atlas\n"), 0o644)
+	t.Setenv("CORTEX_ENTITY_DICTIONARY", dict)
+	t.Setenv("CORTEX_ENTITY_EXTRACTORS", "")
+
+	p, err := PipelineFromEnv()
+	if err != nil {
+		t.Fatalf("PipelineFromEnv failed: %v", err)
+	}
+	got := mentionNames(p.Extract("Die Kollegin arbeitet an project:atlas mit @tom", nil))
+	want := []string{"user:anna", "project:atlas", "user:tom"}
+	if !equalNames(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	t.Setenv("CORTEX_ENTITY_EXTRACTORS", "none")
+	if p, _ := PipelineFromEnv(); p.Enabled() || p.Extract("user:alice", nil) != nil {
+		t.Error("expected disabled pipeline for none")
+	}
+	t.Setenv("CORTEX_ENTITY_EXTRACTORS", "rules,unknown")
+	if _, err := PipelineFromEnv(); err == nil {
+		t.Error("expected error for unknown extractor")
+	}
+}
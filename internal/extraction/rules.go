@@ -0,0 +1,175 @@
+package extraction
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// typ:name, z.B. user:alice oder project:cortex-v2 (Namenskonvention der Entities)
+	prefixedNamePattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_/@.:-])([a-z][a-z0-9_]{1,31}):([\p{L}\p{N}](?:[\p{L}\p{N}_.\-]*[\p{L}\p{N}])?)`)
+	// @alice -> user:alice (E-Mail-Adressen ausgenommen)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])@([\p{L}\p{N}](?:[\p{L}\p{N}_.\-]*[\p{L}\p{N}])?)`)
+)
+
+// ignoredPrefixes sind URL-Schemes und ähnliches, die wie typ:name aussehen
+var ignoredPrefixes = map[string]bool{"http": true, "https": true, "ftp": true, "mailto": true, "file": true, "data": true, "urn": true}
+
+// minAliasLength ist die Mindestlänge (Runen) für Wörterbuch-Treffer ohne Präfix
+const minAliasLength = 3
+
+// RuleExtractor runs offline: it finds prefixed names (user:alice), @mentions (user:alice) and
+// case-insensitive whole-word occurrences of known entity names and dictionary aliases. For a known
+// prefixed entity like user:alice, the bare name "alice" is matched too if it is unambiguous.
+type RuleExtractor struct {
+	aliases map[string]string // lower-case alias -> entity name
+}
+
+// NewRuleExtractor creates a rule extractor with an optional alias dictionary (alias -> entity name).
+func NewRuleExtractor(dictionary map[string]string) *RuleExtractor {
+	aliases := make(map[string]string, len(dictionary))
+	for alias, name := range dictionary {
+		if alias = strings.ToLower(strings.TrimSpace(alias)); alias != "" && name != "" {
+			aliases[alias] = name
+		}
+	}
+	return &RuleExtractor{aliases: aliases}
+}
+
+// NewRuleExtractorFromEnv loads the dictionary from CORTEX_ENTITY_DICTIONARY (optional).
+// Format: one entry per line, "alias = entity" or just "entity"; lines starting with # are ignored.
+func NewRuleExtractorFromEnv() (*RuleExtractor, error) {
+	path := os.Getenv("CORTEX_ENTITY_DICTIONARY")
+	if path == "" {
+		return NewRuleExtractor(nil), nil
+	}
+	dictionary, err := LoadDictionary(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRuleExtractor(dictionary), nil
+}
+
+// LoadDictionary reads an alias dictionary file (see NewRuleExtractorFromEnv).
+func LoadDictionary(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dictionary := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		alias, name, found := strings.Cut(entry, "=")
+		alias, name = strings.TrimSpace(alias), strings.TrimSpace(name)
+		if !found {
+			name = alias
+		}
+		if alias == "" || name == "" {
+			return nil, fmt.Errorf("%s:%d: invalid dictionary entry %q", path, line, entry)
+		}
+		dictionary[alias] = name
+	}
+	return dictionary, scanner.Err()
+}
+
+// Name implements Extractor.
+func (e *RuleExtractor) Name() string { return "rules" }
+
+// Extract implements Extractor. Mentions are returned in order of their first occurrence.
+func (e *RuleExtractor) Extract(content string, known []string) []Mention {
+	first := make(map[string]int)
+	add := func(name string, pos int) {
+		if p, ok := first[name]; !ok || pos < p {
+			first[name] = pos
+		}
+	}
+
+	for _, m := range prefixedNamePattern.FindAllStringSubmatchIndex(content, -1) {
+		prefix := content[m[2]:m[3]]
+		if ignoredPrefixes[prefix] {
+			continue
+		}
+		add(prefix+":"+content[m[4]:m[5]], m[2])
+	}
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		add("user:"+content[m[2]:m[3]], m[2]-1)
+	}
+
+	lower := strings.ToLower(content)
+	for alias, name := range e.dictionary(known) {
+		if pos := indexWord(lower, alias); pos >= 0 {
+			add(name, pos)
+		}
+	}
+
+	mentions := make([]Mention, 0, len(first))
+	for name := range first {
+		mentions = append(mentions, Mention{Name: name, Source: e.Name()})
+	}
+	sort.Slice(mentions, func(i, j int) bool {
+		pi, pj := first[mentions[i].Name], first[mentions[j].Name]
+		if pi != pj {
+			return pi < pj
+		}
+		return mentions[i].Name < mentions[j].Name
+	})
+	return mentions
+}
+
+// dictionary merges the configured aliases with the known entity names of the tenant.
+func (e *RuleExtractor) dictionary(known []string) map[string]string {
+	aliases := make(map[string]string, len(e.aliases)+2*len(known))
+	bare := make(map[string][]string)
+	for _, name := range known {
+		if utf8.RuneCountInString(name) >= minAliasLength {
+			aliases[strings.ToLower(name)] = name
+		}
+		if _, suffix, ok := strings.Cut(name, ":"); ok && utf8.RuneCountInString(suffix) >= minAliasLength {
+			bare[strings.ToLower(suffix)] = append(bare[strings.ToLower(suffix)], name)
+		}
+	}
+	for suffix, names := range bare {
+		if _, taken := aliases[suffix]; !taken && len(names) == 1 {
+			aliases[suffix] = names[0]
+		}
+	}
+	for alias, name := range e.aliases {
+		aliases[alias] = name
+	}
+	return aliases
+}
+
+// indexWord returns the byte offset of the first occurrence of word in text that is not part of a longer
+// word, or -1.
+func indexWord(text, word string) int {
+	for offset := 0; offset < len(text); {
+		i := strings.Index(text[offset:], word)
+		if i < 0 {
+			return -1
+		}
+		start, end := offset+i, offset+i+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return start
+		}
+		offset = start + 1
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
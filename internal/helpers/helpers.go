@@ -1,30 +1,41 @@
 package helpers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 // Constants
 const (
-	DefaultPort          = "9123"
-	DefaultDBName        = "cortex.db"
-	DefaultMemType       = "semantic"
-	DefaultImportance    = 5
-	DefaultLimit         = 10
-	MaxLimit             = 100
-	DefaultQueryLimit    = 5   // Default limit for query operations
-	DefaultAnalyticsDays = 30  // Default days for analytics queries
-	DefaultSimilarity    = 0.5 // Default similarity score
-	TextMatchSimilarity  = 0.8 // Similarity score for text matches
+	DefaultPort           = "9123"
+	DefaultDBName         = "cortex.db"
+	DefaultMemType        = "semantic"
+	DefaultImportance     = 5
+	DefaultLimit          = 10
+	MaxLimit              = 100
+	DefaultQueryLimit     = 5    // Default limit for query operations
+	DefaultAnalyticsDays  = 30   // Default days for analytics queries
+	DefaultSimilarity     = 0.5  // Default similarity score
+	DefaultEntityMemories = 20   // Default number of linked memories in entity lookups
+	DefaultChangesLimit   = 100  // Default page size of the change feed
+	MaxChangesLimit       = 1000 // Maximum page size of the change feed
+	DefaultAuditLimit     = 100  // Default page size of the audit log
+	MaxAuditLimit         = 1000 // Maximum page size of the audit log
+	TextMatchSimilarity   = 0.8  // Similarity score for text matches
 )
 
 // JSON Helpers
@@ -64,6 +75,19 @@ func ParseLimit(limitStr string, defaultLimit, maxLimit int) int {
 	return limit
 }
 
+// ParseTimeParam parses an optional RFC3339 query parameter. Returns nil if the parameter is absent.
+func ParseTimeParam(r *http.Request, name string) (*time.Time, error) {
+	raw := GetQueryParam(r, name)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, &ValidationError{Field: name, Message: "invalid RFC3339 timestamp"}
+	}
+	return &t, nil
+}
+
 // TenantParamExtractor interface für Request-Types mit Tenant-Parametern
 type TenantParamExtractor interface {
 	GetAppID() string
@@ -183,6 +207,7 @@ func ValidateWebhookURL(raw string) error {
 }
 
 // ValidateTenantParams validates tenant parameters and writes error response if invalid
+// (400 if missing, 403 if the principal of the request may not access the tenant)
 // Returns true if valid, false otherwise (error already written)
 func ValidateTenantParams(w http.ResponseWriter, r *http.Request, req TenantParamExtractor, isQueryParam bool) (appID, externalUserID string, ok bool) {
 	appID, externalUserID = ExtractTenantParams(r, req)
@@ -200,6 +225,9 @@ func ValidateTenantParams(w http.ResponseWriter, r *http.Request, req TenantPara
 		http.Error(w, msg, http.StatusBadRequest)
 		return "", "", false
 	}
+	if !AuthorizeTenant(w, r, appID, externalUserID) {
+		return "", "", false
+	}
 
 	return appID, externalUserID, true
 }
@@ -225,6 +253,9 @@ func ValidateTenantParamsWithFields(w http.ResponseWriter, r *http.Request, req
 		http.Error(w, msg, http.StatusBadRequest)
 		return "", "", false
 	}
+	if !AuthorizeTenant(w, r, appID, externalUserID) {
+		return "", "", false
+	}
 
 	return appID, externalUserID, true
 }
@@ -289,6 +320,12 @@ func UnmarshalEntityData(dataJSON string) map[string]any {
 	return data
 }
 
+// ContentHash returns the hex-encoded SHA-256 of a memory content (exact-duplicate detection).
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
 // SanitizeFilenameForHeader removes characters that could break HTTP header values (e.g. Content-Disposition filename).
 // Removes double-quote, backslash, newline, carriage return.
 func SanitizeFilenameForHeader(s string) string {
@@ -343,6 +380,61 @@ func GetQueryParam(r *http.Request, key string) string {
 	return strings.TrimSpace(r.URL.Query().Get(key))
 }
 
+// ClientIP returns the client address of a request: the host of RemoteAddr. X-Forwarded-For is
+// only honoured if RemoteAddr is a proxy listed in CORTEX_TRUSTED_PROXIES (comma-separated IPs or
+// CIDRs); then the client is the rightmost entry that is not a trusted proxy itself, since every
+// proxy appends the address it received the request from and entries left of it are client-supplied.
+func ClientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+	trusted := trustedProxies()
+	if !isTrustedProxy(trusted, host) {
+		return host
+	}
+	entries := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(entries[i])
+		if ip == "" {
+			continue
+		}
+		if !isTrustedProxy(trusted, ip) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+// trustedProxies parses CORTEX_TRUSTED_PROXIES; invalid entries are ignored.
+func trustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("CORTEX_TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if p, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	return prefixes
+}
+
+func isTrustedProxy(trusted []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 func ExtractPathID(path, prefix string) (string, error) {
 	idStr := strings.TrimPrefix(path, prefix)
 	if idStr == "" || idStr == path {
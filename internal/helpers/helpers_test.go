@@ -177,6 +177,15 @@ func TestUnmarshalMetadataNull(t *testing.T) {
 	}
 }
 
+func TestContentHash(t *testing.T) {
+	if got := ContentHash("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("ContentHash(abc) = %s", got)
+	}
+	if ContentHash("a") == ContentHash("a ") {
+		t.Error("different content must hash differently")
+	}
+}
+
 func TestValidateWebhookURL(t *testing.T) {
 	ok := []string{"https://example.com/hook", "http://localhost:8080/callback"}
 	for _, u := range ok {
@@ -264,6 +273,46 @@ func TestGetQueryParam(t *testing.T) {
 	}
 }
 
+func TestClientIP(t *testing.T) {
+	request := func(remote string, forwarded ...string) *http.Request {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = remote
+		for _, f := range forwarded {
+			req.Header.Add("X-Forwarded-For", f)
+		}
+		return req
+	}
+
+	// Ohne vertrauenswürdige Proxys zählt nur die Verbindungsadresse
+	if got := ClientIP(request("198.51.100.7:4711", "203.0.113.9")); got != "198.51.100.7" {
+		t.Errorf("untrusted client: got %q", got)
+	}
+
+	t.Setenv("CORTEX_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1, ::1, invalid")
+	if got := ClientIP(request("198.51.100.7:4711", "203.0.113.9")); got != "198.51.100.7" {
+		t.Errorf("client not in trusted proxies must not set its address: got %q", got)
+	}
+	cases := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1:80", []string{"203.0.113.9"}, "203.0.113.9"},
+		// Vom Client gesetzte Einträge links vom Proxy-Eintrag zählen nicht
+		{"192.0.2.1:80", []string{"1.2.3.4, 203.0.113.9"}, "203.0.113.9"},
+		// Proxy-Kette: von rechts der erste nicht vertrauenswürdige Eintrag, auch über mehrere Header
+		{"10.1.2.3:80", []string{"1.2.3.4, 203.0.113.9", "192.0.2.1"}, "203.0.113.9"},
+		{"[::1]:80", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"192.0.2.1:80", nil, "192.0.2.1"},
+		{"192.0.2.1:80", []string{"10.0.0.5"}, "10.0.0.5"},
+	}
+	for _, tc := range cases {
+		if got := ClientIP(request(tc.remote, tc.forwarded...)); got != tc.want {
+			t.Errorf("ClientIP(%s, %q) = %q, expected %q", tc.remote, tc.forwarded, got, tc.want)
+		}
+	}
+}
+
 func TestWriteJSON(t *testing.T) {
 	w := httptest.NewRecorder()
 	data := map[string]string{"status": "ok"}
@@ -283,3 +332,32 @@ func TestWriteJSON(t *testing.T) {
 		t.Error("response body does not contain expected data")
 	}
 }
+
+func TestPrincipalAllowsTenant(t *testing.T) {
+	var none *Principal
+	all := &Principal{Scopes: []string{"read"}}
+	app := &Principal{AppIDs: []string{"app1", "app2"}}
+	user := &Principal{AppIDs: []string{"app1"}, ExternalUserID: "alice"}
+	tests := []struct {
+		name          string
+		p             *Principal
+		appID, userID string
+		expected      bool
+	}{
+		{"no auth", none, "", "", true},
+		{"unrestricted all tenants", all, "", "", true},
+		{"app key own app", app, "app2", "bob", true},
+		{"app key other app", app, "app3", "bob", false},
+		{"app key all tenants", app, "", "", false},
+		{"user key own tenant", user, "app1", "alice", true},
+		{"user key other user", user, "app1", "bob", false},
+		{"user key whole app", user, "app1", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.AllowsTenant(tt.appID, tt.userID); got != tt.expected {
+				t.Errorf("AllowsTenant(%q, %q) = %v, expected %v", tt.appID, tt.userID, got, tt.expected)
+			}
+		})
+	}
+}
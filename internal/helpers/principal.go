@@ -0,0 +1,102 @@
+package helpers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// Principal is the authenticated caller of a request (set by middleware.AuthMiddleware). Handlers
+// check every tenant they access against it, so a key cannot reach other tenants by sending
+// another appId/externalUserId.
+type Principal struct {
+	Name           string   // key name (or subject) for logs
+	KeyID          int64    // ID of the stored API key, 0 for CORTEX_API_KEY
+	AppIDs         []string // allowed apps, empty = all apps
+	ExternalUserID string   // bound user, empty = all users of the allowed apps
+	Scopes         []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of a request, nil without authentication (local/dev mode).
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+type principalObserverKey struct{}
+
+// WithPrincipalObserver returns ctx carrying f, which AuthMiddleware calls with the principal as soon
+// as it has authenticated the request, before it checks scopes and apps. Wrappers outside the
+// middleware (the audit log) learn the actor of requests it denies this way.
+func WithPrincipalObserver(ctx context.Context, f func(*Principal)) context.Context {
+	return context.WithValue(ctx, principalObserverKey{}, f)
+}
+
+// ObservePrincipal passes p to the observer of ctx, if any.
+func ObservePrincipal(ctx context.Context, p *Principal) {
+	if f, ok := ctx.Value(principalObserverKey{}).(func(*Principal)); ok {
+		f(p)
+	}
+}
+
+// HasScope reports whether p grants scope (a nil principal grants everything).
+func (p *Principal) HasScope(scope string) bool {
+	return p == nil || slices.Contains(p.Scopes, scope)
+}
+
+// Restricted reports whether p is limited to some apps or a user.
+func (p *Principal) Restricted() bool {
+	return p != nil && (len(p.AppIDs) > 0 || p.ExternalUserID != "")
+}
+
+// AllowsApp reports whether p may access appID (for some or all of its users).
+func (p *Principal) AllowsApp(appID string) bool {
+	return p == nil || len(p.AppIDs) == 0 || slices.Contains(p.AppIDs, appID)
+}
+
+// AllowsTenant reports whether p may access the tenant. An empty appID (all tenants, records
+// without tenant) or externalUserID (all users of the app) is only allowed if p is not limited to it.
+func (p *Principal) AllowsTenant(appID, externalUserID string) bool {
+	if !p.Restricted() {
+		return true
+	}
+	if appID == "" || !p.AllowsApp(appID) {
+		return false
+	}
+	return p.ExternalUserID == "" || p.ExternalUserID == externalUserID
+}
+
+// TenantQuota takes a token from the rate limit quota of an app (set by middleware.AuthMiddleware). It
+// writes 429 and returns false if the quota is exhausted.
+type TenantQuota func(w http.ResponseWriter, r *http.Request, appID string) bool
+
+type tenantQuotaKey struct{}
+
+// WithTenantQuota returns ctx carrying q.
+func WithTenantQuota(ctx context.Context, q TenantQuota) context.Context {
+	return context.WithValue(ctx, tenantQuotaKey{}, q)
+}
+
+// AuthorizeTenant checks the tenant against the principal of the request and writes 403 if it is not
+// allowed. An allowed app is charged against its rate limit quota (see TenantQuota), so tenants sent
+// in the request body count like an appId parameter. Returns true if the request may proceed.
+func AuthorizeTenant(w http.ResponseWriter, r *http.Request, appID, externalUserID string) bool {
+	p := PrincipalFromContext(r.Context())
+	if !p.AllowsTenant(appID, externalUserID) {
+		slog.Warn("forbidden tenant", "path", r.URL.Path, "principal", p.Name, "appId", appID, "userId", externalUserID)
+		http.Error(w, "forbidden: tenant not allowed for this key", http.StatusForbidden)
+		return false
+	}
+	if q, ok := r.Context().Value(tenantQuotaKey{}).(TenantQuota); ok && appID != "" {
+		return q(w, r, appID)
+	}
+	return true
+}
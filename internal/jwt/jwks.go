@@ -0,0 +1,219 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefetchInterval limits how often an unknown kid triggers a reload of a JWKS URL.
+const minRefetchInterval = time.Minute
+
+// maxJWKSSize limits the size of a JWKS document.
+const maxJWKSSize = 1 << 20
+
+// jwk is a key of a JWKS document (RFC 7517); only the fields for RSA, EC P-256 and Ed25519.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a parsed verification key with the algorithm it is used with.
+type publicKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// parseJWKS parses a JWKS document. Keys of other types or for encryption are skipped.
+func parseJWKS(data []byte) ([]publicKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	var keys []publicKey
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.parse()
+		if err != nil {
+			slog.Warn("skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, pk)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys (RS256, ES256, EdDSA)")
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (publicKey, error) {
+	pk := publicKey{kid: k.Kid}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return pk, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return pk, errors.New("invalid exponent")
+		}
+		if n.BitLen() < 2048 {
+			return pk, fmt.Errorf("RSA key too short (%d bits)", n.BitLen())
+		}
+		pk.alg, pk.key = RS256, &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if k.Crv != "P-256" {
+			return pk, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return pk, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return pk, fmt.Errorf("y: %w", err)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return pk, errors.New("point is not on P-256")
+		}
+		pk.alg, pk.key = ES256, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return pk, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return pk, errors.New("invalid Ed25519 key")
+		}
+		pk.alg, pk.key = EdDSA, ed25519.PublicKey(x)
+	default:
+		return pk, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	if k.Alg != "" && k.Alg != pk.alg {
+		return pk, fmt.Errorf("alg %q does not match key type %s", k.Alg, k.Kty)
+	}
+	return pk, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// keySet holds the keys of a JWKS file or URL. Keys from a URL are reloaded after refresh and,
+// at most once per minRefetchInterval, when a token names an unknown kid (key rotation).
+type keySet struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	keys      []publicKey
+	loadedAt  time.Time
+	fetchedAt time.Time
+}
+
+func newKeySet(source string, refresh time.Duration) (*keySet, error) {
+	ks := &keySet{source: source, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *keySet) isURL() bool {
+	return strings.HasPrefix(ks.source, "https://") || strings.HasPrefix(ks.source, "http://")
+}
+
+// load reads the JWKS; the caller holds mu or has exclusive access.
+func (ks *keySet) load() error {
+	ks.fetchedAt = time.Now()
+	var data []byte
+	var err error
+	if ks.isURL() {
+		data, err = ks.fetch()
+	} else {
+		data, err = os.ReadFile(ks.source)
+	}
+	if err != nil {
+		return fmt.Errorf("load JWKS %s: %w", ks.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("load JWKS %s: %w", ks.source, err)
+	}
+	ks.keys, ks.loadedAt = keys, ks.fetchedAt
+	return nil
+}
+
+func (ks *keySet) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// lookup returns the keys for alg and kid (all keys for alg if kid is empty).
+func (ks *keySet) lookup(alg, kid string) []publicKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.isURL() && ks.refresh > 0 && time.Since(ks.loadedAt) > ks.refresh && time.Since(ks.fetchedAt) > minRefetchInterval {
+		if err := ks.load(); err != nil {
+			// Alte Keys weiter verwenden, bis der Endpunkt wieder erreichbar ist
+			slog.Warn("JWKS refresh failed", "error", err)
+		}
+	}
+	found := ks.match(alg, kid)
+	if len(found) == 0 && kid != "" && ks.isURL() && time.Since(ks.fetchedAt) > minRefetchInterval {
+		if err := ks.load(); err != nil {
+			slog.Warn("JWKS refresh failed", "error", err)
+		}
+		found = ks.match(alg, kid)
+	}
+	return found
+}
+
+func (ks *keySet) match(alg, kid string) []publicKey {
+	var found []publicKey
+	for _, k := range ks.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			found = append(found, k)
+		}
+	}
+	return found
+}
@@ -0,0 +1,273 @@
+// Package jwt verifies bearer tokens (JWS compact serialization) issued by an external gateway or
+// OIDC provider against a JSON Web Key Set from a file or URL. Supported algorithms are RS256,
+// ES256 (P-256) and EdDSA (Ed25519); issuer, audience, expiry and not-before are checked.
+//
+// Claims map to a request principal: app_id (string or list) limits the apps, sub is the
+// externalUserId of the tenant and scope (space-separated or list) holds read, write and admin.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// Supported signature algorithms
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Defaults for Config
+const (
+	DefaultLeeway  = time.Minute
+	DefaultRefresh = time.Hour
+)
+
+// maxTokenSize limits the size of a token before it is parsed.
+const maxTokenSize = 16 * 1024
+
+var (
+	// ErrInvalidToken is returned for malformed tokens and wrong signatures.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for tokens past exp (or before nbf).
+	ErrTokenExpired = errors.New("token expired or not yet valid")
+	// ErrClaims is returned when issuer, audience or the tenant claims do not fit.
+	ErrClaims = errors.New("token claims rejected")
+)
+
+// Config configures a Verifier. Issuer and Audience are required.
+type Config struct {
+	JWKS     string        // path of a JWKS file or http(s) URL
+	Issuer   string        // expected iss
+	Audience string        // expected aud (one of them)
+	Leeway   time.Duration // tolerated clock skew for exp/nbf
+	Refresh  time.Duration // reload interval for a JWKS URL
+}
+
+// Verifier checks tokens against the keys of a JWKS.
+type Verifier struct {
+	cfg  Config
+	keys *keySet
+	now  func() time.Time
+}
+
+// NewVerifier loads the JWKS and returns a Verifier.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.JWKS == "" || cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("JWT verification needs a JWKS, an issuer and an audience")
+	}
+	if cfg.Leeway < 0 {
+		cfg.Leeway = 0
+	}
+	keys, err := newKeySet(cfg.JWKS, cfg.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{cfg: cfg, keys: keys, now: time.Now}, nil
+}
+
+// VerifierFromEnv returns a Verifier for CORTEX_JWT_JWKS, CORTEX_JWT_ISSUER and CORTEX_JWT_AUDIENCE
+// (optional CORTEX_JWT_LEEWAY, CORTEX_JWT_JWKS_REFRESH), nil if no JWKS is configured.
+func VerifierFromEnv() (*Verifier, error) {
+	cfg := Config{
+		JWKS:     os.Getenv("CORTEX_JWT_JWKS"),
+		Issuer:   os.Getenv("CORTEX_JWT_ISSUER"),
+		Audience: os.Getenv("CORTEX_JWT_AUDIENCE"),
+		Leeway:   DefaultLeeway,
+		Refresh:  DefaultRefresh,
+	}
+	if cfg.JWKS == "" {
+		return nil, nil
+	}
+	for name, target := range map[string]*time.Duration{
+		"CORTEX_JWT_LEEWAY":       &cfg.Leeway,
+		"CORTEX_JWT_JWKS_REFRESH": &cfg.Refresh,
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			*target = d
+		} else {
+			slog.Warn("invalid "+name+", using default", "value", v, "default", *target)
+		}
+	}
+	return NewVerifier(cfg)
+}
+
+// stringList is a claim that is either a string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected string or list of strings")
+	}
+	*l = list
+	return nil
+}
+
+// Claims are the registered and Cortex-specific claims of a token.
+type Claims struct {
+	Issuer    string     `json:"iss"`
+	Subject   string     `json:"sub"`
+	Audience  stringList `json:"aud"`
+	ExpiresAt *float64   `json:"exp"`
+	NotBefore *float64   `json:"nbf"`
+	AppIDs    stringList `json:"app_id"`
+	Scope     stringList `json:"scope"`
+}
+
+// Scopes returns the scopes of the token: scope as space-separated string or list, read and write
+// if the claim is missing.
+func (c *Claims) Scopes() []string {
+	if c.Scope == nil {
+		return []string{models.ScopeRead, models.ScopeWrite}
+	}
+	var scopes []string
+	for _, s := range c.Scope {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return scopes
+}
+
+// Principal maps the claims to a request principal. Tokens with app_id are bound to the tenant
+// app_id/sub; tokens without app_id need the admin scope and act on all tenants.
+func (c *Claims) Principal() (*helpers.Principal, error) {
+	scopes := c.Scopes()
+	p := &helpers.Principal{Name: "jwt:" + c.Subject, AppIDs: c.AppIDs, Scopes: scopes}
+	if len(c.AppIDs) == 0 {
+		if !slices.Contains(scopes, models.ScopeAdmin) {
+			return nil, fmt.Errorf("%w: no app_id claim", ErrClaims)
+		}
+		return p, nil
+	}
+	if slices.Contains(c.AppIDs, "") {
+		return nil, fmt.Errorf("%w: empty app_id", ErrClaims)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: no sub claim", ErrClaims)
+	}
+	p.ExternalUserID = c.Subject
+	return p, nil
+}
+
+// Verify checks signature, issuer, audience and validity period of token and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	if len(token) > maxTokenSize {
+		return nil, fmt.Errorf("%w: too large", ErrInvalidToken)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWS", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if header.Alg != RS256 && header.Alg != ES256 && header.Alg != EdDSA {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	keys := v.keys.lookup(header.Alg, header.Kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key for alg %s, kid %q", ErrInvalidToken, header.Alg, header.Kid)
+	}
+	if !slices.ContainsFunc(keys, func(k publicKey) bool { return verifySignature(k, signed, sig) }) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: no exp claim", ErrClaims)
+	}
+	if !now.Before(unixTime(*claims.ExpiresAt).Add(v.cfg.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(v.cfg.Leeway).Before(unixTime(*claims.NotBefore)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.Issuer != v.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrClaims, claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, v.cfg.Audience) {
+		return nil, fmt.Errorf("%w: audience %v", ErrClaims, []string(claims.Audience))
+	}
+	return &claims, nil
+}
+
+// VerifyToken verifies token and returns its principal (middleware.TokenVerifier).
+func (v *Verifier) VerifyToken(token string) (*helpers.Principal, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return claims.Principal()
+}
+
+func verifySignature(k publicKey, signed, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS: r und s als je 32 Byte, nicht ASN.1
+		if len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, sum[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, sig)
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// unixTime converts a NumericDate; values beyond year ~36000 are clamped instead of overflowing.
+func unixTime(sec float64) time.Time {
+	sec = min(max(sec, -1<<40), 1<<40)
+	return time.Unix(int64(sec), 0)
+}
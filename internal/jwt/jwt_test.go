@@ -0,0 +1,282 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://gateway.example"
+	testAudience = "cortex"
+)
+
+// testKey is a locally generated signing key with its JWK.
+type testKey struct {
+	alg  string
+	kid  string
+	priv crypto.Signer
+}
+
+func newTestKeys(t *testing.T) []testKey {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []testKey{{RS256, "rsa-1", rsaKey}, {ES256, "ec-1", ecKey}, {EdDSA, "ed-1", edKey}}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (k testKey) jwk() map[string]string {
+	switch pub := k.priv.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "alg": k.alg, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256", "x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": k.kid, "crv": "Ed25519", "x": b64(pub)}
+	}
+	return nil
+}
+
+func jwksJSON(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+	var doc struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		doc.Keys = append(doc.Keys, k.jwk())
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sign creates a token with the header alg/kid over claims.
+func (k testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	var sig []byte
+	var err error
+	switch priv := k.priv.(type) {
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, sum[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(priv, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":    testIssuer,
+		"aud":    []string{"other", testAudience},
+		"sub":    "alice",
+		"app_id": "app1",
+		"scope":  "read write",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"nbf":    time.Now().Add(-time.Minute).Unix(),
+	}
+}
+
+func newFileVerifier(t *testing.T, keys ...testKey) *Verifier {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksJSON(t, keys...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(Config{JWKS: path, Issuer: testIssuer, Audience: testAudience, Leeway: DefaultLeeway})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newFileVerifier(t, keys...)
+	for _, k := range keys {
+		t.Run(k.alg, func(t *testing.T) {
+			p, err := v.VerifyToken(k.sign(t, validClaims()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(p.AppIDs, []string{"app1"}) || p.ExternalUserID != "alice" || !slices.Equal(p.Scopes, []string{"read", "write"}) {
+				t.Errorf("principal = %+v", p)
+			}
+			if !p.AllowsTenant("app1", "alice") || p.AllowsTenant("app1", "bob") {
+				t.Errorf("tenant binding: %+v", p)
+			}
+
+			// Ohne kid werden alle Keys des Algorithmus probiert
+			noKid := k
+			noKid.kid = ""
+			if _, err := v.Verify(noKid.sign(t, validClaims())); err != nil {
+				t.Errorf("token without kid: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newFileVerifier(t, keys[0], keys[1])
+	rsaKey, ecKey, edKey := keys[0], keys[1], keys[2]
+	otherRSA := newTestKeys(t)[0]
+
+	// with returns valid claims with claim set to value (nil: removed)
+	with := func(claim string, value any) map[string]any {
+		c := validClaims()
+		if value == nil {
+			delete(c, claim)
+		} else {
+			c[claim] = value
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"expired", rsaKey.sign(t, with("exp", time.Now().Add(-2*time.Minute).Unix())), ErrTokenExpired},
+		{"not yet valid", rsaKey.sign(t, with("nbf", time.Now().Add(5*time.Minute).Unix())), ErrTokenExpired},
+		{"missing exp", rsaKey.sign(t, with("exp", nil)), ErrClaims},
+		{"wrong issuer", ecKey.sign(t, with("iss", "https://evil.example")), ErrClaims},
+		{"wrong audience", ecKey.sign(t, with("aud", "other")), ErrClaims},
+		{"missing audience", ecKey.sign(t, with("aud", nil)), ErrClaims},
+		{"unknown signing key", otherRSA.sign(t, validClaims()), ErrInvalidToken},
+		{"key not in JWKS", edKey.sign(t, validClaims()), ErrInvalidToken},
+		{"alg none", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"iss":"x"}`)) + ".", ErrInvalidToken},
+		{"malformed", "a.b", ErrInvalidToken},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := v.Verify(tc.token); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+
+	// Payload nach dem Signieren verändert
+	orig := strings.Split(rsaKey.sign(t, validClaims()), ".")
+	tampered := strings.Split(rsaKey.sign(t, with("app_id", "app2")), ".")
+	if _, err := v.Verify(orig[0] + "." + tampered[1] + "." + orig[2]); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("tampered payload: expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestClaimsPrincipal(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newFileVerifier(t, keys...)
+	k := keys[2]
+
+	// Admin-Token ohne app_id gilt für alle Tenants
+	admin := validClaims()
+	delete(admin, "app_id")
+	admin["scope"] = []string{"read", "admin"}
+	p, err := v.VerifyToken(k.sign(t, admin))
+	if err != nil || p.Restricted() || !p.HasScope("admin") || p.HasScope("write") {
+		t.Fatalf("admin token: %v %+v", err, p)
+	}
+
+	// Ohne scope: read und write; app_id als Liste
+	c := validClaims()
+	delete(c, "scope")
+	c["app_id"] = []string{"app1", "app2"}
+	p, err = v.VerifyToken(k.sign(t, c))
+	if err != nil || !p.HasScope("read") || !p.HasScope("write") || p.HasScope("admin") || !p.AllowsApp("app2") {
+		t.Fatalf("default scopes: %v %+v", err, p)
+	}
+
+	for name, mutate := range map[string]func(map[string]any){
+		"no app_id without admin": func(c map[string]any) { delete(c, "app_id") },
+		"app_id without sub":      func(c map[string]any) { delete(c, "sub") },
+		"empty app_id":            func(c map[string]any) { c["app_id"] = "" },
+	} {
+		c := validClaims()
+		mutate(c)
+		if _, err := v.VerifyToken(k.sign(t, c)); !errors.Is(err, ErrClaims) {
+			t.Errorf("%s: expected ErrClaims, got %v", name, err)
+		}
+	}
+}
+
+func TestJWKSFromURL(t *testing.T) {
+	keys := newTestKeys(t)
+	var served atomic.Value
+	served.Store(jwksJSON(t, keys[0]))
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(served.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	v, err := NewVerifier(Config{JWKS: srv.URL, Issuer: testIssuer, Audience: testAudience, Refresh: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(keys[0].sign(t, validClaims())); err != nil {
+		t.Fatal(err)
+	}
+
+	// Key-Rotation: ein unbekannter kid lädt die Keys neu (höchstens einmal pro minRefetchInterval)
+	served.Store(jwksJSON(t, keys[0], keys[1]))
+	if _, err := v.Verify(keys[1].sign(t, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("refetch must be rate limited, got %v", err)
+	}
+	v.keys.fetchedAt = time.Now().Add(-2 * minRefetchInterval)
+	if _, err := v.Verify(keys[1].sign(t, validClaims())); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected 2 fetches, got %d", n)
+	}
+
+	if _, err := NewVerifier(Config{JWKS: srv.URL + "/missing", Issuer: testIssuer}); err == nil {
+		t.Error("expected error without audience")
+	}
+}
@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
 )
 
 // responseRecorder captures status code and size for logging
@@ -25,6 +29,18 @@ func (r *responseRecorder) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Flush passes through to the underlying writer so streaming handlers (SSE) work behind the logger.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // LoggingMiddleware logs each request to the console (method, path, status, size).
 // If the handler panics, the panic is logged and re-raised.
 func LoggingMiddleware(next http.Handler) http.Handler {
@@ -61,27 +77,167 @@ func MethodAllowed(handler http.HandlerFunc, methods ...string) http.HandlerFunc
 	}
 }
 
+// KeyStore looks up API keys (implemented by store.CortexStore).
+type KeyStore interface {
+	AuthenticateAPIKey(key string) (*models.APIKey, error)
+	HasAPIKeys() (bool, error)
+}
+
+var keyStore KeyStore
+
+// SetKeyStore sets the store AuthMiddleware checks API keys against. Call it before serving requests.
+func SetKeyStore(ks KeyStore) {
+	keyStore = ks
+}
+
+// TokenVerifier verifies bearer tokens (implemented by jwt.Verifier).
+type TokenVerifier interface {
+	VerifyToken(token string) (*helpers.Principal, error)
+}
+
+var tokenVerifier TokenVerifier
+
+// SetTokenVerifier enables bearer tokens (JWTs) in AuthMiddleware. Call it before serving requests.
+func SetTokenVerifier(tv TokenVerifier) {
+	tokenVerifier = tv
+}
+
+// isToken reports whether a credential is a JWS (three dot-separated segments) rather than an API key.
+func isToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// rootPrincipal is the principal for CORTEX_API_KEY: all scopes, all apps.
+var rootPrincipal = &helpers.Principal{Name: "CORTEX_API_KEY", Scopes: []string{models.ScopeRead, models.ScopeWrite, models.ScopeAdmin}}
+
 // AuthMiddleware enforces optional API key authentication.
-// If CORTEX_API_KEY is set, requests must send X-API-Key or Authorization: Bearer <key>.
-// If CORTEX_API_KEY is empty, all requests are allowed (local/dev mode - no API key required).
+// Requests send X-API-Key or Authorization: Bearer <key>. CORTEX_API_KEY grants everything; keys from
+// the key store (see SetKeyStore) are limited to their scopes and apps and rejected once expired.
+// With a token verifier (see SetTokenVerifier) JWTs are accepted as well, limited by their claims.
+// The route rate limit (see RateLimit) is taken here per authenticated principal, or per client IP for
+// unauthenticated requests; quotas of apps and keys (CORTEX_RATE_LIMIT_QUOTAS) are enforced after
+// authorization, so unauthenticated requests cannot use up the quota of a tenant. Apps sent in the
+// request body are charged when handlers authorize them (helpers.AuthorizeTenant).
+// The authenticated principal is passed on in the request context (helpers.PrincipalFromContext);
+// handlers check the tenants they access against it.
+// If CORTEX_API_KEY is empty, no keys exist and JWTs are not enabled, all requests are allowed
+// (local/dev mode - no API key required).
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	apiKey := os.Getenv("CORTEX_API_KEY")
-	if apiKey == "" {
-		return next
-	}
+	quotas := quotasFromEnv()
 	return func(w http.ResponseWriter, r *http.Request) {
-		provided := r.Header.Get("X-API-Key")
-		if provided == "" {
-			auth := r.Header.Get("Authorization")
-			provided = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
-		}
-		if provided != apiKey {
-			slog.Warn("unauthorized", "path", r.URL.Path, "ip", r.RemoteAddr)
+		provided := credential(r)
+		// Fehlversuche zählen gegen die IP, nicht gegen den ungeprüften Key
+		unauthorized := func(args ...any) {
+			if !takeRoute(w, r, ipClient(r)) {
+				return
+			}
+			slog.Warn("unauthorized", append([]any{"path", r.URL.Path, "ip", r.RemoteAddr}, args...)...)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+		openMode := func() {
+			if !takeRoute(w, r, ipClient(r)) {
+				return
+			}
+			if r, ok := quotas.allow(w, r, nil); ok {
+				next(w, r)
+			}
+		}
+
+		var principal *helpers.Principal
+		switch {
+		case apiKey != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) == 1:
+			principal = rootPrincipal
+		case tokenVerifier != nil && isToken(provided):
+			p, err := tokenVerifier.VerifyToken(provided)
+			if err != nil {
+				unauthorized("error", err)
+				return
+			}
+			principal = p
+		case provided != "" && keyStore != nil:
+			k, err := keyStore.AuthenticateAPIKey(provided)
+			if err != nil && apiKey == "" && tokenVerifier == nil && !hasStoredKeys() {
+				// Ohne Keys bleibt der Server offen, auch wenn der Client einen Key mitschickt
+				openMode()
+				return
+			}
+			if err != nil {
+				unauthorized("error", err)
+				return
+			}
+			principal = k.Principal()
+		default:
+			if apiKey == "" && tokenVerifier == nil && !hasStoredKeys() {
+				openMode()
+				return
+			}
+			unauthorized()
+			return
+		}
+
+		helpers.ObservePrincipal(r.Context(), principal)
+		if !takeRoute(w, r, principalClient(principal)) {
+			return
+		}
+		scope := requiredScope(r)
+		if !principal.HasScope(scope) {
+			slog.Warn("forbidden", "path", r.URL.Path, "principal", principal.Name, "scope", scope)
+			http.Error(w, "forbidden: key lacks scope "+scope, http.StatusForbidden)
+			return
+		}
+		// Administration wirkt auf alle Tenants
+		if scope == models.ScopeAdmin && principal.Restricted() {
+			slog.Warn("forbidden", "path", r.URL.Path, "principal", principal.Name, "scope", scope)
+			http.Error(w, "forbidden: admin endpoints need a key for all tenants", http.StatusForbidden)
 			return
 		}
-		next(w, r)
+		if appID := r.URL.Query().Get("appId"); appID != "" && !principal.AllowsApp(appID) {
+			slog.Warn("forbidden", "path", r.URL.Path, "principal", principal.Name, "app_id", appID)
+			http.Error(w, "forbidden: key not valid for app "+appID, http.StatusForbidden)
+			return
+		}
+		r, ok := quotas.allow(w, r, principal)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(helpers.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// credential returns the API key or token of a request: X-API-Key, else Authorization: Bearer.
+func credential(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+// hasStoredKeys reports whether the key store has keys; on errors it fails closed.
+func hasStoredKeys() bool {
+	if keyStore == nil {
+		return false
+	}
+	has, err := keyStore.HasAPIKeys()
+	if err != nil {
+		slog.Error("failed to check API keys", "error", err)
+		return true
+	}
+	return has
+}
+
+// requiredScope returns the scope a request needs: admin for administration (admin endpoints,
+// backups, key management), read for GET/HEAD and searches, write otherwise.
+func requiredScope(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/backup"), path == "/restore",
+		path == "/api-keys", strings.HasPrefix(path, "/api-keys/"):
+		return models.ScopeAdmin
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, path == "/seeds/query":
+		return models.ScopeRead
 	}
+	return models.ScopeWrite
 }
 
 // CORSMiddleware sets CORS headers when CORTEX_CORS_ORIGIN is set (e.g. http://localhost:5173 for dashboard dev).
@@ -93,8 +249,9 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
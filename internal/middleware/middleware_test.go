@@ -1,10 +1,15 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
 )
 
 func TestAuthMiddleware_NoKey(t *testing.T) {
@@ -65,3 +70,180 @@ func TestAuthMiddleware_WithKey(t *testing.T) {
 		}
 	})
 }
+
+// fakeKeyStore maps keys to API keys.
+type fakeKeyStore map[string]*models.APIKey
+
+func (f fakeKeyStore) AuthenticateAPIKey(key string) (*models.APIKey, error) {
+	k, ok := f[key]
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	if k.Expired(time.Now()) {
+		return nil, errors.New("API key expired")
+	}
+	return k, nil
+}
+
+func (f fakeKeyStore) HasAPIKeys() (bool, error) {
+	return len(f) > 0, nil
+}
+
+func TestAuthMiddleware_KeyStore(t *testing.T) {
+	os.Setenv("CORTEX_API_KEY", "root")
+	defer os.Unsetenv("CORTEX_API_KEY")
+	expired := time.Now().Add(-time.Minute)
+	SetKeyStore(fakeKeyStore{
+		"reader":  {Prefix: "reader", Scopes: "read"},
+		"writer":  {Prefix: "writer", Scopes: "read,write", AppIDs: "app1,app2"},
+		"admin":   {Prefix: "admin", Scopes: "read,write,admin"},
+		"app1adm": {Prefix: "app1adm", Scopes: "read,write,admin", AppIDs: "app1"},
+		"expired": {Prefix: "expired", Scopes: "read,write", ExpiresAt: &expired},
+	})
+	defer SetKeyStore(nil)
+	var principal *helpers.Principal
+	handler := AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		principal = helpers.PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		key, method, target string
+		want                int
+	}{
+		{"reader", "GET", "/seeds?appId=app9", http.StatusOK},
+		{"reader", "POST", "/seeds/query", http.StatusOK},
+		{"reader", "POST", "/seeds", http.StatusForbidden},
+		{"writer", "POST", "/seeds?appId=app2", http.StatusOK},
+		{"writer", "GET", "/seeds?appId=app3", http.StatusForbidden},
+		{"writer", "POST", "/admin/cleanup", http.StatusForbidden},
+		{"writer", "GET", "/backups", http.StatusForbidden},
+		{"writer", "GET", "/api-keys", http.StatusForbidden},
+		{"admin", "DELETE", "/api-keys/3", http.StatusOK},
+		{"admin", "POST", "/restore", http.StatusOK},
+		{"root", "POST", "/admin/verify", http.StatusOK},
+		{"app1adm", "POST", "/admin/verify", http.StatusForbidden},
+		{"expired", "GET", "/seeds", http.StatusUnauthorized},
+		{"unknown", "GET", "/seeds", http.StatusUnauthorized},
+		{"", "GET", "/seeds", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s %s with key %q: expected %d, got %d", tc.method, tc.target, tc.key, tc.want, w.Code)
+		}
+	}
+
+	// Der Principal landet im Request-Context
+	req := httptest.NewRequest("GET", "/seeds?appId=app1", nil)
+	req.Header.Set("X-API-Key", "writer")
+	handler(httptest.NewRecorder(), req)
+	if principal == nil || !principal.AllowsTenant("app2", "bob") || principal.AllowsTenant("app3", "bob") {
+		t.Errorf("principal in context: %+v", principal)
+	}
+}
+
+func TestAuthMiddleware_StoredKeysWithoutEnv(t *testing.T) {
+	os.Unsetenv("CORTEX_API_KEY")
+	handler := AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	ks := fakeKeyStore{}
+	SetKeyStore(ks)
+	defer SetKeyStore(nil)
+
+	// Ohne Keys ist der Server offen, auch mit (veraltetem) Key im Header
+	for _, key := range []string{"", "stale"} {
+		req := httptest.NewRequest("GET", "/seeds", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("open mode with key %q: expected 200, got %d", key, w.Code)
+		}
+	}
+
+	// Sobald ein Key existiert, ist Auth Pflicht
+	ks["k1"] = &models.APIKey{Scopes: "read"}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/seeds", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 once keys exist, got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/seeds", nil)
+	req.Header.Set("X-API-Key", "k1")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with stored key, got %d", w.Code)
+	}
+}
+
+// fakeTokenVerifier accepts the token "eyJ.alice.sig" for app1/alice.
+type fakeTokenVerifier struct{}
+
+func (fakeTokenVerifier) VerifyToken(token string) (*helpers.Principal, error) {
+	if token != "eyJ.alice.sig" {
+		return nil, errors.New("invalid token")
+	}
+	return &helpers.Principal{Name: "jwt:alice", AppIDs: []string{"app1"}, ExternalUserID: "alice", Scopes: []string{"read"}}, nil
+}
+
+func TestAuthMiddleware_Tokens(t *testing.T) {
+	os.Unsetenv("CORTEX_API_KEY")
+	SetTokenVerifier(fakeTokenVerifier{})
+	defer SetTokenVerifier(nil)
+	var principal *helpers.Principal
+	handler := AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		principal = helpers.PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		token, method, target string
+		want                  int
+	}{
+		{"eyJ.alice.sig", "GET", "/seeds?appId=app1", http.StatusOK},
+		{"eyJ.alice.sig", "GET", "/seeds?appId=app2", http.StatusForbidden},
+		{"eyJ.alice.sig", "POST", "/seeds", http.StatusForbidden},
+		{"eyJ.bob.sig", "GET", "/seeds", http.StatusUnauthorized},
+		// Mit JWTs ist Auth Pflicht, auch ohne CORTEX_API_KEY und gespeicherte Keys
+		{"", "GET", "/seeds", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s %s with token %q: expected %d, got %d", tc.method, tc.target, tc.token, tc.want, w.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/seeds", nil)
+	req.Header.Set("Authorization", "Bearer eyJ.alice.sig")
+	handler(httptest.NewRecorder(), req)
+	if principal == nil || principal.ExternalUserID != "alice" {
+		t.Errorf("token principal in context: %+v", principal)
+	}
+}
+
+func TestLoggingMiddleware_Flush(t *testing.T) {
+	handler := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped writer must implement http.Flusher (SSE)")
+		}
+		w.Write([]byte("data: x\n\n"))
+		f.Flush()
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/events/stream", nil))
+	if !w.Flushed {
+		t.Error("expected flush to reach the underlying writer")
+	}
+}
@@ -1,157 +1,357 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"cortex/internal/helpers"
+)
+
+// Route groups share one bucket per client: all routes of a group count against the same limit.
+const (
+	RouteDefault = "default"
+	RouteMemory  = "memory" // /remember, /recall
+	RouteGraph   = "graph"  // /entities, /relations, /graph/traverse
+	RouteAdmin   = "admin"  // /admin/*, backups, restore, API keys
+)
+
+// Defaults for CORTEX_RATE_LIMIT and CORTEX_RATE_LIMIT_WINDOW.
+const (
+	DefaultRateLimit       = 100
+	DefaultRateLimitWindow = time.Minute
 )
 
-// RateLimiter implements token bucket rate limiting
-type RateLimiter struct {
-	mu          sync.RWMutex
-	clients     map[string]*clientLimiter
-	rate        int           // requests per window
-	window      time.Duration // time window
-	cleanupTick *time.Ticker
+// bucketIdleSweep is how often the memory backend drops buckets that have refilled completely.
+const bucketIdleSweep = time.Minute
+
+// Policy allows Limit requests per Window: a token bucket holding up to Limit tokens that refills
+// continuously at Limit/Window tokens per second. Limit 0 means unlimited.
+type Policy struct {
+	Limit  int
+	Window time.Duration
 }
 
-type clientLimiter struct {
-	tokens     int
-	lastAccess time.Time
-	mu         sync.Mutex
+// perSecond returns the refill rate in tokens per second.
+func (p Policy) perSecond() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		clients: make(map[string]*clientLimiter),
-		rate:    rate,
-		window:  window,
-	}
+func (p Policy) String() string {
+	return strconv.Itoa(p.Limit) + "/" + p.Window.String()
+}
+
+// Decision is the result of taking a token from a bucket.
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int           // whole tokens left after this request
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, 0 if allowed
+}
 
-	// Cleanup old entries every minute
-	rl.cleanupTick = time.NewTicker(1 * time.Minute)
-	go rl.cleanup()
+// Backend stores the token buckets. The in-memory backend serves a single instance; a shared store
+// (e.g. Redis) lets several instances enforce the same limits. Set it with SetRateLimitBackend.
+type Backend interface {
+	// Take removes one token from the bucket key under policy p, creating a full bucket if needed.
+	Take(ctx context.Context, key string, p Policy, now time.Time) (Decision, error)
+}
+
+// MemoryBackend keeps the buckets in process memory.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // time tokens was computed
+	full   time.Time // time the bucket is full again
+}
 
-	return rl
+// NewMemoryBackend creates an empty in-memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: make(map[string]*bucket)}
 }
 
-// cleanup removes old client entries
-func (rl *RateLimiter) cleanup() {
-	for range rl.cleanupTick.C {
-		rl.mu.Lock()
-		now := time.Now()
-		for key, cl := range rl.clients {
-			cl.mu.Lock()
-			if now.Sub(cl.lastAccess) > rl.window*2 {
-				delete(rl.clients, key)
+// Take implements Backend.
+func (m *MemoryBackend) Take(_ context.Context, key string, p Policy, now time.Time) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) >= bucketIdleSweep {
+		for k, b := range m.buckets {
+			if !now.Before(b.full) {
+				delete(m.buckets, k)
 			}
-			cl.mu.Unlock()
 		}
-		rl.mu.Unlock()
+		m.lastSweep = now
 	}
-}
 
-// Allow checks if a request is allowed
-func (rl *RateLimiter) Allow(clientID string) bool {
-	rl.mu.Lock()
-	cl, exists := rl.clients[clientID]
-	if !exists {
-		cl = &clientLimiter{
-			tokens:     rl.rate,
-			lastAccess: time.Now(),
-		}
-		rl.clients[clientID] = cl
+	limit := float64(p.Limit)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit, last: now}
+		m.buckets[key] = b
 	}
-	rl.mu.Unlock()
+	// Anteilig auffüllen, auch Bruchteile eines Tokens
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(limit, b.tokens+elapsed.Seconds()*p.perSecond())
+		b.last = now
+	}
+	b.tokens = math.Min(limit, b.tokens) // Limit kann gesenkt worden sein
 
-	cl.mu.Lock()
-	defer cl.mu.Unlock()
+	d := Decision{Limit: p.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = secondsToDuration((1 - b.tokens) / p.perSecond())
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = secondsToDuration((limit - b.tokens) / p.perSecond())
+	b.full = now.Add(d.Reset)
+	return d, nil
+}
 
-	now := time.Now()
-	elapsed := now.Sub(cl.lastAccess)
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
 
-	// Refill tokens based on elapsed time
-	if elapsed >= rl.window {
-		cl.tokens = rl.rate
-	} else {
-		// Refill proportionally
-		refill := int(float64(rl.rate) * elapsed.Seconds() / rl.window.Seconds())
-		if refill > 0 {
-			cl.tokens = min(cl.tokens+refill, rl.rate)
+var rateLimitBackend Backend = NewMemoryBackend()
+
+// SetRateLimitBackend sets the backend all rate limits share. Call it before serving requests.
+func SetRateLimitBackend(b Backend) {
+	rateLimitBackend = b
+}
+
+// parsePolicy parses "<limit>/<window>" (e.g. 300/1m) or "<limit>" (window def.Window).
+func parsePolicy(s string, def Policy) (Policy, error) {
+	limitStr, windowStr, hasWindow := strings.Cut(strings.TrimSpace(s), "/")
+	limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+	if err != nil || limit < 0 {
+		return Policy{}, fmt.Errorf("invalid limit %q", limitStr)
+	}
+	p := Policy{Limit: limit, Window: def.Window}
+	if hasWindow {
+		w, err := time.ParseDuration(strings.TrimSpace(windowStr))
+		if err != nil || w <= 0 {
+			return Policy{}, fmt.Errorf("invalid window %q", windowStr)
 		}
+		p.Window = w
 	}
+	return p, nil
+}
 
-	if cl.tokens > 0 {
-		cl.tokens--
-		cl.lastAccess = now
-		return true
+// parsePolicies parses a comma-separated list of name=<limit>/<window> entries (CORTEX_RATE_LIMIT_ROUTES,
+// CORTEX_RATE_LIMIT_QUOTAS). Invalid entries are logged and skipped.
+func parsePolicies(env string, def Policy) map[string]Policy {
+	policies := make(map[string]Policy)
+	for _, entry := range strings.Split(os.Getenv(env), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		p, err := parsePolicy(spec, def)
+		if !ok || name == "" || err != nil {
+			slog.Warn("invalid rate limit policy, ignoring", "env", env, "entry", entry, "error", err)
+			continue
+		}
+		policies[name] = p
 	}
-
-	return false
+	return policies
 }
 
-// getClientID extracts client identifier from request
-func getClientID(r *http.Request) string {
-	// Try API key first (if available)
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" {
-		return authHeader
+// defaultPolicy returns CORTEX_RATE_LIMIT requests per CORTEX_RATE_LIMIT_WINDOW (limit 0 disables
+// rate limiting).
+func defaultPolicy() Policy {
+	p := Policy{Limit: DefaultRateLimit, Window: DefaultRateLimitWindow}
+	if v := os.Getenv("CORTEX_RATE_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			p.Limit = n
+		}
 	}
-
-	// Fallback to IP address; X-Forwarded-For can be "client, proxy1, proxy2" — use leftmost (client)
-	ip := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		if first := strings.Split(forwarded, ",")[0]; strings.TrimSpace(first) != "" {
-			ip = strings.TrimSpace(first)
+	if v := os.Getenv("CORTEX_RATE_LIMIT_WINDOW"); v != "" {
+		if w, err := time.ParseDuration(v); err == nil && w > 0 {
+			p.Window = w
 		}
 	}
+	return p
+}
 
-	return ip
+// routePolicy returns the per-client policy of a route group: its entry in CORTEX_RATE_LIMIT_ROUTES,
+// else the default policy.
+func routePolicy(group string) Policy {
+	def := defaultPolicy()
+	if def.Limit == 0 {
+		return def
+	}
+	if p, ok := parsePolicies("CORTEX_RATE_LIMIT_ROUTES", def)[group]; ok {
+		return p
+	}
+	return def
 }
 
-// RateLimitMiddleware provides rate limiting
-func RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	// Get rate limit config from environment
-	rateStr := os.Getenv("CORTEX_RATE_LIMIT")
-	windowStr := os.Getenv("CORTEX_RATE_LIMIT_WINDOW")
+// routeLimit is the route group of a request, set by RateLimit and taken by AuthMiddleware once the
+// client is known.
+type routeLimit struct {
+	group  string
+	policy Policy
+}
 
-	rate := 100               // Default: 100 requests
-	window := 1 * time.Minute // Default: 1 minute
+type routeLimitKey struct{}
 
-	if rateStr != "" {
-		if r, err := strconv.Atoi(rateStr); err == nil && r > 0 {
-			rate = r
-		}
+// principalClient identifies an authenticated client: stored keys by ID, other principals (CORTEX_API_KEY,
+// JWT subjects) by name.
+func principalClient(p *helpers.Principal) string {
+	if p.KeyID != 0 {
+		return "key:" + strconv.FormatInt(p.KeyID, 10)
 	}
+	return "principal:" + p.Name
+}
 
-	if windowStr != "" {
-		if w, err := time.ParseDuration(windowStr); err == nil && w > 0 {
-			window = w
-		}
+// ipClient identifies a client without valid credentials by its IP.
+func ipClient(r *http.Request) string {
+	return "ip:" + helpers.ClientIP(r)
+}
+
+// takeRoute takes a token from the route bucket of client if the request passed RateLimit. It writes
+// 429 and returns false if the bucket is empty.
+func takeRoute(w http.ResponseWriter, r *http.Request, client string) bool {
+	rl, ok := r.Context().Value(routeLimitKey{}).(routeLimit)
+	if !ok {
+		return true
 	}
+	return take(w, r, "route:"+rl.group+":"+client, rl.policy)
+}
 
-	// If rate limiting is disabled (rate = 0), skip middleware
-	if rate == 0 {
-		return next
+// take takes a token from bucket key and writes the X-RateLimit-* headers; if the bucket is empty it
+// writes 429 and returns false. Backend errors let the request through.
+func take(w http.ResponseWriter, r *http.Request, key string, p Policy) bool {
+	d, err := rateLimitBackend.Take(r.Context(), key, p, time.Now())
+	if err != nil {
+		slog.Error("rate limit backend failed, allowing request", "error", err, "key", key)
+		return true
 	}
+	setRateLimitHeaders(w, d)
+	if !d.Allowed {
+		slog.Warn("rate limit exceeded", "key", key, "policy", p.String(), "path", r.URL.Path)
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
 
-	limiter := NewRateLimiter(rate, window)
+// setRateLimitHeaders writes the X-RateLimit-* headers of d unless an earlier limit of the same request
+// (route or quota) has fewer requests left.
+func setRateLimitHeaders(w http.ResponseWriter, d Decision) {
+	h := w.Header()
+	if prev, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil && prev < d.Remaining {
+		return
+	}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+}
 
+func ceilSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// RateLimit limits the requests of each client to the policy of a route group. All routes wrapped
+// with the same group share one bucket per client. The policy comes from CORTEX_RATE_LIMIT_ROUTES
+// (e.g. memory=300/1m,admin=10/1m), else CORTEX_RATE_LIMIT per CORTEX_RATE_LIMIT_WINDOW;
+// CORTEX_RATE_LIMIT=0 disables rate limiting.
+// RateLimit must wrap AuthMiddleware, which takes the token once the client is known: the authenticated
+// principal, or the client IP for requests without valid credentials. Unverified credentials never
+// select a bucket, so random keys cannot bypass the limit. Tenant and key quotas are checked there as well.
+func RateLimit(group string, next http.HandlerFunc) http.HandlerFunc {
+	p := routePolicy(group)
+	if p.Limit == 0 {
+		return next
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		clientID := getClientID(r)
+		next(w, r.WithContext(context.WithValue(r.Context(), routeLimitKey{}, routeLimit{group: group, policy: p})))
+	}
+}
+
+// RateLimitMiddleware limits a route with the default route group.
+func RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return RateLimit(RouteDefault, next)
+}
+
+// quotas are the limits of CORTEX_RATE_LIMIT_QUOTAS, shared by all clients and routes: app:<appId>
+// per app, key:<id> per stored API key; app:* and key:* apply to apps and keys without an own entry.
+type quotas map[string]Policy
 
-		if !limiter.Allow(clientID) {
-			slog.Warn("rate limit exceeded", "client", clientID, "path", r.URL.Path)
-			w.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
-			return
+// quotasFromEnv reads CORTEX_RATE_LIMIT_QUOTAS (nil if rate limiting is disabled).
+func quotasFromEnv() quotas {
+	def := defaultPolicy()
+	if def.Limit == 0 {
+		return nil
+	}
+	q := quotas(parsePolicies("CORTEX_RATE_LIMIT_QUOTAS", def))
+	if len(q) == 0 {
+		return nil
+	}
+	return q
+}
+
+func (q quotas) lookup(kind, id string) (Policy, bool) {
+	if p, ok := q[kind+":"+id]; ok {
+		return p, p.Limit > 0
+	}
+	p, ok := q[kind+":*"]
+	return p, ok && p.Limit > 0
+}
+
+// allow takes a token from the app quota (appId parameter, or the only app of the principal) and the
+// key quota of the principal. It writes 429 and returns false if one is exhausted. The returned
+// request carries the quota of the apps handlers authorize later (see helpers.AuthorizeTenant).
+func (q quotas) allow(w http.ResponseWriter, r *http.Request, p *helpers.Principal) (*http.Request, bool) {
+	if len(q) == 0 {
+		return r, true
+	}
+	appID := r.URL.Query().Get("appId")
+	if appID == "" && p != nil && len(p.AppIDs) == 1 {
+		appID = p.AppIDs[0]
+	}
+	if appID != "" {
+		if policy, ok := q.lookup("app", appID); ok && !take(w, r, "quota:app:"+appID, policy) {
+			return r, false
 		}
+	}
+	if p != nil && p.KeyID != 0 {
+		id := strconv.FormatInt(p.KeyID, 10)
+		if policy, ok := q.lookup("key", id); ok && !take(w, r, "quota:key:"+id, policy) {
+			return r, false
+		}
+	}
+	return r.WithContext(helpers.WithTenantQuota(r.Context(), q.tenantQuota(appID))), true
+}
 
-		next(w, r)
+// tenantQuota charges each app a request authorizes once; charged was already taken by allow.
+func (q quotas) tenantQuota(charged string) helpers.TenantQuota {
+	var mu sync.Mutex
+	seen := map[string]bool{charged: true}
+	return func(w http.ResponseWriter, r *http.Request, appID string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[appID] {
+			return true
+		}
+		seen[appID] = true
+		policy, ok := q.lookup("app", appID)
+		return !ok || take(w, r, "quota:app:"+appID, policy)
 	}
 }
@@ -1,23 +1,37 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"testing"
 	"time"
+
+	"cortex/internal/helpers"
 )
 
+// useMemoryBackend gives a test its own buckets.
+func useMemoryBackend(t *testing.T) {
+	t.Helper()
+	SetRateLimitBackend(NewMemoryBackend())
+	t.Cleanup(func() { SetRateLimitBackend(NewMemoryBackend()) })
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
 func TestRateLimitMiddleware(t *testing.T) {
+	useMemoryBackend(t)
 	// Set rate limit for testing
 	os.Setenv("CORTEX_RATE_LIMIT", "5")
 	os.Setenv("CORTEX_RATE_LIMIT_WINDOW", "1s")
 	defer os.Unsetenv("CORTEX_RATE_LIMIT")
 	defer os.Unsetenv("CORTEX_RATE_LIMIT_WINDOW")
 
-	handler := RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	handler := RateLimitMiddleware(AuthMiddleware(okHandler))
 
 	req := httptest.NewRequest("GET", "/test", nil)
 	req.RemoteAddr = "127.0.0.1:12345"
@@ -29,6 +43,9 @@ func TestRateLimitMiddleware(t *testing.T) {
 		if w.Code != http.StatusOK {
 			t.Errorf("request %d should succeed, got status %d", i+1, w.Code)
 		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(4-i) {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q", i+1, got)
+		}
 	}
 
 	// 6th request should be rate limited
@@ -42,6 +59,17 @@ func TestRateLimitMiddleware(t *testing.T) {
 	if w.Header().Get("Retry-After") == "" {
 		t.Error("Retry-After header should be set")
 	}
+	if w.Header().Get("X-RateLimit-Limit") != "5" || w.Header().Get("X-RateLimit-Reset") != "1" {
+		t.Errorf("headers: %v", w.Header())
+	}
+
+	// Gleiche IP über einen anderen Port teilt sich den Bucket
+	req.RemoteAddr = "127.0.0.1:23456"
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("same IP on another port should be limited, got status %d", w.Code)
+	}
 }
 
 func TestRateLimitDisabled(t *testing.T) {
@@ -49,36 +77,237 @@ func TestRateLimitDisabled(t *testing.T) {
 	os.Setenv("CORTEX_RATE_LIMIT", "0")
 	defer os.Unsetenv("CORTEX_RATE_LIMIT")
 
-	handler := RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	handler := RateLimitMiddleware(okHandler)
 
-	req := httptest.NewRequest("GET", "/test", nil)
-	w := httptest.NewRecorder()
-	handler(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("rate limiting should be disabled, got status %d", w.Code)
+	for i := 0; i < DefaultRateLimit+1; i++ {
+		req := httptest.NewRequest("GET", "/test", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("rate limiting should be disabled, got status %d", w.Code)
+		}
 	}
 }
 
-func TestRateLimiterAllow(t *testing.T) {
-	limiter := NewRateLimiter(10, 1*time.Second)
+func TestMemoryBackendTake(t *testing.T) {
+	b := NewMemoryBackend()
+	ctx := context.Background()
+	p := Policy{Limit: 10, Window: time.Second}
+	now := time.Now()
 
 	// First 10 requests should be allowed
 	for i := 0; i < 10; i++ {
-		if !limiter.Allow("client1") {
-			t.Errorf("request %d should be allowed", i+1)
+		if d, _ := b.Take(ctx, "client1", p, now); !d.Allowed || d.Remaining != 9-i {
+			t.Errorf("request %d: %+v", i+1, d)
 		}
 	}
 
 	// 11th request should be denied
-	if limiter.Allow("client1") {
-		t.Error("11th request should be denied")
+	d, _ := b.Take(ctx, "client1", p, now)
+	if d.Allowed || d.RetryAfter != 100*time.Millisecond || d.Reset != time.Second {
+		t.Errorf("11th request should be denied: %+v", d)
 	}
 
 	// Different client should still be allowed
-	if !limiter.Allow("client2") {
+	if d, _ := b.Take(ctx, "client2", p, now); !d.Allowed {
 		t.Error("different client should be allowed")
 	}
+
+	// Bruchteile summieren sich: 2 x 50ms ergeben ein Token
+	now = now.Add(50 * time.Millisecond)
+	if d, _ := b.Take(ctx, "client1", p, now); d.Allowed {
+		t.Errorf("half a token must not allow a request: %+v", d)
+	}
+	now = now.Add(50 * time.Millisecond)
+	if d, _ := b.Take(ctx, "client1", p, now); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("refilled token should allow a request: %+v", d)
+	}
+
+	// Volle Buckets werden nach bucketIdleSweep entfernt
+	now = now.Add(bucketIdleSweep)
+	b.Take(ctx, "client3", p, now)
+	if len(b.buckets) != 1 {
+		t.Errorf("expected idle buckets to be swept, got %d buckets", len(b.buckets))
+	}
+}
+
+func TestRateLimitRouteGroups(t *testing.T) {
+	useMemoryBackend(t)
+	t.Setenv("CORTEX_RATE_LIMIT", "2")
+	t.Setenv("CORTEX_RATE_LIMIT_ROUTES", "memory=3/1m, graph=0, broken=x/1m")
+	t.Setenv("CORTEX_API_KEY", "root")
+	SetKeyStore(fakeKeyStore{
+		"k1": {ID: 1, Prefix: "k1", Scopes: "read"},
+		"k2": {ID: 2, Prefix: "k2", Scopes: "read"},
+	})
+	defer SetKeyStore(nil)
+
+	recall := RateLimit(RouteMemory, AuthMiddleware(okHandler))
+	remember := RateLimit(RouteMemory, AuthMiddleware(okHandler))
+	entities := RateLimit(RouteGraph, AuthMiddleware(okHandler))
+	stats := RateLimitMiddleware(AuthMiddleware(okHandler))
+
+	run := func(h http.HandlerFunc, key, ip string) int {
+		req := httptest.NewRequest("GET", "/x", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w.Code
+	}
+	// Routen einer Gruppe teilen sich den Bucket eines Keys, auch über IPs hinweg
+	for i, h := range []http.HandlerFunc{recall, remember, recall} {
+		if code := run(h, "k1", "10.0.0."+strconv.Itoa(i+1)); code != http.StatusOK {
+			t.Fatalf("memory request %d: got %d", i+1, code)
+		}
+	}
+	if code := run(remember, "k1", "10.0.0.9"); code != http.StatusTooManyRequests {
+		t.Errorf("memory group should be exhausted, got %d", code)
+	}
+	if code := run(remember, "k2", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("other key should have its own bucket, got %d", code)
+	}
+	// Andere Gruppe: Default-Policy (2); graph=0 ist unbegrenzt
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := run(stats, "k1", "10.0.0.1"); code != want {
+			t.Errorf("default request %d: expected %d, got %d", i+1, want, code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if code := run(entities, "k1", "10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("graph group should be unlimited, got %d", code)
+		}
+	}
+
+	// Ungültige Keys zählen gegen die IP: zufällige Keys umgehen das Limit nicht
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if code := run(recall, "random-"+strconv.Itoa(i), "10.0.0.7"); code != want {
+			t.Errorf("invalid key %d: expected %d, got %d", i+1, want, code)
+		}
+	}
+	if code := run(recall, "k2", "10.0.0.7"); code != http.StatusOK {
+		t.Errorf("valid key from a limited IP should use its own bucket, got %d", code)
+	}
+	if code := run(recall, "", "10.0.0.8"); code != http.StatusUnauthorized {
+		t.Errorf("request without key from another IP: expected 401, got %d", code)
+	}
+}
+
+func TestRateLimitQuotas(t *testing.T) {
+	useMemoryBackend(t)
+	t.Setenv("CORTEX_API_KEY", "root")
+	t.Setenv("CORTEX_RATE_LIMIT_QUOTAS", "app:app1=2/1m,app:*=3/1m,app:free=0,key:7=1/1m")
+	SetKeyStore(fakeKeyStore{
+		"bound":   {ID: 7, Prefix: "bound", Scopes: "read,write", AppIDs: "app2"},
+		"reader":  {ID: 8, Prefix: "reader", Scopes: "read"},
+		"reader2": {ID: 9, Prefix: "reader2", Scopes: "read"},
+	})
+	defer SetKeyStore(nil)
+	handler := RateLimitMiddleware(AuthMiddleware(okHandler))
+
+	run := func(key, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Unauthentifizierte Requests verbrauchen keine Tenant-Quota
+	for i := 0; i < 3; i++ {
+		if w := run("wrong", "/seeds?appId=app1"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", w.Code)
+		}
+	}
+	// app1: 2 Requests, egal von welchem Key
+	for i, tc := range []struct {
+		key  string
+		want int
+	}{{"reader", http.StatusOK}, {"reader2", http.StatusOK}, {"root", http.StatusTooManyRequests}} {
+		w := run(tc.key, "/seeds?appId=app1")
+		if w.Code != tc.want {
+			t.Errorf("app1 request %d: expected %d, got %d", i+1, tc.want, w.Code)
+		}
+		if tc.want == http.StatusOK && w.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Errorf("app1 request %d: quota headers %v", i+1, w.Header())
+		}
+	}
+	// app:free ist unbegrenzt trotz app:*
+	for i := 0; i < 4; i++ {
+		if w := run("reader", "/seeds?appId=free"); w.Code != http.StatusOK {
+			t.Fatalf("app free should be unlimited, got %d", w.Code)
+		}
+	}
+	// key:7 (1/min) gilt zusätzlich zu app:* (einzige App des Keys)
+	if w := run("bound", "/seeds"); w.Code != http.StatusOK {
+		t.Errorf("bound key: expected 200, got %d", w.Code)
+	}
+	if w := run("bound", "/seeds"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("bound key: expected 429 with Retry-After 60, got %d %v", w.Code, w.Header())
+	}
+	if w := run("root", "/seeds?appId=app2"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("app2 via app:*: got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRateLimitQuotasBodyTenant(t *testing.T) {
+	useMemoryBackend(t)
+	t.Setenv("CORTEX_API_KEY", "root")
+	t.Setenv("CORTEX_RATE_LIMIT_QUOTAS", "app:app1=3/1m")
+	SetKeyStore(fakeKeyStore{"app2": {ID: 2, Prefix: "app2", Scopes: "read,write", AppIDs: "app2"}})
+	defer SetKeyStore(nil)
+	// Wie die Handler: Tenant aus dem Body, mehrfach geprüft
+	handler := RateLimitMiddleware(AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 2; i++ {
+			if !helpers.AuthorizeTenant(w, r, "app1", "u1") {
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	run := func(key, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Verbotene Tenants verbrauchen keine Quote
+	if w := run("app2", "/remember"); w.Code != http.StatusForbidden {
+		t.Fatalf("foreign tenant: expected 403, got %d", w.Code)
+	}
+	// Jede App zählt einmal pro Request, auch wenn sie zusätzlich als appId kommt
+	for i, target := range []string{"/remember", "/remember?appId=app1", "/remember"} {
+		w := run("root", target)
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(2-i) {
+			t.Errorf("request %d: got %d %v", i+1, w.Code, w.Header())
+		}
+	}
+	if w := run("root", "/remember"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("body tenant quota should be exhausted, got %d", w.Code)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	def := Policy{Limit: DefaultRateLimit, Window: DefaultRateLimitWindow}
+	cases := map[string]Policy{
+		"300/1m":  {300, time.Minute},
+		" 10/1s ": {10, time.Second},
+		"50":      {50, time.Minute},
+		"0":       {0, time.Minute},
+	}
+	for in, want := range cases {
+		if got, err := parsePolicy(in, def); err != nil || got != want {
+			t.Errorf("parsePolicy(%q) = %v, %v; expected %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "-1/1m", "10/0s", "10/x", "a/1m"} {
+		if _, err := parsePolicy(in, def); err == nil {
+			t.Errorf("parsePolicy(%q): expected error", in)
+		}
+	}
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"cortex/internal/helpers"
+	"slices"
 	"strings"
 	"time"
 )
@@ -17,17 +18,21 @@ const (
 type Memory struct {
 	ID             int64          `gorm:"primaryKey;autoIncrement" json:"id"`
 	Type           string         `gorm:"not null;default:'semantic'" json:"type"`
-	Content        string         `gorm:"not null" json:"content"`
+	Content        string         `gorm:"not null;serializer:sealed" json:"content"`
 	Entity         string         `json:"entity,omitempty"`
 	Tags           string         `json:"tags,omitempty"`
 	Importance     int            `gorm:"not null;default:5" json:"importance"`
 	AppID          string         `gorm:"column:app_id;not null;default:'openclaw';index" json:"app_id,omitempty"`
 	ExternalUserID string         `gorm:"column:external_user_id;not null;default:'default';index" json:"external_user_id,omitempty"`
 	BundleID       *int64         `gorm:"column:bundle_id;index" json:"bundle_id,omitempty"`
-	Metadata       string         `gorm:"type:text" json:"-"`
+	Metadata       string         `gorm:"type:text;serializer:sealed" json:"-"`
 	MetadataMap    map[string]any `gorm:"-" json:"metadata,omitempty"`
-	Embedding      string         `gorm:"type:text" json:"-"` // JSON-encoded []float32
+	Embedding      []byte         `gorm:"type:blob" json:"-"` // binary vector, see embeddings.EncodeVector
+	EmbeddingModel string         `gorm:"column:embedding_model;index" json:"embedding_model,omitempty"` // model that produced Embedding ("" = unknown, pre-model schema)
+	EmbeddingDim   int            `gorm:"column:embedding_dim" json:"embedding_dim,omitempty"`
 	ContentType    string         `gorm:"column:content_type;default:'text/plain'" json:"content_type,omitempty"`
+	ContentHash    string         `gorm:"column:content_hash;index" json:"content_hash,omitempty"` // SHA-256 of Content (hex), keyed with field encryption; set on every write
+	Signature      string         `gorm:"column:signature" json:"-"`                               // HMAC under the server signing key (optional)
 	Status         string         `gorm:"not null;default:'active';index" json:"status,omitempty"`   // active, archived
 	ExpiresAt      *time.Time     `gorm:"column:expires_at;index" json:"expires_at,omitempty"`     // optional TTL
 	CreatedAt      time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
@@ -36,17 +41,20 @@ type Memory struct {
 
 // MemoryVersion stores a snapshot of a memory for version history.
 type MemoryVersion struct {
-	ID             int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	MemoryID       int64     `gorm:"column:memory_id;not null;index" json:"memory_id"`
-	Version        int       `gorm:"not null" json:"version"`
-	Content        string    `gorm:"type:text;not null" json:"content"`
-	Metadata       string    `gorm:"type:text" json:"-"`
-	Importance     int       `gorm:"not null" json:"importance"`
-	Tags           string    `gorm:"type:text" json:"tags,omitempty"`
-	Entity         string    `gorm:"type:text" json:"entity,omitempty"`
-	Type           string    `gorm:"type:text" json:"type,omitempty"`
-	ChangedAt      time.Time `gorm:"column:changed_at;not null;default:CURRENT_TIMESTAMP" json:"changed_at"`
-	ChangedBy      string    `gorm:"column:changed_by" json:"changed_by,omitempty"` // e.g. "api", "merge", "import"
+	ID          int64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	MemoryID    int64          `gorm:"column:memory_id;not null;index" json:"memory_id"`
+	Version     int            `gorm:"not null" json:"version"`
+	Content     string         `gorm:"type:text;not null;serializer:sealed" json:"content"`
+	ContentHash string         `gorm:"column:content_hash" json:"content_hash,omitempty"`
+	Signature   string         `gorm:"column:signature" json:"-"`
+	Metadata    string         `gorm:"type:text;serializer:sealed" json:"-"`
+	MetadataMap map[string]any `gorm:"-" json:"metadata,omitempty"`
+	Importance  int            `gorm:"not null" json:"importance"`
+	Tags        string         `gorm:"type:text" json:"tags,omitempty"`
+	Entity      string         `gorm:"type:text" json:"entity,omitempty"`
+	Type        string         `gorm:"type:text" json:"type,omitempty"`
+	ChangedAt   time.Time      `gorm:"column:changed_at;not null;default:CURRENT_TIMESTAMP" json:"changed_at"`
+	ChangedBy   string         `gorm:"column:changed_by" json:"changed_by,omitempty"` // e.g. "api", "merge", "import"
 }
 
 // NewMemoryFromRememberRequest creates a Memory from RememberRequest
@@ -67,6 +75,18 @@ func NewMemoryFromRememberRequest(req *RememberRequest) *Memory {
 	return mem
 }
 
+// EmbeddingCompatible reports whether the stored embedding can be compared with a query vector of the
+// given model and dimension. Memories without recorded model (pre-model schema) only need a matching dimension.
+func (m *Memory) EmbeddingCompatible(modelID string, dim int) bool {
+	if len(m.Embedding) == 0 {
+		return false
+	}
+	if m.EmbeddingDim != 0 && m.EmbeddingDim != dim {
+		return false
+	}
+	return m.EmbeddingModel == "" || modelID == "" || m.EmbeddingModel == modelID
+}
+
 // NewMemoryFromStoreSeedRequest creates a Memory from StoreSeedRequest
 func NewMemoryFromStoreSeedRequest(req *StoreSeedRequest, appID, externalUserID string) *Memory {
 	mem := &Memory{
@@ -88,23 +108,62 @@ func NewMemoryFromStoreSeedRequest(req *StoreSeedRequest, appID, externalUserID
 	return mem
 }
 
+// Entity is a named node of the knowledge graph. Names are unique per tenant; rows from the
+// pre-tenant schema have an empty app_id/external_user_id until claimed (see store.ClaimLegacyGraph).
 type Entity struct {
-	ID        int64          `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name      string         `gorm:"uniqueIndex;not null" json:"name"`
-	Data      string         `gorm:"type:text" json:"-"`
-	DataMap   map[string]any `gorm:"-" json:"data"`
-	CreatedAt time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID             int64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	AppID          string         `gorm:"column:app_id;not null;default:'';uniqueIndex:idx_entity_tenant_name,priority:1" json:"app_id"`
+	ExternalUserID string         `gorm:"column:external_user_id;not null;default:'';uniqueIndex:idx_entity_tenant_name,priority:2" json:"external_user_id"`
+	Name           string         `gorm:"not null;uniqueIndex:idx_entity_tenant_name,priority:3" json:"name"`
+	Data           string         `gorm:"type:text" json:"-"`
+	DataMap        map[string]any `gorm:"-" json:"data"`
+	Relations      []Relation     `gorm:"-" json:"relations,omitempty"` // only set for lookups with asOf
+	Memories       []Memory       `gorm:"-" json:"memories,omitempty"`  // linked memories, only set for single lookups
+	CreatedAt      time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// MemoryEntity links a memory to an entity it mentions (filled by the entity extraction pipeline).
+type MemoryEntity struct {
+	MemoryID  int64     `gorm:"column:memory_id;primaryKey;autoIncrement:false" json:"memory_id"`
+	EntityID  int64     `gorm:"column:entity_id;primaryKey;autoIncrement:false;index" json:"entity_id"`
+	CreatedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
 }
 
 type Relation struct {
-	ID        int64      `gorm:"primaryKey;autoIncrement" json:"id"`
-	From      string     `gorm:"column:from_entity;not null" json:"from"`
-	To        string     `gorm:"column:to_entity;not null" json:"to"`
-	Type      string     `gorm:"column:type;not null" json:"type"`
-	ValidFrom *time.Time `gorm:"column:valid_from" json:"valid_from,omitempty"`
-	ValidTo   *time.Time `gorm:"column:valid_to" json:"valid_to,omitempty"`
-	CreatedAt time.Time  `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	ID             int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	AppID          string     `gorm:"column:app_id;not null;default:'';index:idx_relation_tenant,priority:1" json:"app_id"`
+	ExternalUserID string     `gorm:"column:external_user_id;not null;default:'';index:idx_relation_tenant,priority:2" json:"external_user_id"`
+	From           string     `gorm:"column:from_entity;not null" json:"from"`
+	To             string     `gorm:"column:to_entity;not null" json:"to"`
+	Type           string     `gorm:"column:type;not null" json:"type"`
+	ValidFrom      *time.Time `gorm:"column:valid_from" json:"valid_from,omitempty"`
+	ValidTo        *time.Time `gorm:"column:valid_to" json:"valid_to,omitempty"`
+	CreatedAt      time.Time  `gorm:"column:created_at;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// ValidAt reports whether the relation is valid at t. A missing ValidFrom/ValidTo means unbounded;
+// ValidTo is exclusive.
+func (r *Relation) ValidAt(t time.Time) bool {
+	if r.ValidFrom != nil && t.Before(*r.ValidFrom) {
+		return false
+	}
+	if r.ValidTo != nil && !t.Before(*r.ValidTo) {
+		return false
+	}
+	return true
+}
+
+// Overlaps reports whether the validity interval of the relation overlaps [from, to).
+// nil bounds are unbounded.
+func (r *Relation) Overlaps(from, to *time.Time) bool {
+	if to != nil && r.ValidFrom != nil && !r.ValidFrom.Before(*to) {
+		return false
+	}
+	if from != nil && r.ValidTo != nil && !from.Before(*r.ValidTo) {
+		return false
+	}
+	return true
 }
 
 type Bundle struct {
@@ -126,6 +185,91 @@ type Webhook struct {
 	UpdatedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
+// Webhook delivery status values
+const (
+	DeliveryStatusPending   = "pending"   // waiting for the (next) attempt
+	DeliveryStatusDelivered = "delivered" // receiver answered 2xx
+	DeliveryStatusDead      = "dead"      // max attempts reached (dead letter), only redeliver revives it
+)
+
+// WebhookDelivery is one event queued for one webhook. Payload is the exact JSON body, so retries
+// and redeliveries send the same bytes (and signature) as the first attempt. AppID/ExternalUserID are
+// the tenant of the event; with field encryption the payload is sealed with its data key.
+type WebhookDelivery struct {
+	ID             int64                    `gorm:"primaryKey;autoIncrement" json:"id"`
+	WebhookID      int64                    `gorm:"column:webhook_id;not null;index" json:"webhook_id"`
+	EventID        int64                    `gorm:"column:event_id;index" json:"event_id,omitempty"` // entry of the event log (0 = not logged)
+	AppID          string                   `gorm:"column:app_id;index" json:"app_id,omitempty"`
+	ExternalUserID string                   `gorm:"column:external_user_id;not null;default:''" json:"external_user_id,omitempty"`
+	Event          string                   `gorm:"not null" json:"event"`
+	Payload        string                   `gorm:"type:text;not null;serializer:sealed" json:"payload"`
+	Status         string                   `gorm:"not null;default:'pending';index:idx_delivery_due,priority:1" json:"status"`
+	Attempts       int                      `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts    int                      `gorm:"not null;default:0" json:"max_attempts"`
+	NextAttemptAt  *time.Time               `gorm:"index:idx_delivery_due,priority:2" json:"next_attempt_at,omitempty"`
+	LastStatusCode int                      `json:"last_status_code,omitempty"`
+	LastError      string                   `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time               `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time                `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time                `gorm:"not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+	History        []WebhookDeliveryAttempt `gorm:"-" json:"history,omitempty"`
+}
+
+// WebhookDeliveryAttempt records one HTTP attempt of a delivery.
+type WebhookDeliveryAttempt struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	DeliveryID int64     `gorm:"column:delivery_id;not null;index" json:"delivery_id"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// Event is an entry of the persisted event log: every event emitted to webhooks is recorded here as
+// well. ID is the monotonic sequence used as SSE event id (Last-Event-ID resume).
+type Event struct {
+	ID             int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Type           string    `gorm:"not null;index" json:"type"`
+	AppID          string    `gorm:"column:app_id;not null;default:'';index:idx_event_tenant,priority:1" json:"app_id"`
+	ExternalUserID string    `gorm:"column:external_user_id;not null;default:'';index:idx_event_tenant,priority:2" json:"external_user_id"`
+	Payload        string    `gorm:"type:text;not null;serializer:sealed" json:"payload"` // JSON {event, timestamp, data} as sent to webhooks
+	CreatedAt      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index" json:"created_at"`
+}
+
+// Change kinds (Change.Kind) and operations (Change.Op)
+const (
+	ChangeKindMemory       = "memory"
+	ChangeKindMemoryEntity = "memory_entities" // entity links of a memory (ObjectID = memory id)
+	ChangeKindBundle       = "bundle"
+	ChangeKindEntity       = "entity"
+	ChangeKindRelation     = "relation"
+	ChangeKindWebhook      = "webhook"
+	ChangeKindAgentContext = "agent_context"
+
+	ChangeOpCreate = "create"
+	ChangeOpUpdate = "update"
+	ChangeOpDelete = "delete"
+)
+
+// ChangeKinds lists all change kinds.
+var ChangeKinds = []string{ChangeKindMemory, ChangeKindMemoryEntity, ChangeKindBundle, ChangeKindEntity, ChangeKindRelation, ChangeKindWebhook, ChangeKindAgentContext}
+
+// Change is an entry of the append-only change log. Every mutation of the store writes its changes in
+// the same transaction; Seq is strictly increasing (never reused), so consumers can follow the log
+// with /changes?since=<last seq>.
+type Change struct {
+	Seq            int64          `gorm:"column:seq;primaryKey;autoIncrement" json:"seq"`
+	Kind           string         `gorm:"not null;index:idx_change_kind,priority:1" json:"kind"`
+	ObjectID       int64          `gorm:"column:object_id;not null;index:idx_change_kind,priority:2" json:"object_id"`
+	Op             string         `gorm:"not null" json:"op"`
+	AppID          string         `gorm:"column:app_id;not null;default:'';index:idx_change_tenant,priority:1" json:"app_id"`
+	ExternalUserID string         `gorm:"column:external_user_id;not null;default:'';index:idx_change_tenant,priority:2" json:"external_user_id"`
+	Data           string         `gorm:"type:text;serializer:sealed" json:"-"` // JSON snapshot after the change (before it, for delete)
+	DataMap        map[string]any `gorm:"-" json:"data,omitempty"`
+	CreatedAt      time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
 // AgentContext: session state / conversation history (Neutron-compatible)
 // MemoryType: episodic, semantic, procedural, working
 type AgentContext struct {
@@ -134,13 +278,109 @@ type AgentContext struct {
 	ExternalUserID string         `gorm:"column:external_user_id;not null;index" json:"external_user_id"`
 	AgentID        string         `gorm:"column:agent_id;not null;index" json:"agent_id"`
 	MemoryType     string         `gorm:"column:memory_type;not null;index" json:"memory_type"` // episodic, semantic, procedural, working
-	Payload        string         `gorm:"type:text;not null" json:"-"`                          // JSON
+	Payload        string         `gorm:"type:text;not null;serializer:sealed" json:"-"`        // JSON
 	PayloadMap     map[string]any `gorm:"-" json:"payload,omitempty"`
 	Tags           string         `gorm:"type:text" json:"-"` // optional comma-separated or JSON array
 	CreatedAt      time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt      time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
+// TenantKey is a data encryption key of a tenant for field-level encryption (see
+// store.EnableFieldEncryption), stored wrapped with the master key MasterKeyID. The newest key of a
+// tenant encrypts new values; older keys stay until no value references them (key rotation).
+type TenantKey struct {
+	ID             int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	KeyID          string    `gorm:"column:key_id;not null;uniqueIndex" json:"key_id"`
+	AppID          string    `gorm:"column:app_id;not null;default:'';index:idx_tenant_key_tenant,priority:1" json:"app_id"`
+	ExternalUserID string    `gorm:"column:external_user_id;not null;default:'';index:idx_tenant_key_tenant,priority:2" json:"external_user_id"`
+	WrappedKey     []byte    `gorm:"column:wrapped_key;type:blob;not null" json:"-"`
+	MasterKeyID    string    `gorm:"column:master_key_id;not null" json:"master_key_id"`
+	CreatedAt      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// Audit outcomes (AuditEvent.Outcome)
+const (
+	AuditOutcomeSuccess = "success" // status < 400
+	AuditOutcomeDenied  = "denied"  // 401/403, e.g. tenant not allowed for the key
+	AuditOutcomeFailure = "failure" // other errors
+)
+
+// AuditEvent is an entry of the audit log of sensitive operations (see audit.Logger): who (API key or
+// JWT subject) did what on which tenant and targets, from where, and whether it succeeded.
+type AuditEvent struct {
+	ID             int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Operation      string    `gorm:"not null;index" json:"operation"`
+	ActorKeyID     int64     `gorm:"column:actor_key_id;not null;default:0;index" json:"actor_key_id"` // stored API key, 0 for CORTEX_API_KEY, JWTs and open mode
+	Actor          string    `gorm:"not null" json:"actor"`                                            // key name, jwt:<sub> or anonymous
+	AppID          string    `gorm:"column:app_id;not null;default:'';index:idx_audit_tenant,priority:1" json:"app_id"`
+	ExternalUserID string    `gorm:"column:external_user_id;not null;default:'';index:idx_audit_tenant,priority:2" json:"external_user_id"`
+	TargetIDs      string    `gorm:"column:target_ids" json:"-"` // comma-separated
+	Targets        []string  `gorm:"-" json:"target_ids"`
+	Method         string    `gorm:"not null" json:"method"`
+	Path           string    `gorm:"type:text;not null" json:"path"` // with query
+	ClientIP       string    `gorm:"column:client_ip" json:"client_ip"`
+	Status         int       `gorm:"not null" json:"status"`
+	Outcome        string    `gorm:"not null;index" json:"outcome"`
+	Error          string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index" json:"created_at"`
+}
+
+// SplitTargets fills Targets from TargetIDs.
+func (e *AuditEvent) SplitTargets() {
+	e.Targets = splitList(e.TargetIDs)
+}
+
+// API key scopes
+const (
+	ScopeRead  = "read"  // read endpoints (GET, /seeds/query)
+	ScopeWrite = "write" // endpoints that change data
+	ScopeAdmin = "admin" // /admin/*, backup/restore, key management
+)
+
+// APIKey is an API key of the server (store.CreateAPIKey). Only the SHA-256 of the key is stored;
+// Prefix identifies it in listings. AppIDs and Scopes are comma-separated, empty AppIDs = all apps.
+type APIKey struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	KeyHash    string     `gorm:"column:key_hash;not null;uniqueIndex" json:"-"`
+	AppIDs     string     `gorm:"column:app_ids" json:"-"`
+	Scopes     string     `gorm:"not null" json:"-"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// HasScope reports whether the key grants scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(splitList(k.Scopes), scope)
+}
+
+// AllowsApp reports whether the key may access appID.
+func (k *APIKey) AllowsApp(appID string) bool {
+	return k.AppIDs == "" || slices.Contains(splitList(k.AppIDs), appID)
+}
+
+// Expired reports whether the key is expired at t.
+func (k *APIKey) Expired(t time.Time) bool {
+	return k.ExpiresAt != nil && !t.Before(*k.ExpiresAt)
+}
+
+// Principal returns the request principal for the key (see helpers.Principal).
+func (k *APIKey) Principal() *helpers.Principal {
+	return &helpers.Principal{Name: k.Name, KeyID: k.ID, AppIDs: splitList(k.AppIDs), Scopes: splitList(k.Scopes)}
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 type Stats struct {
 	Memories  int64 `json:"memories"`
 	Entities  int64 `json:"entities"`
@@ -162,14 +402,28 @@ type RememberResponse struct {
 }
 
 type FactRequest struct {
+	TenantRequest
 	Key   string `json:"key"`
 	Value any    `json:"value"`
 }
 
 type RelationRequest struct {
-	From string `json:"from"`
-	To   string `json:"to"`
-	Type string `json:"type"`
+	TenantRequest
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Type      string     `json:"type"`
+	ValidFrom *time.Time `json:"validFrom,omitempty"` // start of validity (default: unbounded, or now if superseding/reopening)
+	ValidTo   *time.Time `json:"validTo,omitempty"`   // end of validity, exclusive (default: open)
+	Supersede bool       `json:"supersede,omitempty"` // close other open relations with same from and type (e.g. job change)
+}
+
+// CloseRelationRequest ends the validity of an open relation.
+type CloseRelationRequest struct {
+	TenantRequest
+	From string     `json:"from"`
+	To   string     `json:"to"`
+	Type string     `json:"type"`
+	At   *time.Time `json:"at,omitempty"` // end of validity (default: now)
 }
 
 // TenantRequest provides common tenant parameter fields and getters
@@ -190,11 +444,14 @@ type StoreSeedRequest struct {
 	BundleID    *int64         `json:"bundleId,omitempty"`
 	TTLSeconds  *int           `json:"ttlSeconds,omitempty"`  // optional: set ExpiresAt = now + ttlSeconds
 	ExpiresAt   *time.Time     `json:"expiresAt,omitempty"`   // optional: explicit expiry (ISO8601)
+	Dedupe      bool           `json:"dedupe,omitempty"`      // optional: return the existing memory instead of storing an exact duplicate
 }
 
 type StoreSeedResponse struct {
-	ID      int64  `json:"id"`
-	Message string `json:"message"`
+	ID          int64  `json:"id"`
+	Message     string `json:"message"`
+	ContentHash string `json:"content_hash,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"` // memory already existed (dedupe)
 }
 
 type QuerySeedRequest struct {
@@ -205,14 +462,32 @@ type QuerySeedRequest struct {
 	Threshold     float64        `json:"threshold,omitempty"` // 0-1, default 0; only return results with similarity >= threshold
 	SeedIDs       []int64        `json:"seedIds,omitempty"`   // optional: limit search to these memory IDs
 	MetadataFilter map[string]any `json:"metadataFilter,omitempty"` // optional: filter by metadata fields (e.g., {"typ": "persönlich", "kategorie": "präferenz"})
+	Mode          string         `json:"mode,omitempty"`      // semantic (default), keyword (BM25) or hybrid (fusion of both)
+	Weights       *SearchWeights `json:"weights,omitempty"`   // optional: fusion weights for mode hybrid (default 1/1)
+	Entity        string         `json:"entity,omitempty"`    // optional: only memories linked to this entity
+}
+
+// Search modes for QuerySeedRequest.Mode
+const (
+	SearchModeSemantic = "semantic"
+	SearchModeKeyword  = "keyword"
+	SearchModeHybrid   = "hybrid"
+)
+
+// SearchWeights weights the vector and keyword ranking in hybrid search (reciprocal rank fusion).
+type SearchWeights struct {
+	Vector  float64 `json:"vector"`
+	Keyword float64 `json:"keyword"`
 }
 
 type QuerySeedResult struct {
-	ID         int64          `json:"id"`
-	Content    string         `json:"content"`
-	Metadata   map[string]any `json:"metadata"`
-	CreatedAt  time.Time      `json:"created_at"`
-	Similarity float64        `json:"similarity"`
+	ID          int64          `json:"id"`
+	Content     string         `json:"content"`
+	ContentHash string         `json:"content_hash,omitempty"`
+	Metadata    map[string]any `json:"metadata"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Similarity  float64        `json:"similarity"`
+	Score       float64        `json:"score,omitempty"` // fused score 0-1 (mode keyword/hybrid)
 }
 
 type DeleteSeedResponse struct {
@@ -255,6 +530,14 @@ type CreateWebhookRequest struct {
 	AppID  string   `json:"appId,omitempty"`
 }
 
+// UpdateWebhookRequest is the body for PATCH /webhooks/:id; omitted fields stay unchanged.
+type UpdateWebhookRequest struct {
+	URL    *string  `json:"url,omitempty"`
+	Events []string `json:"events,omitempty"`
+	Secret *string  `json:"secret,omitempty"`
+	Active *bool    `json:"active,omitempty"`
+}
+
 type WebhookResponse struct {
 	ID        int64     `json:"id"`
 	URL       string    `json:"url"`
@@ -287,6 +570,41 @@ type AgentContextResponse struct {
 	UpdatedAt      time.Time      `json:"updated_at"`
 }
 
+// API Key Types
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	AppIDs    []string   `json:"appIds,omitempty"`    // empty: all apps
+	Scopes    []string   `json:"scopes,omitempty"`    // default: read, write
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // optional expiry (ISO8601)
+}
+
+type APIKeyResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	AppIDs     []string   `json:"app_ids"` // empty: all apps
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Key        string     `json:"key,omitempty"` // only in the response to create
+}
+
+// ToAPIKeyResponse converts an APIKey model to APIKeyResponse
+func (k *APIKey) ToAPIKeyResponse() APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		AppIDs:     splitList(k.AppIDs),
+		Scopes:     splitList(k.Scopes),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
 // ToWebhookResponse converts a Webhook model to WebhookResponse
 // eventsStr should be the comma-separated events string from the model
 func (w *Webhook) ToWebhookResponse(eventsStr string) WebhookResponse {
@@ -0,0 +1,193 @@
+package reembed
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/store"
+)
+
+// Job status values
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// DefaultBatchSize is the number of memories embedded per batch.
+const DefaultBatchSize = 32
+
+// ErrJobRunning is returned when a re-embed job for the tenant is already running.
+var ErrJobRunning = errors.New("re-embed job already running for this tenant")
+
+// Job describes a re-embed run for one tenant. Progress fields are updated after every batch.
+type Job struct {
+	ID             int64      `json:"id"`
+	AppID          string     `json:"appId"`
+	ExternalUserID string     `json:"externalUserId"`
+	Model          string     `json:"model"`
+	Status         string     `json:"status"`
+	Total          int64      `json:"total"`
+	Processed      int64      `json:"processed"`
+	Skipped        int64      `json:"skipped"`
+	Error          string     `json:"error,omitempty"`
+	StartedAt      time.Time  `json:"startedAt"`
+	FinishedAt     *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Manager runs re-embed jobs in the background (one per tenant at a time). Jobs are kept in memory;
+// a job interrupted by a restart can simply be started again, already migrated memories are skipped.
+type Manager struct {
+	store   *store.CortexStore
+	service func() embeddings.EmbeddingService
+
+	mu      sync.Mutex
+	nextID  int64
+	jobs    map[int64]*Job
+	cancels map[int64]context.CancelFunc
+}
+
+// NewManager creates a manager that re-embeds with the configured embedding service.
+func NewManager(s *store.CortexStore) *Manager {
+	return NewManagerWithService(s, embeddings.GetEmbeddingService)
+}
+
+// NewManagerWithService creates a manager with an explicit embedding service source (tests).
+func NewManagerWithService(s *store.CortexStore, service func() embeddings.EmbeddingService) *Manager {
+	return &Manager{
+		store:   s,
+		service: service,
+		jobs:    make(map[int64]*Job),
+		cancels: make(map[int64]context.CancelFunc),
+	}
+}
+
+// Start launches a re-embed job for the tenant and returns its initial state.
+func (m *Manager) Start(appID, externalUserID string, batchSize int) (Job, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	service := m.service()
+	modelID := embeddings.ModelIDOf(service)
+
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		if j.AppID == appID && j.ExternalUserID == externalUserID && j.Status == StatusRunning {
+			m.mu.Unlock()
+			return Job{}, ErrJobRunning
+		}
+	}
+	total, err := m.store.CountMemoriesNeedingReembed(appID, externalUserID, modelID)
+	if err != nil {
+		m.mu.Unlock()
+		return Job{}, err
+	}
+	m.nextID++
+	job := &Job{
+		ID:             m.nextID,
+		AppID:          appID,
+		ExternalUserID: externalUserID,
+		Model:          modelID,
+		Status:         StatusRunning,
+		Total:          total,
+		StartedAt:      time.Now(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.jobs[job.ID] = job
+	m.cancels[job.ID] = cancel
+	snapshot := *job
+	m.mu.Unlock()
+
+	slog.Info("re-embed job started", "jobId", job.ID, "appId", appID, "userId", externalUserID, "model", modelID, "total", total)
+	go m.run(ctx, job, service, batchSize)
+	return snapshot, nil
+}
+
+func (m *Manager) run(ctx context.Context, job *Job, service embeddings.EmbeddingService, batchSize int) {
+	var lastID int64
+	status, errMsg := StatusCompleted, ""
+	for {
+		if ctx.Err() != nil {
+			status = StatusCancelled
+			break
+		}
+		batch, err := m.store.ListMemoriesNeedingReembed(job.AppID, job.ExternalUserID, job.Model, lastID, batchSize)
+		if err != nil {
+			status, errMsg = StatusFailed, err.Error()
+			break
+		}
+		if len(batch) == 0 {
+			break
+		}
+		updated, err := m.store.ReembedMemories(batch, service)
+		if err != nil {
+			status, errMsg = StatusFailed, err.Error()
+			break
+		}
+		lastID = batch[len(batch)-1].ID
+
+		m.mu.Lock()
+		job.Processed += int64(updated)
+		job.Skipped += int64(len(batch) - updated)
+		m.mu.Unlock()
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	job.Status, job.Error, job.FinishedAt = status, errMsg, &now
+	if cancel := m.cancels[job.ID]; cancel != nil {
+		cancel()
+		delete(m.cancels, job.ID)
+	}
+	processed := job.Processed
+	m.mu.Unlock()
+
+	if status == StatusFailed {
+		slog.Error("re-embed job failed", "jobId", job.ID, "processed", processed, "error", errMsg)
+	} else {
+		slog.Info("re-embed job finished", "jobId", job.ID, "status", status, "processed", processed)
+	}
+}
+
+// Get returns a snapshot of a job.
+func (m *Manager) Get(id int64) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List returns the jobs of a tenant, newest first.
+func (m *Manager) List(appID, externalUserID string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0)
+	for _, j := range m.jobs {
+		if j.AppID == appID && j.ExternalUserID == externalUserID {
+			jobs = append(jobs, *j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID > jobs[b].ID })
+	return jobs
+}
+
+// Cancel stops a running job after its current batch. Returns false if the job is not running.
+func (m *Manager) Cancel(id int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cancel, ok := m.cancels[id]
+	if !ok {
+		return false
+	}
+	cancel()
+	return true
+}
@@ -0,0 +1,130 @@
+package reembed
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+// fakeModel liefert 8-dimensionale Embeddings unter eigener Modell-ID
+type fakeModel struct{}
+
+func (fakeModel) ModelID() string { return "fake-8" }
+
+func (f fakeModel) GenerateEmbedding(content string, contentType string) ([]float32, error) {
+	v := make([]float32, 8)
+	for i, c := range content {
+		v[i%8] += float32(c)
+	}
+	return embeddings.Normalize(v), nil
+}
+
+func (f fakeModel) GenerateEmbeddingsBatch(contents []string, contentType string) ([][]float32, error) {
+	out := make([][]float32, len(contents))
+	for i, c := range contents {
+		out[i], _ = f.GenerateEmbedding(c, contentType)
+	}
+	return out, nil
+}
+
+func setupTestStore(t *testing.T) *store.CortexStore {
+	t.Helper()
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCortexStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func waitForJob(t *testing.T, m *Manager, id int64) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := m.Get(id); job.Status != StatusRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("reembed job did not finish in time")
+	return Job{}
+}
+
+func TestManagerReembedsTenant(t *testing.T) {
+	s := setupTestStore(t)
+	for i := 0; i < 5; i++ {
+		mem := &models.Memory{Type: "semantic", Content: "memory number " + string(rune('a'+i)), AppID: "app1", ExternalUserID: "user1", Importance: 5}
+		s.CreateMemory(mem)
+		// Bestehende Embeddings des Hash-Services (anderes Modell, andere Dimension)
+		if i < 3 {
+			s.GenerateEmbeddingForMemory(mem)
+		}
+	}
+	other := &models.Memory{Type: "semantic", Content: "other tenant", AppID: "app2", ExternalUserID: "user2", Importance: 5}
+	s.CreateMemory(other)
+	s.GenerateEmbeddingForMemory(other)
+
+	m := NewManagerWithService(s, func() embeddings.EmbeddingService { return fakeModel{} })
+	job, err := m.Start("app1", "user1", 2)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if job.Total != 5 || job.Model != "fake-8" {
+		t.Errorf("unexpected initial job: %+v", job)
+	}
+
+	job = waitForJob(t, m, job.ID)
+	if job.Status != StatusCompleted || job.Processed != 5 {
+		t.Fatalf("expected completed job with 5 processed, got %+v", job)
+	}
+	if pending, _ := s.CountMemoriesNeedingReembed("app1", "user1", "fake-8"); pending != 0 {
+		t.Errorf("expected no pending memories, got %d", pending)
+	}
+	// Anderer Tenant bleibt unverändert
+	if pending, _ := s.CountMemoriesNeedingReembed("app2", "user2", "fake-8"); pending != 1 {
+		t.Errorf("expected other tenant untouched, got %d pending", pending)
+	}
+
+	mems, _ := s.ListMemoriesByTenant("app1", "user1", 10, 0, false)
+	for _, mem := range mems {
+		if mem.EmbeddingModel != "fake-8" || mem.EmbeddingDim != 8 {
+			t.Errorf("memory %d: expected fake-8/8, got %s/%d", mem.ID, mem.EmbeddingModel, mem.EmbeddingDim)
+		}
+	}
+
+	if jobs := m.List("app1", "user1"); len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("expected job in tenant list, got %+v", jobs)
+	}
+	if jobs := m.List("app2", "user2"); len(jobs) != 0 {
+		t.Errorf("expected no jobs for other tenant, got %d", len(jobs))
+	}
+}
+
+func TestManagerRejectsConcurrentJob(t *testing.T) {
+	s := setupTestStore(t)
+	for i := 0; i < 50; i++ {
+		s.CreateMemory(&models.Memory{Type: "semantic", Content: "bulk memory", AppID: "app1", ExternalUserID: "user1", Importance: 5})
+	}
+	m := NewManagerWithService(s, func() embeddings.EmbeddingService { return fakeModel{} })
+
+	first, err := m.Start("app1", "user1", 1)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err := m.Start("app1", "user1", 1); err != ErrJobRunning {
+		if job, _ := m.Get(first.ID); job.Status == StatusRunning {
+			t.Errorf("expected ErrJobRunning while first job runs, got %v", err)
+		}
+	}
+	m.Cancel(first.ID)
+	if job := waitForJob(t, m, first.ID); job.Status != StatusCancelled && job.Status != StatusCompleted {
+		t.Errorf("expected cancelled or completed job, got %s", job.Status)
+	}
+	if m.Cancel(first.ID) {
+		t.Error("expected Cancel to return false for finished job")
+	}
+}
@@ -63,7 +63,7 @@ func (s *CortexStore) GetAnalytics(appID, externalUserID string, days int) (*Ana
 		SELECT 
 			(SELECT COUNT(*) FROM memories WHERE app_id = ? AND external_user_id = ?) as total_memories,
 			(SELECT COUNT(*) FROM bundles WHERE app_id = ? AND external_user_id = ?) as total_bundles,
-			(SELECT COUNT(*) FROM memories WHERE app_id = ? AND external_user_id = ? AND embedding != '' AND embedding IS NOT NULL) as memories_with_embeddings
+			(SELECT COUNT(*) FROM memories WHERE app_id = ? AND external_user_id = ? AND length(embedding) > 0) as memories_with_embeddings
 	`, appID, externalUserID, appID, externalUserID, appID, externalUserID).Scan(&counts).Error
 
 	if err != nil {
@@ -195,7 +195,7 @@ func (s *CortexStore) GetGlobalAnalytics(days int) (*AnalyticsData, error) {
 		SELECT 
 			(SELECT COUNT(*) FROM memories) as total_memories,
 			(SELECT COUNT(*) FROM bundles) as total_bundles,
-			(SELECT COUNT(*) FROM memories WHERE embedding != '' AND embedding IS NOT NULL) as memories_with_embeddings,
+			(SELECT COUNT(*) FROM memories WHERE length(embedding) > 0) as memories_with_embeddings,
 			(SELECT COUNT(*) FROM webhooks) as webhooks_count
 	`).Scan(&counts).Error
 
@@ -20,7 +20,7 @@ func TestGetAnalytics(t *testing.T) {
 		AppID:          appID,
 		ExternalUserID: userID,
 		Importance:     5,
-		Embedding:      "test-embedding",
+		Embedding:      []byte("test-embedding"),
 	}
 	mem2 := &models.Memory{
 		Type:           "episodic",
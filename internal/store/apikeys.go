@@ -0,0 +1,121 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every API key (same format as the keys cortex-cli used to write into .env).
+const APIKeyPrefix = "ck_"
+
+// apiKeyLastUsedInterval limits how often last_used_at is written for a busy key.
+const apiKeyLastUsedInterval = time.Minute
+
+var (
+	// ErrAPIKeyInvalid is returned for unknown keys.
+	ErrAPIKeyInvalid = errors.New("invalid API key")
+	// ErrAPIKeyExpired is returned for keys past their expiry.
+	ErrAPIKeyExpired = errors.New("API key expired")
+	// ErrAPIKeyRequest wraps validation errors of CreateAPIKey.
+	ErrAPIKeyRequest = errors.New("invalid API key request")
+)
+
+// CreateAPIKey creates an API key and returns it in plaintext; only its hash is stored. scopes
+// defaults to read and write; empty appIDs allows all apps. Admin keys act on all tenants and
+// cannot be restricted to apps.
+func (s *CortexStore) CreateAPIKey(name string, appIDs, scopes []string, expiresAt *time.Time) (string, *models.APIKey, error) {
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeRead, models.ScopeWrite}
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case models.ScopeRead, models.ScopeWrite, models.ScopeAdmin:
+		default:
+			return "", nil, fmt.Errorf("%w: unknown scope %q (read, write, admin)", ErrAPIKeyRequest, scope)
+		}
+	}
+	if slices.Contains(scopes, models.ScopeAdmin) && len(appIDs) > 0 {
+		return "", nil, fmt.Errorf("%w: admin keys cannot be restricted to apps", ErrAPIKeyRequest)
+	}
+	for _, appID := range appIDs {
+		if appID == "" || strings.Contains(appID, ",") {
+			return "", nil, fmt.Errorf("%w: invalid app ID %q", ErrAPIKeyRequest, appID)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, fmt.Errorf("%w: expiresAt must be in the future", ErrAPIKeyRequest)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	key := APIKeyPrefix + hex.EncodeToString(buf)
+	row := &models.APIKey{
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+8],
+		KeyHash:   helpers.ContentHash(key),
+		AppIDs:    strings.Join(appIDs, ","),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(row).Error; err != nil {
+		return "", nil, err
+	}
+	return key, row, nil
+}
+
+// ListAPIKeys returns all API keys (without the keys themselves).
+func (s *CortexStore) ListAPIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// DeleteAPIKey revokes an API key (gorm.ErrRecordNotFound if it does not exist).
+func (s *CortexStore) DeleteAPIKey(id int64) error {
+	res := s.db.Delete(&models.APIKey{}, id)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return res.Error
+}
+
+// HasAPIKeys reports whether any API key exists (without keys the server runs without auth).
+func (s *CortexStore) HasAPIKeys() (bool, error) {
+	var ids []int64
+	err := s.db.Model(&models.APIKey{}).Limit(1).Pluck("id", &ids).Error
+	return len(ids) > 0, err
+}
+
+// AuthenticateAPIKey returns the stored key for key and records its use.
+func (s *CortexStore) AuthenticateAPIKey(key string) (*models.APIKey, error) {
+	var row models.APIKey
+	err := s.db.Where("key_hash = ?", helpers.ContentHash(key)).Limit(1).Find(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	if row.ID == 0 {
+		return nil, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if row.Expired(now) {
+		return nil, ErrAPIKeyExpired
+	}
+	if row.LastUsedAt == nil || now.Sub(*row.LastUsedAt) >= apiKeyLastUsedInterval {
+		// Direkt per SQL, ohne Callbacks; ein Fehler soll die Anfrage nicht abweisen
+		s.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, row.ID)
+		row.LastUsedAt = &now
+	}
+	return &row, nil
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	if has, err := s.HasAPIKeys(); err != nil || has {
+		t.Fatalf("HasAPIKeys on empty store = %v, %v", has, err)
+	}
+	key, row, err := s.CreateAPIKey("agent", []string{"app1", "app2"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || !strings.HasPrefix(key, row.Prefix) || row.KeyHash == key {
+		t.Fatalf("key %q, row %+v", key, row)
+	}
+	if !row.HasScope(models.ScopeRead) || !row.HasScope(models.ScopeWrite) || row.HasScope(models.ScopeAdmin) {
+		t.Errorf("default scopes: %q", row.Scopes)
+	}
+	if has, _ := s.HasAPIKeys(); !has {
+		t.Error("HasAPIKeys after create = false")
+	}
+
+	got, err := s.AuthenticateAPIKey(key)
+	if err != nil || got.ID != row.ID || got.LastUsedAt == nil {
+		t.Fatalf("AuthenticateAPIKey: %v %+v", err, got)
+	}
+	if !got.AllowsApp("app2") || got.AllowsApp("app3") {
+		t.Errorf("app restriction: %q", got.AppIDs)
+	}
+	keys, err := s.ListAPIKeys()
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("last_used_at not stored: %v %+v", err, keys)
+	}
+	if _, err := s.AuthenticateAPIKey(key + "x"); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("unknown key: expected ErrAPIKeyInvalid, got %v", err)
+	}
+
+	// Abgelaufene Keys werden abgewiesen
+	expiry := time.Now().Add(time.Hour)
+	expiring, _, err := s.CreateAPIKey("temp", nil, []string{models.ScopeRead}, &expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(expiring); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Exec("UPDATE api_keys SET expires_at = ? WHERE key_hash = ?", time.Now().Add(-time.Second), helpers.ContentHash(expiring))
+	if _, err := s.AuthenticateAPIKey(expiring); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("expired key: expected ErrAPIKeyExpired, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		apps, scopes []string
+		expires      *time.Time
+	}{
+		"unknown scope":    {scopes: []string{"delete"}},
+		"restricted admin": {apps: []string{"app1"}, scopes: []string{models.ScopeAdmin}},
+		"invalid app":      {apps: []string{"a,b"}},
+		"expiry in past":   {expires: &time.Time{}},
+	} {
+		if _, _, err := s.CreateAPIKey(name, tc.apps, tc.scopes, tc.expires); !errors.Is(err, ErrAPIKeyRequest) {
+			t.Errorf("%s: expected ErrAPIKeyRequest, got %v", name, err)
+		}
+	}
+
+	if err := s.DeleteAPIKey(row.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(key); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("deleted key: expected ErrAPIKeyInvalid, got %v", err)
+	}
+	if err := s.DeleteAPIKey(row.ID); !helpers.IsNotFoundError(err) {
+		t.Errorf("delete twice: expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"cortex/internal/models"
+)
+
+// AuditFilter restricts the audit log. Empty fields do not filter.
+type AuditFilter struct {
+	Operations     []string // exact operation or "<prefix>.*" (e.g. backup.*)
+	ActorKeyID     int64
+	Actor          string
+	AppID          string
+	ExternalUserID string
+	Outcome        string
+	Since          *time.Time // created_at >= Since
+	Until          *time.Time // created_at < Until
+}
+
+// AuditPage is one page of the audit log, newest first.
+type AuditPage struct {
+	Events     []models.AuditEvent `json:"events"`
+	NextBefore int64               `json:"next_before,omitempty"` // id to pass as before for the next (older) page
+	HasMore    bool                `json:"has_more"`
+}
+
+// AppendAuditEvent appends an event to the audit log.
+func (s *CortexStore) AppendAuditEvent(ev *models.AuditEvent) error {
+	ev.TargetIDs = strings.Join(ev.Targets, ",")
+	return s.db.Create(ev).Error
+}
+
+// ListAuditEvents returns up to limit audit events with ID < before (0 = from the newest), newest first.
+func (s *CortexStore) ListAuditEvents(filter AuditFilter, before int64, limit int) (*AuditPage, error) {
+	dbQuery := s.db.Model(&models.AuditEvent{})
+	if before > 0 {
+		dbQuery = dbQuery.Where("id < ?", before)
+	}
+	if len(filter.Operations) > 0 {
+		conds := make([]string, len(filter.Operations))
+		args := make([]any, len(filter.Operations))
+		for i, op := range filter.Operations {
+			if strings.HasSuffix(op, ".*") {
+				conds[i], args[i] = "operation LIKE ?", strings.TrimSuffix(op, "*")+"%"
+			} else {
+				conds[i], args[i] = "operation = ?", op
+			}
+		}
+		dbQuery = dbQuery.Where(strings.Join(conds, " OR "), args...)
+	}
+	if filter.ActorKeyID != 0 {
+		dbQuery = dbQuery.Where("actor_key_id = ?", filter.ActorKeyID)
+	}
+	if filter.Actor != "" {
+		dbQuery = dbQuery.Where("actor = ?", filter.Actor)
+	}
+	if filter.AppID != "" {
+		dbQuery = dbQuery.Where("app_id = ?", filter.AppID)
+	}
+	if filter.ExternalUserID != "" {
+		dbQuery = dbQuery.Where("external_user_id = ?", filter.ExternalUserID)
+	}
+	if filter.Outcome != "" {
+		dbQuery = dbQuery.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.Since != nil {
+		dbQuery = dbQuery.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		dbQuery = dbQuery.Where("created_at < ?", *filter.Until)
+	}
+
+	events := make([]models.AuditEvent, 0)
+	if err := dbQuery.Order("id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	page := &AuditPage{}
+	if len(events) > limit {
+		events = events[:limit]
+		page.HasMore = true
+	}
+	for i := range events {
+		events[i].SplitTargets()
+	}
+	if page.HasMore {
+		page.NextBefore = events[len(events)-1].ID
+	}
+	page.Events = events
+	return page, nil
+}
+
+// PruneAuditEvents deletes audit events created before cutoff. Returns the number of deleted events.
+func (s *CortexStore) PruneAuditEvents(cutoff time.Time) (int64, error) {
+	res := s.db.Where("created_at < ?", cutoff).Delete(&models.AuditEvent{})
+	return res.RowsAffected, res.Error
+}
+
+// auditColumns are the columns of audit_events, listed explicitly because migrated databases may
+// have them in a different order.
+const auditColumns = "id, operation, actor_key_id, actor, app_id, external_user_id, target_ids, method, path, client_ip, status, outcome, error, created_at"
+
+// carryOverAuditLog replaces the audit log of a restored database with the one of the pre-restore
+// snapshot, so a restore does not erase the record of earlier operations.
+func carryOverAuditLog(db *sql.DB, snapshot string) error {
+	ctx := context.Background()
+	// ATTACH gilt pro Verbindung
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS snapshot", snapshot); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE snapshot")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM main.audit_events"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO main.audit_events (" + auditColumns + ") SELECT " + auditColumns + " FROM snapshot.audit_events"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
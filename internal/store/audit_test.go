@@ -0,0 +1,122 @@
+package store
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+)
+
+func TestAuditEvents(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	old := time.Now().Add(-48 * time.Hour)
+	events := []models.AuditEvent{
+		{Operation: "backup.create", Actor: "admin", ActorKeyID: 1, Outcome: models.AuditOutcomeSuccess, CreatedAt: old},
+		{Operation: "backup.restore", Actor: "admin", ActorKeyID: 1, Outcome: models.AuditOutcomeFailure},
+		{Operation: "memory.delete", Actor: "agent", ActorKeyID: 2, AppID: "app1", ExternalUserID: "alice", Targets: []string{"7"}, Outcome: models.AuditOutcomeSuccess},
+		{Operation: "webhook.delete", Actor: "agent", ActorKeyID: 2, AppID: "app2", Targets: []string{"3"}, Outcome: models.AuditOutcomeDenied},
+		{Operation: "memory.merge", Actor: "agent", ActorKeyID: 2, AppID: "app1", ExternalUserID: "alice", Targets: []string{"1", "2", "3"}, Outcome: models.AuditOutcomeSuccess},
+	}
+	for i := range events {
+		events[i].Method, events[i].Path, events[i].Status = "POST", "/x", 200
+		if err := s.AppendAuditEvent(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(page *AuditPage) []int64 {
+		var out []int64
+		for _, ev := range page.Events {
+			out = append(out, ev.ID)
+		}
+		return out
+	}
+	since := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []int64
+	}{
+		{"all, newest first", AuditFilter{}, []int64{5, 4, 3, 2, 1}},
+		{"operation prefix", AuditFilter{Operations: []string{"backup.*"}}, []int64{2, 1}},
+		{"operations", AuditFilter{Operations: []string{"memory.delete", "webhook.delete"}}, []int64{4, 3}},
+		{"actor key", AuditFilter{ActorKeyID: 1}, []int64{2, 1}},
+		{"actor name", AuditFilter{Actor: "agent"}, []int64{5, 4, 3}},
+		{"tenant", AuditFilter{AppID: "app1", ExternalUserID: "alice"}, []int64{5, 3}},
+		{"outcome", AuditFilter{Outcome: models.AuditOutcomeDenied}, []int64{4}},
+		{"since", AuditFilter{Since: &since, Operations: []string{"backup.*"}}, []int64{2}},
+		{"until", AuditFilter{Until: &since}, []int64{1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := s.ListAuditEvents(tc.filter, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(page); !slices.Equal(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	// Pagination über before/next_before
+	page, err := s.ListAuditEvents(AuditFilter{}, 0, 2)
+	if err != nil || !page.HasMore || page.NextBefore != 4 || !slices.Equal(ids(page), []int64{5, 4}) {
+		t.Fatalf("first page: %v %+v", err, page)
+	}
+	if !slices.Equal(page.Events[0].Targets, []string{"1", "2", "3"}) {
+		t.Errorf("targets = %v", page.Events[0].Targets)
+	}
+	page, err = s.ListAuditEvents(AuditFilter{}, page.NextBefore, 2)
+	if err != nil || !page.HasMore || !slices.Equal(ids(page), []int64{3, 2}) {
+		t.Fatalf("second page: %v %+v", err, page)
+	}
+	page, err = s.ListAuditEvents(AuditFilter{}, page.NextBefore, 2)
+	if err != nil || page.HasMore || page.NextBefore != 0 || !slices.Equal(ids(page), []int64{1}) {
+		t.Fatalf("last page: %v %+v", err, page)
+	}
+
+	n, err := s.PruneAuditEvents(since)
+	if err != nil || n != 1 {
+		t.Fatalf("PruneAuditEvents = %d, %v", n, err)
+	}
+	page, _ = s.ListAuditEvents(AuditFilter{}, 0, 10)
+	if len(page.Events) != 4 {
+		t.Errorf("expected 4 events after prune, got %d", len(page.Events))
+	}
+}
+
+func TestRestoreKeepsAuditLog(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	record := func(op string) {
+		t.Helper()
+		if err := s.AppendAuditEvent(&models.AuditEvent{Operation: op, Actor: "admin", Method: "POST", Path: "/" + op, Status: 200, Outcome: models.AuditOutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("backup.create")
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := s.BackupDatabase(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	record("memory.delete")
+
+	if _, err := s.RestoreDatabase(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	page, err := s.ListAuditEvents(AuditFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 || page.Events[0].Operation != "memory.delete" {
+		t.Fatalf("audit log after restore: %+v", page.Events)
+	}
+	// Neue Einträge zählen nach dem Restore weiter
+	record("backup.restore")
+}
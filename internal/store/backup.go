@@ -0,0 +1,246 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+)
+
+// restoreDrainTimeout is how long RestoreDatabase waits for running statements and transactions.
+var restoreDrainTimeout = 30 * time.Second
+
+// ErrInvalidBackup is returned when a backup file fails verification (see VerifyDatabaseFile).
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// ErrDatabaseUnavailable is returned when a failed restore left no database that could be opened; the
+// store cannot be used until the process is restarted.
+var ErrDatabaseUnavailable = errors.New("database unavailable after failed restore")
+
+// renameFile moves the verified copy over the database file (replaced in tests).
+var renameFile = os.Rename
+
+// RestoreResult describes a live restore.
+type RestoreResult struct {
+	BackupPath   string `json:"backup_path"`
+	RestoredTo   string `json:"restored_to"`
+	RollbackPath string `json:"rollback_path"` // snapshot of the database before the restore
+}
+
+// BackupDir returns the default backup directory next to the database (created if missing).
+func (s *CortexStore) BackupDir() (string, error) {
+	dbPath, err := s.GetDatabasePath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(dbPath), "backups")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	return dir, nil
+}
+
+// BackupDatabase writes a consistent snapshot of the live database to backupPath using VACUUM INTO
+// (concurrent writers are not blocked). The snapshot is written next to the target and renamed when
+// complete, so a crash never leaves a partial backup under backupPath. Existing files are not
+// overwritten.
+func (s *CortexStore) BackupDatabase(backupPath string) error {
+	return s.vacuumInto(backupPath, func(tmp string) error {
+		return s.db.Exec("VACUUM INTO ?", tmp).Error
+	})
+}
+
+// vacuumInto writes a snapshot to backupPath via vacuum (which runs VACUUM INTO on its argument).
+func (s *CortexStore) vacuumInto(backupPath string, vacuum func(tmp string) error) error {
+	if s.FileExists(backupPath) {
+		return fmt.Errorf("backup file %s already exists", backupPath)
+	}
+	tmp := backupPath + ".tmp"
+	os.Remove(tmp)
+	if err := vacuum(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to backup database: %w", err)
+	}
+	if err := os.Rename(tmp, backupPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to backup database: %w", err)
+	}
+	return nil
+}
+
+// VerifyDatabaseFile checks that path is an intact SQLite database (PRAGMA integrity_check) with the
+// cortex schema. Errors wrap ErrInvalidBackup.
+func VerifyDatabaseFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	db, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer db.Close()
+	return verifyDatabase(db)
+}
+
+func verifyDatabase(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, strings.Join(problems, "; "))
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'memories'").Scan(&tables); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("%w: not a cortex database (table memories missing)", ErrInvalidBackup)
+	}
+	return nil
+}
+
+// RestoreDatabase replaces the live database with a backup without restarting the process:
+//
+//  1. The backup is copied next to the database, verified (VerifyDatabaseFile) and migrated to the
+//     current schema. The backup file itself is not modified.
+//  2. New statements are held back until running statements and transactions are done (at most
+//     restoreDrainTimeout, else ErrDatabaseBusy).
+//  3. The live database is snapshotted into the backup directory (RestoreResult.RollbackPath);
+//     restoring that file undoes the restore. Taken while writers are held back, the snapshot
+//     contains every write before the restore and cannot be starved by a busy writer.
+//  4. The verified copy replaces the database file and the store switches to a new connection.
+//
+// If the restored database cannot be opened, the snapshot is put back and the previous state stays
+// in use. If that fails as well, the store reopens whatever file is at the database path; only if
+// that cannot be opened either, ErrDatabaseUnavailable is returned.
+func (s *CortexStore) RestoreDatabase(backupPath string) (*RestoreResult, error) {
+	s.restoreMu.Lock()
+	defer s.restoreMu.Unlock()
+
+	dbPath, err := s.GetDatabasePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyDatabaseFile(backupPath); err != nil {
+		return nil, err
+	}
+	staging := dbPath + ".restore"
+	os.Remove(staging)
+	defer os.Remove(staging)
+	if err := s.CopyFile(backupPath, staging); err != nil {
+		return nil, err
+	}
+	// Ältere Backups auf das aktuelle Schema bringen, bevor sie live gehen
+	restored, err := NewCortexStore(staging)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if err := restored.Close(); err != nil {
+		return nil, err
+	}
+
+	dir, err := s.BackupDir()
+	if err != nil {
+		return nil, err
+	}
+	result := &RestoreResult{
+		BackupPath:   backupPath,
+		RestoredTo:   dbPath,
+		RollbackPath: filepath.Join(dir, fmt.Sprintf("cortex-pre-restore-%s.db", time.Now().Format("20060102-150405.000"))),
+	}
+	if err := s.pool.pause(restoreDrainTimeout); err != nil {
+		return nil, err
+	}
+	// Pool ist angehalten: Snapshot direkt über das aktuelle Handle
+	if err := s.vacuumInto(result.RollbackPath, func(tmp string) error {
+		_, err := s.pool.current().Exec("VACUUM INTO ?", tmp)
+		return err
+	}); err != nil {
+		s.pool.resume(nil)
+		return nil, fmt.Errorf("failed to snapshot database before restore: %w", err)
+	}
+	newDB, err := s.swapDatabaseFile(staging, dbPath)
+	if err != nil {
+		slog.Error("restore failed, rolling back", "error", err, "backup", backupPath)
+		newDB, err = s.rollbackDatabaseFile(result.RollbackPath, dbPath, err)
+		if newDB == nil {
+			// Das alte Handle ist geschlossen: die Datei öffnen, die jetzt auf der Platte liegt
+			var openErr error
+			if newDB, openErr = openDatabaseFile(dbPath); openErr != nil {
+				slog.Error("restore and rollback failed, database unavailable until restart", "error", openErr, "path", dbPath)
+				err = fmt.Errorf("%w: %v (reopen: %v)", ErrDatabaseUnavailable, err, openErr)
+			}
+		}
+	} else if err := carryOverAuditLog(newDB, result.RollbackPath); err != nil {
+		// Das Audit-Log des Snapshots bleibt im rollback_path erhalten
+		slog.Error("restore: failed to carry over audit log", "error", err, "snapshot", result.RollbackPath)
+	}
+	s.pool.resume(newDB)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidateVectorIndex()
+	s.reloadDataKeys()
+	return result, nil
+}
+
+// swapDatabaseFile closes the current connection, moves src over dbPath and opens it. Must be called
+// with the pool paused.
+func (s *CortexStore) swapDatabaseFile(src, dbPath string) (*sql.DB, error) {
+	if err := s.pool.current().Close(); err != nil {
+		return nil, fmt.Errorf("failed to close database: %w", err)
+	}
+	if err := renameFile(src, dbPath); err != nil {
+		return nil, fmt.Errorf("failed to replace database file: %w", err)
+	}
+	return openDatabaseFile(dbPath)
+}
+
+// openDatabaseFile opens dbPath and checks that it can be reached.
+func openDatabaseFile(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open(sqlite.DriverName, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// rollbackDatabaseFile puts the pre-restore snapshot back after a failed swap.
+func (s *CortexStore) rollbackDatabaseFile(snapshot, dbPath string, cause error) (*sql.DB, error) {
+	tmp := dbPath + ".rollback"
+	if err := s.CopyFile(snapshot, tmp); err != nil {
+		return nil, fmt.Errorf("restore failed (%v) and rollback failed: %w", cause, err)
+	}
+	db, err := s.swapDatabaseFile(tmp, dbPath)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("restore failed (%v) and rollback failed: %w", cause, err)
+	}
+	return db, fmt.Errorf("restore failed, previous database restored: %w", cause)
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+)
+
+func countMemories(t *testing.T, s *CortexStore) int {
+	t.Helper()
+	memories, err := s.ListMemoriesByTenant("app", "u1", 1000, 0, true)
+	if err != nil {
+		t.Fatalf("ListMemoriesByTenant: %v", err)
+	}
+	return len(memories)
+}
+
+func TestRestoreDatabaseLive(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	if err := s.CreateMemory(&models.Memory{Content: "vor dem Backup", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := s.BackupDatabase(backupPath); err != nil {
+		t.Fatalf("BackupDatabase: %v", err)
+	}
+	if err := s.BackupDatabase(backupPath); err == nil {
+		t.Error("an existing backup must not be overwritten")
+	}
+	if err := VerifyDatabaseFile(backupPath); err != nil {
+		t.Fatalf("VerifyDatabaseFile: %v", err)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "nach dem Backup", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+
+	// Schreiber laufen während des Restores weiter und dürfen keine Fehler sehen
+	var stop atomic.Bool
+	var writeErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; !stop.Load(); i++ {
+			if err := s.CreateMemory(&models.Memory{Content: fmt.Sprint("parallel ", i), AppID: "app", ExternalUserID: "u2"}); err != nil {
+				writeErr = err
+				return
+			}
+		}
+	}()
+	result, err := s.RestoreDatabase(backupPath)
+	stop.Store(true)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("RestoreDatabase: %v", err)
+	}
+	if writeErr != nil {
+		t.Fatalf("concurrent write failed during restore: %v", writeErr)
+	}
+
+	if n := countMemories(t, s); n != 1 {
+		t.Fatalf("expected the backup state (1 memory), got %d", n)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "nach dem Restore", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("store must stay usable after restore: %v", err)
+	}
+
+	// Rollback: Snapshot vor dem Restore wieder einspielen
+	if _, err := s.RestoreDatabase(result.RollbackPath); err != nil {
+		t.Fatalf("RestoreDatabase(rollback): %v", err)
+	}
+	if n := countMemories(t, s); n != 2 {
+		t.Fatalf("rollback must bring back the pre-restore state (2 memories), got %d", n)
+	}
+}
+
+func TestRestoreDatabaseRejectsInvalidBackup(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	if err := s.CreateMemory(&models.Memory{Content: "live", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RestoreDatabase(garbage); !errors.Is(err, ErrInvalidBackup) {
+		t.Fatalf("expected ErrInvalidBackup, got %v", err)
+	}
+	if _, err := s.RestoreDatabase(filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, ErrInvalidBackup) {
+		t.Fatalf("expected ErrInvalidBackup for a missing file, got %v", err)
+	}
+	if n := countMemories(t, s); n != 1 {
+		t.Fatalf("live database must be untouched, got %d memories", n)
+	}
+}
+
+func TestRestoreDatabaseBusy(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := s.BackupDatabase(backupPath); err != nil {
+		t.Fatalf("BackupDatabase: %v", err)
+	}
+	old := restoreDrainTimeout
+	restoreDrainTimeout = 100 * time.Millisecond
+	defer func() { restoreDrainTimeout = old }()
+
+	tx := s.GetDB().Begin()
+	if _, err := s.RestoreDatabase(backupPath); !errors.Is(err, ErrDatabaseBusy) {
+		t.Fatalf("expected ErrDatabaseBusy while a transaction is open, got %v", err)
+	}
+	if err := tx.Rollback().Error; err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "m", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("store must stay usable after a busy restore: %v", err)
+	}
+	if _, err := s.RestoreDatabase(backupPath); err != nil {
+		t.Fatalf("RestoreDatabase after the transaction ended: %v", err)
+	}
+}
+
+func TestRestoreDatabaseRollbackFails(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	if err := s.CreateMemory(&models.Memory{Content: "live", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := s.BackupDatabase(backupPath); err != nil {
+		t.Fatalf("BackupDatabase: %v", err)
+	}
+
+	// Weder der Restore noch der Rollback können die Datei ersetzen
+	renameFile = func(string, string) error { return errors.New("disk full") }
+	defer func() { renameFile = os.Rename }()
+	if _, err := s.RestoreDatabase(backupPath); err == nil || errors.Is(err, ErrDatabaseUnavailable) {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+	// Die Datei auf der Platte wird neu geöffnet, der Store bleibt benutzbar
+	if n := countMemories(t, s); n != 1 {
+		t.Fatalf("expected 1 memory after failed restore, got %d", n)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "after", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("store must stay usable after a failed rollback: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// ChangeFilter restricts the change feed. Empty fields do not filter; AppID alone also returns
+// app-level changes (webhooks).
+type ChangeFilter struct {
+	AppID          string
+	ExternalUserID string
+	Kinds          []string
+}
+
+// ChangeFeed is one page of the change log.
+type ChangeFeed struct {
+	Changes   []models.Change `json:"changes"`
+	NextSince int64           `json:"next_since"` // seq to pass as since for the next page
+	HasMore   bool            `json:"has_more"`
+}
+
+// logChange appends a change to the change log. tx must be the transaction of the mutation, so the
+// log entry is committed (or rolled back) together with it. snapshot is stored as JSON.
+func logChange(tx *gorm.DB, kind, op string, objectID int64, appID, externalUserID string, snapshot any) error {
+	data, err := json.Marshal(changeSnapshot(snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to marshal change snapshot: %w", err)
+	}
+	return tx.Create(&models.Change{
+		Kind:           kind,
+		ObjectID:       objectID,
+		Op:             op,
+		AppID:          appID,
+		ExternalUserID: externalUserID,
+		Data:           string(data),
+	}).Error
+}
+
+// logMemoryChange logs a change of mem (snapshot without embedding; embeddings are derived from the
+// content and not logged).
+func logMemoryChange(tx *gorm.DB, op string, mem *models.Memory) error {
+	return logChange(tx, models.ChangeKindMemory, op, mem.ID, mem.AppID, mem.ExternalUserID, mem)
+}
+
+// logMemoryEntitiesChange logs the current entity links of a memory (names of the linked entities).
+func logMemoryEntitiesChange(tx *gorm.DB, mem *models.Memory) error {
+	names := make([]string, 0)
+	if err := tx.Table("memory_entities").
+		Joins("JOIN entities ON entities.id = memory_entities.entity_id").
+		Where("memory_entities.memory_id = ?", mem.ID).
+		Order("entities.name").
+		Pluck("entities.name", &names).Error; err != nil {
+		return err
+	}
+	return logChange(tx, models.ChangeKindMemoryEntity, models.ChangeOpUpdate, mem.ID, mem.AppID, mem.ExternalUserID,
+		map[string]any{"memory_id": mem.ID, "entities": names})
+}
+
+// changeSnapshot returns the value to store for a row: JSON-encoded text columns decoded, embedding omitted.
+func changeSnapshot(v any) any {
+	switch row := v.(type) {
+	case *models.Memory:
+		snap := *row
+		snap.MetadataMap = helpers.UnmarshalMetadata(row.Metadata)
+		return &snap
+	case *models.Entity:
+		snap := *row
+		snap.DataMap = helpers.UnmarshalEntityData(row.Data)
+		snap.Relations, snap.Memories = nil, nil
+		return &snap
+	case *models.AgentContext:
+		snap := *row
+		snap.PayloadMap = helpers.UnmarshalMetadata(row.Payload)
+		return &snap
+	}
+	return v
+}
+
+// ListChangesSince returns up to limit changes with seq > since in log order.
+func (s *CortexStore) ListChangesSince(since int64, filter ChangeFilter, limit int) (*ChangeFeed, error) {
+	dbQuery := s.db.Model(&models.Change{}).Where("seq > ?", since)
+	if filter.AppID != "" {
+		dbQuery = dbQuery.Where("app_id = ?", filter.AppID)
+	}
+	if filter.ExternalUserID != "" {
+		dbQuery = dbQuery.Where("external_user_id = ?", filter.ExternalUserID)
+	}
+	if len(filter.Kinds) > 0 {
+		dbQuery = dbQuery.Where("kind IN ?", filter.Kinds)
+	}
+
+	changes := make([]models.Change, 0)
+	if err := dbQuery.Order("seq ASC").Limit(limit + 1).Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	feed := &ChangeFeed{NextSince: since}
+	if len(changes) > limit {
+		changes = changes[:limit]
+		feed.HasMore = true
+	}
+	for i := range changes {
+		changes[i].DataMap = helpers.UnmarshalMetadata(changes[i].Data)
+	}
+	if len(changes) > 0 {
+		feed.NextSince = changes[len(changes)-1].Seq
+	}
+	feed.Changes = changes
+	return feed, nil
+}
+
+// LatestChangeSeq returns the seq of the newest change (0 if the log is empty), e.g. as the starting
+// point for a consumer that only wants changes from now on.
+func (s *CortexStore) LatestChangeSeq() (int64, error) {
+	var seq int64
+	err := s.db.Model(&models.Change{}).Select("COALESCE(MAX(seq), 0)").Scan(&seq).Error
+	return seq, err
+}
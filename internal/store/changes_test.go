@@ -0,0 +1,198 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+)
+
+func changeOps(feed *ChangeFeed) []string {
+	ops := make([]string, len(feed.Changes))
+	for i, c := range feed.Changes {
+		ops[i] = c.Kind + ":" + c.Op
+	}
+	return ops
+}
+
+func TestChangeLogRecordsMutations(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	bundle := models.Bundle{Name: "b", AppID: "app", ExternalUserID: "u1"}
+	if err := s.CreateBundle(&bundle); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	mem := models.Memory{Content: "Alice mag Kaffee", AppID: "app", ExternalUserID: "u1", BundleID: &bundle.ID, Metadata: `{"k":"v"}`}
+	if err := s.CreateMemory(&mem); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	mem.Content = "Alice mag Tee"
+	if err := s.UpdateMemory(&mem, "api"); err != nil {
+		t.Fatalf("UpdateMemory: %v", err)
+	}
+	if _, err := s.LinkMemoryEntities(&mem, []string{"Alice"}); err != nil {
+		t.Fatalf("LinkMemoryEntities: %v", err)
+	}
+	if _, err := s.SetEntityFact("app", "u1", "Alice", "drink", "tea"); err != nil {
+		t.Fatalf("SetEntityFact: %v", err)
+	}
+	if err := s.CreateOrUpdateRelation(&models.Relation{AppID: "app", ExternalUserID: "u1", From: "Alice", To: "Bob", Type: "knows"}); err != nil {
+		t.Fatalf("CreateOrUpdateRelation: %v", err)
+	}
+	if err := s.DeleteBundle(bundle.ID, "app", "u1"); err != nil {
+		t.Fatalf("DeleteBundle: %v", err)
+	}
+	if err := s.DeleteMemory(&mem); err != nil {
+		t.Fatalf("DeleteMemory: %v", err)
+	}
+	other := models.Memory{Content: "other tenant", AppID: "app", ExternalUserID: "u2"}
+	if err := s.CreateMemory(&other); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+
+	feed, err := s.ListChangesSince(0, ChangeFilter{AppID: "app", ExternalUserID: "u1"}, 100)
+	if err != nil {
+		t.Fatalf("ListChangesSince: %v", err)
+	}
+	want := []string{
+		"bundle:create", "memory:create", "memory:update",
+		"entity:create", "memory_entities:update",
+		"entity:update", "relation:create",
+		"memory:update", "bundle:delete", "memory:delete",
+	}
+	got := changeOps(feed)
+	if len(got) != len(want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", got, want)
+		}
+	}
+	for i := 1; i < len(feed.Changes); i++ {
+		if feed.Changes[i].Seq <= feed.Changes[i-1].Seq {
+			t.Fatal("seq must be strictly increasing")
+		}
+	}
+
+	// Snapshots: Metadata dekodiert, ohne Embedding; Delete enthält den Zustand davor
+	created := feed.Changes[1]
+	if created.ObjectID != mem.ID || created.DataMap["content"] != "Alice mag Kaffee" {
+		t.Errorf("unexpected create snapshot: %+v", created.DataMap)
+	}
+	if meta, _ := created.DataMap["metadata"].(map[string]any); meta["k"] != "v" {
+		t.Errorf("metadata not decoded in snapshot: %+v", created.DataMap)
+	}
+	if _, ok := created.DataMap["embedding"]; ok {
+		t.Error("snapshot must not contain the embedding")
+	}
+	if links := feed.Changes[4].DataMap["entities"].([]any); len(links) != 1 || links[0] != "Alice" {
+		t.Errorf("unexpected link snapshot: %+v", feed.Changes[4].DataMap)
+	}
+	if data, _ := feed.Changes[5].DataMap["data"].(map[string]any); data["drink"] != "tea" {
+		t.Errorf("fact missing in entity snapshot: %+v", feed.Changes[5].DataMap)
+	}
+	if _, ok := feed.Changes[7].DataMap["bundle_id"]; ok {
+		t.Errorf("memory moved out of deleted bundle must have no bundle_id: %+v", feed.Changes[7].DataMap)
+	}
+	if deleted := feed.Changes[9]; deleted.DataMap["content"] != "Alice mag Tee" {
+		t.Errorf("delete must carry the last state: %+v", deleted.DataMap)
+	}
+}
+
+func TestChangeFeedPagingAndFilter(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := s.CreateMemory(&models.Memory{Content: "m", AppID: "app", ExternalUserID: "u1"}); err != nil {
+			t.Fatalf("CreateMemory: %v", err)
+		}
+	}
+	wh := models.Webhook{URL: "http://example.com", Events: "*", AppID: "app", Active: true}
+	if err := s.CreateWebhook(&wh); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	page, err := s.ListChangesSince(0, ChangeFilter{}, 2)
+	if err != nil {
+		t.Fatalf("ListChangesSince: %v", err)
+	}
+	if len(page.Changes) != 2 || !page.HasMore || page.NextSince != page.Changes[1].Seq {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	rest, _ := s.ListChangesSince(page.NextSince, ChangeFilter{}, 100)
+	if len(rest.Changes) != 4 || rest.HasMore {
+		t.Fatalf("expected remaining 4 changes, got %d (has_more=%v)", len(rest.Changes), rest.HasMore)
+	}
+	empty, _ := s.ListChangesSince(rest.NextSince, ChangeFilter{}, 100)
+	if len(empty.Changes) != 0 || empty.NextSince != rest.NextSince {
+		t.Fatalf("expected empty page keeping since, got %+v", empty)
+	}
+
+	hooks, _ := s.ListChangesSince(0, ChangeFilter{AppID: "app", Kinds: []string{models.ChangeKindWebhook}}, 100)
+	if len(hooks.Changes) != 1 || hooks.Changes[0].ObjectID != wh.ID {
+		t.Fatalf("expected the webhook change, got %+v", hooks.Changes)
+	}
+	if _, ok := hooks.Changes[0].DataMap["secret"]; ok {
+		t.Error("webhook secret must not be logged")
+	}
+
+	latest, err := s.LatestChangeSeq()
+	if err != nil || latest != rest.NextSince {
+		t.Fatalf("LatestChangeSeq = %d, %v; want %d", latest, err, rest.NextSince)
+	}
+}
+
+func TestChangeLogCleanupAndMerge(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	past := time.Now().Add(-time.Hour)
+	expired := models.Memory{Content: "expired", AppID: "app", ExternalUserID: "u1", ExpiresAt: &past}
+	keep := models.Memory{Content: "keep", AppID: "app", ExternalUserID: "u1"}
+	merge := models.Memory{Content: "merge", AppID: "app", ExternalUserID: "u1"}
+	for _, m := range []*models.Memory{&expired, &keep, &merge} {
+		if err := s.CreateMemory(m); err != nil {
+			t.Fatalf("CreateMemory: %v", err)
+		}
+	}
+	start, _ := s.LatestChangeSeq()
+
+	if _, err := s.ArchiveExpiredMemories(time.Now()); err != nil {
+		t.Fatalf("ArchiveExpiredMemories: %v", err)
+	}
+	if err := s.MergeMemories(keep.ID, merge.ID, "app", "u1"); err != nil {
+		t.Fatalf("MergeMemories: %v", err)
+	}
+	if _, err := s.DeleteArchivedMemoriesOlderThan(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteArchivedMemoriesOlderThan: %v", err)
+	}
+
+	feed, _ := s.ListChangesSince(start, ChangeFilter{Kinds: []string{models.ChangeKindMemory}}, 100)
+	type entry struct {
+		id     int64
+		op     string
+		status any
+	}
+	want := []entry{
+		{expired.ID, models.ChangeOpUpdate, models.MemoryStatusArchived},
+		{keep.ID, models.ChangeOpUpdate, models.MemoryStatusActive},
+		{merge.ID, models.ChangeOpUpdate, models.MemoryStatusArchived},
+		{expired.ID, models.ChangeOpDelete, models.MemoryStatusArchived},
+		{merge.ID, models.ChangeOpDelete, models.MemoryStatusArchived},
+	}
+	if len(feed.Changes) != len(want) {
+		t.Fatalf("expected %d memory changes, got %v", len(want), changeOps(feed))
+	}
+	for i, w := range want {
+		c := feed.Changes[i]
+		if c.ObjectID != w.id || c.Op != w.op || c.DataMap["status"] != w.status {
+			t.Errorf("change %d = %d %s %v, want %+v", i, c.ObjectID, c.Op, c.DataMap["status"], w)
+		}
+	}
+	if feed.Changes[1].DataMap["content"] != "keep | merge" {
+		t.Errorf("merge update must carry merged content: %+v", feed.Changes[1].DataMap)
+	}
+}
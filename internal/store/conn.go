@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrDatabaseBusy is returned when the connection could not be swapped because statements or
+// transactions did not finish in time.
+var ErrDatabaseBusy = errors.New("database busy: running statements did not finish in time")
+
+// livePool is the gorm connection pool of a CortexStore. It forwards to a *sql.DB that can be
+// replaced while the store is in use (see RestoreDatabase): swap first waits until running statements
+// and transactions are done and holds new ones back until the new handle is in place.
+type livePool struct {
+	mu     sync.Mutex
+	db     *sql.DB
+	active int           // running statements and open transactions
+	paused chan struct{} // non-nil while a swap is pending; closed when it is done
+	idle   chan struct{} // closed when active drops to 0 during a pending swap
+}
+
+var (
+	_ gorm.ConnPool         = (*livePool)(nil)
+	_ gorm.ConnPoolBeginner = (*livePool)(nil)
+	_ gorm.GetDBConnector   = (*livePool)(nil)
+)
+
+func newLivePool(db *sql.DB) *livePool {
+	return &livePool{db: db}
+}
+
+// enter registers a user of the current handle; it blocks while a swap is pending.
+func (p *livePool) enter(ctx context.Context) (*sql.DB, error) {
+	for {
+		p.mu.Lock()
+		if p.paused == nil {
+			p.active++
+			db := p.db
+			p.mu.Unlock()
+			return db, nil
+		}
+		wait := p.paused
+		p.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *livePool) leave() {
+	p.mu.Lock()
+	p.active--
+	if p.active == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+	p.mu.Unlock()
+}
+
+// pause holds new users back and waits until the running ones are done. After timeout the pool is
+// resumed and ErrDatabaseBusy returned (a transaction that uses the store outside of its tx would
+// otherwise wait forever). Every successful pause must be followed by resume.
+func (p *livePool) pause(timeout time.Duration) error {
+	p.mu.Lock()
+	p.paused = make(chan struct{})
+	idle := make(chan struct{})
+	if p.active == 0 {
+		close(idle)
+	} else {
+		p.idle = idle
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-time.After(timeout):
+		p.resume(nil)
+		return ErrDatabaseBusy
+	}
+}
+
+// resume installs db (if not nil) and lets waiting users continue.
+func (p *livePool) resume(db *sql.DB) {
+	p.mu.Lock()
+	if db != nil {
+		p.db = db
+	}
+	close(p.paused)
+	p.paused, p.idle = nil, nil
+	p.mu.Unlock()
+}
+
+// current returns the handle in use (for Close and pragmas that need the *sql.DB).
+func (p *livePool) current() *sql.DB {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.db
+}
+
+func (p *livePool) GetDBConn() (*sql.DB, error) {
+	return p.current(), nil
+}
+
+func (p *livePool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	db, err := p.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	return db.PrepareContext(ctx, query)
+}
+
+func (p *livePool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db, err := p.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	return db.ExecContext(ctx, query, args...)
+}
+
+func (p *livePool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db, err := p.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	return db.QueryContext(ctx, query, args...)
+}
+
+func (p *livePool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db, err := p.enter(ctx)
+	if err != nil {
+		// *sql.Row kann keinen Fehler tragen: abgebrochenen Context an database/sql durchreichen
+		return p.current().QueryRowContext(ctx, query, args...)
+	}
+	defer p.leave()
+	return db.QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx starts a transaction that counts as running until it is committed or rolled back.
+func (p *livePool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	db, err := p.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		p.leave()
+		return nil, err
+	}
+	return &liveTx{Tx: tx, pool: p, db: db}, nil
+}
+
+// liveTx is a transaction of a livePool.
+type liveTx struct {
+	*sql.Tx
+	pool *livePool
+	db   *sql.DB
+	once sync.Once
+}
+
+var _ gorm.TxCommitter = (*liveTx)(nil)
+
+func (t *liveTx) Commit() error {
+	defer t.done()
+	return t.Tx.Commit()
+}
+
+func (t *liveTx) Rollback() error {
+	defer t.done()
+	return t.Tx.Rollback()
+}
+
+func (t *liveTx) GetDBConn() (*sql.DB, error) {
+	return t.db, nil
+}
+
+func (t *liveTx) done() {
+	t.once.Do(t.pool.leave)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"log/slog"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/embeddings"
+)
+
+// legacyEmbeddingBatchSize is the number of rows converted per UPDATE batch.
+const legacyEmbeddingBatchSize = 500
+
+// migrateLegacyEmbeddings converts embeddings stored as JSON text (schema before the binary format)
+// into the binary BLOB format. Rows that cannot be decoded are cleared so they get re-embedded.
+func (s *CortexStore) migrateLegacyEmbeddings() error {
+	type legacyRow struct {
+		ID        int64
+		Embedding string
+	}
+	converted, cleared := 0, 0
+	var lastID int64
+	for {
+		var rows []legacyRow
+		err := s.db.Raw(
+			"SELECT id, embedding FROM memories WHERE typeof(embedding) = 'text' AND id > ? ORDER BY id LIMIT ?",
+			lastID, legacyEmbeddingBatchSize,
+		).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				var data []byte
+				dim := 0
+				if vec, err := embeddings.DecodeVector([]byte(row.Embedding)); err == nil && len(vec) > 0 {
+					if data, err = embeddings.EncodeVector(vec); err != nil {
+						return err
+					}
+					dim = len(vec)
+					converted++
+				} else {
+					cleared++
+				}
+				if err := tx.Exec("UPDATE memories SET embedding = ?, embedding_dim = ? WHERE id = ?", data, dim, row.ID).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+	if converted > 0 || cleared > 0 {
+		slog.Info("legacy embeddings migrated to binary format", "converted", converted, "cleared", cleared)
+	}
+	return nil
+}
+
+// backfillEmbeddingDimensions fills embedding_dim (and embedding_model, if recorded in the vector header)
+// for embeddings written before model identity was stored per memory.
+func (s *CortexStore) backfillEmbeddingDimensions() error {
+	type row struct {
+		ID        int64
+		Embedding []byte
+	}
+	var lastID int64
+	for {
+		var rows []row
+		err := s.db.Raw(
+			"SELECT id, embedding FROM memories WHERE length(embedding) > 0 AND (embedding_dim IS NULL OR embedding_dim = 0) AND id > ? ORDER BY id LIMIT ?",
+			lastID, legacyEmbeddingBatchSize,
+		).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			for _, r := range rows {
+				h, err := embeddings.DecodeVectorHeader(r.Embedding)
+				if err != nil {
+					slog.Warn("undecodable embedding, skipping dimension backfill", "memoryId", r.ID, "error", err)
+					continue
+				}
+				if err := tx.Exec("UPDATE memories SET embedding_dim = ?, embedding_model = ? WHERE id = ?", h.Dimension, h.ModelID, r.ID).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/models"
+)
+
+func TestMigrateLegacyEmbeddings(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	s, err := NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewCortexStore failed: %v", err)
+	}
+
+	good := &models.Memory{Type: "semantic", Content: "legacy vector", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	broken := &models.Memory{Type: "semantic", Content: "broken vector", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	s.CreateMemory(good)
+	s.CreateMemory(broken)
+	// Altes Schema: Embedding als JSON-Text
+	s.db.Exec("UPDATE memories SET embedding = ? WHERE id = ?", "[0.5,-0.25,1]", good.ID)
+	s.db.Exec("UPDATE memories SET embedding = ? WHERE id = ?", "not-a-vector", broken.ID)
+	s.Close()
+
+	s, err = NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+
+	var legacy int64
+	s.db.Raw("SELECT COUNT(*) FROM memories WHERE typeof(embedding) = 'text'").Scan(&legacy)
+	if legacy != 0 {
+		t.Errorf("expected no text embeddings after migration, got %d", legacy)
+	}
+
+	var mem models.Memory
+	s.db.First(&mem, good.ID)
+	h, err := embeddings.DecodeVectorHeader(mem.Embedding)
+	if err != nil || h.Encoding != embeddings.VectorFloat32 || h.Dimension != 3 {
+		t.Fatalf("expected binary float32 header with dim 3, got %+v (err %v)", h, err)
+	}
+	if mem.EmbeddingDim != 3 {
+		t.Errorf("expected embedding_dim 3 after migration, got %d", mem.EmbeddingDim)
+	}
+	vec, _ := embeddings.DecodeVector(mem.Embedding)
+	if vec[0] != 0.5 || vec[1] != -0.25 || vec[2] != 1 {
+		t.Errorf("unexpected vector after migration: %v", vec)
+	}
+
+	var clearedMem models.Memory
+	s.db.First(&clearedMem, broken.ID)
+	if len(clearedMem.Embedding) != 0 {
+		t.Errorf("expected undecodable embedding to be cleared, got %q", clearedMem.Embedding)
+	}
+}
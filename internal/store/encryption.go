@@ -0,0 +1,864 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"cortex/internal/crypt"
+	"cortex/internal/models"
+)
+
+// Field-level encryption at rest: memory content and metadata (also of memory versions), agent
+// context payloads, the change and event log and webhook deliveries (which contain snapshots of them)
+// are stored sealed with a data key of their tenant (envelope encryption, see crypt.SealField). Data
+// keys are stored wrapped with the master key in tenant_keys.
+//
+// Values are sealed as gorm writes them (serializer:sealed on the model fields) and opened by a query
+// callback, so models only ever hold plaintext and the store API keeps working on it.
+// SQL filters on encrypted columns go through the SQL function cortex_decrypt (see plainColumn).
+// Embeddings, tags and all other columns stay unencrypted: semantic search works as before, the FTS
+// index only sees ciphertext of the content (keyword search matches tags only).
+
+var (
+	// ErrEncryptionDisabled is returned by key operations while field encryption is off.
+	ErrEncryptionDisabled = errors.New("field encryption is not enabled (CORTEX_MASTER_KEYFILE)")
+	// ErrFieldKeyMissing is returned when an encrypted value is read whose data key is not loaded
+	// (field encryption disabled or tenant key deleted).
+	ErrFieldKeyMissing = errors.New("encrypted value with unknown data key")
+)
+
+// dataKeys maps key IDs to the unwrapped data keys ([]byte) of all stores of the process. Decryption
+// only needs the key ID of a value; cortex_decrypt is registered with the driver, not with a store.
+var dataKeys sync.Map
+
+func init() {
+	// Vor dem ersten Connect registrieren: die Funktion gilt nur für danach geöffnete Verbindungen
+	if err := gosqlite.RegisterDeterministicScalarFunction("cortex_decrypt", 2, sqlDecrypt); err != nil {
+		panic(err)
+	}
+	schema.RegisterSerializer("sealed", sealedSerializer{})
+}
+
+// sqlDecrypt implements cortex_decrypt(value, 'table.column'): the plaintext of a sealed value, an
+// unsealed value unchanged, NULL if the value cannot be decrypted.
+func sqlDecrypt(_ *gosqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	value, ok := args[0].(string)
+	if !ok {
+		return args[0], nil
+	}
+	column, _ := args[1].(string)
+	if err := openValue(column, &value); err != nil {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// sealedTable lists the encrypted columns of a table (for rotation and status).
+type sealedTable struct {
+	table   string
+	key     string // primary key column
+	columns []string
+	join    string // join providing the tenant as t.app_id/t.external_user_id, if the table has none
+}
+
+var sealedTables = []sealedTable{
+	{table: "memories", key: "id", columns: []string{"content", "metadata"}},
+	{table: "memory_versions", key: "id", columns: []string{"content", "metadata"}, join: "JOIN memories t ON t.id = x.memory_id"},
+	{table: "agent_contexts", key: "id", columns: []string{"payload"}},
+	{table: "changes", key: "seq", columns: []string{"data"}},
+	{table: "events", key: "id", columns: []string{"payload"}},
+	{table: "webhook_deliveries", key: "id", columns: []string{"payload"}},
+}
+
+// from returns the FROM clause (table alias x) and the tenant condition of the table.
+func (st sealedTable) from() string {
+	tenant := "x"
+	if st.join != "" {
+		tenant = "t"
+	}
+	return fmt.Sprintf("%s x %s WHERE %s.app_id = ? AND %s.external_user_id = ?", st.table, st.join, tenant, tenant)
+}
+
+// isSealedColumn reports whether "table.column" is encrypted.
+func isSealedColumn(column string) bool {
+	table, col, _ := strings.Cut(column, ".")
+	for _, st := range sealedTables {
+		if st.table == table {
+			for _, c := range st.columns {
+				if c == col {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// sealedValue is an encrypted column of a model: "table.column" (authenticated with the ciphertext)
+// and a pointer to the field.
+type sealedValue struct {
+	column string
+	value  *string
+}
+
+// sealedValues returns the encrypted fields of model and its tenant. Memory versions have no tenant
+// columns; memoryID is set instead.
+func sealedValues(model any) (values []sealedValue, tenant *tenantKey, memoryID int64) {
+	switch m := model.(type) {
+	case *models.Memory:
+		return []sealedValue{{"memories.content", &m.Content}, {"memories.metadata", &m.Metadata}}, &tenantKey{m.AppID, m.ExternalUserID}, 0
+	case *models.MemoryVersion:
+		return []sealedValue{{"memory_versions.content", &m.Content}, {"memory_versions.metadata", &m.Metadata}}, nil, m.MemoryID
+	case *models.AgentContext:
+		return []sealedValue{{"agent_contexts.payload", &m.Payload}}, &tenantKey{m.AppID, m.ExternalUserID}, 0
+	case *models.Change:
+		return []sealedValue{{"changes.data", &m.Data}}, &tenantKey{m.AppID, m.ExternalUserID}, 0
+	case *models.Event:
+		return []sealedValue{{"events.payload", &m.Payload}}, &tenantKey{m.AppID, m.ExternalUserID}, 0
+	case *models.WebhookDelivery:
+		return []sealedValue{{"webhook_deliveries.payload", &m.Payload}}, &tenantKey{m.AppID, m.ExternalUserID}, 0
+	}
+	return nil, nil, 0
+}
+
+// forEachModel calls fn with a pointer to every struct in rv (a struct or a slice of structs or
+// pointers). Structs embedding a model (search rows) are not descended into.
+func forEachModel(rv reflect.Value, fn func(model any)) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			forEachModel(rv.Index(i), fn)
+		}
+	case reflect.Struct:
+		if rv.CanAddr() {
+			fn(rv.Addr().Interface())
+		}
+	}
+}
+
+// openValue decrypts a sealed value in place; other values are left unchanged.
+func openValue(column string, value *string) error {
+	id, ok := crypt.FieldKeyID(*value)
+	if !ok {
+		return nil
+	}
+	dek, ok := dataKeys.Load(id)
+	if !ok {
+		return fmt.Errorf("%w: %s (key %s)", ErrFieldKeyMissing, column, id)
+	}
+	plain, err := crypt.OpenField(dek.([]byte), *value, column)
+	if err != nil {
+		return fmt.Errorf("%s: %w", column, err)
+	}
+	*value = plain
+	return nil
+}
+
+// openModel decrypts the encrypted fields of model in place.
+func openModel(model any) error {
+	values, _, _ := sealedValues(model)
+	for _, v := range values {
+		if err := openValue(v.column, v.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldKeyring holds the master keys and the current data key of every tenant while field
+// encryption is enabled.
+type fieldKeyring struct {
+	master   *crypt.Key
+	previous []*crypt.Key // only for unwrapping keys wrapped before a master key change
+
+	mu      sync.Mutex
+	current map[tenantKey]*dataKey // newest data key per tenant
+}
+
+// dataKey is an unwrapped data key of a tenant.
+type dataKey struct {
+	row models.TenantKey
+	key []byte
+	// confirmed is set once the key row is known to be committed. Until then every write that uses
+	// the key inserts the row (INSERT OR IGNORE) in its own transaction, so a key created in a
+	// transaction that is rolled back is never used without being stored.
+	confirmed atomic.Bool
+}
+
+func (r *fieldKeyring) newDataKey(t tenantKey) (*dataKey, error) {
+	id, dek, err := crypt.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := r.master.WrapKey(id, dek)
+	if err != nil {
+		return nil, err
+	}
+	dataKeys.Store(id, dek)
+	return &dataKey{
+		row: models.TenantKey{KeyID: id, AppID: t.appID, ExternalUserID: t.externalUserID, WrappedKey: wrapped, MasterKeyID: r.master.ID()},
+		key: dek,
+	}, nil
+}
+
+// unwrap returns the data key of row, using the master key it was wrapped with.
+func (r *fieldKeyring) unwrap(row *models.TenantKey) ([]byte, error) {
+	for _, k := range append([]*crypt.Key{r.master}, r.previous...) {
+		if k.ID() == row.MasterKeyID {
+			return k.UnwrapKey(row.KeyID, row.WrappedKey)
+		}
+	}
+	return nil, fmt.Errorf("tenant key %s is wrapped with unknown master key %s (set CORTEX_MASTER_KEYFILE_PREVIOUS)", row.KeyID, row.MasterKeyID)
+}
+
+// key returns the current data key of tenant t (created on first use); db is the statement that
+// is about to write with it.
+func (r *fieldKeyring) key(db *gorm.DB, t tenantKey) (*dataKey, error) {
+	r.mu.Lock()
+	dk := r.current[t]
+	if dk == nil {
+		var err error
+		if dk, err = r.newDataKey(t); err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+		r.current[t] = dk
+	}
+	r.mu.Unlock()
+
+	if !dk.confirmed.Load() {
+		row := dk.row
+		if err := db.Session(&gorm.Session{NewDB: true}).Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return nil, fmt.Errorf("failed to store tenant key: %w", err)
+		}
+	}
+	return dk, nil
+}
+
+// currentKey returns the current data key of tenant t, nil if it has none yet.
+func (r *fieldKeyring) currentKey(t tenantKey) *dataKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current[t]
+}
+
+func (r *fieldKeyring) setCurrent(t tenantKey, dk *dataKey) {
+	r.mu.Lock()
+	r.current[t] = dk
+	r.mu.Unlock()
+}
+
+// EnableFieldEncryption turns on field-level encryption with master (a keyfile key). Tenant keys
+// wrapped with one of the previous master keys are re-wrapped with master; the number of re-wrapped
+// keys is returned. Values written before stay readable and are encrypted by RotateTenantKey.
+func (s *CortexStore) EnableFieldEncryption(master *crypt.Key, previous ...*crypt.Key) (int, error) {
+	if master == nil || master.ID() == "" {
+		return 0, crypt.ErrNotMasterKey
+	}
+	ring := &fieldKeyring{master: master, previous: previous}
+	rewrapped, err := s.loadDataKeys(ring)
+	if err != nil {
+		return rewrapped, err
+	}
+	s.keyring.Store(ring)
+	// Hashes verschlüsselter Werte ließen sich erst mit den Tenant-Keys berechnen
+	if err := s.backfillContentHashes(); err != nil {
+		return rewrapped, err
+	}
+	return rewrapped, nil
+}
+
+// FieldEncryptionEnabled reports whether new values are encrypted.
+func (s *CortexStore) FieldEncryptionEnabled() bool {
+	return s.keyring.Load() != nil
+}
+
+// loadDataKeys unwraps all tenant keys into ring (re-wrapping keys of previous master keys).
+func (s *CortexStore) loadDataKeys(ring *fieldKeyring) (int, error) {
+	var rows []models.TenantKey
+	if err := s.db.Order("id").Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	masterID := ring.master.ID()
+	current := make(map[tenantKey]*dataKey)
+	rewrapped := 0
+	for _, row := range rows {
+		dek, err := ring.unwrap(&row)
+		if err != nil {
+			return rewrapped, err
+		}
+		if row.MasterKeyID != masterID {
+			wrapped, err := ring.master.WrapKey(row.KeyID, dek)
+			if err != nil {
+				return rewrapped, err
+			}
+			if err := s.db.Model(&models.TenantKey{}).Where("id = ?", row.ID).
+				UpdateColumns(map[string]any{"wrapped_key": wrapped, "master_key_id": masterID}).Error; err != nil {
+				return rewrapped, err
+			}
+			row.WrappedKey, row.MasterKeyID = wrapped, masterID
+			rewrapped++
+		}
+		dataKeys.Store(row.KeyID, dek)
+		dk := &dataKey{row: row, key: dek}
+		dk.confirmed.Store(true)
+		current[tenantKey{row.AppID, row.ExternalUserID}] = dk // nach ID sortiert: der neueste gewinnt
+	}
+	ring.mu.Lock()
+	ring.current = current
+	ring.mu.Unlock()
+	return rewrapped, nil
+}
+
+// reloadDataKeys loads the tenant keys again after the database was replaced (RestoreDatabase) and
+// fills in the content hashes of encrypted rows that could not be hashed without them.
+func (s *CortexStore) reloadDataKeys() {
+	ring := s.keyring.Load()
+	if ring == nil {
+		return
+	}
+	if _, err := s.loadDataKeys(ring); err != nil {
+		slog.Error("failed to load tenant keys of the restored database; encrypted values cannot be read", "error", err)
+		return
+	}
+	if err := s.backfillContentHashes(); err != nil {
+		slog.Warn("failed to fill in content hashes of the restored database", "error", err)
+	}
+}
+
+// registerFieldCallbacks installs the gorm callbacks that encrypt on write and decrypt on read.
+func (s *CortexStore) registerFieldCallbacks() error {
+	cb := s.db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("cortex:seal_fields", s.sealFields(true)),
+		cb.Create().After("gorm:commit_or_rollback_transaction").Register("cortex:confirm_keys", confirmDataKeys),
+		cb.Update().Before("gorm:update").Register("cortex:seal_fields", s.sealFields(false)),
+		cb.Update().After("gorm:commit_or_rollback_transaction").Register("cortex:confirm_keys", confirmDataKeys),
+		cb.Query().After("gorm:query").Register("cortex:open_fields", openFields),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealPlan holds the data keys of a create/update statement, resolved by sealFields before the
+// statement runs. sealedSerializer seals the column values with them while gorm writes them.
+type sealPlan struct {
+	keys     map[tenantKey]*dataKey
+	versions map[int64]tenantKey // tenant of the memory of a version
+}
+
+type sealPlanKey struct{}
+
+// sealedSerializer is the gorm serializer of the encrypted columns (tag serializer:sealed). Value
+// seals the plaintext of the model with the data key of its tenant, so only the statement's column
+// value is encrypted and the model keeps its plaintext. Scan passes the stored value through; the
+// open_fields callback decrypts it after the query.
+type sealedSerializer struct{}
+
+func (sealedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("%s.%s: unexpected value %T", field.Schema.Table, field.DBName, dbValue)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (sealedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	value, _ := fieldValue.(string)
+	plan, _ := ctx.Value(sealPlanKey{}).(*sealPlan)
+	if plan == nil || value == "" {
+		return value, nil
+	}
+	column := field.Schema.Table + "." + field.DBName
+	if !dst.CanAddr() {
+		cp := reflect.New(dst.Type()).Elem()
+		cp.Set(dst)
+		dst = cp
+	}
+	_, tenant, memoryID := sealedValues(dst.Addr().Interface())
+	if tenant == nil {
+		if t, ok := plan.versions[memoryID]; ok {
+			tenant = &t
+		}
+	}
+	var dk *dataKey
+	if tenant != nil {
+		dk = plan.keys[*tenant]
+	}
+	if dk == nil {
+		return nil, fmt.Errorf("cannot encrypt %s: no data key for the tenant of the model", column)
+	}
+	return crypt.SealField(dk.row.KeyID, dk.key, value, column)
+}
+
+// updateMap returns the update map of an update statement. On first use it is replaced by a copy
+// owned by the statement, so callbacks can change it without touching the caller's map.
+func updateMap(db *gorm.DB) (map[string]any, bool) {
+	updates, ok := db.Statement.Dest.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	if _, copied := db.InstanceGet("cortex:update_map"); !copied {
+		updates = maps.Clone(updates)
+		db.Statement.Dest = updates
+		db.InstanceSet("cortex:update_map", true)
+	}
+	return updates, true
+}
+
+// sealFields returns the callback that prepares the encryption of a create/update statement: it
+// resolves the data keys of the tenants of its models for sealedSerializer. Encrypted columns of an
+// update map are sealed in the statement's copy of the map; the model gets the plaintext, as gorm
+// would assign it.
+func (s *CortexStore) sealFields(create bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) { s.prepareSeal(db, create) }
+}
+
+func (s *CortexStore) prepareSeal(db *gorm.DB, create bool) {
+	ring := s.keyring.Load()
+	if ring == nil || db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	var used []*dataKey
+	plan := &sealPlan{keys: make(map[tenantKey]*dataKey), versions: make(map[int64]tenantKey)}
+	key := func(t tenantKey) (*dataKey, error) {
+		if dk := plan.keys[t]; dk != nil {
+			return dk, nil
+		}
+		dk, err := ring.key(db, t)
+		if err != nil {
+			return nil, err
+		}
+		plan.keys[t] = dk
+		used = append(used, dk)
+		return dk, nil
+	}
+
+	if updates, ok := updateMap(db); ok {
+		// Update("metadata", ...): der Tenant kommt aus dem Model
+		var tenant *tenantKey
+		forEachModel(db.Statement.ReflectValue, func(model any) {
+			if _, t, _ := sealedValues(model); t != nil && tenant == nil {
+				tenant = t
+			}
+		})
+		for col, v := range updates {
+			str, ok := v.(string)
+			column := db.Statement.Table + "." + col
+			if !ok || !isSealedColumn(column) {
+				continue
+			}
+			if tenant == nil {
+				db.AddError(fmt.Errorf("cannot encrypt %s: update it through the loaded model", column))
+				return
+			}
+			if field := db.Statement.Schema.LookUpField(col); field != nil && db.Statement.ReflectValue.Kind() == reflect.Struct &&
+				db.Statement.ReflectValue.CanAddr() {
+				if err := field.Set(db.Statement.Context, db.Statement.ReflectValue, str); err != nil {
+					db.AddError(err)
+					return
+				}
+			}
+			if str == "" {
+				continue
+			}
+			dk, err := key(*tenant)
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			sealed, err := crypt.SealField(dk.row.KeyID, dk.key, str, column)
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			// Als Ausdruck übergeben: gorm weist ihn nicht dem Model zu
+			updates[col] = clause.Expr{SQL: "?", Vars: []any{sealed}}
+		}
+	} else {
+		forEachModel(db.Statement.ReflectValue, func(model any) {
+			values, tenant, memoryID := sealedValues(model)
+			if db.Error != nil || !hasPlaintext(values) {
+				return
+			}
+			if tenant == nil {
+				t, ok := plan.versions[memoryID]
+				if !ok {
+					var err error
+					if t, err = memoryTenant(db, memoryID); err != nil {
+						db.AddError(err)
+						return
+					}
+					plan.versions[memoryID] = t
+				}
+				tenant = &t
+			} else if create {
+				tenant = withDefaultTenant(db.Statement.Schema, *tenant)
+			}
+			if _, err := key(*tenant); err != nil {
+				db.AddError(err)
+			}
+		})
+		db.Statement.Context = context.WithValue(db.Statement.Context, sealPlanKey{}, plan)
+	}
+	if len(used) > 0 {
+		db.InstanceSet("cortex:data_keys", used)
+	}
+}
+
+// withDefaultTenant returns t with the column defaults gorm inserts for empty tenant fields (e.g.
+// app_id 'openclaw' of memories), the tenant the row is stored with.
+func withDefaultTenant(sch *schema.Schema, t tenantKey) *tenantKey {
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{{"AppID", &t.appID}, {"ExternalUserID", &t.externalUserID}} {
+		if field := sch.LookUpField(f.name); field != nil && *f.value == "" {
+			if def, ok := field.DefaultValueInterface.(string); ok {
+				*f.value = def
+			}
+		}
+	}
+	return &t
+}
+
+func hasPlaintext(values []sealedValue) bool {
+	for _, v := range values {
+		if *v.value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryTenant returns the tenant of a memory, read in the transaction of db.
+func memoryTenant(db *gorm.DB, memoryID int64) (tenantKey, error) {
+	var t tenantKey
+	err := db.Session(&gorm.Session{NewDB: true}).Table("memories").Select("app_id", "external_user_id").
+		Where("id = ?", memoryID).Row().Scan(&t.appID, &t.externalUserID)
+	if err != nil {
+		return t, fmt.Errorf("cannot encrypt version of memory %d: %w", memoryID, err)
+	}
+	return t, nil
+}
+
+// openFields decrypts the fields of the models a statement read.
+func openFields(db *gorm.DB) {
+	forEachModel(db.Statement.ReflectValue, func(model any) {
+		if err := openModel(model); err != nil {
+			db.AddError(err)
+		}
+	})
+}
+
+// confirmDataKeys marks the data keys of a statement as stored once its own transaction committed.
+func confirmDataKeys(db *gorm.DB) {
+	used, ok := db.InstanceGet("cortex:data_keys")
+	if !ok || db.Error != nil {
+		return
+	}
+	if _, started := db.InstanceGet("gorm:started_transaction"); !started {
+		return // Transaktion des Aufrufers: Commit noch offen
+	}
+	for _, dk := range used.([]*dataKey) {
+		dk.confirmed.Store(true)
+	}
+}
+
+// plainColumn returns an SQL expression for the plaintext of an encrypted column, for filters
+// (LIKE, json_extract, equality). column is the name used in the query, sealed the "table.column"
+// it belongs to. Without field encryption it is the column itself.
+func (s *CortexStore) plainColumn(column, sealed string) string {
+	if s.keyring.Load() == nil {
+		return column
+	}
+	return fmt.Sprintf("cortex_decrypt(%s, '%s')", column, sealed)
+}
+
+// KeyRotation is the result of rotating the data key of a tenant.
+type KeyRotation struct {
+	AppID          string   `json:"app_id"`
+	ExternalUserID string   `json:"external_user_id"`
+	KeyID          string   `json:"key_id"`       // new data key
+	Reencrypted    int64    `json:"reencrypted"`  // values encrypted with the new key (including previously unencrypted ones)
+	RetiredKeys    []string `json:"retired_keys"` // old data keys that were deleted
+}
+
+// EncryptionStatus describes the state of field-level encryption.
+type EncryptionStatus struct {
+	Enabled     bool   `json:"enabled"`
+	MasterKeyID string `json:"master_key_id,omitempty"`
+	TenantKeys  int64  `json:"tenant_keys"`
+	Unencrypted int64  `json:"unencrypted"` // values still stored in plaintext (written before encryption was enabled)
+}
+
+// reencryptBatchSize is the number of rows re-encrypted per transaction.
+const reencryptBatchSize = 500
+
+// errKeyInUse rolls back the deletion of a data key that is still referenced.
+var errKeyInUse = errors.New("data key still in use")
+
+// RotateTenantKey creates a new data key for the tenant, re-encrypts all its values with it (values
+// stored in plaintext are encrypted as well) and deletes the old data keys. A key that is still
+// referenced afterwards (concurrent write during the rotation) is kept until the next rotation.
+func (s *CortexStore) RotateTenantKey(appID, externalUserID string) (*KeyRotation, error) {
+	ring := s.keyring.Load()
+	if ring == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	t := tenantKey{appID, externalUserID}
+	dk, err := ring.newDataKey(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&dk.row).Error; err != nil {
+		return nil, err
+	}
+	dk.confirmed.Store(true)
+	ring.setCurrent(t, dk)
+
+	rot := &KeyRotation{AppID: appID, ExternalUserID: externalUserID, KeyID: dk.row.KeyID, RetiredKeys: []string{}}
+	for _, st := range sealedTables {
+		n, err := s.reencryptTable(st, t, dk)
+		rot.Reencrypted += n
+		if err != nil {
+			return rot, fmt.Errorf("failed to re-encrypt %s: %w", st.table, err)
+		}
+	}
+	if err := s.rehashContent(t, dk); err != nil {
+		return rot, fmt.Errorf("failed to rehash content: %w", err)
+	}
+
+	var old []models.TenantKey
+	if err := s.db.Where("app_id = ? AND external_user_id = ? AND key_id <> ?", appID, externalUserID, dk.row.KeyID).
+		Order("id").Find(&old).Error; err != nil {
+		return rot, err
+	}
+	for _, k := range old {
+		// Erst löschen (Schreibsperre), dann zählen: so sind auch zuletzt committete Werte sichtbar
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&models.TenantKey{}, k.ID).Error; err != nil {
+				return err
+			}
+			refs, err := countSealedWith(tx, t, k.KeyID)
+			if err != nil {
+				return err
+			}
+			if refs > 0 {
+				return errKeyInUse
+			}
+			return nil
+		})
+		if errors.Is(err, errKeyInUse) {
+			slog.Warn("data key still in use after rotation; kept until the next rotation", "key_id", k.KeyID)
+			continue
+		}
+		if err != nil {
+			return rot, err
+		}
+		dataKeys.Delete(k.KeyID)
+		rot.RetiredKeys = append(rot.RetiredKeys, k.KeyID)
+	}
+	return rot, nil
+}
+
+// RotateAllTenantKeys rotates the data keys of all tenants that have encrypted (or encryptable) values.
+func (s *CortexStore) RotateAllTenantKeys() ([]KeyRotation, error) {
+	if s.keyring.Load() == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	rows, err := s.db.Raw(`SELECT app_id, external_user_id FROM memories
+		UNION SELECT app_id, external_user_id FROM agent_contexts
+		UNION SELECT app_id, external_user_id FROM changes
+		UNION SELECT app_id, external_user_id FROM events
+		UNION SELECT app_id, external_user_id FROM webhook_deliveries
+		UNION SELECT app_id, external_user_id FROM tenant_keys
+		ORDER BY 1, 2`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	var tenants []tenantKey
+	for rows.Next() {
+		var t tenantKey
+		if err := rows.Scan(&t.appID, &t.externalUserID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rotations := make([]KeyRotation, 0, len(tenants))
+	for _, t := range tenants {
+		rot, err := s.RotateTenantKey(t.appID, t.externalUserID)
+		if rot != nil {
+			rotations = append(rotations, *rot)
+		}
+		if err != nil {
+			return rotations, err
+		}
+	}
+	return rotations, nil
+}
+
+// reencryptTable seals all values of a tenant in st with dk that are not sealed with it yet.
+func (s *CortexStore) reencryptTable(st sealedTable, t tenantKey, dk *dataKey) (int64, error) {
+	cols := make([]string, len(st.columns))
+	sets := make([]string, len(st.columns))
+	for i, c := range st.columns {
+		cols[i] = "x." + c
+		sets[i] = c + " = ?"
+	}
+	query := fmt.Sprintf("SELECT x.%s, %s FROM %s AND x.%s > ? ORDER BY x.%s LIMIT ?",
+		st.key, strings.Join(cols, ", "), st.from(), st.key, st.key)
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", st.table, strings.Join(sets, ", "), st.key)
+
+	var total int64
+	var after int64
+	for {
+		n := 0
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			rows, err := tx.Raw(query, t.appID, t.externalUserID, after, reencryptBatchSize).Rows()
+			if err != nil {
+				return err
+			}
+			type row struct {
+				key    int64
+				values []sql.NullString
+			}
+			var batch []row
+			for rows.Next() {
+				r := row{values: make([]sql.NullString, len(st.columns))}
+				dest := []any{&r.key}
+				for i := range r.values {
+					dest = append(dest, &r.values[i])
+				}
+				if err := rows.Scan(dest...); err != nil {
+					rows.Close()
+					return err
+				}
+				batch = append(batch, r)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, r := range batch {
+				after = r.key
+				n++
+				args := make([]any, 0, len(st.columns)+1)
+				changed := false
+				for i, c := range st.columns {
+					v := r.values[i]
+					if !v.Valid || v.String == "" {
+						args = append(args, v)
+						continue
+					}
+					if id, _ := crypt.FieldKeyID(v.String); id == dk.row.KeyID {
+						args = append(args, v.String)
+						continue
+					}
+					column := st.table + "." + c
+					plain := v.String
+					if err := openValue(column, &plain); err != nil {
+						return fmt.Errorf("%s %d: %w", st.table, r.key, err)
+					}
+					sealed, err := crypt.SealField(dk.row.KeyID, dk.key, plain, column)
+					if err != nil {
+						return err
+					}
+					args = append(args, sealed)
+					changed = true
+					total++
+				}
+				if !changed {
+					continue
+				}
+				if err := tx.Exec(update, append(args, r.key)...).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		if n < reencryptBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// countSealedWith counts the values of tenant t sealed with the data key keyID.
+func countSealedWith(tx *gorm.DB, t tenantKey, keyID string) (int64, error) {
+	prefix := crypt.FieldPrefix + keyID + ":"
+	var total int64
+	for _, st := range sealedTables {
+		conds := make([]string, len(st.columns))
+		args := []any{t.appID, t.externalUserID}
+		for i, c := range st.columns {
+			conds[i] = fmt.Sprintf("substr(x.%s, 1, %d) = ?", c, len(prefix))
+			args = append(args, prefix)
+		}
+		var n int64
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s AND (%s)", st.from(), strings.Join(conds, " OR "))
+		if err := tx.Raw(query, args...).Row().Scan(&n); err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// EncryptionStatus reports whether field encryption is enabled, the number of tenant keys and how
+// many values are still stored in plaintext.
+func (s *CortexStore) EncryptionStatus() (*EncryptionStatus, error) {
+	status := &EncryptionStatus{}
+	if ring := s.keyring.Load(); ring != nil {
+		status.Enabled = true
+		status.MasterKeyID = ring.master.ID()
+	}
+	if err := s.db.Model(&models.TenantKey{}).Count(&status.TenantKeys).Error; err != nil {
+		return nil, err
+	}
+	for _, st := range sealedTables {
+		for _, c := range st.columns {
+			var n int64
+			query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s <> '' AND substr(%s, 1, %d) <> ?", st.table, c, c, len(crypt.FieldPrefix))
+			if err := s.db.Raw(query, crypt.FieldPrefix).Row().Scan(&n); err != nil {
+				return nil, err
+			}
+			status.Unencrypted += n
+		}
+	}
+	return status, nil
+}
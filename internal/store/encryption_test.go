@@ -0,0 +1,370 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/crypt"
+	"cortex/internal/models"
+)
+
+func testMasterKey(t *testing.T, b byte) *crypt.Key {
+	t.Helper()
+	key, err := crypt.NewKey(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// rawValues returns a column as stored on disk (read past the gorm callbacks).
+func rawValues(t *testing.T, s *CortexStore, query string, args ...any) []string {
+	t.Helper()
+	rows, err := s.GetDB().Raw(query, args...).Rows()
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func assertSealed(t *testing.T, s *CortexStore, query string, keyID string) {
+	t.Helper()
+	values := rawValues(t, s, query)
+	if len(values) == 0 {
+		t.Fatalf("%s: no rows", query)
+	}
+	for _, v := range values {
+		id, ok := crypt.FieldKeyID(v)
+		if !ok || keyID != "" && id != keyID {
+			t.Errorf("%s: value not sealed with key %q: %.60s", query, keyID, v)
+		}
+	}
+}
+
+func TestFieldEncryptionAtRest(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	if _, err := s.EnableFieldEncryption(testMasterKey(t, 1)); err != nil {
+		t.Fatalf("EnableFieldEncryption: %v", err)
+	}
+
+	mem := &models.Memory{Content: "geheimer Inhalt", Tags: "projekt", Metadata: `{"project":"apollo"}`, AppID: "app", ExternalUserID: "u1"}
+	if err := s.CreateMemory(mem); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	if mem.Content != "geheimer Inhalt" {
+		t.Fatalf("caller must keep the plaintext, got %q", mem.Content)
+	}
+	mem.Content = "geheimer Inhalt v2"
+	if err := s.UpdateMemory(mem, "api"); err != nil {
+		t.Fatalf("UpdateMemory: %v", err)
+	}
+	if err := s.CreateAgentContext(&models.AgentContext{AppID: "app", ExternalUserID: "u1", AgentID: "a", MemoryType: "episodic", Payload: `{"note":"vertraulich"}`}); err != nil {
+		t.Fatalf("CreateAgentContext: %v", err)
+	}
+	if err := s.AppendEvent(&models.Event{Type: "memory.created", AppID: "app", ExternalUserID: "u1", Payload: `{"content":"geheimer Inhalt"}`}); err != nil {
+		t.Fatalf("AppendEvent: %v", err)
+	}
+	wh := &models.Webhook{URL: "https://example.com/hook", Events: "*", Active: true}
+	if err := s.CreateWebhook(wh); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	delivery := &models.WebhookDelivery{WebhookID: wh.ID, AppID: "app", ExternalUserID: "u1", Event: "memory.created", Payload: `{"content":"geheimer Inhalt"}`, MaxAttempts: 3}
+	if err := s.EnqueueWebhookDelivery(delivery); err != nil {
+		t.Fatalf("EnqueueWebhookDelivery: %v", err)
+	}
+	delivery.Status, delivery.Attempts, delivery.LastError = models.DeliveryStatusPending, 1, "timeout"
+	if err := s.RecordWebhookDeliveryAttempt(delivery, &models.WebhookDeliveryAttempt{Attempt: 1, Error: "timeout"}); err != nil {
+		t.Fatalf("RecordWebhookDeliveryAttempt: %v", err)
+	}
+
+	// Auf der Platte nur Chiffrat
+	for _, q := range []string{
+		"SELECT content FROM memories", "SELECT metadata FROM memories",
+		"SELECT content FROM memory_versions", "SELECT metadata FROM memory_versions",
+		"SELECT payload FROM agent_contexts", "SELECT data FROM changes", "SELECT payload FROM events",
+		"SELECT payload FROM webhook_deliveries",
+	} {
+		assertSealed(t, s, q, "")
+	}
+	if tags := rawValues(t, s, "SELECT tags FROM memories"); tags[0] != "projekt" {
+		t.Errorf("tags must stay searchable in plaintext, got %q", tags[0])
+	}
+	dbPath, _ := s.GetDatabasePath()
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"geheimer Inhalt", "apollo", "vertraulich"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("database file contains plaintext %q", secret)
+		}
+	}
+
+	// Lesen liefert Klartext
+	got, err := s.GetMemoryByIDAndTenant(mem.ID, "app", "u1", false)
+	if err != nil || got.Content != "geheimer Inhalt v2" || got.Metadata != `{"project":"apollo"}` {
+		t.Fatalf("GetMemoryByIDAndTenant: %v %+v", err, got)
+	}
+	if list, _ := s.ListMemoriesByTenant("app", "u1", 10, 0, false); len(list) != 1 || list[0].Content != "geheimer Inhalt v2" {
+		t.Errorf("ListMemoriesByTenant: %+v", list)
+	}
+	found, err := s.SearchMemoriesByTenantAndBundle("app", "u1", "Inhalt v2", nil, 10, nil, map[string]any{"project": "apollo"}, false)
+	if err != nil || len(found) != 1 {
+		t.Errorf("query and metadata filter must match the plaintext: %v %d", err, len(found))
+	}
+	if found, _ := s.SearchMemoriesByTenantAndBundle("app", "u1", "enc:v1", nil, 10, nil, nil, false); len(found) != 0 {
+		t.Error("query must not match the ciphertext")
+	}
+	if ranked, err := s.SearchMemoriesKeyword("app", "u1", "projekt", nil, 10, nil, nil, false); err != nil || len(ranked) != 1 || ranked[0].Content != "geheimer Inhalt v2" {
+		t.Errorf("keyword search by tag: %v %+v", err, ranked)
+	}
+	versions, err := s.ListMemoryVersions(mem.ID, "app", "u1")
+	if err != nil || len(versions) != 1 || versions[0].Content != "geheimer Inhalt" {
+		t.Errorf("ListMemoryVersions: %v %+v", err, versions)
+	}
+	contexts, err := s.ListAgentContexts("app", "u1", "", "", "")
+	if err != nil || len(contexts) != 1 || contexts[0].Payload != `{"note":"vertraulich"}` {
+		t.Errorf("ListAgentContexts: %v %+v", err, contexts)
+	}
+	feed, err := s.ListChangesSince(0, ChangeFilter{AppID: "app", ExternalUserID: "u1"}, 100)
+	if err != nil || len(feed.Changes) == 0 || !strings.Contains(feed.Changes[0].Data, "geheimer Inhalt") {
+		t.Errorf("ListChangesSince: %v %+v", err, feed)
+	}
+	events, err := s.ListEventsSince("app", "u1", 0, nil, 10)
+	if err != nil || len(events) != 1 || !strings.Contains(events[0].Payload, "geheimer Inhalt") {
+		t.Errorf("ListEventsSince: %v %+v", err, events)
+	}
+	due, err := s.ListDueWebhookDeliveries(time.Now(), 10)
+	if err != nil || len(due) != 1 || due[0].Payload != `{"content":"geheimer Inhalt"}` || due[0].Attempts != 1 {
+		t.Errorf("ListDueWebhookDeliveries: %v %+v", err, due)
+	}
+	var export bytes.Buffer
+	if _, err := s.ExportNDJSON(&export, ExportOptions{AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("ExportNDJSON: %v", err)
+	}
+	if !strings.Contains(export.String(), "geheimer Inhalt v2") || strings.Contains(export.String(), crypt.FieldPrefix) {
+		t.Error("export must contain the plaintext")
+	}
+
+	// Import in einen verschlüsselten Store: Konflikterkennung vergleicht Klartext
+	report, err := s.ImportNDJSON(bytes.NewReader(export.Bytes()), ImportOptions{Conflict: ConflictSkip})
+	if err != nil || report.Imported[RecordMemory] != 0 || report.Imported[RecordAgentContext] != 0 {
+		t.Errorf("re-import must detect the existing rows: %v %+v", err, report)
+	}
+}
+
+func TestFieldEncryptionKeepsCallerPlaintext(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	if _, err := s.EnableFieldEncryption(testMasterKey(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// Das Model während des Inserts beobachten: andere Goroutinen dürfen es lesen
+	var seen []string
+	if err := s.GetDB().Callback().Create().After("cortex:seal_fields").Before("gorm:create").Register("test:observe", func(db *gorm.DB) {
+		if m, ok := db.Statement.Dest.(*models.Memory); ok {
+			seen = append(seen, m.Content)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.GetDB().Callback().Create().Remove("test:observe")
+
+	// Ohne AppID: gespeichert unter dem Default-Tenant, mit dessen Key
+	mem := &models.Memory{Content: "geheim"}
+	if err := s.CreateMemory(mem); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0] != "geheim" || mem.Content != "geheim" {
+		t.Fatalf("model must keep the plaintext while it is written: %q %q", seen, mem.Content)
+	}
+	assertSealed(t, s, "SELECT content FROM memories", "")
+	got, err := s.GetMemoryByIDAndTenant(mem.ID, "openclaw", "default", false)
+	if err != nil || got.Content != "geheim" {
+		t.Fatalf("GetMemoryByIDAndTenant: %v %+v", err, got)
+	}
+	if rot, err := s.RotateTenantKey("openclaw", "default"); err != nil || len(rot.RetiredKeys) != 1 {
+		t.Errorf("the value must be sealed with the key of its tenant: %v %+v", err, rot)
+	}
+
+	updates := map[string]any{"metadata": `{"k":"v"}`}
+	if err := s.GetDB().Model(got).Updates(updates).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates["metadata"] != `{"k":"v"}` || got.Metadata != `{"k":"v"}` {
+		t.Errorf("update map and model must keep the plaintext: %v %q", updates, got.Metadata)
+	}
+	assertSealed(t, s, "SELECT metadata FROM memories", "")
+}
+
+func TestFieldEncryptionKeyRotation(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Vor dem Einschalten geschrieben: bleibt lesbar, bis die Rotation es verschlüsselt
+	if err := s.CreateMemory(&models.Memory{Content: "alt", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	master := testMasterKey(t, 1)
+	if _, err := s.EnableFieldEncryption(master); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "neu", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "andere", AppID: "app", ExternalUserID: "u2"}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := s.EncryptionStatus()
+	if err != nil || !status.Enabled || status.TenantKeys != 2 || status.Unencrypted != 2 { // memory "alt" und ihr Change
+		t.Fatalf("EncryptionStatus: %v %+v", err, status)
+	}
+	firstKey := rawValues(t, s, "SELECT key_id FROM tenant_keys WHERE external_user_id = 'u1'")[0]
+
+	rot, err := s.RotateTenantKey("app", "u1")
+	if err != nil {
+		t.Fatalf("RotateTenantKey: %v", err)
+	}
+	// 2 Memories + 2 Changes
+	if rot.Reencrypted != 4 || len(rot.RetiredKeys) != 1 || rot.RetiredKeys[0] != firstKey {
+		t.Fatalf("unexpected rotation %+v", rot)
+	}
+	assertSealed(t, s, "SELECT content FROM memories WHERE external_user_id = 'u1'", rot.KeyID)
+	assertSealed(t, s, "SELECT data FROM changes WHERE external_user_id = 'u1'", rot.KeyID)
+	if status, _ := s.EncryptionStatus(); status.Unencrypted != 0 || status.TenantKeys != 2 {
+		t.Errorf("after rotation: %+v", status)
+	}
+	if n := countMemories(t, s); n != 2 {
+		t.Errorf("memories must stay readable after rotation, got %d", n)
+	}
+	rotations, err := s.RotateAllTenantKeys()
+	if err != nil || len(rotations) != 2 {
+		t.Fatalf("RotateAllTenantKeys: %v %+v", err, rotations)
+	}
+	s.Close()
+
+	// Master-Key-Wechsel: Tenant-Keys werden beim Start neu verpackt
+	dataKeys.Clear()
+	newMaster := testMasterKey(t, 2)
+	s, err = NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListMemoriesByTenant("app", "u1", 10, 0, true); !errors.Is(err, ErrFieldKeyMissing) {
+		t.Errorf("reading without master key: expected ErrFieldKeyMissing, got %v", err)
+	}
+	if _, err := s.EnableFieldEncryption(newMaster); err == nil {
+		t.Error("keys wrapped with an unknown master key must be rejected")
+	}
+	rewrapped, err := s.EnableFieldEncryption(newMaster, master)
+	if err != nil || rewrapped != 2 {
+		t.Fatalf("EnableFieldEncryption(new, previous): %v rewrapped=%d", err, rewrapped)
+	}
+	if n := countMemories(t, s); n != 2 {
+		t.Errorf("memories must be readable with the new master key, got %d", n)
+	}
+	s.Close()
+
+	dataKeys.Clear()
+	s, err = NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if rewrapped, err := s.EnableFieldEncryption(newMaster); err != nil || rewrapped != 0 {
+		t.Fatalf("previous master key must no longer be needed: %v rewrapped=%d", err, rewrapped)
+	}
+	if n := countMemories(t, s); n != 2 {
+		t.Errorf("got %d memories", n)
+	}
+}
+
+func TestFieldEncryptionRolledBackKey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := testMasterKey(t, 1)
+	if _, err := s.EnableFieldEncryption(master); err != nil {
+		t.Fatal(err)
+	}
+	// Der erste Write des Tenants legt den Key an und wird zurückgerollt
+	rollback := errors.New("rollback")
+	err = s.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.Memory{Content: "verworfen", AppID: "app", ExternalUserID: "u1"}).Error; err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatal(err)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "bleibt", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	dataKeys.Clear()
+	s, err = NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.EnableFieldEncryption(master); err != nil {
+		t.Fatal(err)
+	}
+	memories, err := s.ListMemoriesByTenant("app", "u1", 10, 0, true)
+	if err != nil || len(memories) != 1 || memories[0].Content != "bleibt" {
+		t.Fatalf("the data key must have been stored with the committed write: %v %+v", err, memories)
+	}
+}
+
+func TestFieldEncryptionRestore(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	if _, err := s.EnableFieldEncryption(testMasterKey(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "im Backup", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := s.BackupDatabase(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	// Nach der Rotation ist der Key des Backups gelöscht; der Restore muss ihn aus dem Backup laden
+	if _, err := s.RotateTenantKey("app", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RestoreDatabase(backupPath); err != nil {
+		t.Fatalf("RestoreDatabase: %v", err)
+	}
+	memories, err := s.ListMemoriesByTenant("app", "u1", 10, 0, true)
+	if err != nil || len(memories) != 1 || memories[0].Content != "im Backup" {
+		t.Fatalf("restored memories must be readable: %v %+v", err, memories)
+	}
+	if err := s.CreateMemory(&models.Memory{Content: "nach dem Restore", AppID: "app", ExternalUserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	assertSealed(t, s, "SELECT content FROM memories", rawValues(t, s, "SELECT key_id FROM tenant_keys")[0])
+}
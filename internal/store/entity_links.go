@@ -0,0 +1,76 @@
+package store
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"cortex/internal/models"
+)
+
+// ListEntityNames returns the names of all entities of a tenant (dictionary for entity extraction).
+func (s *CortexStore) ListEntityNames(appID, externalUserID string) ([]string, error) {
+	var names []string
+	err := s.applyTenantFilter(s.db.Model(&models.Entity{}), appID, externalUserID).Pluck("name", &names).Error
+	return names, err
+}
+
+// LinkMemoryEntities replaces the entity links of mem with the given entity names. Missing entities are
+// created (without facts) in the memory's tenant. Returns the linked entities.
+func (s *CortexStore) LinkMemoryEntities(mem *models.Memory, names []string) ([]models.Entity, error) {
+	var linked []models.Entity
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("memory_id = ?", mem.ID).Delete(&models.MemoryEntity{}).Error; err != nil {
+			return err
+		}
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+			ent := models.Entity{AppID: mem.AppID, ExternalUserID: mem.ExternalUserID, Name: name, Data: "{}"}
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ent)
+			if res.Error != nil {
+				return res.Error
+			}
+			if err := s.applyTenantFilter(tx, mem.AppID, mem.ExternalUserID).Where("name = ?", name).First(&ent).Error; err != nil {
+				return err
+			}
+			if res.RowsAffected > 0 {
+				if err := logChange(tx, models.ChangeKindEntity, models.ChangeOpCreate, ent.ID, ent.AppID, ent.ExternalUserID, &ent); err != nil {
+					return err
+				}
+			}
+			link := models.MemoryEntity{MemoryID: mem.ID, EntityID: ent.ID}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error; err != nil {
+				return err
+			}
+			linked = append(linked, ent)
+		}
+		return logMemoryEntitiesChange(tx, mem)
+	})
+	return linked, err
+}
+
+// linkedMemoriesQuery selects the memories of a tenant linked to the entity name.
+func (s *CortexStore) linkedMemoriesQuery(appID, externalUserID, name string) *gorm.DB {
+	sub := s.db.Table("memory_entities").
+		Select("memory_entities.memory_id").
+		Joins("JOIN entities ON entities.id = memory_entities.entity_id").
+		Where("entities.app_id = ? AND entities.external_user_id = ? AND entities.name = ?", appID, externalUserID, name)
+	return s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID).Where("id IN (?)", sub)
+}
+
+// MemoryIDsForEntity returns the IDs of active memories of a tenant linked to the entity name.
+func (s *CortexStore) MemoryIDsForEntity(appID, externalUserID, name string) ([]int64, error) {
+	var ids []int64
+	err := s.memoryStatusFilter(s.linkedMemoriesQuery(appID, externalUserID, name), false).
+		Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ListEntityMemories returns the newest active memories linked to the entity name.
+func (s *CortexStore) ListEntityMemories(appID, externalUserID, name string, limit int) ([]models.Memory, error) {
+	var memories []models.Memory
+	err := s.memoryStatusFilter(s.linkedMemoriesQuery(appID, externalUserID, name), false).
+		Order("created_at DESC").Limit(limit).Find(&memories).Error
+	return memories, err
+}
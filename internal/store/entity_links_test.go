@@ -0,0 +1,93 @@
+package store
+
+import (
+	"testing"
+
+	"cortex/internal/models"
+)
+
+func TestLinkMemoryEntities(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	s.CreateOrUpdateEntity(&models.Entity{AppID: "app1", ExternalUserID: "user1", Name: "user:alice", Data: `{"role":"dev"}`})
+	m1 := &models.Memory{Type: "semantic", Content: "alice mag project:cortex", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	m2 := &models.Memory{Type: "semantic", Content: "noch was zu alice", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	other := &models.Memory{Type: "semantic", Content: "fremder Tenant", AppID: "app1", ExternalUserID: "user2", Importance: 5}
+	for _, m := range []*models.Memory{m1, m2, other} {
+		s.CreateMemory(m)
+	}
+
+	linked, err := s.LinkMemoryEntities(m1, []string{"user:alice", "project:cortex"})
+	if err != nil {
+		t.Fatalf("LinkMemoryEntities failed: %v", err)
+	}
+	if len(linked) != 2 {
+		t.Fatalf("expected 2 linked entities, got %d", len(linked))
+	}
+	if _, err := s.LinkMemoryEntities(m2, []string{"user:alice"}); err != nil {
+		t.Fatalf("LinkMemoryEntities failed: %v", err)
+	}
+	if _, err := s.LinkMemoryEntities(other, []string{"user:alice"}); err != nil {
+		t.Fatalf("LinkMemoryEntities failed: %v", err)
+	}
+
+	// Vorhandene Entity bleibt unverändert, fehlende wird angelegt
+	ent, err := s.GetEntity("app1", "user1", "user:alice")
+	if err != nil || ent.Data != `{"role":"dev"}` {
+		t.Errorf("expected existing entity to keep its facts, got %+v (err %v)", ent, err)
+	}
+	if _, err := s.GetEntity("app1", "user1", "project:cortex"); err != nil {
+		t.Errorf("expected project:cortex to be created: %v", err)
+	}
+
+	ids, err := s.MemoryIDsForEntity("app1", "user1", "user:alice")
+	if err != nil {
+		t.Fatalf("MemoryIDsForEntity failed: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != m1.ID || ids[1] != m2.ID {
+		t.Errorf("expected memories %d and %d, got %v", m1.ID, m2.ID, ids)
+	}
+	names, _ := s.ListEntityNames("app1", "user2")
+	if len(names) != 1 || names[0] != "user:alice" {
+		t.Errorf("expected user2 to have its own user:alice, got %v", names)
+	}
+
+	// Neu verknüpfen ersetzt die Links
+	if _, err := s.LinkMemoryEntities(m1, []string{"project:cortex"}); err != nil {
+		t.Fatalf("relink failed: %v", err)
+	}
+	if ids, _ := s.MemoryIDsForEntity("app1", "user1", "user:alice"); len(ids) != 1 || ids[0] != m2.ID {
+		t.Errorf("expected only m2 linked to alice after relink, got %v", ids)
+	}
+
+	// Löschen entfernt die Links
+	s.DeleteMemory(m2)
+	if mems, _ := s.ListEntityMemories("app1", "user1", "user:alice", 10); len(mems) != 0 {
+		t.Errorf("expected no linked memories after delete, got %d", len(mems))
+	}
+	var count int64
+	s.db.Model(&models.MemoryEntity{}).Where("memory_id = ?", m2.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("expected link rows of deleted memory to be removed, got %d", count)
+	}
+}
+
+func TestMergeMemoriesKeepsEntityLinks(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	keep := &models.Memory{Type: "semantic", Content: "Kaffee", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	merge := &models.Memory{Type: "semantic", Content: "Kaffee mit alice", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	s.CreateMemory(keep)
+	s.CreateMemory(merge)
+	s.LinkMemoryEntities(merge, []string{"user:alice"})
+
+	if err := s.MergeMemories(keep.ID, merge.ID, "app1", "user1"); err != nil {
+		t.Fatalf("MergeMemories failed: %v", err)
+	}
+	ids, _ := s.MemoryIDsForEntity("app1", "user1", "user:alice")
+	if len(ids) != 1 || ids[0] != keep.ID {
+		t.Errorf("expected kept memory to inherit the link (archived one filtered), got %v", ids)
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"cortex/internal/models"
+)
+
+// AppendEvent appends an event to the event log; ev.ID receives the next sequence number.
+func (s *CortexStore) AppendEvent(ev *models.Event) error {
+	return s.db.Create(ev).Error
+}
+
+// ListEventsSince returns events of a tenant with ID > afterID in sequence order. types filters by event
+// type; an entry may be an exact type, "<prefix>.*" or "*" (empty = all types).
+func (s *CortexStore) ListEventsSince(appID, externalUserID string, afterID int64, types []string, limit int) ([]models.Event, error) {
+	events := make([]models.Event, 0)
+	dbQuery := s.applyTenantFilter(s.db.Model(&models.Event{}), appID, externalUserID).Where("id > ?", afterID)
+	if len(types) > 0 && !slices.Contains(types, "*") {
+		conds := make([]string, len(types))
+		args := make([]any, len(types))
+		for i, t := range types {
+			if strings.HasSuffix(t, ".*") {
+				conds[i], args[i] = "type LIKE ?", strings.TrimSuffix(t, "*")+"%"
+			} else {
+				conds[i], args[i] = "type = ?", t
+			}
+		}
+		dbQuery = dbQuery.Where(strings.Join(conds, " OR "), args...)
+	}
+	err := dbQuery.Order("id ASC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// PruneEvents deletes events created before cutoff. Returns the number of deleted events.
+func (s *CortexStore) PruneEvents(cutoff time.Time) (int64, error) {
+	res := s.db.Where("created_at < ?", cutoff).Delete(&models.Event{})
+	return res.RowsAffected, res.Error
+}
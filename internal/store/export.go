@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
 	"time"
 
+	"gorm.io/gorm"
+
+	"cortex/internal/helpers"
 	"cortex/internal/models"
 )
 
@@ -26,8 +28,14 @@ func (s *CortexStore) ExportMemories(appID, externalUserID string, includeArchiv
 	if !includeArchived {
 		q = q.Where("status = ?", models.MemoryStatusActive)
 	}
-	err := q.Order("created_at ASC").Find(&memories).Error
-	return memories, err
+	if err := q.Order("created_at ASC").Find(&memories).Error; err != nil {
+		return nil, err
+	}
+	// Metadata ist json:"-": nur MetadataMap landet im Export
+	for i := range memories {
+		memories[i].MetadataMap = helpers.UnmarshalMetadata(memories[i].Metadata)
+	}
+	return memories, nil
 }
 
 // ExportBundles exports all bundles for a tenant
@@ -79,18 +87,33 @@ func (s *CortexStore) ExportAll(appID, externalUserID string, includeArchived bo
 // ImportMemories imports memories from a slice
 func (s *CortexStore) ImportMemories(memories []models.Memory, overwrite bool) error {
 	for _, mem := range memories {
-		if overwrite && mem.ID > 0 {
-			// Update existing memory
-			if err := s.db.Save(&mem).Error; err != nil {
-				return fmt.Errorf("failed to import memory %d: %w", mem.ID, err)
+		if mem.Metadata == "" && mem.MetadataMap != nil {
+			mem.Metadata = helpers.MarshalMetadata(mem.MetadataMap)
+		}
+		op := models.ChangeOpCreate
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if overwrite && mem.ID > 0 {
+				// Update existing memory
+				op = models.ChangeOpUpdate
+				if err := tx.Save(&mem).Error; err != nil {
+					return err
+				}
+			} else {
+				// Create new memory (ignore ID)
+				mem.ID = 0
+				if err := tx.Create(&mem).Error; err != nil {
+					return err
+				}
 			}
-		} else {
-			// Create new memory (ignore ID)
-			mem.ID = 0
-			if err := s.db.Create(&mem).Error; err != nil {
-				return fmt.Errorf("failed to import memory: %w", err)
+			return logMemoryChange(tx, op, &mem)
+		})
+		if err != nil {
+			if op == models.ChangeOpUpdate {
+				return fmt.Errorf("failed to import memory %d: %w", mem.ID, err)
 			}
+			return fmt.Errorf("failed to import memory: %w", err)
 		}
+		s.indexMemory(&mem)
 	}
 	return nil
 }
@@ -98,17 +121,28 @@ func (s *CortexStore) ImportMemories(memories []models.Memory, overwrite bool) e
 // ImportBundles imports bundles from a slice
 func (s *CortexStore) ImportBundles(bundles []models.Bundle, overwrite bool) error {
 	for _, bundle := range bundles {
-		if overwrite && bundle.ID > 0 {
-			// Update existing bundle
-			if err := s.db.Save(&bundle).Error; err != nil {
-				return fmt.Errorf("failed to import bundle %d: %w", bundle.ID, err)
+		op := models.ChangeOpCreate
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if overwrite && bundle.ID > 0 {
+				// Update existing bundle
+				op = models.ChangeOpUpdate
+				if err := tx.Save(&bundle).Error; err != nil {
+					return err
+				}
+			} else {
+				// Create new bundle (ignore ID)
+				bundle.ID = 0
+				if err := tx.Create(&bundle).Error; err != nil {
+					return err
+				}
 			}
-		} else {
-			// Create new bundle (ignore ID)
-			bundle.ID = 0
-			if err := s.db.Create(&bundle).Error; err != nil {
-				return fmt.Errorf("failed to import bundle: %w", err)
+			return logChange(tx, models.ChangeKindBundle, op, bundle.ID, bundle.AppID, bundle.ExternalUserID, &bundle)
+		})
+		if err != nil {
+			if op == models.ChangeOpUpdate {
+				return fmt.Errorf("failed to import bundle %d: %w", bundle.ID, err)
 			}
+			return fmt.Errorf("failed to import bundle: %w", err)
 		}
 	}
 	return nil
@@ -117,17 +151,28 @@ func (s *CortexStore) ImportBundles(bundles []models.Bundle, overwrite bool) err
 // ImportWebhooks imports webhooks from a slice
 func (s *CortexStore) ImportWebhooks(webhooks []models.Webhook, overwrite bool) error {
 	for _, webhook := range webhooks {
-		if overwrite && webhook.ID > 0 {
-			// Update existing webhook
-			if err := s.db.Save(&webhook).Error; err != nil {
-				return fmt.Errorf("failed to import webhook %d: %w", webhook.ID, err)
+		op := models.ChangeOpCreate
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if overwrite && webhook.ID > 0 {
+				// Update existing webhook
+				op = models.ChangeOpUpdate
+				if err := tx.Save(&webhook).Error; err != nil {
+					return err
+				}
+			} else {
+				// Create new webhook (ignore ID)
+				webhook.ID = 0
+				if err := tx.Create(&webhook).Error; err != nil {
+					return err
+				}
 			}
-		} else {
-			// Create new webhook (ignore ID)
-			webhook.ID = 0
-			if err := s.db.Create(&webhook).Error; err != nil {
-				return fmt.Errorf("failed to import webhook: %w", err)
+			return logChange(tx, models.ChangeKindWebhook, op, webhook.ID, webhook.AppID, "", &webhook)
+		})
+		if err != nil {
+			if op == models.ChangeOpUpdate {
+				return fmt.Errorf("failed to import webhook %d: %w", webhook.ID, err)
 			}
+			return fmt.Errorf("failed to import webhook: %w", err)
 		}
 	}
 	return nil
@@ -151,27 +196,6 @@ func (s *CortexStore) ImportData(data *ExportData, overwrite bool) error {
 	return nil
 }
 
-// BackupDatabase creates a backup of the database file
-func (s *CortexStore) BackupDatabase(backupPath string) error {
-	sqlDB, err := s.db.DB()
-	if err != nil {
-		return fmt.Errorf("failed to get database connection: %w", err)
-	}
-
-	// SQLite backup using VACUUM INTO (SQLite 3.27+)
-	// Escape single quotes in path
-	escapedPath := strings.ReplaceAll(backupPath, "'", "''")
-	backupSQL := fmt.Sprintf("VACUUM INTO '%s'", escapedPath)
-
-	// Use raw SQL execution
-	_, err = sqlDB.Exec(backupSQL)
-	if err != nil {
-		return fmt.Errorf("failed to backup database: %w", err)
-	}
-
-	return nil
-}
-
 // CopyFile copies a file from src to dst
 func (s *CortexStore) CopyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -0,0 +1,284 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// Traversal strategies and edge directions
+const (
+	TraverseBFS = "bfs"
+	TraverseDFS = "dfs"
+
+	DirectionOut  = "out"
+	DirectionIn   = "in"
+	DirectionBoth = "both"
+)
+
+// Traversal limits
+const (
+	DefaultTraverseDepth = 2
+	MaxTraverseDepth     = 6
+	DefaultTraverseNodes = 100
+	MaxTraverseNodes     = 1000
+)
+
+// TraverseOptions configures a graph traversal starting at one entity.
+type TraverseOptions struct {
+	Start     string
+	MaxDepth  int        // hops from Start, default DefaultTraverseDepth
+	Types     []string   // only follow relations of these types (empty = all)
+	Direction string     // out (from -> to), in (to -> from) or both (default)
+	Strategy  string     // bfs (default) or dfs
+	At        *time.Time // only follow relations valid at this time (nil = now)
+	MaxNodes  int        // stop after this many nodes, default DefaultTraverseNodes
+}
+
+// GraphNode is an entity name reached by a traversal.
+type GraphNode struct {
+	Name  string         `json:"name"`
+	Depth int            `json:"depth"`
+	Data  map[string]any `json:"data,omitempty"` // facts, if an entity with this name exists
+}
+
+// GraphPath leads from the start node to one reached node.
+type GraphPath struct {
+	Nodes     []string `json:"nodes"`     // start ... target
+	Relations []int64  `json:"relations"` // relation IDs between consecutive nodes
+}
+
+// GraphTraversal is the result of TraverseGraph.
+type GraphTraversal struct {
+	Start     string            `json:"start"`
+	At        time.Time         `json:"at"`
+	Nodes     []GraphNode       `json:"nodes"`
+	Edges     []models.Relation `json:"edges"`
+	Paths     []GraphPath       `json:"paths"`
+	Truncated bool              `json:"truncated"` // MaxNodes was reached
+}
+
+// graphWalker holds the state of one traversal.
+type graphWalker struct {
+	s             *CortexStore
+	appID, userID string
+	opts          TraverseOptions
+	at            time.Time
+	nodes         map[string]*GraphNode
+	order         []string
+	paths         map[string]GraphPath
+	edges         []models.Relation
+	edgeSeen      map[int64]bool
+	truncated     bool
+}
+
+// TraverseGraph walks the relations of a tenant from opts.Start up to opts.MaxDepth hops. Every reached
+// node gets its shortest known path (BFS: shortest path; DFS: re-expanded when found on a shorter path).
+func (s *CortexStore) TraverseGraph(appID, externalUserID string, opts TraverseOptions) (*GraphTraversal, error) {
+	if opts.Start == "" {
+		return nil, fmt.Errorf("start entity is required")
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultTraverseDepth
+	}
+	if opts.MaxDepth > MaxTraverseDepth {
+		return nil, fmt.Errorf("depth must be at most %d", MaxTraverseDepth)
+	}
+	if opts.MaxNodes <= 0 {
+		opts.MaxNodes = DefaultTraverseNodes
+	}
+	opts.MaxNodes = min(opts.MaxNodes, MaxTraverseNodes)
+	switch opts.Direction {
+	case "":
+		opts.Direction = DirectionBoth
+	case DirectionOut, DirectionIn, DirectionBoth:
+	default:
+		return nil, fmt.Errorf("invalid direction %q (out, in, both)", opts.Direction)
+	}
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = TraverseBFS
+	case TraverseBFS, TraverseDFS:
+	default:
+		return nil, fmt.Errorf("invalid strategy %q (bfs, dfs)", opts.Strategy)
+	}
+	at := time.Now()
+	if opts.At != nil {
+		at = *opts.At
+	}
+
+	w := &graphWalker{
+		s: s, appID: appID, userID: externalUserID, opts: opts, at: at,
+		nodes:    map[string]*GraphNode{opts.Start: {Name: opts.Start}},
+		order:    []string{opts.Start},
+		paths:    map[string]GraphPath{opts.Start: {Nodes: []string{opts.Start}, Relations: []int64{}}},
+		edgeSeen: make(map[int64]bool),
+	}
+	var err error
+	if opts.Strategy == TraverseDFS {
+		err = w.dfs(opts.Start, 0)
+	} else {
+		err = w.bfs()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w.result()
+}
+
+// neighbors loads the relations leaving (out), entering (in) or touching (both) the given names,
+// restricted to the configured types and valid at w.at.
+func (w *graphWalker) neighbors(names []string) ([]models.Relation, error) {
+	dbQuery := w.s.applyTenantFilter(w.s.db.Model(&models.Relation{}), w.appID, w.userID)
+	switch w.opts.Direction {
+	case DirectionOut:
+		dbQuery = dbQuery.Where("from_entity IN ?", names)
+	case DirectionIn:
+		dbQuery = dbQuery.Where("to_entity IN ?", names)
+	default:
+		dbQuery = dbQuery.Where("from_entity IN ? OR to_entity IN ?", names, names)
+	}
+	if len(w.opts.Types) > 0 {
+		dbQuery = dbQuery.Where("type IN ?", w.opts.Types)
+	}
+	var relations []models.Relation
+	if err := dbQuery.Order("id").Find(&relations).Error; err != nil {
+		return nil, err
+	}
+	valid := relations[:0]
+	for _, rel := range relations {
+		if rel.ValidAt(w.at) {
+			valid = append(valid, rel)
+		}
+	}
+	return valid, nil
+}
+
+// steps returns the nodes reachable from name over rel in the configured direction.
+func (w *graphWalker) steps(name string, rel models.Relation) []string {
+	var next []string
+	if w.opts.Direction != DirectionIn && rel.From == name {
+		next = append(next, rel.To)
+	}
+	if w.opts.Direction != DirectionOut && rel.To == name && rel.From != rel.To {
+		next = append(next, rel.From)
+	}
+	return next
+}
+
+// reach records that target was reached from source over rel at depth. Returns true if target has to
+// be expanded (new node or found on a shorter path).
+func (w *graphWalker) reach(source, target string, rel models.Relation, depth int) bool {
+	node, known := w.nodes[target]
+	if !known && len(w.nodes) >= w.opts.MaxNodes {
+		w.truncated = true
+		return false
+	}
+	if !w.edgeSeen[rel.ID] {
+		w.edgeSeen[rel.ID] = true
+		w.edges = append(w.edges, rel)
+	}
+	if known && node.Depth <= depth {
+		return false
+	}
+	if !known {
+		node = &GraphNode{Name: target}
+		w.nodes[target] = node
+		w.order = append(w.order, target)
+	}
+	node.Depth = depth
+	parent := w.paths[source]
+	w.paths[target] = GraphPath{
+		Nodes:     append(append([]string{}, parent.Nodes...), target),
+		Relations: append(append([]int64{}, parent.Relations...), rel.ID),
+	}
+	return true
+}
+
+func (w *graphWalker) bfs() error {
+	frontier := []string{w.opts.Start}
+	for depth := 1; depth <= w.opts.MaxDepth && len(frontier) > 0; depth++ {
+		relations, err := w.neighbors(frontier)
+		if err != nil {
+			return err
+		}
+		inFrontier := make(map[string]bool, len(frontier))
+		for _, name := range frontier {
+			inFrontier[name] = true
+		}
+		var next []string
+		for _, rel := range relations {
+			for _, source := range []string{rel.From, rel.To} {
+				if !inFrontier[source] {
+					continue
+				}
+				for _, target := range w.steps(source, rel) {
+					if w.reach(source, target, rel, depth) {
+						next = append(next, target)
+					}
+				}
+				if rel.From == rel.To {
+					break
+				}
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+func (w *graphWalker) dfs(name string, depth int) error {
+	if depth >= w.opts.MaxDepth {
+		return nil
+	}
+	relations, err := w.neighbors([]string{name})
+	if err != nil {
+		return err
+	}
+	for _, rel := range relations {
+		for _, target := range w.steps(name, rel) {
+			if !w.reach(name, target, rel, depth+1) {
+				continue
+			}
+			if err := w.dfs(target, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// result assembles nodes (discovery order, with entity facts), edges and the paths to all reached nodes.
+func (w *graphWalker) result() (*GraphTraversal, error) {
+	var entities []models.Entity
+	if err := w.s.applyTenantFilter(w.s.db, w.appID, w.userID).Where("name IN ?", w.order).Find(&entities).Error; err != nil {
+		return nil, err
+	}
+	data := make(map[string]map[string]any, len(entities))
+	for _, ent := range entities {
+		data[ent.Name] = helpers.UnmarshalEntityData(ent.Data)
+	}
+
+	res := &GraphTraversal{
+		Start:     w.opts.Start,
+		At:        w.at,
+		Nodes:     make([]GraphNode, 0, len(w.order)),
+		Edges:     w.edges,
+		Paths:     make([]GraphPath, 0, len(w.order)),
+		Truncated: w.truncated,
+	}
+	if res.Edges == nil {
+		res.Edges = []models.Relation{}
+	}
+	for _, name := range w.order {
+		node := *w.nodes[name]
+		node.Data = data[name]
+		res.Nodes = append(res.Nodes, node)
+		if name != w.opts.Start {
+			res.Paths = append(res.Paths, w.paths[name])
+		}
+	}
+	return res, nil
+}
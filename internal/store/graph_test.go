@@ -0,0 +1,138 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+)
+
+// seedGraph legt einen kleinen Graphen an:
+// alice -works_on-> project:x <-works_on- bob -knows-> carol -works_on-> project:y
+// dave -works_on-> project:x (abgelaufen), eve -works_on-> project:x (anderer Tenant)
+func seedGraph(t *testing.T, s *CortexStore) {
+	t.Helper()
+	past := time.Now().Add(-48 * time.Hour)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	rels := []models.Relation{
+		{From: "alice", To: "project:x", Type: "works_on"},
+		{From: "bob", To: "project:x", Type: "works_on"},
+		{From: "bob", To: "carol", Type: "knows"},
+		{From: "carol", To: "project:y", Type: "works_on"},
+		{From: "dave", To: "project:x", Type: "works_on", ValidFrom: &past, ValidTo: &yesterday},
+	}
+	for i := range rels {
+		rels[i].AppID, rels[i].ExternalUserID = "app1", "user1"
+		if err := s.CreateOrUpdateRelation(&rels[i]); err != nil {
+			t.Fatalf("CreateOrUpdateRelation failed: %v", err)
+		}
+	}
+	s.CreateOrUpdateRelation(&models.Relation{AppID: "app1", ExternalUserID: "other", From: "eve", To: "project:x", Type: "works_on"})
+	s.CreateOrUpdateEntity(&models.Entity{AppID: "app1", ExternalUserID: "user1", Name: "alice", Data: `{"role":"dev"}`})
+}
+
+func nodeNames(g *GraphTraversal) map[string]int {
+	names := make(map[string]int, len(g.Nodes))
+	for _, n := range g.Nodes {
+		names[n.Name] = n.Depth
+	}
+	return names
+}
+
+func TestTraverseGraphBFS(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	seedGraph(t, s)
+
+	g, err := s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", MaxDepth: 3})
+	if err != nil {
+		t.Fatalf("TraverseGraph failed: %v", err)
+	}
+	want := map[string]int{"project:x": 0, "alice": 1, "bob": 1, "carol": 2, "project:y": 3}
+	got := nodeNames(g)
+	if len(got) != len(want) {
+		t.Fatalf("expected nodes %v, got %v", want, got)
+	}
+	for name, depth := range want {
+		if got[name] != depth {
+			t.Errorf("node %s: expected depth %d, got %d (present: %v)", name, depth, got[name], got)
+		}
+	}
+	if len(g.Edges) != 4 {
+		t.Errorf("expected 4 edges (expired and foreign tenant excluded), got %d", len(g.Edges))
+	}
+	if len(g.Paths) != 4 {
+		t.Fatalf("expected a path per reached node, got %d", len(g.Paths))
+	}
+	for _, p := range g.Paths {
+		if p.Nodes[len(p.Nodes)-1] == "project:y" {
+			if len(p.Nodes) != 4 || p.Nodes[1] != "bob" || len(p.Relations) != 3 {
+				t.Errorf("unexpected path to project:y: %+v", p)
+			}
+		}
+	}
+	if g.Nodes[1].Name == "alice" && g.Nodes[1].Data["role"] != "dev" {
+		t.Errorf("expected entity facts on node alice, got %v", g.Nodes[1].Data)
+	}
+}
+
+func TestTraverseGraphFilters(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	seedGraph(t, s)
+
+	// Nur works_on: carol ist über knows nicht erreichbar
+	g, err := s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", MaxDepth: 3, Types: []string{"works_on"}})
+	if err != nil {
+		t.Fatalf("TraverseGraph failed: %v", err)
+	}
+	if got := nodeNames(g); len(got) != 3 {
+		t.Errorf("expected project:x, alice, bob; got %v", got)
+	}
+
+	// Richtung out: von project:x führen keine Kanten weg
+	g, _ = s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", Direction: DirectionOut})
+	if len(g.Nodes) != 1 {
+		t.Errorf("expected only the start node for direction out, got %v", nodeNames(g))
+	}
+
+	// Zeitpunkt innerhalb der Gültigkeit von dave
+	at := time.Now().Add(-36 * time.Hour)
+	g, _ = s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", MaxDepth: 1, At: &at})
+	if _, ok := nodeNames(g)["dave"]; !ok {
+		t.Errorf("expected dave to be reachable at %v, got %v", at, nodeNames(g))
+	}
+
+	g, _ = s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", MaxDepth: 3, MaxNodes: 2})
+	if len(g.Nodes) != 2 || !g.Truncated {
+		t.Errorf("expected truncation at 2 nodes, got %d (truncated %v)", len(g.Nodes), g.Truncated)
+	}
+
+	if _, err := s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", Direction: "sideways"}); err == nil {
+		t.Error("expected error for invalid direction")
+	}
+	if _, err := s.TraverseGraph("app1", "user1", TraverseOptions{Start: "project:x", MaxDepth: MaxTraverseDepth + 1}); err == nil {
+		t.Error("expected error for depth above maximum")
+	}
+}
+
+func TestTraverseGraphDFS(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+	seedGraph(t, s)
+
+	bfs, _ := s.TraverseGraph("app1", "user1", TraverseOptions{Start: "alice", MaxDepth: 4})
+	dfs, err := s.TraverseGraph("app1", "user1", TraverseOptions{Start: "alice", MaxDepth: 4, Strategy: TraverseDFS})
+	if err != nil {
+		t.Fatalf("TraverseGraph (dfs) failed: %v", err)
+	}
+	want, got := nodeNames(bfs), nodeNames(dfs)
+	if len(want) != len(got) {
+		t.Fatalf("bfs and dfs should reach the same nodes: %v vs %v", want, got)
+	}
+	for name, depth := range want {
+		if got[name] != depth {
+			t.Errorf("node %s: bfs depth %d, dfs depth %d", name, depth, got[name])
+		}
+	}
+}
@@ -0,0 +1,869 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// RecordWebhook is an import-only record type: JSON exports (ExportData) contain webhooks, NDJSON
+// exports do not (webhooks belong to an app, not to a tenant).
+const RecordWebhook = "webhook"
+
+// Conflict strategies of an import with new IDs (ImportOptions.Conflict)
+const (
+	ConflictDuplicate = "duplicate" // always insert a new row (default)
+	ConflictSkip      = "skip"      // keep the existing row, drop the record
+	ConflictOverwrite = "overwrite" // replace the fields of the existing row with the record
+	ConflictMerge     = "merge"     // merge the record into the existing row (metadata, tags, facts)
+)
+
+// ConflictStrategies lists the valid values of ImportOptions.Conflict.
+var ConflictStrategies = []string{ConflictDuplicate, ConflictSkip, ConflictOverwrite, ConflictMerge}
+
+// errSkipRecord marks a record that is valid but not applied (tombstones, ConflictSkip).
+var errSkipRecord = errors.New("record skipped")
+
+// errDryRun rolls back a dry-run import.
+var errDryRun = errors.New("dry run")
+
+// ImportOptions controls ImportNDJSON and ImportExportData.
+//
+// By default every record gets a new ID and references between records (bundle_id, memory_id,
+// metadata.merged_into) are remapped to the new IDs. A record that matches an existing row of its
+// tenant is handled by Conflict. Matches: memories by content hash, bundles and entities by name,
+// relations by from/to/type, agent contexts by agent, memory type and payload, webhooks by URL.
+type ImportOptions struct {
+	// KeepIDs keeps the IDs and tenants of the records and replaces existing rows with the same ID
+	// (restore of the same database); tombstones are applied. Rows of another tenant are never
+	// replaced. AppID/ExternalUserID and Conflict do not apply.
+	KeepIDs bool
+	// AppID and ExternalUserID, if set, rewrite the tenant of every record (import into another
+	// tenant). Webhooks only take the AppID.
+	AppID          string
+	ExternalUserID string
+	// Conflict is one of ConflictStrategies (default ConflictDuplicate). Entities and relations
+	// cannot be duplicated; for them duplicate behaves like overwrite.
+	Conflict string
+	// DryRun validates and applies every record in one transaction that is rolled back at the end.
+	DryRun bool
+	// OnProgress is called after each batch of records.
+	OnProgress func(ImportReport)
+	// OnError is called for every record that failed.
+	OnError func(ImportError)
+}
+
+// ImportError describes a record that could not be imported.
+type ImportError struct {
+	Line   int    `json:"line"`             // line of the NDJSON stream (JSON: position of the record)
+	Record string `json:"record,omitempty"` // record type
+	ID     int64  `json:"id,omitempty"`     // ID of the record in the import
+	Error  string `json:"error"`
+}
+
+// ImportReport summarises an import.
+type ImportReport struct {
+	DryRun          bool           `json:"dry_run"`
+	Lines           int            `json:"lines"`
+	Imported        map[string]int `json:"imported"` // per record type, created or updated
+	Skipped         int            `json:"skipped"`  // tombstones without KeepIDs, conflicts with ConflictSkip
+	Failed          int            `json:"failed"`
+	Errors          []ImportError  `json:"errors,omitempty"`
+	ErrorsTruncated bool           `json:"errors_truncated,omitempty"`
+}
+
+// importer holds the state of one import.
+type importer struct {
+	s      *CortexStore
+	db     *gorm.DB // s.db, or the transaction of a dry run
+	opts   ImportOptions
+	report *ImportReport
+
+	bundleIDs  map[int64]int64 // record ID -> ID in this database
+	memoryIDs  map[int64]int64
+	created    map[int64]bool  // record IDs of memories inserted by this import
+	kept       map[int64]bool  // record IDs of memories kept unchanged (ConflictSkip)
+	mergedInto map[int64]int64 // memory ID -> record ID of its merged_into target
+}
+
+type pendingRecord struct {
+	line   int
+	record Record
+}
+
+func (s *CortexStore) newImporter(opts ImportOptions) *importer {
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictDuplicate
+	}
+	return &importer{
+		s:          s,
+		db:         s.db,
+		opts:       opts,
+		report:     &ImportReport{DryRun: opts.DryRun, Imported: make(map[string]int)},
+		bundleIDs:  make(map[int64]int64),
+		memoryIDs:  make(map[int64]int64),
+		created:    make(map[int64]bool),
+		kept:       make(map[int64]bool),
+		mergedInto: make(map[int64]int64),
+	}
+}
+
+// ImportExportData imports a JSON export through the same record pipeline as ImportNDJSON (see
+// ImportOptions). Records are applied in the order bundles, memories, webhooks; ImportError.Line is
+// the position in that order.
+func (s *CortexStore) ImportExportData(data *ExportData, opts ImportOptions) (*ImportReport, error) {
+	imp := s.newImporter(opts)
+	err := imp.run(func(emit func(int, Record) error) error {
+		n := 0
+		add := func(typ string, v any) error {
+			n++
+			imp.report.Lines = n
+			raw, err := json.Marshal(v)
+			if err != nil {
+				imp.fail(n, typ, 0, err)
+				return nil
+			}
+			return emit(n, Record{Type: typ, Data: raw})
+		}
+		for i := range data.Bundles {
+			if err := add(RecordBundle, &data.Bundles[i]); err != nil {
+				return err
+			}
+		}
+		for i := range data.Memories {
+			mem := data.Memories[i]
+			if mem.MetadataMap == nil {
+				mem.MetadataMap = helpers.UnmarshalMetadata(mem.Metadata)
+			}
+			if err := add(RecordMemory, &mem); err != nil {
+				return err
+			}
+		}
+		for i := range data.Webhooks {
+			if err := add(RecordWebhook, &data.Webhooks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return imp.report, err
+}
+
+// run applies the records passed to emit by read in batches of ndjsonBatchSize records. A dry run
+// applies everything in one transaction that is rolled back at the end, so later records see earlier
+// ones exactly like in a real import.
+func (imp *importer) run(read func(emit func(int, Record) error) error) error {
+	if !imp.opts.DryRun {
+		err := imp.process(read)
+		if imp.report.Imported[RecordMemory] > 0 {
+			imp.s.invalidateVectorIndex()
+		}
+		return err
+	}
+	err := imp.s.db.Transaction(func(tx *gorm.DB) error {
+		imp.db = tx
+		if err := imp.process(read); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+func (imp *importer) process(read func(emit func(int, Record) error) error) error {
+	batch := make([]pendingRecord, 0, ndjsonBatchSize)
+	err := read(func(line int, rec Record) error {
+		batch = append(batch, pendingRecord{line: line, record: rec})
+		if len(batch) < ndjsonBatchSize {
+			return nil
+		}
+		err := imp.applyBatch(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := imp.applyBatch(batch); err != nil {
+		return err
+	}
+	return imp.resolveMergedInto()
+}
+
+// applyBatch applies records in one transaction, each in its own savepoint: a failing record is
+// rolled back and reported, the rest of the batch is kept.
+func (imp *importer) applyBatch(batch []pendingRecord) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	imported := make(map[string]int)
+	skipped := 0
+	var failed []ImportError
+	err := imp.db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range batch {
+			err := tx.Transaction(func(rtx *gorm.DB) error {
+				return imp.apply(rtx, p.record)
+			})
+			switch {
+			case errors.Is(err, errSkipRecord):
+				skipped++
+			case err != nil:
+				failed = append(failed, ImportError{Line: p.line, Record: p.record.Type, ID: recordID(p.record), Error: err.Error()})
+			case p.record.Type != RecordHeader && p.record.Type != RecordEnd:
+				imported[p.record.Type]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit records from line %d: %w", batch[0].line, err)
+	}
+	for typ, n := range imported {
+		imp.report.Imported[typ] += n
+	}
+	imp.report.Skipped += skipped
+	for _, e := range failed {
+		imp.fail(e.Line, e.Record, e.ID, errors.New(e.Error))
+	}
+	if imp.opts.OnProgress != nil {
+		imp.opts.OnProgress(*imp.report)
+	}
+	return nil
+}
+
+func (imp *importer) fail(line int, typ string, id int64, err error) {
+	e := ImportError{Line: line, Record: typ, ID: id, Error: err.Error()}
+	imp.report.Failed++
+	if len(imp.report.Errors) < ndjsonMaxErrors {
+		imp.report.Errors = append(imp.report.Errors, e)
+	} else {
+		imp.report.ErrorsTruncated = true
+	}
+	if imp.opts.OnError != nil {
+		imp.opts.OnError(e)
+	}
+}
+
+// recordID returns the id of a record's data for error reports (0 if it has none).
+func recordID(rec Record) int64 {
+	var ref struct {
+		ID int64 `json:"id"`
+	}
+	_ = json.Unmarshal(rec.Data, &ref)
+	return ref.ID
+}
+
+// retenant rewrites the tenant of a record if the import targets another tenant.
+func (imp *importer) retenant(appID, externalUserID *string) {
+	if imp.opts.KeepIDs || imp.opts.AppID == "" {
+		return
+	}
+	*appID = imp.opts.AppID
+	if externalUserID != nil {
+		*externalUserID = imp.opts.ExternalUserID
+	}
+}
+
+// apply writes one record within tx.
+func (imp *importer) apply(tx *gorm.DB, rec Record) error {
+	decode := func(v any) error {
+		if len(rec.Data) == 0 {
+			return errors.New("data is required")
+		}
+		if err := json.Unmarshal(rec.Data, v); err != nil {
+			return fmt.Errorf("invalid %s data: %w", rec.Type, err)
+		}
+		return nil
+	}
+
+	switch rec.Type {
+	case RecordHeader:
+		var h ExportHeader
+		if err := decode(&h); err != nil {
+			return err
+		}
+		if h.Format != NDJSONFormat {
+			return fmt.Errorf("unsupported format %q", h.Format)
+		}
+		return nil
+	case RecordEnd:
+		return nil
+	case RecordBundle:
+		var b models.Bundle
+		if err := decode(&b); err != nil {
+			return err
+		}
+		return imp.applyBundle(tx, &b)
+	case RecordMemory:
+		var m models.Memory
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return imp.applyMemory(tx, &m)
+	case RecordMemoryVersion:
+		var v models.MemoryVersion
+		if err := decode(&v); err != nil {
+			return err
+		}
+		return imp.applyMemoryVersion(tx, &v)
+	case RecordEntity:
+		var e models.Entity
+		if err := decode(&e); err != nil {
+			return err
+		}
+		return imp.applyEntity(tx, &e)
+	case RecordMemoryEntity:
+		var l MemoryEntityRecord
+		if err := decode(&l); err != nil {
+			return err
+		}
+		return imp.applyMemoryEntity(tx, &l)
+	case RecordRelation:
+		var rel models.Relation
+		if err := decode(&rel); err != nil {
+			return err
+		}
+		return imp.applyRelation(tx, &rel)
+	case RecordAgentContext:
+		var rc agentContextRecord
+		if err := decode(&rc); err != nil {
+			return err
+		}
+		ctx := rc.AgentContext
+		ctx.Tags = rc.Tags
+		return imp.applyAgentContext(tx, &ctx)
+	case RecordWebhook:
+		var wh models.Webhook
+		if err := decode(&wh); err != nil {
+			return err
+		}
+		return imp.applyWebhook(tx, &wh)
+	case RecordDeleted:
+		var d DeletedRecord
+		if err := decode(&d); err != nil {
+			return err
+		}
+		if !imp.opts.KeepIDs {
+			// IDs der Quelle existieren hier nicht (neue IDs beim Import)
+			return errSkipRecord
+		}
+		return imp.applyTombstone(tx, &d)
+	}
+	return fmt.Errorf("unknown record type %q", rec.Type)
+}
+
+func (imp *importer) applyBundle(tx *gorm.DB, b *models.Bundle) error {
+	imp.retenant(&b.AppID, &b.ExternalUserID)
+	if err := requireTenant(b.AppID, b.ExternalUserID); err != nil {
+		return err
+	}
+	if b.Name == "" {
+		return errors.New("name is required")
+	}
+	oldID := b.ID
+	if !imp.opts.KeepIDs && imp.opts.Conflict != ConflictDuplicate {
+		var existing models.Bundle
+		err := imp.s.applyTenantFilter(tx, b.AppID, b.ExternalUserID).Where("name = ?", b.Name).Order("id").First(&existing).Error
+		if err == nil {
+			// Bundles haben nur den Namen: Treffer wird übernommen
+			imp.bundleIDs[oldID] = existing.ID
+			if imp.opts.Conflict == ConflictSkip {
+				return errSkipRecord
+			}
+			return nil
+		}
+		if !helpers.IsNotFoundError(err) {
+			return err
+		}
+	}
+	op, err := imp.write(tx, &models.Bundle{}, b, &b.ID, tenantCond(b.AppID, b.ExternalUserID))
+	if err != nil {
+		return err
+	}
+	if err := logChange(tx, models.ChangeKindBundle, op, b.ID, b.AppID, b.ExternalUserID, b); err != nil {
+		return err
+	}
+	imp.bundleIDs[oldID] = b.ID
+	return nil
+}
+
+func (imp *importer) applyMemory(tx *gorm.DB, m *models.Memory) error {
+	imp.retenant(&m.AppID, &m.ExternalUserID)
+	if err := requireTenant(m.AppID, m.ExternalUserID); err != nil {
+		return err
+	}
+	if m.Content == "" {
+		return errors.New("content is required")
+	}
+	if m.Status == "" {
+		m.Status = models.MemoryStatusActive
+	}
+	// Embeddings werden nicht exportiert und müssen neu erzeugt werden
+	m.Embedding, m.EmbeddingModel, m.EmbeddingDim = nil, "", 0
+	oldID := m.ID
+
+	if imp.opts.KeepIDs {
+		m.Metadata = helpers.MarshalMetadata(m.MetadataMap)
+		op, err := imp.write(tx, &models.Memory{}, m, &m.ID, tenantCond(m.AppID, m.ExternalUserID))
+		if err != nil {
+			return err
+		}
+		return logMemoryChange(tx, op, m)
+	}
+
+	if m.BundleID != nil {
+		id, ok := imp.bundleIDs[*m.BundleID]
+		if !ok {
+			return fmt.Errorf("unknown bundle_id %d (bundle records must precede their memories)", *m.BundleID)
+		}
+		m.BundleID = &id
+	}
+	// merged_into zeigt auf eine Record-ID: umschreiben, sonst nach dem Import auflösen
+	mergeTarget, hasMergeTarget := int64(0), false
+	if v, ok := m.MetadataMap["merged_into"].(float64); ok {
+		mergeTarget, hasMergeTarget = int64(v), true
+		if id, ok := imp.memoryIDs[mergeTarget]; ok {
+			m.MetadataMap["merged_into"] = float64(id)
+			hasMergeTarget = false
+		}
+	}
+	m.Metadata = helpers.MarshalMetadata(m.MetadataMap)
+
+	if imp.opts.Conflict != ConflictDuplicate {
+		existingID, err := imp.memoryByContent(tx, m.AppID, m.ExternalUserID, m.Content)
+		if err != nil {
+			return err
+		}
+		if existingID > 0 {
+			if imp.opts.Conflict == ConflictSkip {
+				imp.memoryIDs[oldID] = existingID
+				imp.kept[oldID] = true
+				return errSkipRecord
+			}
+			var existing models.Memory
+			if err := tx.First(&existing, existingID).Error; err != nil {
+				return err
+			}
+			if imp.opts.Conflict == ConflictMerge {
+				mergeMemoryFields(&existing, m)
+			} else {
+				overwriteMemoryFields(&existing, m)
+			}
+			// Inhalt ist gleich: das vorhandene Embedding bleibt gültig
+			if err := imp.s.updateMemory(tx, &existing, "import"); err != nil {
+				return err
+			}
+			imp.memoryIDs[oldID] = existing.ID
+			if hasMergeTarget {
+				imp.mergedInto[existing.ID] = mergeTarget
+			}
+			return nil
+		}
+	}
+
+	m.ID = 0
+	if err := tx.Create(m).Error; err != nil {
+		return err
+	}
+	if err := logMemoryChange(tx, models.ChangeOpCreate, m); err != nil {
+		return err
+	}
+	imp.memoryIDs[oldID] = m.ID
+	imp.created[oldID] = true
+	if hasMergeTarget {
+		imp.mergedInto[m.ID] = mergeTarget
+	}
+	return nil
+}
+
+// memoryByContent returns the ID of the oldest memory of the tenant with the same content, found by
+// content hash (0 if none).
+func (imp *importer) memoryByContent(tx *gorm.DB, appID, externalUserID, content string) (int64, error) {
+	var ids []int64
+	err := imp.s.applyTenantFilter(tx.Model(&models.Memory{}), appID, externalUserID).
+		Where("content_hash IN ?", imp.s.contentHashes(tenantKey{appID, externalUserID}, content)).Order("id").Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// overwriteMemoryFields copies the fields of an imported memory onto an existing one (same content).
+func overwriteMemoryFields(existing, rec *models.Memory) {
+	existing.Type = rec.Type
+	existing.Entity = rec.Entity
+	existing.Tags = rec.Tags
+	existing.Importance = rec.Importance
+	existing.Metadata = rec.Metadata
+	existing.BundleID = rec.BundleID
+	existing.ContentType = rec.ContentType
+	existing.Status = rec.Status
+	existing.ExpiresAt = rec.ExpiresAt
+}
+
+// mergeMemoryFields merges an imported memory into an existing one (same content) like
+// MergeMemories: metadata keys and tags are combined (existing values win), importance is the max.
+func mergeMemoryFields(existing, rec *models.Memory) {
+	meta := helpers.UnmarshalMetadata(existing.Metadata)
+	for k, v := range helpers.UnmarshalMetadata(rec.Metadata) {
+		if _, ok := meta[k]; !ok {
+			meta[k] = v
+		}
+	}
+	existing.Metadata = helpers.MarshalMetadata(meta)
+	existing.Tags = mergeList(existing.Tags, rec.Tags)
+	existing.Importance = max(existing.Importance, rec.Importance)
+	if existing.BundleID == nil {
+		existing.BundleID = rec.BundleID
+	}
+	if existing.Entity == "" {
+		existing.Entity = rec.Entity
+	}
+}
+
+// mergeList combines two comma-separated lists (order of a, then new items of b).
+func mergeList(a, b string) string {
+	var items []string
+	for _, item := range strings.Split(a+","+b, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// resolveMergedInto points metadata.merged_into of imported memories to the new ID of their target.
+// Targets that were not part of the import are removed (the old ID would point to an unrelated memory).
+func (imp *importer) resolveMergedInto() error {
+	if len(imp.mergedInto) == 0 {
+		return nil
+	}
+	return imp.db.Transaction(func(tx *gorm.DB) error {
+		for id, target := range imp.mergedInto {
+			var mem models.Memory
+			if err := tx.First(&mem, id).Error; err != nil {
+				return err
+			}
+			meta := helpers.UnmarshalMetadata(mem.Metadata)
+			if newID, ok := imp.memoryIDs[target]; ok {
+				meta["merged_into"] = float64(newID)
+			} else {
+				delete(meta, "merged_into")
+			}
+			mem.Metadata = helpers.MarshalMetadata(meta)
+			if err := tx.Model(&mem).Update("metadata", mem.Metadata).Error; err != nil {
+				return err
+			}
+			if err := logMemoryChange(tx, models.ChangeOpUpdate, &mem); err != nil {
+				return err
+			}
+		}
+		clear(imp.mergedInto)
+		return nil
+	})
+}
+
+func (imp *importer) applyMemoryVersion(tx *gorm.DB, v *models.MemoryVersion) error {
+	if imp.opts.KeepIDs {
+		if err := requireMemory(tx, v.MemoryID); err != nil {
+			return err
+		}
+	} else {
+		id, ok := imp.memoryIDs[v.MemoryID]
+		if !ok {
+			return fmt.Errorf("unknown memory_id %d (memory records must precede their versions and links)", v.MemoryID)
+		}
+		if !imp.created[v.MemoryID] {
+			// Vorhandenes Memory behält seine eigene Historie
+			return errSkipRecord
+		}
+		v.MemoryID = id
+	}
+	v.Metadata = helpers.MarshalMetadata(v.MetadataMap)
+	_, err := imp.write(tx, &models.MemoryVersion{}, v, &v.ID, nil)
+	return err
+}
+
+func (imp *importer) applyEntity(tx *gorm.DB, e *models.Entity) error {
+	imp.retenant(&e.AppID, &e.ExternalUserID)
+	if err := requireTenant(e.AppID, e.ExternalUserID); err != nil {
+		return err
+	}
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	if e.DataMap == nil {
+		e.DataMap = map[string]any{}
+	}
+	// Entities sind pro Tenant über den Namen eindeutig: Upsert statt ID
+	if !imp.opts.KeepIDs && (imp.opts.Conflict == ConflictSkip || imp.opts.Conflict == ConflictMerge) {
+		var existing models.Entity
+		err := imp.s.applyTenantFilter(tx, e.AppID, e.ExternalUserID).Where("name = ?", e.Name).First(&existing).Error
+		switch {
+		case err == nil && imp.opts.Conflict == ConflictSkip:
+			return errSkipRecord
+		case err == nil:
+			facts := helpers.UnmarshalEntityData(existing.Data)
+			for k, v := range e.DataMap {
+				if _, ok := facts[k]; !ok {
+					facts[k] = v
+				}
+			}
+			e.DataMap = facts
+		case !helpers.IsNotFoundError(err):
+			return err
+		}
+	}
+	e.ID = 0
+	e.Data = helpers.MarshalEntityData(e.DataMap)
+	return imp.s.upsertEntity(tx, e)
+}
+
+func (imp *importer) applyMemoryEntity(tx *gorm.DB, l *MemoryEntityRecord) error {
+	if l.Entity == "" {
+		return errors.New("entity is required")
+	}
+	memoryID := l.MemoryID
+	if imp.opts.KeepIDs {
+		if err := requireMemory(tx, memoryID); err != nil {
+			return err
+		}
+	} else {
+		id, ok := imp.memoryIDs[l.MemoryID]
+		if !ok {
+			return fmt.Errorf("unknown memory_id %d (memory records must precede their versions and links)", l.MemoryID)
+		}
+		if imp.kept[l.MemoryID] {
+			return errSkipRecord
+		}
+		memoryID = id
+	}
+	var mem models.Memory
+	if err := tx.Omit("embedding").First(&mem, memoryID).Error; err != nil {
+		return err
+	}
+	ent := models.Entity{AppID: mem.AppID, ExternalUserID: mem.ExternalUserID, Name: l.Entity, Data: "{}"}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ent)
+	if res.Error != nil {
+		return res.Error
+	}
+	if err := imp.s.applyTenantFilter(tx, mem.AppID, mem.ExternalUserID).Where("name = ?", l.Entity).First(&ent).Error; err != nil {
+		return err
+	}
+	if res.RowsAffected > 0 {
+		if err := logChange(tx, models.ChangeKindEntity, models.ChangeOpCreate, ent.ID, ent.AppID, ent.ExternalUserID, &ent); err != nil {
+			return err
+		}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MemoryEntity{MemoryID: mem.ID, EntityID: ent.ID}).Error; err != nil {
+		return err
+	}
+	return logMemoryEntitiesChange(tx, &mem)
+}
+
+func (imp *importer) applyRelation(tx *gorm.DB, rel *models.Relation) error {
+	imp.retenant(&rel.AppID, &rel.ExternalUserID)
+	if err := requireTenant(rel.AppID, rel.ExternalUserID); err != nil {
+		return err
+	}
+	if rel.From == "" || rel.To == "" || rel.Type == "" {
+		return errors.New("from, to and type are required")
+	}
+	if imp.opts.KeepIDs && rel.ID > 0 {
+		op, err := imp.write(tx, &models.Relation{}, rel, &rel.ID, tenantCond(rel.AppID, rel.ExternalUserID))
+		if err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindRelation, op, rel.ID, rel.AppID, rel.ExternalUserID, rel)
+	}
+	if imp.opts.Conflict == ConflictSkip {
+		var n int64
+		if err := imp.s.applyTenantFilter(tx.Model(&models.Relation{}), rel.AppID, rel.ExternalUserID).
+			Where("from_entity = ? AND to_entity = ? AND type = ?", rel.From, rel.To, rel.Type).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return errSkipRecord
+		}
+	}
+	// Überlappende Gültigkeit wie beim API-Upsert zusammenführen
+	rel.ID = 0
+	return imp.s.upsertRelation(tx, rel)
+}
+
+func (imp *importer) applyAgentContext(tx *gorm.DB, ctx *models.AgentContext) error {
+	imp.retenant(&ctx.AppID, &ctx.ExternalUserID)
+	if err := requireTenant(ctx.AppID, ctx.ExternalUserID); err != nil {
+		return err
+	}
+	if ctx.AgentID == "" || ctx.MemoryType == "" {
+		return errors.New("agent_id and memory_type are required")
+	}
+	ctx.Payload = helpers.MarshalMetadata(ctx.PayloadMap)
+	if !imp.opts.KeepIDs && imp.opts.Conflict != ConflictDuplicate {
+		var existing models.AgentContext
+		err := imp.s.applyTenantFilter(tx, ctx.AppID, ctx.ExternalUserID).
+			Where("agent_id = ? AND memory_type = ? AND "+imp.s.plainColumn("payload", "agent_contexts.payload")+" = ?", ctx.AgentID, ctx.MemoryType, ctx.Payload).
+			Order("id").First(&existing).Error
+		if err == nil {
+			switch imp.opts.Conflict {
+			case ConflictSkip:
+				return errSkipRecord
+			case ConflictMerge:
+				existing.Tags = mergeList(existing.Tags, ctx.Tags)
+			default:
+				existing.Tags = ctx.Tags
+			}
+			if err := tx.Save(&existing).Error; err != nil {
+				return err
+			}
+			return logChange(tx, models.ChangeKindAgentContext, models.ChangeOpUpdate, existing.ID, existing.AppID, existing.ExternalUserID, &existing)
+		}
+		if !helpers.IsNotFoundError(err) {
+			return err
+		}
+	}
+	op, err := imp.write(tx, &models.AgentContext{}, ctx, &ctx.ID, tenantCond(ctx.AppID, ctx.ExternalUserID))
+	if err != nil {
+		return err
+	}
+	return logChange(tx, models.ChangeKindAgentContext, op, ctx.ID, ctx.AppID, ctx.ExternalUserID, ctx)
+}
+
+func (imp *importer) applyWebhook(tx *gorm.DB, wh *models.Webhook) error {
+	imp.retenant(&wh.AppID, nil)
+	if wh.URL == "" || wh.Events == "" {
+		return errors.New("url and events are required")
+	}
+	if err := helpers.ValidateWebhookURL(wh.URL); err != nil {
+		return err
+	}
+	var existing models.Webhook
+	var err error
+	if imp.opts.KeepIDs && wh.ID > 0 {
+		err = tx.First(&existing, wh.ID).Error
+		if err == nil && existing.AppID != wh.AppID {
+			return fmt.Errorf("id %d belongs to another app", wh.ID)
+		}
+	} else if imp.opts.Conflict != ConflictDuplicate {
+		err = tx.Where("app_id = ? AND url = ?", wh.AppID, wh.URL).Order("id").First(&existing).Error
+	} else {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil && !helpers.IsNotFoundError(err) {
+		return err
+	}
+
+	if err == nil {
+		if imp.opts.Conflict == ConflictSkip && !imp.opts.KeepIDs {
+			return errSkipRecord
+		}
+		if imp.opts.Conflict == ConflictMerge && !imp.opts.KeepIDs {
+			existing.Events = mergeList(existing.Events, wh.Events)
+			existing.Active = existing.Active || wh.Active
+		} else {
+			existing.URL, existing.Events, existing.Active = wh.URL, wh.Events, wh.Active
+		}
+		// Secrets sind nicht Teil des Exports: vorhandenes Secret bleibt
+		if err := tx.Model(&existing).Select("url", "events", "active", "updated_at").Updates(&existing).Error; err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindWebhook, models.ChangeOpUpdate, existing.ID, existing.AppID, "", &existing)
+	}
+	if !imp.opts.KeepIDs {
+		wh.ID = 0
+	}
+	if err := tx.Create(wh).Error; err != nil {
+		return err
+	}
+	return logChange(tx, models.ChangeKindWebhook, models.ChangeOpCreate, wh.ID, wh.AppID, "", wh)
+}
+
+// write stores row (a pointer to a model with primary key *id). With KeepIDs and an ID the row is
+// saved under that ID (created if missing; an existing row must match tenant), otherwise it is
+// created with a new ID. Returns the change op.
+func (imp *importer) write(tx *gorm.DB, model, row any, id *int64, tenant map[string]any) (string, error) {
+	if !imp.opts.KeepIDs || *id <= 0 {
+		*id = 0
+		return models.ChangeOpCreate, tx.Create(row).Error
+	}
+	var existing int64
+	if err := tx.Model(model).Where("id = ?", *id).Count(&existing).Error; err != nil {
+		return "", err
+	}
+	if existing == 0 {
+		return models.ChangeOpCreate, tx.Create(row).Error
+	}
+	if tenant != nil {
+		var own int64
+		if err := tx.Model(model).Where("id = ?", *id).Where(tenant).Count(&own).Error; err != nil {
+			return "", err
+		}
+		if own == 0 {
+			return "", fmt.Errorf("id %d belongs to another tenant", *id)
+		}
+	}
+	return models.ChangeOpUpdate, tx.Save(row).Error
+}
+
+// applyTombstone deletes the object of a deleted record (skipped if it is already gone).
+func (imp *importer) applyTombstone(tx *gorm.DB, d *DeletedRecord) error {
+	var model any
+	switch d.Kind {
+	case models.ChangeKindMemory:
+		model = &models.Memory{}
+	case models.ChangeKindBundle:
+		model = &models.Bundle{}
+	case models.ChangeKindEntity:
+		model = &models.Entity{}
+	case models.ChangeKindRelation:
+		model = &models.Relation{}
+	case models.ChangeKindAgentContext:
+		model = &models.AgentContext{}
+	default:
+		return fmt.Errorf("cannot delete kind %q", d.Kind)
+	}
+	res := imp.s.applyTenantFilter(tx, d.AppID, d.ExternalUserID).Where("id = ?", d.ID).Delete(model)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errSkipRecord
+	}
+	return logChange(tx, d.Kind, models.ChangeOpDelete, d.ID, d.AppID, d.ExternalUserID, d)
+}
+
+func tenantCond(appID, externalUserID string) map[string]any {
+	return map[string]any{"app_id": appID, "external_user_id": externalUserID}
+}
+
+// requireMemory checks that the memory of a KeepIDs record exists.
+func requireMemory(tx *gorm.DB, id int64) error {
+	var n int64
+	if err := tx.Model(&models.Memory{}).Where("id = ?", id).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("memory %d not found", id)
+	}
+	return nil
+}
+
+func requireTenant(appID, externalUserID string) error {
+	if appID == "" || externalUserID == "" {
+		return errors.New("app_id and external_user_id are required")
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+func TestImportRemapsTenantAndIDs(t *testing.T) {
+	src := setupTestDB(t)
+	defer src.Close()
+
+	bundle := models.Bundle{Name: "b", AppID: "app", ExternalUserID: "u1"}
+	if err := src.CreateBundle(&bundle); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	merged := models.Memory{Content: "Alice mag Kaffee", AppID: "app", ExternalUserID: "u1", BundleID: &bundle.ID}
+	keep := models.Memory{Content: "Alice mag Tee", AppID: "app", ExternalUserID: "u1"}
+	for _, m := range []*models.Memory{&merged, &keep} {
+		if err := src.CreateMemory(m); err != nil {
+			t.Fatalf("CreateMemory: %v", err)
+		}
+	}
+	// merged_into zeigt auf ein späteres Memory des Streams
+	if err := src.MergeMemories(keep.ID, merged.ID, "app", "u1"); err != nil {
+		t.Fatalf("MergeMemories: %v", err)
+	}
+	if _, err := src.LinkMemoryEntities(&keep, []string{"Alice"}); err != nil {
+		t.Fatalf("LinkMemoryEntities: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.ExportNDJSON(&buf, ExportOptions{AppID: "app", ExternalUserID: "u1", IncludeArchived: true}); err != nil {
+		t.Fatalf("ExportNDJSON: %v", err)
+	}
+
+	dst := setupTestDB(t)
+	defer dst.Close()
+	// Belegte IDs in einem anderen Tenant: Import darf sie nicht überschreiben
+	for i := 0; i < 3; i++ {
+		if err := dst.CreateBundle(&models.Bundle{Name: fmt.Sprint("x", i), AppID: "app", ExternalUserID: "u1"}); err != nil {
+			t.Fatalf("CreateBundle: %v", err)
+		}
+		if err := dst.CreateMemory(&models.Memory{Content: fmt.Sprint("x", i), AppID: "app", ExternalUserID: "u1"}); err != nil {
+			t.Fatalf("CreateMemory: %v", err)
+		}
+	}
+
+	report, err := dst.ImportNDJSON(bytes.NewReader(buf.Bytes()), ImportOptions{AppID: "app2", ExternalUserID: "u9"})
+	if err != nil || report.Failed != 0 {
+		t.Fatalf("ImportNDJSON: %v %+v", err, report)
+	}
+	if own, _ := dst.ListMemoriesByTenant("app", "u1", 10, 0, true); len(own) != 3 {
+		t.Fatalf("rows of the source tenant must stay untouched, got %d", len(own))
+	}
+	memories, _ := dst.ListMemoriesByTenant("app2", "u9", 10, 0, true)
+	if len(memories) != 2 {
+		t.Fatalf("expected 2 imported memories, got %d", len(memories))
+	}
+	byContent := make(map[string]models.Memory)
+	for _, m := range memories {
+		if m.ID == merged.ID || m.ID == keep.ID {
+			t.Errorf("memory %q kept its source id %d", m.Content, m.ID)
+		}
+		byContent[m.Content] = m
+	}
+	newKeep, newMerged := byContent["Alice mag Tee | Alice mag Kaffee"], byContent["Alice mag Kaffee"]
+	bundles, _ := dst.ListBundles("app2", "u9")
+	if len(bundles) != 1 || newMerged.BundleID == nil || *newMerged.BundleID != bundles[0].ID {
+		t.Fatalf("bundle_id must point to the imported bundle: %+v %+v", newMerged.BundleID, bundles)
+	}
+	meta := helpers.UnmarshalMetadata(newMerged.Metadata)
+	if meta["merged_into"] != float64(newKeep.ID) {
+		t.Errorf("merged_into = %v, want %d", meta["merged_into"], newKeep.ID)
+	}
+	versions, _ := dst.ListMemoryVersions(newKeep.ID, "app2", "u9")
+	if len(versions) == 0 {
+		t.Error("versions of imported memories must be imported")
+	}
+	if _, err := dst.GetEntity("app2", "u9", "Alice"); err != nil {
+		t.Errorf("entity must be imported into the new tenant: %v", err)
+	}
+
+	// merged_into auf ein Memory außerhalb des Imports wird entfernt
+	single := strings.Join([]string{
+		`{"type":"memory","data":{"id":5,"content":"orphan","metadata":{"merged_into":99,"k":"v"},"app_id":"app","external_user_id":"u1"}}`,
+	}, "\n")
+	if _, err := dst.ImportNDJSON(strings.NewReader(single), ImportOptions{AppID: "app3", ExternalUserID: "u1"}); err != nil {
+		t.Fatalf("ImportNDJSON: %v", err)
+	}
+	orphans, _ := dst.ListMemoriesByTenant("app3", "u1", 10, 0, true)
+	if meta := helpers.UnmarshalMetadata(orphans[0].Metadata); meta["merged_into"] != nil || meta["k"] != "v" {
+		t.Errorf("dangling merged_into must be removed: %+v", meta)
+	}
+}
+
+func TestImportConflictStrategies(t *testing.T) {
+	input := strings.Join([]string{
+		`{"type":"entity","data":{"id":3,"name":"Alice","data":{"drink":"coffee","city":"Berlin"},"app_id":"app","external_user_id":"u1"}}`,
+		`{"type":"memory","data":{"id":1,"content":"Alice mag Tee","tags":"b","importance":9,"metadata":{"k":"new","x":1},"app_id":"app","external_user_id":"u1"}}`,
+		`{"type":"memory_entity","data":{"memory_id":1,"entity":"Bob"}}`,
+		`{"type":"memory","data":{"id":2,"content":"neu","app_id":"app","external_user_id":"u1"}}`,
+	}, "\n")
+
+	tests := []struct {
+		conflict   string
+		memories   int
+		skipped    int
+		tags       string
+		importance int
+		meta       map[string]any
+		facts      map[string]any
+	}{
+		{ConflictSkip, 2, 3, "a", 5, map[string]any{"k": "old"}, map[string]any{"drink": "tea"}},
+		{ConflictOverwrite, 2, 0, "b", 9, map[string]any{"k": "new", "x": float64(1)}, map[string]any{"drink": "coffee", "city": "Berlin"}},
+		{ConflictMerge, 2, 0, "a,b", 9, map[string]any{"k": "old", "x": float64(1)}, map[string]any{"drink": "tea", "city": "Berlin"}},
+		{ConflictDuplicate, 3, 0, "a", 5, map[string]any{"k": "old"}, map[string]any{"drink": "coffee", "city": "Berlin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.conflict, func(t *testing.T) {
+			s := setupTestDB(t)
+			defer s.Close()
+			existing := models.Memory{Content: "Alice mag Tee", Tags: "a", Importance: 5, Metadata: `{"k":"old"}`, AppID: "app", ExternalUserID: "u1"}
+			if err := s.CreateMemory(&existing); err != nil {
+				t.Fatalf("CreateMemory: %v", err)
+			}
+			if _, err := s.SetEntityFact("app", "u1", "Alice", "drink", "tea"); err != nil {
+				t.Fatalf("SetEntityFact: %v", err)
+			}
+
+			report, err := s.ImportNDJSON(strings.NewReader(input), ImportOptions{Conflict: tt.conflict})
+			if err != nil || report.Failed != 0 {
+				t.Fatalf("ImportNDJSON: %v %+v", err, report)
+			}
+			if report.Skipped != tt.skipped {
+				t.Errorf("skipped = %d, want %d", report.Skipped, tt.skipped)
+			}
+			memories, _ := s.ListMemoriesByTenant("app", "u1", 10, 0, true)
+			if len(memories) != tt.memories {
+				t.Fatalf("memories = %d, want %d", len(memories), tt.memories)
+			}
+			got, err := s.GetMemoryByIDAndTenant(existing.ID, "app", "u1", true)
+			if err != nil {
+				t.Fatalf("GetMemoryByIDAndTenant: %v", err)
+			}
+			if got.Tags != tt.tags || got.Importance != tt.importance {
+				t.Errorf("tags=%q importance=%d, want %q %d", got.Tags, got.Importance, tt.tags, tt.importance)
+			}
+			if meta := helpers.UnmarshalMetadata(got.Metadata); fmt.Sprint(meta) != fmt.Sprint(tt.meta) {
+				t.Errorf("metadata = %v, want %v", meta, tt.meta)
+			}
+			ent, err := s.GetEntity("app", "u1", "Alice")
+			if err != nil {
+				t.Fatalf("GetEntity: %v", err)
+			}
+			if facts := helpers.UnmarshalEntityData(ent.Data); fmt.Sprint(facts) != fmt.Sprint(tt.facts) {
+				t.Errorf("facts = %v, want %v", facts, tt.facts)
+			}
+		})
+	}
+}
+
+func TestImportKeepIDsTenantGuard(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	mem := models.Memory{Content: "geheim", AppID: "app", ExternalUserID: "u1"}
+	if err := s.CreateMemory(&mem); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	input := fmt.Sprintf(`{"type":"memory","data":{"id":%d,"content":"übernommen","app_id":"app","external_user_id":"u2"}}`, mem.ID)
+	report, err := s.ImportNDJSON(strings.NewReader(input), ImportOptions{KeepIDs: true})
+	if err != nil || report.Failed != 1 || !strings.Contains(report.Errors[0].Error, "another tenant") {
+		t.Fatalf("expected a tenant error: %v %+v", err, report)
+	}
+	got, _ := s.GetMemoryByIDAndTenant(mem.ID, "app", "u1", true)
+	if got == nil || got.Content != "geheim" {
+		t.Fatalf("memory of another tenant must not be overwritten: %+v", got)
+	}
+}
+
+func TestImportDryRunAcrossBatches(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	lines := []string{`{"type":"bundle","data":{"id":1,"name":"b","app_id":"app","external_user_id":"u1"}}`}
+	for i := 0; i < ndjsonBatchSize; i++ {
+		lines = append(lines, fmt.Sprintf(`{"type":"memory","data":{"id":%d,"content":"m%d","bundle_id":1,"app_id":"app","external_user_id":"u1"}}`, i+10, i))
+	}
+	report, err := s.ImportNDJSON(strings.NewReader(strings.Join(lines, "\n")), ImportOptions{DryRun: true})
+	if err != nil || report.Failed != 0 || report.Imported[RecordMemory] != ndjsonBatchSize {
+		t.Fatalf("later batches must see earlier records in a dry run: %v %+v", err, report)
+	}
+	if latest, _ := s.LatestChangeSeq(); latest != 0 {
+		t.Fatalf("dry run must not write (changes=%d)", latest)
+	}
+}
+
+func TestImportExportDataRemap(t *testing.T) {
+	src := setupTestDB(t)
+	defer src.Close()
+
+	bundle := models.Bundle{Name: "b", AppID: "app", ExternalUserID: "u1"}
+	if err := src.CreateBundle(&bundle); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	if err := src.CreateMemory(&models.Memory{Content: "m", AppID: "app", ExternalUserID: "u1", BundleID: &bundle.ID, Metadata: `{"k":"v"}`}); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	if err := src.CreateWebhook(&models.Webhook{URL: "http://example.com/hook", Events: "memory.created", AppID: "app", Active: true}); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	export, err := src.ExportAll("app", "u1", false)
+	if err != nil {
+		t.Fatalf("ExportAll: %v", err)
+	}
+	raw, _ := json.Marshal(export)
+	var data ExportData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	dst := setupTestDB(t)
+	defer dst.Close()
+	for i := 0; i < 2; i++ {
+		report, err := dst.ImportExportData(&data, ImportOptions{AppID: "app2", ExternalUserID: "u2", Conflict: ConflictSkip})
+		if err != nil || report.Failed != 0 {
+			t.Fatalf("ImportExportData: %v %+v", err, report)
+		}
+		if i == 1 && report.Skipped != 3 {
+			t.Errorf("second import must skip bundle, memory and webhook, got %+v", report)
+		}
+	}
+	memories, _ := dst.ListMemoriesByTenant("app2", "u2", 10, 0, false)
+	bundles, _ := dst.ListBundles("app2", "u2")
+	if len(memories) != 1 || len(bundles) != 1 || *memories[0].BundleID != bundles[0].ID {
+		t.Fatalf("unexpected import: %+v %+v", memories, bundles)
+	}
+	if meta := helpers.UnmarshalMetadata(memories[0].Metadata); meta["k"] != "v" {
+		t.Errorf("metadata must survive the JSON export: %+v", meta)
+	}
+	hooks, _ := dst.ListWebhooks("app2")
+	if len(hooks) != 1 || hooks[0].AppID != "app2" {
+		t.Errorf("webhook must move to the new app: %+v", hooks)
+	}
+}
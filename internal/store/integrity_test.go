@@ -0,0 +1,222 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"cortex/internal/crypt"
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// problems returns the problems of a report per "record:id".
+func problems(report *IntegrityReport) map[string]string {
+	out := make(map[string]string)
+	for _, issue := range report.Issues {
+		out[fmt.Sprintf("%s:%d", issue.Record, issue.ID)] = issue.Problem
+	}
+	return out
+}
+
+func TestContentHashAndVerify(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	mem := &models.Memory{Content: "Kaffee schwarz", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	if err := s.CreateMemory(mem); err != nil {
+		t.Fatal(err)
+	}
+	if mem.ContentHash != helpers.ContentHash("Kaffee schwarz") {
+		t.Fatalf("content hash not set on create: %q", mem.ContentHash)
+	}
+	mem.Content = "Kaffee mit Hafermilch"
+	if err := s.UpdateMemory(mem, "api"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := s.ListMemoryVersions(mem.ID, "app1", "user1")
+	if err != nil || len(versions) != 1 || versions[0].ContentHash != helpers.ContentHash("Kaffee schwarz") {
+		t.Fatalf("version must keep the hash of the old content: %v %+v", err, versions)
+	}
+	got, err := s.GetMemoryByIDAndTenant(mem.ID, "app1", "user1", false)
+	if err != nil || got.ContentHash != helpers.ContentHash("Kaffee mit Hafermilch") {
+		t.Fatalf("content hash not updated: %v %+v", err, got)
+	}
+
+	// Exakte Duplikate nur innerhalb des Tenants
+	if dup, err := s.FindMemoryByContent("app1", "user1", "Kaffee mit Hafermilch"); err != nil || dup.ID != mem.ID {
+		t.Errorf("FindMemoryByContent: %v %+v", err, dup)
+	}
+	if _, err := s.FindMemoryByContent("app1", "user2", "Kaffee mit Hafermilch"); !helpers.IsNotFoundError(err) {
+		t.Errorf("other tenant must not see the duplicate, got %v", err)
+	}
+
+	report, err := s.VerifyIntegrity(VerifyOptions{})
+	if err != nil || !report.DatabaseOK || report.Memories != 1 || report.Versions != 1 || len(report.Issues) != 0 {
+		t.Fatalf("clean database: %v %+v", err, report)
+	}
+	if report.SignaturesKey != "" || report.Unsigned != 0 {
+		t.Errorf("without signing key signatures must not be checked: %+v", report)
+	}
+
+	// Am Store vorbei verändert bzw. ohne Hash
+	other := &models.Memory{Content: "Tee", AppID: "app1", ExternalUserID: "user2", Importance: 5}
+	if err := s.CreateMemory(other); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Exec("UPDATE memories SET content = 'Kaffee mit Zucker' WHERE id = ?", mem.ID)
+	s.db.Exec("UPDATE memory_versions SET content_hash = '' WHERE memory_id = ?", mem.ID)
+	report, err = s.VerifyIntegrity(VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := problems(report); len(p) != 2 || p[fmt.Sprintf("memory:%d", mem.ID)] != IntegrityHashMismatch ||
+		p[fmt.Sprintf("memory_version:%d", versions[0].ID)] != IntegrityMissingHash {
+		t.Errorf("problems = %v", p)
+	}
+	report, _ = s.VerifyIntegrity(VerifyOptions{AppID: "app1", ExternalUserID: "user2"})
+	if report.Memories != 1 || report.Versions != 0 || len(report.Issues) != 0 {
+		t.Errorf("tenant filter: %+v", report)
+	}
+
+	// Fehlende Hashes werden beim Start nachgetragen
+	if err := s.backfillContentHashes(); err != nil {
+		t.Fatal(err)
+	}
+	if h := rawValues(t, s, "SELECT content_hash FROM memory_versions WHERE memory_id = ?", mem.ID); len(h) != 1 || h[0] != helpers.ContentHash("Kaffee schwarz") {
+		t.Errorf("backfill: %v", h)
+	}
+	if _, err := s.VerifyIntegrity(VerifyOptions{Sign: true}); !errors.Is(err, ErrSigningDisabled) {
+		t.Errorf("signing without key: expected ErrSigningDisabled, got %v", err)
+	}
+}
+
+func TestRowSigning(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	before := &models.Memory{Content: "vor der Signatur", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	if err := s.CreateMemory(before); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableRowSigning(testMasterKey(t, 3)); err != nil {
+		t.Fatal(err)
+	}
+	mem := &models.Memory{Content: "signiert", Tags: "a,b", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	if err := s.CreateMemory(mem); err != nil {
+		t.Fatal(err)
+	}
+	mem.Importance = 8
+	if err := s.UpdateMemory(mem, "api"); err != nil {
+		t.Fatal(err)
+	}
+	// Update über eine Map mit geladenem Model (wie beim Import) signiert neu
+	if err := s.db.Model(mem).Update("metadata", `{"k":"v"}`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Model(&models.Memory{}).Where("id = ?", mem.ID).Update("content", "x").Error; err == nil {
+		t.Error("a bulk update of signed columns must fail while rows are signed")
+	}
+
+	report, err := s.VerifyIntegrity(VerifyOptions{})
+	if err != nil || len(report.Issues) != 0 || report.Unsigned != 1 || report.SignaturesKey == "" {
+		t.Fatalf("signed rows: %v %+v", err, report)
+	}
+	report, err = s.VerifyIntegrity(VerifyOptions{Sign: true})
+	if err != nil || report.Signed != 1 {
+		t.Fatalf("Sign: %v %+v", err, report)
+	}
+	if report, _ = s.VerifyIntegrity(VerifyOptions{}); report.Unsigned != 0 || len(report.Issues) != 0 {
+		t.Fatalf("after signing: %+v", report)
+	}
+
+	// Signierte Felder einer anderen Zeile (samt Hash und Signatur) darüber kopiert
+	copied := &models.Memory{Content: "kopiert", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	if err := s.CreateMemory(copied); err != nil || copied.Signature == "" {
+		t.Fatalf("create must sign the new row: %v %q", err, copied.Signature)
+	}
+	s.db.Exec(`UPDATE memories SET (type, entity, tags, importance, content, metadata, content_hash, signature) =
+		(SELECT type, entity, tags, importance, content, metadata, content_hash, signature FROM memories WHERE id = ?) WHERE id = ?`, mem.ID, copied.ID)
+	report, _ = s.VerifyIntegrity(VerifyOptions{})
+	if p := problems(report); len(p) != 1 || p[fmt.Sprintf("memory:%d", copied.ID)] != IntegritySignatureMismatch {
+		t.Errorf("copied row: %v", p)
+	}
+	s.db.Exec("DELETE FROM memories WHERE id = ?", copied.ID)
+
+	// Tags sind nicht verschlüsselt, aber signiert
+	s.db.Exec("UPDATE memories SET tags = 'a,b,c' WHERE id = ?", mem.ID)
+	report, _ = s.VerifyIntegrity(VerifyOptions{})
+	if p := problems(report); len(p) != 1 || p[fmt.Sprintf("memory:%d", mem.ID)] != IntegritySignatureMismatch {
+		t.Errorf("tampered tags: %v", p)
+	}
+
+	s.EnableRowSigning(testMasterKey(t, 4))
+	report, _ = s.VerifyIntegrity(VerifyOptions{})
+	if len(report.Issues) != 3 || report.Issues[0].Problem != IntegrityUnknownSigningKey {
+		t.Errorf("other signing key: %+v", report.Issues)
+	}
+}
+
+func TestIntegrityWithFieldEncryption(t *testing.T) {
+	defer dataKeys.Clear()
+	s := setupTestDB(t)
+	defer s.Close()
+	if _, err := s.EnableFieldEncryption(testMasterKey(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	mem := &models.Memory{Content: "geheim", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	if err := s.CreateMemory(mem); err != nil {
+		t.Fatal(err)
+	}
+	// Hash unter dem Data-Key des Tenants: ohne Key nicht gegen geratene Inhalte prüfbar
+	keyed := rawValues(t, s, "SELECT content_hash FROM memories WHERE id = ?", mem.ID)
+	if id, ok := crypt.FieldHashKeyID(keyed[0]); !ok || keyed[0] != mem.ContentHash || keyed[0] == helpers.ContentHash("geheim") {
+		t.Fatalf("hash must be keyed: %v %q", keyed, id)
+	}
+	if dup, err := s.FindMemoryByContent("app1", "user1", "geheim"); err != nil || dup.ID != mem.ID {
+		t.Errorf("FindMemoryByContent: %v %+v", err, dup)
+	}
+	other := &models.Memory{Content: "geheim", AppID: "app2", ExternalUserID: "user1", Importance: 5}
+	if err := s.CreateMemory(other); err != nil || other.ContentHash == mem.ContentHash {
+		t.Errorf("tenants must not share hashes: %v %q", err, other.ContentHash)
+	}
+	s.db.Exec("DELETE FROM memories WHERE id = ?", other.ID)
+
+	// Backfill füllt fehlende Hashes und ersetzt ungeschlüsselte Hashes verschlüsselter Inhalte
+	for _, h := range []any{nil, helpers.ContentHash("geheim")} {
+		s.db.Exec("UPDATE memories SET content_hash = ? WHERE id = ?", h, mem.ID)
+		if err := s.backfillContentHashes(); err != nil {
+			t.Fatal(err)
+		}
+		if got := rawValues(t, s, "SELECT content_hash FROM memories WHERE id = ?", mem.ID); len(got) != 1 || got[0] != keyed[0] {
+			t.Fatalf("backfill of encrypted content from %v: %v", h, got)
+		}
+	}
+
+	// Rotation hasht mit dem neuen Key; der alte wird gelöscht
+	rot, err := s.RotateTenantKey("app1", "user1")
+	if err != nil || len(rot.RetiredKeys) != 1 {
+		t.Fatalf("RotateTenantKey: %v %+v", err, rot)
+	}
+	if h := rawValues(t, s, "SELECT content_hash FROM memories WHERE id = ?", mem.ID); len(h) != 1 || h[0] == keyed[0] {
+		t.Errorf("rotation must rehash the content: %v", h)
+	} else if id, _ := crypt.FieldHashKeyID(h[0]); id != rot.KeyID {
+		t.Errorf("hash key %q, expected %q", id, rot.KeyID)
+	}
+	if report, err := s.VerifyIntegrity(VerifyOptions{}); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("after rotation: %v %+v", err, report)
+	}
+	if dup, err := s.FindMemoryByContent("app1", "user1", "geheim"); err != nil || dup.ID != mem.ID {
+		t.Errorf("FindMemoryByContent after rotation: %v %+v", err, dup)
+	}
+
+	// Chiffretext beschädigen: die Prüfung meldet die Zeile, statt abzubrechen
+	s.db.Exec("UPDATE memories SET content = substr(content, 1, length(content) - 4) || 'AAAA' WHERE id = ?", mem.ID)
+	report, err := s.VerifyIntegrity(VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Problem != IntegrityUnreadable || report.Issues[0].Error == "" {
+		t.Errorf("corrupted ciphertext: %+v", report.Issues)
+	}
+}
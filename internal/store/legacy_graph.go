@@ -0,0 +1,126 @@
+package store
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// LegacyGraphCounts reports what ClaimLegacyGraph moved into a tenant.
+type LegacyGraphCounts struct {
+	Entities       int64 `json:"entities"`
+	MergedEntities int64 `json:"mergedEntities"`
+	Relations      int64 `json:"relations"`
+}
+
+// CountLegacyGraph counts entities and relations from the pre-tenant schema (empty app_id and external_user_id).
+func (s *CortexStore) CountLegacyGraph() (entities, relations int64, err error) {
+	if err = s.applyTenantFilter(s.db.Model(&models.Entity{}), "", "").Count(&entities).Error; err != nil {
+		return 0, 0, err
+	}
+	err = s.applyTenantFilter(s.db.Model(&models.Relation{}), "", "").Count(&relations).Error
+	return entities, relations, err
+}
+
+// ClaimLegacyGraph assigns all entities and relations without tenant (created before entities were
+// tenant-scoped) to the given tenant. If the tenant already has an entity of the same name, the legacy
+// facts are merged into it (existing facts win) and the legacy row is removed; duplicate relations are
+// dropped. Runs in one transaction, so it can simply be repeated after a failure.
+func (s *CortexStore) ClaimLegacyGraph(appID, externalUserID string) (LegacyGraphCounts, error) {
+	var counts LegacyGraphCounts
+	if appID == "" || externalUserID == "" {
+		return counts, errors.New("appId and externalUserId are required")
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var legacy []models.Entity
+		if err := s.applyTenantFilter(tx, "", "").Order("id").Find(&legacy).Error; err != nil {
+			return err
+		}
+		for _, ent := range legacy {
+			var target models.Entity
+			err := s.applyTenantFilter(tx, appID, externalUserID).Where("name = ?", ent.Name).First(&target).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if err := tx.Model(&models.Entity{}).Where("id = ?", ent.ID).
+					UpdateColumns(map[string]any{"app_id": appID, "external_user_id": externalUserID}).Error; err != nil {
+					return err
+				}
+				ent.AppID, ent.ExternalUserID = appID, externalUserID
+				if err := logChange(tx, models.ChangeKindEntity, models.ChangeOpUpdate, ent.ID, appID, externalUserID, &ent); err != nil {
+					return err
+				}
+				counts.Entities++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			data := helpers.UnmarshalEntityData(ent.Data)
+			for k, v := range helpers.UnmarshalEntityData(target.Data) {
+				data[k] = v
+			}
+			target.Data, target.UpdatedAt = helpers.MarshalEntityData(data), time.Now()
+			if err := tx.Model(&models.Entity{}).Where("id = ?", target.ID).
+				UpdateColumns(map[string]any{"data": target.Data, "updated_at": target.UpdatedAt}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&models.Entity{}, ent.ID).Error; err != nil {
+				return err
+			}
+			if err := logChange(tx, models.ChangeKindEntity, models.ChangeOpUpdate, target.ID, appID, externalUserID, &target); err != nil {
+				return err
+			}
+			if err := logChange(tx, models.ChangeKindEntity, models.ChangeOpDelete, ent.ID, "", "", &ent); err != nil {
+				return err
+			}
+			counts.MergedEntities++
+		}
+
+		var relations []models.Relation
+		if err := s.applyTenantFilter(tx, "", "").Order("id").Find(&relations).Error; err != nil {
+			return err
+		}
+		for _, rel := range relations {
+			var dup int64
+			if err := s.applyTenantFilter(tx.Model(&models.Relation{}), appID, externalUserID).
+				Where("from_entity = ? AND to_entity = ? AND type = ?", rel.From, rel.To, rel.Type).
+				Count(&dup).Error; err != nil {
+				return err
+			}
+			if dup > 0 {
+				if err := tx.Delete(&models.Relation{}, rel.ID).Error; err != nil {
+					return err
+				}
+				if err := logChange(tx, models.ChangeKindRelation, models.ChangeOpDelete, rel.ID, "", "", &rel); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(&models.Relation{}).Where("id = ?", rel.ID).
+				UpdateColumns(map[string]any{"app_id": appID, "external_user_id": externalUserID}).Error; err != nil {
+				return err
+			}
+			rel.AppID, rel.ExternalUserID = appID, externalUserID
+			if err := logChange(tx, models.ChangeKindRelation, models.ChangeOpUpdate, rel.ID, appID, externalUserID, &rel); err != nil {
+				return err
+			}
+			counts.Relations++
+		}
+		return nil
+	})
+	if err != nil {
+		return LegacyGraphCounts{}, err
+	}
+
+	if counts.Entities > 0 || counts.MergedEntities > 0 || counts.Relations > 0 {
+		slog.Info("legacy graph claimed", "appId", appID, "userId", externalUserID,
+			"entities", counts.Entities, "mergedEntities", counts.MergedEntities, "relations", counts.Relations)
+	}
+	return counts, nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// createPreTenantGraphDB legt eine Datenbank mit dem alten Schema an (Entity-Namen global eindeutig, keine Tenant-Spalten).
+func createPreTenantGraphDB(t *testing.T, dbPath string) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	for _, q := range []string{
+		"CREATE TABLE entities (id integer PRIMARY KEY AUTOINCREMENT, name text NOT NULL, data text, created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP)",
+		"CREATE UNIQUE INDEX idx_entities_name ON entities(name)",
+		"CREATE TABLE relations (id integer PRIMARY KEY AUTOINCREMENT, from_entity text NOT NULL, to_entity text NOT NULL, type text NOT NULL, valid_from datetime, valid_to datetime, created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP)",
+		`INSERT INTO entities (name, data) VALUES ('user:carsten', '{"color":"blue","city":"Berlin"}'), ('project:cortex', '{"lang":"go"}')`,
+		"INSERT INTO relations (from_entity, to_entity, type) VALUES ('user:carsten', 'project:cortex', 'works_on'), ('user:carsten', 'user:anna', 'knows')",
+	} {
+		if err := db.Exec(q).Error; err != nil {
+			t.Fatalf("legacy schema setup failed (%s): %v", q, err)
+		}
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+}
+
+func TestClaimLegacyGraph(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy-graph.db")
+	createPreTenantGraphDB(t, dbPath)
+
+	s, err := NewCortexStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewCortexStore on pre-tenant schema failed: %v", err)
+	}
+	defer s.Close()
+
+	entities, relations, err := s.CountLegacyGraph()
+	if err != nil || entities != 2 || relations != 2 {
+		t.Fatalf("expected 2 legacy entities and 2 relations, got %d/%d (err %v)", entities, relations, err)
+	}
+	// Nach der Migration sind Namen nur noch pro Tenant eindeutig
+	if err := s.CreateOrUpdateEntity(&models.Entity{AppID: "app1", ExternalUserID: "user1", Name: "user:carsten", Data: `{"color":"green"}`}); err != nil {
+		t.Fatalf("same name in a tenant should be allowed next to the legacy row: %v", err)
+	}
+	s.CreateOrUpdateRelation(&models.Relation{AppID: "app1", ExternalUserID: "user1", From: "user:carsten", To: "user:anna", Type: "knows"})
+	if got, _ := s.ListEntities("app1", "user1"); len(got) != 1 {
+		t.Fatalf("legacy entities must not be visible to a tenant before claiming, got %d", len(got))
+	}
+
+	counts, err := s.ClaimLegacyGraph("app1", "user1")
+	if err != nil {
+		t.Fatalf("ClaimLegacyGraph failed: %v", err)
+	}
+	if counts.Entities != 1 || counts.MergedEntities != 1 || counts.Relations != 1 {
+		t.Errorf("unexpected counts: %+v", counts)
+	}
+
+	ent, err := s.GetEntity("app1", "user1", "user:carsten")
+	if err != nil {
+		t.Fatalf("GetEntity failed: %v", err)
+	}
+	data := helpers.UnmarshalEntityData(ent.Data)
+	if data["color"] != "green" || data["city"] != "Berlin" {
+		t.Errorf("expected merged facts with tenant values winning, got %v", data)
+	}
+	if _, err := s.GetEntity("app1", "user1", "project:cortex"); err != nil {
+		t.Errorf("expected project:cortex to be claimed: %v", err)
+	}
+	if rels, _ := s.GetRelations("app1", "user1", "user:carsten"); len(rels) != 2 {
+		t.Errorf("expected 2 relations (duplicate dropped), got %d", len(rels))
+	}
+	if entities, relations, _ := s.CountLegacyGraph(); entities != 0 || relations != 0 {
+		t.Errorf("expected no legacy rows left, got %d/%d", entities, relations)
+	}
+
+	// Wiederholung ist ein No-op
+	if counts, err := s.ClaimLegacyGraph("app1", "user1"); err != nil || counts != (LegacyGraphCounts{}) {
+		t.Errorf("expected no-op on second claim, got %+v (err %v)", counts, err)
+	}
+	if _, err := s.ClaimLegacyGraph("", "user1"); err == nil {
+		t.Error("expected error for missing appId")
+	}
+}
@@ -0,0 +1,352 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// NDJSON export format (one JSON record per line, see Record)
+const (
+	NDJSONFormat  = "cortex-ndjson"
+	NDJSONVersion = "2.0"
+)
+
+// NDJSON record types (Record.Type). An export starts with header and ends with end; records that
+// reference others (memory -> bundle, memory_version/memory_entity -> memory) follow them.
+const (
+	RecordHeader        = "header"
+	RecordBundle        = "bundle"
+	RecordMemory        = "memory"
+	RecordMemoryVersion = "memory_version"
+	RecordEntity        = "entity"
+	RecordMemoryEntity  = "memory_entity"
+	RecordRelation      = "relation"
+	RecordAgentContext  = "agent_context"
+	RecordDeleted       = "deleted" // tombstone, only in incremental exports
+	RecordEnd           = "end"
+)
+
+const (
+	ndjsonBatchSize   = 500      // rows read per query (export) and records per transaction (import)
+	ndjsonMaxLineSize = 16 << 20 // longest accepted import line
+	ndjsonMaxErrors   = 1000     // errors kept in ImportReport.Errors
+)
+
+// Record is one line of an NDJSON export.
+type Record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ExportHeader is the data of the first record.
+type ExportHeader struct {
+	Format          string     `json:"format"`
+	Version         string     `json:"version"`
+	AppID           string     `json:"app_id"`
+	ExternalUserID  string     `json:"external_user_id"`
+	ExportedAt      time.Time  `json:"exported_at"`
+	UpdatedSince    *time.Time `json:"updated_since,omitempty"`
+	IncludeArchived bool       `json:"include_archived"`
+}
+
+// ExportEnd is the data of the last record. A stream without end record is incomplete.
+type ExportEnd struct {
+	Counts           map[string]int `json:"counts"`
+	NextUpdatedSince time.Time      `json:"next_updated_since"` // updatedSince for the next incremental export
+}
+
+// MemoryEntityRecord links a memory to an entity of the same tenant by name.
+type MemoryEntityRecord struct {
+	MemoryID int64  `json:"memory_id"`
+	Entity   string `json:"entity"`
+}
+
+// DeletedRecord is a tombstone: the object was deleted after updatedSince.
+type DeletedRecord struct {
+	Kind           string    `json:"kind"` // change kind, see models.ChangeKinds
+	ID             int64     `json:"id"`
+	AppID          string    `json:"app_id"`
+	ExternalUserID string    `json:"external_user_id"`
+	DeletedAt      time.Time `json:"deleted_at"`
+}
+
+// agentContextRecord exports the tags of an agent context (not part of its API JSON).
+type agentContextRecord struct {
+	models.AgentContext
+	Tags string `json:"tags,omitempty"`
+}
+
+// ExportOptions selects what ExportNDJSON writes.
+type ExportOptions struct {
+	AppID           string
+	ExternalUserID  string
+	IncludeArchived bool
+	UpdatedSince    *time.Time // only objects created/changed at or after this time, plus tombstones
+}
+
+// ExportNDJSON streams the tenant as NDJSON to w (bundles, memories, versions, entities, memory
+// links, relations, agent contexts). Rows are read in batches, so memory use does not grow with the
+// tenant. Embeddings are not exported. With UpdatedSince only rows changed since then are written,
+// plus a deleted record per object deleted since then.
+func (s *CortexStore) ExportNDJSON(w io.Writer, opts ExportOptions) (*ExportEnd, error) {
+	// Cursor vor dem ersten Lesen: Änderungen während des Exports kommen beim nächsten Mal erneut
+	start := time.Now().UTC()
+	end := &ExportEnd{Counts: make(map[string]int), NextUpdatedSince: start}
+	enc := json.NewEncoder(w)
+	write := func(typ string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", typ, err)
+		}
+		if err := enc.Encode(Record{Type: typ, Data: data}); err != nil {
+			return err
+		}
+		if typ != RecordHeader && typ != RecordEnd {
+			end.Counts[typ]++
+		}
+		return nil
+	}
+
+	if err := write(RecordHeader, ExportHeader{
+		Format:          NDJSONFormat,
+		Version:         NDJSONVersion,
+		AppID:           opts.AppID,
+		ExternalUserID:  opts.ExternalUserID,
+		ExportedAt:      start,
+		UpdatedSince:    opts.UpdatedSince,
+		IncludeArchived: opts.IncludeArchived,
+	}); err != nil {
+		return nil, err
+	}
+
+	tenant := func(model any) *gorm.DB {
+		return s.applyTenantFilter(s.db.Model(model), opts.AppID, opts.ExternalUserID)
+	}
+	// DB-Defaults (CURRENT_TIMESTAMP) haben nur Sekunden: Cursor abrunden, Zeilen dieser Sekunde kommen erneut
+	var since time.Time
+	if opts.UpdatedSince != nil {
+		since = opts.UpdatedSince.UTC().Truncate(time.Second)
+	}
+	incremental := opts.UpdatedSince != nil
+	// changed selects the IDs of a kind with a change log entry since the cursor (catches updates of
+	// rows without updated_at and updates that did not touch it).
+	changed := func(kind string) *gorm.DB {
+		return tenant(&models.Change{}).Select("object_id").Where("kind = ? AND "+atOrAfter("created_at"), kind, since)
+	}
+
+	bundles := tenant(&models.Bundle{})
+	if incremental {
+		bundles = bundles.Where(atOrAfter("created_at")+" OR id IN (?)", since, changed(models.ChangeKindBundle))
+	}
+	var bundleBatch []models.Bundle
+	if err := exportBatches(bundles, &bundleBatch, func() error {
+		for i := range bundleBatch {
+			if err := write(RecordBundle, &bundleBatch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export bundles: %w", err)
+	}
+
+	memories := s.memoryStatusFilter(tenant(&models.Memory{}), opts.IncludeArchived).Omit("embedding")
+	if incremental {
+		memories = memories.Where(atOrAfter("created_at")+" OR "+atOrAfter("updated_at")+" OR id IN (?)", since, since, changed(models.ChangeKindMemory))
+	}
+	var memoryBatch []models.Memory
+	if err := exportBatches(memories, &memoryBatch, func() error {
+		for i := range memoryBatch {
+			memoryBatch[i].MetadataMap = helpers.UnmarshalMetadata(memoryBatch[i].Metadata)
+			if err := write(RecordMemory, &memoryBatch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export memories: %w", err)
+	}
+
+	// Versionen und Links nur für exportierbare Memories (Tenant, Status)
+	memoryIDs := s.memoryStatusFilter(tenant(&models.Memory{}), opts.IncludeArchived).Select("id")
+	versions := s.db.Model(&models.MemoryVersion{}).Where("memory_id IN (?)", memoryIDs)
+	if incremental {
+		versions = versions.Where(atOrAfter("changed_at"), since)
+	}
+	var versionBatch []models.MemoryVersion
+	if err := exportBatches(versions, &versionBatch, func() error {
+		for i := range versionBatch {
+			versionBatch[i].MetadataMap = helpers.UnmarshalMetadata(versionBatch[i].Metadata)
+			if err := write(RecordMemoryVersion, &versionBatch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export memory versions: %w", err)
+	}
+
+	entities := tenant(&models.Entity{})
+	if incremental {
+		entities = entities.Where(atOrAfter("created_at")+" OR "+atOrAfter("updated_at")+" OR id IN (?)", since, since, changed(models.ChangeKindEntity))
+	}
+	var entityBatch []models.Entity
+	if err := exportBatches(entities, &entityBatch, func() error {
+		for i := range entityBatch {
+			entityBatch[i].DataMap = helpers.UnmarshalEntityData(entityBatch[i].Data)
+			if err := write(RecordEntity, &entityBatch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export entities: %w", err)
+	}
+
+	if err := s.exportMemoryEntities(memoryIDs, incremental, since, changed(models.ChangeKindMemoryEntity), write); err != nil {
+		return nil, fmt.Errorf("failed to export memory entities: %w", err)
+	}
+
+	relations := tenant(&models.Relation{})
+	if incremental {
+		relations = relations.Where(atOrAfter("created_at")+" OR id IN (?)", since, changed(models.ChangeKindRelation))
+	}
+	var relationBatch []models.Relation
+	if err := exportBatches(relations, &relationBatch, func() error {
+		for i := range relationBatch {
+			if err := write(RecordRelation, &relationBatch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export relations: %w", err)
+	}
+
+	contexts := tenant(&models.AgentContext{})
+	if incremental {
+		contexts = contexts.Where(atOrAfter("created_at")+" OR "+atOrAfter("updated_at")+" OR id IN (?)", since, since, changed(models.ChangeKindAgentContext))
+	}
+	var contextBatch []models.AgentContext
+	if err := exportBatches(contexts, &contextBatch, func() error {
+		for i := range contextBatch {
+			ctx := contextBatch[i]
+			ctx.PayloadMap = helpers.UnmarshalMetadata(ctx.Payload)
+			if err := write(RecordAgentContext, agentContextRecord{AgentContext: ctx, Tags: ctx.Tags}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export agent contexts: %w", err)
+	}
+
+	if incremental {
+		deletes := tenant(&models.Change{}).Where("op = ? AND "+atOrAfter("created_at"), models.ChangeOpDelete, since)
+		var changeBatch []models.Change
+		if err := exportBatches(deletes, &changeBatch, func() error {
+			for _, c := range changeBatch {
+				if err := write(RecordDeleted, DeletedRecord{
+					Kind:           c.Kind,
+					ID:             c.ObjectID,
+					AppID:          c.AppID,
+					ExternalUserID: c.ExternalUserID,
+					DeletedAt:      c.CreatedAt.UTC(),
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to export deletions: %w", err)
+		}
+	}
+
+	if err := write(RecordEnd, end); err != nil {
+		return nil, err
+	}
+	return end, nil
+}
+
+// exportMemoryEntities writes the entity links of the exported memories as memory_entity records.
+// Incremental: links created since the cursor and all links of memories whose links changed.
+func (s *CortexStore) exportMemoryEntities(memoryIDs *gorm.DB, incremental bool, since time.Time, changed *gorm.DB, write func(string, any) error) error {
+	links := s.db.Table("memory_entities").
+		Select("memory_entities.memory_id, entities.name").
+		Joins("JOIN entities ON entities.id = memory_entities.entity_id").
+		Where("memory_entities.memory_id IN (?)", memoryIDs)
+	if incremental {
+		links = links.Where(atOrAfter("memory_entities.created_at")+" OR memory_entities.memory_id IN (?)", since, changed)
+	}
+	rows, err := links.Order("memory_entities.memory_id, entities.name").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link MemoryEntityRecord
+		if err := rows.Scan(&link.MemoryID, &link.Entity); err != nil {
+			return err
+		}
+		if err := write(RecordMemoryEntity, link); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// atOrAfter compares a timestamp column with a time parameter. Timestamps are stored as text in
+// different formats (DB default: UTC seconds, gorm: local time with fraction and offset), so both
+// sides are compared as julian days instead of as strings.
+func atOrAfter(column string) string {
+	return "julianday(" + column + ") >= julianday(?)"
+}
+
+// exportBatches reads the rows of query in primary key order, ndjsonBatchSize at a time into dest,
+// and calls fn after each batch.
+func exportBatches(query *gorm.DB, dest any, fn func() error) error {
+	return query.FindInBatches(dest, ndjsonBatchSize, func(*gorm.DB, int) error {
+		return fn()
+	}).Error
+}
+
+// ImportNDJSON imports an NDJSON stream (see ExportNDJSON and ImportOptions) record by record.
+// Records are applied in transactions of ndjsonBatchSize records; a failing record is rolled back on
+// its own and reported, the rest of the batch is kept. The returned error is only set when the
+// stream cannot be read or a batch cannot be committed; the report is valid up to that point.
+func (s *CortexStore) ImportNDJSON(r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	imp := s.newImporter(opts)
+	err := imp.run(func(emit func(int, Record) error) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), ndjsonMaxLineSize)
+		for scanner.Scan() {
+			imp.report.Lines++
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var rec Record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				imp.fail(imp.report.Lines, "", 0, fmt.Errorf("invalid JSON: %w", err))
+				continue
+			}
+			if err := emit(imp.report.Lines, rec); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("line %d: %w", imp.report.Lines+1, err)
+		}
+		return nil
+	})
+	return imp.report, err
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+)
+
+func readRecords(t *testing.T, buf *bytes.Buffer) []Record {
+	t.Helper()
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func countTypes(records []Record) map[string]int {
+	counts := make(map[string]int)
+	for _, rec := range records {
+		counts[rec.Type]++
+	}
+	return counts
+}
+
+func TestNDJSONExportImportRoundtrip(t *testing.T) {
+	src := setupTestDB(t)
+	defer src.Close()
+
+	bundle := models.Bundle{Name: "b", AppID: "app", ExternalUserID: "u1"}
+	if err := src.CreateBundle(&bundle); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	mem := models.Memory{Content: "Alice mag Kaffee", AppID: "app", ExternalUserID: "u1", BundleID: &bundle.ID, Metadata: `{"k":"v"}`, Status: models.MemoryStatusActive}
+	if err := src.CreateMemory(&mem); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	mem.Content = "Alice mag Tee"
+	if err := src.UpdateMemory(&mem, "api"); err != nil {
+		t.Fatalf("UpdateMemory: %v", err)
+	}
+	if _, err := src.LinkMemoryEntities(&mem, []string{"Alice"}); err != nil {
+		t.Fatalf("LinkMemoryEntities: %v", err)
+	}
+	if _, err := src.SetEntityFact("app", "u1", "Alice", "drink", "tea"); err != nil {
+		t.Fatalf("SetEntityFact: %v", err)
+	}
+	if err := src.CreateOrUpdateRelation(&models.Relation{AppID: "app", ExternalUserID: "u1", From: "Alice", To: "Bob", Type: "knows"}); err != nil {
+		t.Fatalf("CreateOrUpdateRelation: %v", err)
+	}
+	if err := src.CreateAgentContext(&models.AgentContext{AppID: "app", ExternalUserID: "u1", AgentID: "a", MemoryType: "episodic", Payload: `{"step":1}`, Tags: "x,y"}); err != nil {
+		t.Fatalf("CreateAgentContext: %v", err)
+	}
+	if err := src.CreateMemory(&models.Memory{Content: "other tenant", AppID: "app", ExternalUserID: "u2"}); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+
+	var buf bytes.Buffer
+	end, err := src.ExportNDJSON(&buf, ExportOptions{AppID: "app", ExternalUserID: "u1"})
+	if err != nil {
+		t.Fatalf("ExportNDJSON: %v", err)
+	}
+	records := readRecords(t, &buf)
+	if records[0].Type != RecordHeader || records[len(records)-1].Type != RecordEnd {
+		t.Fatalf("export must start with header and end with end: %v", countTypes(records))
+	}
+	want := map[string]int{RecordBundle: 1, RecordMemory: 1, RecordMemoryVersion: 1, RecordEntity: 1, RecordMemoryEntity: 1, RecordRelation: 1, RecordAgentContext: 1}
+	for typ, n := range want {
+		if end.Counts[typ] != n {
+			t.Errorf("count %s = %d, want %d (%v)", typ, end.Counts[typ], n, end.Counts)
+		}
+	}
+	if strings.Contains(buf.String(), "other tenant") {
+		t.Error("export contains another tenant")
+	}
+
+	dst := setupTestDB(t)
+	defer dst.Close()
+	// Fremde Zeile belegt ID 1, damit neue IDs von den exportierten abweichen
+	if err := dst.CreateBundle(&models.Bundle{Name: "existing", AppID: "x", ExternalUserID: "x"}); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	report, err := dst.ImportNDJSON(bytes.NewReader(buf.Bytes()), ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportNDJSON: %v", err)
+	}
+	if report.Failed != 0 {
+		t.Fatalf("unexpected errors: %+v", report.Errors)
+	}
+	for typ, n := range want {
+		if report.Imported[typ] != n {
+			t.Errorf("imported %s = %d, want %d", typ, report.Imported[typ], n)
+		}
+	}
+
+	bundles, _ := dst.ListBundles("app", "u1")
+	memories, _ := dst.ListMemoriesByTenant("app", "u1", 10, 0, true)
+	if len(bundles) != 1 || len(memories) != 1 {
+		t.Fatalf("expected 1 bundle and 1 memory, got %d/%d", len(bundles), len(memories))
+	}
+	got := memories[0]
+	if got.Content != "Alice mag Tee" || got.BundleID == nil || *got.BundleID != bundles[0].ID || got.Metadata != `{"k":"v"}` {
+		t.Errorf("unexpected imported memory %+v (bundle %d)", got, bundles[0].ID)
+	}
+	versions, _ := dst.ListMemoryVersions(got.ID, "app", "u1")
+	if len(versions) != 1 || versions[0].Content != "Alice mag Kaffee" {
+		t.Errorf("unexpected versions %+v", versions)
+	}
+	ids, _ := dst.MemoryIDsForEntity("app", "u1", "Alice")
+	if len(ids) != 1 || ids[0] != got.ID {
+		t.Errorf("entity link not remapped: %v", ids)
+	}
+	alice, err := dst.GetEntity("app", "u1", "Alice")
+	if err != nil || alice.Data != `{"drink":"tea"}` {
+		t.Errorf("unexpected entity %+v, %v", alice, err)
+	}
+	contexts, _ := dst.ListAgentContexts("app", "u1", "", "", "")
+	if len(contexts) != 1 || contexts[0].Tags != "x,y" || contexts[0].Payload != `{"step":1}` {
+		t.Errorf("unexpected agent contexts %+v", contexts)
+	}
+	feed, _ := dst.ListChangesSince(0, ChangeFilter{AppID: "app", ExternalUserID: "u1", Kinds: []string{models.ChangeKindMemory}}, 10)
+	if len(feed.Changes) != 1 || feed.Changes[0].Op != models.ChangeOpCreate {
+		t.Errorf("import must be logged: %v", changeOps(feed))
+	}
+}
+
+func TestNDJSONIncrementalExport(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	old := models.Memory{Content: "old", AppID: "app", ExternalUserID: "u1"}
+	gone := models.Memory{Content: "gone", AppID: "app", ExternalUserID: "u1"}
+	for _, m := range []*models.Memory{&old, &gone} {
+		if err := s.CreateMemory(m); err != nil {
+			t.Fatalf("CreateMemory: %v", err)
+		}
+	}
+	// Cursor wird auf Sekunden abgerundet: Export erst in der nächsten Sekunde
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	var full bytes.Buffer
+	end, err := s.ExportNDJSON(&full, ExportOptions{AppID: "app", ExternalUserID: "u1"})
+	if err != nil {
+		t.Fatalf("ExportNDJSON: %v", err)
+	}
+
+	fresh := models.Memory{Content: "fresh", AppID: "app", ExternalUserID: "u1"}
+	if err := s.CreateMemory(&fresh); err != nil {
+		t.Fatalf("CreateMemory: %v", err)
+	}
+	if err := s.DeleteMemory(&gone); err != nil {
+		t.Fatalf("DeleteMemory: %v", err)
+	}
+
+	var inc bytes.Buffer
+	since := end.NextUpdatedSince
+	if _, err := s.ExportNDJSON(&inc, ExportOptions{AppID: "app", ExternalUserID: "u1", UpdatedSince: &since}); err != nil {
+		t.Fatalf("ExportNDJSON: %v", err)
+	}
+	records := readRecords(t, &inc)
+	counts := countTypes(records)
+	if counts[RecordMemory] != 1 || counts[RecordDeleted] != 1 {
+		t.Fatalf("expected 1 memory and 1 tombstone, got %v", counts)
+	}
+	for _, rec := range records {
+		switch rec.Type {
+		case RecordMemory:
+			var m models.Memory
+			json.Unmarshal(rec.Data, &m)
+			if m.ID != fresh.ID {
+				t.Errorf("unexpected memory %d in incremental export", m.ID)
+			}
+		case RecordDeleted:
+			var d DeletedRecord
+			json.Unmarshal(rec.Data, &d)
+			if d.Kind != models.ChangeKindMemory || d.ID != gone.ID {
+				t.Errorf("unexpected tombstone %+v", d)
+			}
+		}
+	}
+
+	// Restore: Voll-Export plus Inkrement mit Overwrite ergibt den aktuellen Stand
+	dst := setupTestDB(t)
+	defer dst.Close()
+	for _, buf := range []*bytes.Buffer{&full, &inc} {
+		report, err := dst.ImportNDJSON(bytes.NewReader(buf.Bytes()), ImportOptions{KeepIDs: true})
+		if err != nil || report.Failed != 0 {
+			t.Fatalf("ImportNDJSON: %v %+v", err, report)
+		}
+	}
+	memories, _ := dst.ListMemoriesByTenant("app", "u1", 10, 0, true)
+	if len(memories) != 2 {
+		t.Fatalf("expected 2 memories after restore, got %+v", memories)
+	}
+	for _, m := range memories {
+		if m.ID == gone.ID {
+			t.Error("tombstone not applied")
+		}
+	}
+}
+
+func TestNDJSONImportErrorsAndDryRun(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	input := strings.Join([]string{
+		`{"type":"header","data":{"format":"cortex-ndjson","version":"2.0"}}`,
+		`{"type":"memory","data":{"id":7,"content":"ok","app_id":"app","external_user_id":"u1"}}`,
+		`not json`,
+		`{"type":"memory","data":{"id":8,"content":"","app_id":"app","external_user_id":"u1"}}`,
+		`{"type":"memory","data":{"id":9,"content":"in bundle","bundle_id":42,"app_id":"app","external_user_id":"u1"}}`,
+		`{"type":"memory_entity","data":{"memory_id":7,"entity":"Alice"}}`,
+		`{"type":"unknown","data":{}}`,
+		`{"type":"deleted","data":{"kind":"memory","id":1,"app_id":"app","external_user_id":"u1"}}`,
+		``,
+		`{"type":"end","data":{}}`,
+	}, "\n")
+
+	var progress int
+	var streamed []ImportError
+	report, err := s.ImportNDJSON(strings.NewReader(input), ImportOptions{
+		DryRun:     true,
+		OnProgress: func(ImportReport) { progress++ },
+		OnError:    func(e ImportError) { streamed = append(streamed, e) },
+	})
+	if err != nil {
+		t.Fatalf("ImportNDJSON: %v", err)
+	}
+	if report.Lines != 10 || report.Imported[RecordMemory] != 1 || report.Imported[RecordMemoryEntity] != 1 || report.Skipped != 1 || report.Failed != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	wantLines := []int{3, 4, 5, 7}
+	for i, e := range report.Errors {
+		if e.Line != wantLines[i] {
+			t.Errorf("error %d on line %d, want %d (%+v)", i, e.Line, wantLines[i], e)
+		}
+	}
+	if report.Errors[2].Record != RecordMemory || report.Errors[2].ID != 9 {
+		t.Errorf("error must identify the record: %+v", report.Errors[2])
+	}
+	if len(streamed) != 4 || progress != 1 {
+		t.Errorf("callbacks: %d errors, %d progress", len(streamed), progress)
+	}
+
+	// Dry-Run schreibt nichts
+	memories, _ := s.ListMemoriesByTenant("app", "u1", 10, 0, true)
+	latest, _ := s.LatestChangeSeq()
+	if len(memories) != 0 || latest != 0 {
+		t.Fatalf("dry run must not write (memories=%d, changes=%d)", len(memories), latest)
+	}
+
+	report, err = s.ImportNDJSON(strings.NewReader(input), ImportOptions{})
+	if err != nil || report.Failed != 4 {
+		t.Fatalf("ImportNDJSON: %v %+v", err, report)
+	}
+	memories, _ = s.ListMemoriesByTenant("app", "u1", 10, 0, true)
+	if len(memories) != 1 || memories[0].Content != "ok" {
+		t.Fatalf("valid record must be kept next to failed ones: %+v", memories)
+	}
+
+	if _, err := s.ImportNDJSON(strings.NewReader(strings.Repeat("x", ndjsonMaxLineSize+1)), ImportOptions{}); err == nil {
+		t.Error("expected an error for an over-long line")
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// setMemoryEmbedding encodes vec (with model ID in the header) and sets embedding, model and dimension on mem.
+func setMemoryEmbedding(mem *models.Memory, vec []float32, modelID string) error {
+	encoded, err := embeddings.EncodeVectorAs(vec, embeddings.StorageEncoding(), modelID)
+	if err != nil {
+		return err
+	}
+	mem.Embedding = encoded
+	mem.EmbeddingModel = modelID
+	mem.EmbeddingDim = len(vec)
+	return nil
+}
+
+// needsReembedQuery selects memories of a tenant whose embedding is missing or stems from another model.
+func (s *CortexStore) needsReembedQuery(appID, externalUserID, modelID string) *gorm.DB {
+	return s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID).
+		Where("embedding IS NULL OR length(embedding) = 0 OR embedding_model IS NULL OR embedding_model != ?", modelID)
+}
+
+// CountMemoriesNeedingReembed counts memories of a tenant (active and archived) not yet embedded with modelID.
+func (s *CortexStore) CountMemoriesNeedingReembed(appID, externalUserID, modelID string) (int64, error) {
+	var count int64
+	err := s.needsReembedQuery(appID, externalUserID, modelID).Count(&count).Error
+	return count, err
+}
+
+// ListMemoriesNeedingReembed returns the next batch (ID > afterID, ascending) of memories not yet embedded with modelID.
+func (s *CortexStore) ListMemoriesNeedingReembed(appID, externalUserID, modelID string, afterID int64, limit int) ([]models.Memory, error) {
+	var memories []models.Memory
+	err := s.needsReembedQuery(appID, externalUserID, modelID).
+		Where("id > ?", afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&memories).Error
+	return memories, err
+}
+
+// ReembedMemories embeds a batch of memories with service and stores the vectors. Old vectors stay in
+// place (and searchable) until they are replaced. A memory whose content changed in the meantime is
+// skipped; its update already regenerates the embedding. Returns the number of updated memories.
+func (s *CortexStore) ReembedMemories(memories []models.Memory, service embeddings.EmbeddingService) (int, error) {
+	if len(memories) == 0 {
+		return 0, nil
+	}
+	modelID := embeddings.ModelIDOf(service)
+	contents := make([]string, len(memories))
+	for i := range memories {
+		contents[i] = memories[i].Content
+	}
+	vectors, err := service.GenerateEmbeddingsBatch(contents, "text/plain")
+	if err != nil {
+		return 0, err
+	}
+	if len(vectors) != len(memories) {
+		return 0, fmt.Errorf("embedding service returned %d vectors for %d memories", len(vectors), len(memories))
+	}
+
+	updated := 0
+	for i := range memories {
+		mem := &memories[i]
+		if err := setMemoryEmbedding(mem, vectors[i], modelID); err != nil {
+			return updated, err
+		}
+		mem.ContentType = embeddings.DetectContentType(mem.Content, helpers.UnmarshalMetadata(mem.Metadata))
+		res := s.db.Model(&models.Memory{}).
+			Where("id = ? AND content_hash = ?", mem.ID, mem.ContentHash).
+			UpdateColumns(map[string]any{
+				"embedding":       mem.Embedding,
+				"embedding_model": mem.EmbeddingModel,
+				"embedding_dim":   mem.EmbeddingDim,
+				"content_type":    mem.ContentType,
+			})
+		if res.Error != nil {
+			return updated, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		s.indexMemory(mem)
+		updated++
+	}
+	return updated, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"testing"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/models"
+)
+
+func TestSemanticSearchSkipsForeignModelVectors(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	current := createMemoryWithEmbedding(t, s, "User likes coffee and espresso", nil, map[string]any{"typ": "präferenz"})
+	if current.EmbeddingModel != "local-hash-384" || current.EmbeddingDim != 384 {
+		t.Fatalf("expected model identity on memory, got %q/%d", current.EmbeddingModel, current.EmbeddingDim)
+	}
+
+	// Gleiche Dimension, aber anderes Modell: darf nicht verglichen werden
+	foreign := createMemoryWithEmbedding(t, s, "User likes coffee and espresso a lot", nil, map[string]any{"typ": "präferenz"})
+	vec, _ := embeddings.DecodeVector(foreign.Embedding)
+	if err := setMemoryEmbedding(foreign, vec, "other-model"); err != nil {
+		t.Fatalf("setMemoryEmbedding failed: %v", err)
+	}
+	if err := s.UpdateMemory(foreign, "api"); err != nil {
+		t.Fatalf("UpdateMemory failed: %v", err)
+	}
+
+	for _, useIndex := range []bool{true, false} {
+		s.vectorIndex.enabled = useIndex
+		results, err := s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "coffee", nil, 10, nil, nil, false)
+		if err != nil {
+			t.Fatalf("semantic search failed: %v", err)
+		}
+		if !containsMemory(results, current.ID) || containsMemory(results, foreign.ID) {
+			t.Errorf("index=%v: expected only current-model memory, got %d results", useIndex, len(results))
+		}
+	}
+
+	pending, err := s.CountMemoriesNeedingReembed("app1", "user1", "local-hash-384")
+	if err != nil || pending != 1 {
+		t.Errorf("expected 1 memory needing re-embed, got %d (err %v)", pending, err)
+	}
+}
+
+func TestReembedMemoriesSkipsChangedContent(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	mem := &models.Memory{Type: "semantic", Content: "original content", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	s.CreateMemory(mem)
+
+	batch, err := s.ListMemoriesNeedingReembed("app1", "user1", "local-hash-384", 0, 10)
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("expected 1 memory to re-embed, got %d (err %v)", len(batch), err)
+	}
+
+	// Inhalt ändert sich zwischen Lesen und Schreiben
+	s.db.Model(&models.Memory{}).Where("id = ?", mem.ID).Update("content", "changed content")
+
+	updated, err := s.ReembedMemories(batch, embeddings.NewLocalEmbeddingService())
+	if err != nil {
+		t.Fatalf("ReembedMemories failed: %v", err)
+	}
+	if updated != 0 {
+		t.Errorf("expected stale memory to be skipped, got %d updated", updated)
+	}
+
+	batch, _ = s.ListMemoriesNeedingReembed("app1", "user1", "local-hash-384", 0, 10)
+	if updated, _ := s.ReembedMemories(batch, embeddings.NewLocalEmbeddingService()); updated != 1 {
+		t.Errorf("expected 1 updated memory, got %d", updated)
+	}
+	var stored models.Memory
+	s.db.First(&stored, mem.ID)
+	if h, _ := embeddings.DecodeVectorHeader(stored.Embedding); h.ModelID != "local-hash-384" || stored.EmbeddingDim != 384 {
+		t.Errorf("expected model in header and column, got %+v / %d", h, stored.EmbeddingDim)
+	}
+}
+
+// shortBatchService returns one vector less than requested.
+type shortBatchService struct{ embeddings.EmbeddingService }
+
+func (s shortBatchService) GenerateEmbeddingsBatch(contents []string, contentType string) ([][]float32, error) {
+	vectors, err := s.EmbeddingService.GenerateEmbeddingsBatch(contents, contentType)
+	return vectors[:len(vectors)-1], err
+}
+
+func TestReembedMemoriesVectorCountMismatch(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	for _, content := range []string{"first", "second"} {
+		s.CreateMemory(&models.Memory{Type: "semantic", Content: content, AppID: "app1", ExternalUserID: "user1", Importance: 5})
+	}
+	batch, _ := s.ListMemoriesNeedingReembed("app1", "user1", "other-model", 0, 10)
+	if updated, err := s.ReembedMemories(batch, shortBatchService{embeddings.NewLocalEmbeddingService()}); err == nil || updated != 0 {
+		t.Errorf("expected error for a short batch, got %d updated (err %v)", updated, err)
+	}
+}
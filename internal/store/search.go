@@ -0,0 +1,196 @@
+package store
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"unicode"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/models"
+)
+
+// DefaultRRFK is the rank offset k of reciprocal rank fusion (score = w / (k + rank)).
+const DefaultRRFK = 60
+
+// hybridCandidateFactor controls how many candidates per ranking are fused (limit * factor, min 20).
+const hybridCandidateFactor = 3
+
+// RankedMemory is a search hit together with its score.
+type RankedMemory struct {
+	models.Memory
+	Score      float64 // similarity (semantic), or fused score normalised to 0-1 (keyword/hybrid)
+	Similarity float64 // cosine similarity to the query, 0 if the memory has no embedding
+}
+
+// HybridOptions configures the fusion of BM25 and vector ranking.
+type HybridOptions struct {
+	VectorWeight  float64 // weight of the semantic ranking (0 = keyword only)
+	KeywordWeight float64 // weight of the BM25 ranking (0 = semantic only)
+	RRFK          int     // rank offset k, default DefaultRRFK
+}
+
+// migrateFTS creates the FTS5 index over memory content and tags. It is an external-content table
+// kept in sync by triggers, so every write path (including raw saves) updates it.
+func (s *CortexStore) migrateFTS() error {
+	var existing int64
+	if err := s.db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'memories_fts'").Scan(&existing).Error; err != nil {
+		return err
+	}
+	for _, q := range []string{
+		"CREATE VIRTUAL TABLE IF NOT EXISTS memories_fts USING fts5(content, tags, content='memories', content_rowid='id', tokenize='unicode61 remove_diacritics 2')",
+		`CREATE TRIGGER IF NOT EXISTS memories_fts_ai AFTER INSERT ON memories BEGIN
+			INSERT INTO memories_fts(rowid, content, tags) VALUES (new.id, new.content, new.tags);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS memories_fts_ad AFTER DELETE ON memories BEGIN
+			INSERT INTO memories_fts(memories_fts, rowid, content, tags) VALUES ('delete', old.id, old.content, old.tags);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS memories_fts_au AFTER UPDATE OF content, tags ON memories BEGIN
+			INSERT INTO memories_fts(memories_fts, rowid, content, tags) VALUES ('delete', old.id, old.content, old.tags);
+			INSERT INTO memories_fts(rowid, content, tags) VALUES (new.id, new.content, new.tags);
+		END`,
+	} {
+		if err := s.db.Exec(q).Error; err != nil {
+			return err
+		}
+	}
+	if existing == 0 {
+		// Bestehende Memories einmalig indexieren
+		if err := s.db.Exec("INSERT INTO memories_fts(memories_fts) VALUES ('rebuild')").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ftsMatchQuery turns free text into an FTS5 MATCH expression: every word is quoted (no FTS syntax
+// injection) and the words are OR-ed, so BM25 ranks documents matching more terms higher.
+func ftsMatchQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+w+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// SearchMemoriesKeyword ranks memories of a tenant by BM25 over content and tags.
+// Score is the negated bm25() value (higher = better).
+func (s *CortexStore) SearchMemoriesKeyword(appID, externalUserID, query string, bundleID *int64, limit int, seedIDs []int64, metadataFilter map[string]any, includeArchived bool) ([]RankedMemory, error) {
+	match := ftsMatchQuery(query)
+	if match == "" || limit <= 0 {
+		return []RankedMemory{}, nil
+	}
+
+	type ftsRow struct {
+		models.Memory
+		FtsRank float64
+	}
+	var rows []ftsRow
+	dbQuery := s.db.Table("memories").
+		Select("memories.*, bm25(memories_fts, 1.0, 0.5) AS fts_rank").
+		Joins("JOIN memories_fts ON memories_fts.rowid = memories.id").
+		Where("memories_fts MATCH ?", match)
+	dbQuery = s.applyTenantFilter(dbQuery, appID, externalUserID)
+	dbQuery = s.memoryStatusFilter(dbQuery, includeArchived)
+	dbQuery = s.applyOptionalFilters(dbQuery, map[string]interface{}{
+		"bundleID":       bundleID,
+		"seedIDs":        seedIDs,
+		"metadataFilter": metadataFilter,
+	})
+	if err := dbQuery.Order("fts_rank").Limit(limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ranked := make([]RankedMemory, len(rows))
+	for i, row := range rows {
+		// Scan läuft an den Query-Callbacks vorbei
+		if err := openModel(&row.Memory); err != nil {
+			return nil, err
+		}
+		ranked[i] = RankedMemory{Memory: row.Memory, Score: -row.FtsRank}
+	}
+	return ranked, nil
+}
+
+// SearchMemoriesHybrid fuses the BM25 ranking and the semantic ranking with weighted reciprocal rank
+// fusion. The fused score is normalised to 0-1 (1 = rank 1 in every weighted ranking).
+func (s *CortexStore) SearchMemoriesHybrid(appID, externalUserID, query string, bundleID *int64, limit int, seedIDs []int64, metadataFilter map[string]any, includeArchived bool, opts HybridOptions) ([]RankedMemory, error) {
+	if limit <= 0 {
+		return []RankedMemory{}, nil
+	}
+	k := opts.RRFK
+	if k <= 0 {
+		k = DefaultRRFK
+	}
+	candidates := max(limit*hybridCandidateFactor, 20)
+
+	embeddingService := embeddings.GetEmbeddingService()
+	queryModel := embeddings.ModelIDOf(embeddingService)
+	var queryEmbedding []float32
+	if emb, err := embeddingService.GenerateEmbedding(query, "text/plain"); err != nil {
+		slog.Warn("hybrid search: failed to generate query embedding, using keyword ranking only", "error", err)
+	} else {
+		queryEmbedding = emb
+	}
+
+	type fused struct {
+		mem   RankedMemory
+		score float64
+	}
+	byID := make(map[int64]*fused)
+	var order []int64
+	add := func(list []RankedMemory, weight float64) {
+		for rank, r := range list {
+			f, ok := byID[r.ID]
+			if !ok {
+				f = &fused{mem: r}
+				byID[r.ID] = f
+				order = append(order, r.ID)
+			}
+			if r.Similarity != 0 {
+				f.mem.Similarity = r.Similarity
+			}
+			f.score += weight / float64(k+rank+1)
+		}
+	}
+
+	if opts.KeywordWeight > 0 {
+		keyword, err := s.SearchMemoriesKeyword(appID, externalUserID, query, bundleID, candidates, seedIDs, metadataFilter, includeArchived)
+		if err != nil {
+			return nil, err
+		}
+		add(keyword, opts.KeywordWeight)
+	}
+	if opts.VectorWeight > 0 && queryEmbedding != nil {
+		semantic, err := s.rankMemoriesSemantic(appID, externalUserID, queryEmbedding, queryModel, bundleID, candidates, seedIDs, metadataFilter, includeArchived)
+		if err != nil {
+			return nil, err
+		}
+		add(semantic, opts.VectorWeight)
+	}
+
+	maxScore := (opts.VectorWeight + opts.KeywordWeight) / float64(k+1)
+	results := make([]RankedMemory, 0, len(order))
+	for _, id := range order {
+		f := byID[id]
+		r := f.mem
+		r.Score = f.score / maxScore
+		// Keyword-Treffer ohne Vektor-Rang: Similarity trotzdem berechnen, falls Embedding vorhanden
+		if r.Similarity == 0 && queryEmbedding != nil && r.EmbeddingCompatible(queryModel, len(queryEmbedding)) {
+			if vec, err := embeddings.DecodeVector(r.Embedding); err == nil {
+				r.Similarity = embeddings.CosineSimilarity(queryEmbedding, vec)
+			}
+		}
+		results = append(results, r)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return results, nil
+}
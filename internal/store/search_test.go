@@ -0,0 +1,127 @@
+package store
+
+import (
+	"testing"
+
+	"cortex/internal/models"
+)
+
+func TestSearchMemoriesKeywordStaysInSync(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	mem := &models.Memory{Type: "semantic", Content: "User drinks espresso", Tags: "kaffee", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	other := &models.Memory{Type: "semantic", Content: "User drinks espresso too", AppID: "app2", ExternalUserID: "user2", Importance: 5}
+	s.CreateMemory(mem)
+	s.CreateMemory(other)
+
+	results, err := s.SearchMemoriesKeyword("app1", "user1", "espresso", nil, 10, nil, nil, false)
+	if err != nil {
+		t.Fatalf("SearchMemoriesKeyword failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != mem.ID {
+		t.Fatalf("expected only tenant memory %d, got %+v", mem.ID, results)
+	}
+
+	// Tags sind ebenfalls indexiert
+	if results, _ := s.SearchMemoriesKeyword("app1", "user1", "kaffee", nil, 10, nil, nil, false); len(results) != 1 {
+		t.Errorf("expected tag match, got %d results", len(results))
+	}
+
+	mem.Content = "User switched to green tea"
+	if err := s.UpdateMemory(mem, "api"); err != nil {
+		t.Fatalf("UpdateMemory failed: %v", err)
+	}
+	if results, _ := s.SearchMemoriesKeyword("app1", "user1", "espresso", nil, 10, nil, nil, false); len(results) != 0 {
+		t.Errorf("expected no match for old content after update, got %d", len(results))
+	}
+	if results, _ := s.SearchMemoriesKeyword("app1", "user1", "tea", nil, 10, nil, nil, false); len(results) != 1 {
+		t.Errorf("expected match for new content after update, got %d", len(results))
+	}
+
+	if err := s.DeleteMemory(mem); err != nil {
+		t.Fatalf("DeleteMemory failed: %v", err)
+	}
+	if results, _ := s.SearchMemoriesKeyword("app1", "user1", "tea", nil, 10, nil, nil, false); len(results) != 0 {
+		t.Errorf("expected no match after delete, got %d", len(results))
+	}
+}
+
+func TestSearchMemoriesKeywordRanking(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	both := &models.Memory{Type: "semantic", Content: "oat milk latte", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	one := &models.Memory{Type: "semantic", Content: "milk chocolate and cookies for the weekend", AppID: "app1", ExternalUserID: "user1", Importance: 5}
+	s.CreateMemory(one)
+	s.CreateMemory(both)
+
+	results, err := s.SearchMemoriesKeyword("app1", "user1", `oat "milk" (NEAR`, nil, 10, nil, nil, false)
+	if err != nil {
+		t.Fatalf("query with FTS syntax characters failed: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != both.ID {
+		t.Fatalf("expected memory matching both terms first, got %+v", results)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Errorf("expected descending scores, got %f <= %f", results[0].Score, results[1].Score)
+	}
+
+	if results, err := s.SearchMemoriesKeyword("app1", "user1", "?!", nil, 10, nil, nil, false); err != nil || len(results) != 0 {
+		t.Errorf("expected empty result for query without words, got %d (err %v)", len(results), err)
+	}
+}
+
+func TestSearchMemoriesHybrid(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	espresso := createMemoryWithEmbedding(t, s, "User likes coffee and espresso", nil, map[string]any{"typ": "präferenz"})
+	tea := createMemoryWithEmbedding(t, s, "User prefers green tea", nil, map[string]any{"typ": "präferenz"})
+	// Ohne Embedding: nur über BM25 auffindbar
+	keywordOnly := &models.Memory{Type: "semantic", Content: "espresso machine manual", AppID: "app1", ExternalUserID: "user1", Importance: 5, Metadata: `{"typ":"notiz"}`}
+	s.CreateMemory(keywordOnly)
+
+	results, err := s.SearchMemoriesHybrid("app1", "user1", "espresso", nil, 10, nil, nil, false, HybridOptions{VectorWeight: 1, KeywordWeight: 1})
+	if err != nil {
+		t.Fatalf("SearchMemoriesHybrid failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 fused results, got %d", len(results))
+	}
+	if results[0].ID != espresso.ID {
+		t.Errorf("expected memory ranked in both lists first, got %d", results[0].ID)
+	}
+	for i, r := range results {
+		if r.Score <= 0 || r.Score > 1 {
+			t.Errorf("result %d: score %f outside (0,1]", i, r.Score)
+		}
+		if i > 0 && r.Score > results[i-1].Score {
+			t.Errorf("results not sorted by score at %d", i)
+		}
+	}
+
+	// Nur Keyword-Gewicht: tea (kein Keyword-Treffer) darf nicht auftauchen
+	results, err = s.SearchMemoriesHybrid("app1", "user1", "espresso", nil, 10, nil, nil, false, HybridOptions{KeywordWeight: 1})
+	if err != nil {
+		t.Fatalf("SearchMemoriesHybrid failed: %v", err)
+	}
+	if len(results) != 2 || containsRanked(results, tea.ID) {
+		t.Errorf("expected only keyword hits, got %+v", results)
+	}
+
+	// Metadata-Filter gilt für beide Rankings
+	results, _ = s.SearchMemoriesHybrid("app1", "user1", "espresso", nil, 10, nil, map[string]any{"typ": "notiz"}, false, HybridOptions{VectorWeight: 1, KeywordWeight: 1})
+	if len(results) != 1 || results[0].ID != keywordOnly.ID {
+		t.Errorf("expected only filtered memory %d, got %+v", keywordOnly.ID, results)
+	}
+}
+
+func containsRanked(results []RankedMemory, id int64) bool {
+	for _, r := range results {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -1,23 +1,34 @@
 package store
 
 import (
+	"database/sql"
+	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
+	"cortex/internal/crypt"
 	"cortex/internal/embeddings"
 	"cortex/internal/helpers"
 	"cortex/internal/models"
 )
 
 type CortexStore struct {
-	db *gorm.DB
+	db          *gorm.DB
+	pool        *livePool  // connection behind db, replaced by RestoreDatabase
+	restoreMu   sync.Mutex // one restore at a time
+	vectorIndex *vectorIndexRegistry
+	keyring     atomic.Pointer[fieldKeyring] // field encryption, nil = off (see EnableFieldEncryption)
+	signer      atomic.Pointer[crypt.Key]    // row signatures, nil = off (see EnableRowSigning)
 }
 
 // GetDB returns the underlying GORM database connection (for transactions)
@@ -38,49 +49,105 @@ func NewCortexStore(dbPath string) (*CortexStore, error) {
 		return nil, err
 	}
 
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	sqlDB, err := sql.Open(sqlite.DriverName, dbPath)
 	if err != nil {
 		return nil, err
 	}
+	pool := newLivePool(sqlDB)
+	db, err := gorm.Open(&sqlite.Dialector{DSN: dbPath, Conn: pool}, &gorm.Config{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
 
-	store := &CortexStore{db: db}
+	store := &CortexStore{db: db, pool: pool, vectorIndex: newVectorIndexRegistry()}
+	if err := store.registerFieldCallbacks(); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	if err := store.registerIntegrityCallbacks(); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
 	if err := store.migrate(); err != nil {
+		sqlDB.Close()
 		return nil, err
 	}
 	return store, nil
 }
 
 func (s *CortexStore) migrate() error {
-	if err := s.db.AutoMigrate(&models.Memory{}, &models.MemoryVersion{}, &models.Entity{}, &models.Relation{}, &models.Bundle{}, &models.Webhook{}, &models.AgentContext{}); err != nil {
+	// Entity-Namen waren global eindeutig; jetzt eindeutig pro Tenant (idx_entity_tenant_name)
+	if err := s.db.Exec("DROP INDEX IF EXISTS idx_entities_name").Error; err != nil {
+		return err
+	}
+
+	if err := s.db.AutoMigrate(&models.Memory{}, &models.MemoryVersion{}, &models.Entity{}, &models.Relation{}, &models.MemoryEntity{}, &models.Bundle{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.WebhookDeliveryAttempt{}, &models.Event{}, &models.Change{}, &models.AgentContext{}, &models.TenantKey{}, &models.APIKey{}, &models.AuditEvent{}); err != nil {
 		return err
 	}
 
 	// Backfill status for existing memories (pre-TTL schema)
 	s.db.Exec("UPDATE memories SET status = ? WHERE status = '' OR status IS NULL", models.MemoryStatusActive)
 
+	// Content-Hashes für Memories/Versionen aus der Zeit vor content_hash nachtragen
+	if err := s.backfillContentHashes(); err != nil {
+		return err
+	}
+
 	// Composite Indizes für häufigste Queries
 	for _, q := range []string{
 		"CREATE INDEX IF NOT EXISTS idx_memory_tenant ON memories(app_id, external_user_id)",
 		"CREATE INDEX IF NOT EXISTS idx_memory_tenant_bundle ON memories(app_id, external_user_id, bundle_id)",
 		"CREATE INDEX IF NOT EXISTS idx_memory_created_at ON memories(created_at DESC)",
-		"CREATE INDEX IF NOT EXISTS idx_memory_embedding ON memories(embedding) WHERE embedding != '' AND embedding IS NOT NULL",
+		"DROP INDEX IF EXISTS idx_memory_embedding",
+		"CREATE INDEX IF NOT EXISTS idx_memory_has_embedding ON memories(app_id, external_user_id) WHERE length(embedding) > 0",
 		"CREATE INDEX IF NOT EXISTS idx_memory_status ON memories(status)",
 		"CREATE INDEX IF NOT EXISTS idx_memory_expires_at ON memories(expires_at) WHERE expires_at IS NOT NULL",
+		// Verknüpfungen Memory <-> Entity mit Memory bzw. Entity löschen
+		`CREATE TRIGGER IF NOT EXISTS memory_entities_memory_ad AFTER DELETE ON memories BEGIN
+			DELETE FROM memory_entities WHERE memory_id = old.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS memory_entities_entity_ad AFTER DELETE ON entities BEGIN
+			DELETE FROM memory_entities WHERE entity_id = old.id;
+		END`,
+		// Zustellungen (samt Versuchen) mit dem Webhook löschen
+		`CREATE TRIGGER IF NOT EXISTS webhook_deliveries_webhook_ad AFTER DELETE ON webhooks BEGIN
+			DELETE FROM webhook_deliveries WHERE webhook_id = old.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS webhook_delivery_attempts_delivery_ad AFTER DELETE ON webhook_deliveries BEGIN
+			DELETE FROM webhook_delivery_attempts WHERE delivery_id = old.id;
+		END`,
 	} {
 		if err := s.db.Exec(q).Error; err != nil {
 			return err
 		}
 	}
 
+	// FTS5-Volltextindex (BM25) über content und tags
+	if err := s.migrateFTS(); err != nil {
+		return err
+	}
+
+	// Alte JSON-Embeddings (TEXT) ins Binärformat überführen
+	if err := s.migrateLegacyEmbeddings(); err != nil {
+		return err
+	}
+
+	// Dimension/Modell für Embeddings aus der Zeit vor embedding_model/embedding_dim nachtragen
+	if err := s.backfillEmbeddingDimensions(); err != nil {
+		return err
+	}
+
+	// Entities/Relations ohne Tenant (vor Tenant-Scoping) sind über die API nicht sichtbar, bis sie übernommen werden
+	if entities, relations, err := s.CountLegacyGraph(); err == nil && entities+relations > 0 {
+		slog.Warn("entities/relations without tenant found; assign them with POST /admin/graph/claim-legacy",
+			"entities", entities, "relations", relations)
+	}
 	return nil
 }
 
 func (s *CortexStore) Close() error {
-	sqlDB, err := s.db.DB()
-	if err != nil {
-		return err
-	}
-	return sqlDB.Close()
+	return s.pool.current().Close()
 }
 
 // applyTenantFilter applies tenant filter (app_id and external_user_id) to a query
@@ -91,7 +158,7 @@ func (s *CortexStore) applyTenantFilter(dbQuery *gorm.DB, appID, externalUserID
 // applyOptionalFilters applies optional filters to a query based on a filter map
 func (s *CortexStore) applyOptionalFilters(dbQuery *gorm.DB, filters map[string]interface{}) *gorm.DB {
 	if query, ok := filters["query"].(string); ok && query != "" {
-		dbQuery = dbQuery.Where("content LIKE ?", "%"+query+"%")
+		dbQuery = dbQuery.Where(s.plainColumn("content", "memories.content")+" LIKE ?", "%"+query+"%")
 	}
 	if memType, ok := filters["memType"].(string); ok && memType != "" {
 		dbQuery = dbQuery.Where("type = ?", memType)
@@ -110,6 +177,7 @@ func (s *CortexStore) applyOptionalFilters(dbQuery *gorm.DB, filters map[string]
 	}
 	// Metadata filter: filter by JSON fields in metadata column using SQLite JSON1 extension
 	if metadataFilter, ok := filters["metadataFilter"].(map[string]any); ok && len(metadataFilter) > 0 {
+		metadata := s.plainColumn("metadata", "memories.metadata")
 		for key, value := range metadataFilter {
 			if !helpers.SafeJSONPathKey(key) {
 				continue
@@ -117,9 +185,9 @@ func (s *CortexStore) applyOptionalFilters(dbQuery *gorm.DB, filters map[string]
 			// Use json_extract to query JSON fields in SQLite
 			// Handle both string and other types
 			if strValue, isString := value.(string); isString {
-				dbQuery = dbQuery.Where("json_extract(metadata, ?) = ?", "$."+key, strValue)
+				dbQuery = dbQuery.Where("json_extract("+metadata+", ?) = ?", "$."+key, strValue)
 			} else {
-				dbQuery = dbQuery.Where("json_extract(metadata, ?) = ?", "$."+key, value)
+				dbQuery = dbQuery.Where("json_extract("+metadata+", ?) = ?", "$."+key, value)
 			}
 		}
 	}
@@ -140,7 +208,17 @@ func (s *CortexStore) CreateMemory(mem *models.Memory) error {
 	if mem.Status == "" {
 		mem.Status = models.MemoryStatusActive
 	}
-	return s.db.Create(mem).Error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(mem).Error; err != nil {
+			return err
+		}
+		return logMemoryChange(tx, models.ChangeOpCreate, mem)
+	})
+	if err != nil {
+		return err
+	}
+	s.indexMemory(mem)
+	return nil
 }
 
 func (s *CortexStore) SearchMemories(query, memType string, limit int) ([]models.Memory, error) {
@@ -187,7 +265,7 @@ func (s *CortexStore) ListMemoriesByTenant(appID, externalUserID string, limit,
 	}
 	// Clear embedding for list response (not needed, reduces payload)
 	for i := range memories {
-		memories[i].Embedding = ""
+		memories[i].Embedding = nil
 	}
 	return memories, nil
 }
@@ -224,6 +302,31 @@ func (s *CortexStore) SearchMemoriesByTenantSemanticAndBundle(appID, externalUse
 		return s.SearchMemoriesByTenantAndBundle(appID, externalUserID, query, bundleID, limit, seedIDs, metadataFilter, includeArchived)
 	}
 
+	ranked, err := s.rankMemoriesSemantic(appID, externalUserID, queryEmbedding, embeddings.ModelIDOf(embeddingService), bundleID, limit, seedIDs, metadataFilter, includeArchived)
+	if err != nil {
+		return nil, err
+	}
+	memories := make([]models.Memory, len(ranked))
+	for i := range ranked {
+		memories[i] = ranked[i].Memory
+	}
+	return memories, nil
+}
+
+// rankMemoriesSemantic liefert die Top-limit Memories nach Cosine-Similarity zum Query-Embedding.
+// Vektoren eines anderen Modells oder mit anderer Dimension werden übersprungen.
+func (s *CortexStore) rankMemoriesSemantic(appID, externalUserID string, queryEmbedding []float32, queryModel string, bundleID *int64, limit int, seedIDs []int64, metadataFilter map[string]any, includeArchived bool) ([]RankedMemory, error) {
+	// ANN-Index für Top-k (nicht bei seedIDs: dort ist der exakte Scan über wenige IDs günstiger)
+	if len(seedIDs) == 0 {
+		ranked, ok, err := s.searchMemoriesANN(appID, externalUserID, queryEmbedding, queryModel, bundleID, limit, metadataFilter, includeArchived)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return ranked, nil
+		}
+	}
+
 	// Hole alle Memories für diesen Tenant (und optional Bundle, optional seedIDs, optional metadataFilter)
 	var allMemories []models.Memory
 	dbQuery := s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID)
@@ -241,21 +344,15 @@ func (s *CortexStore) SearchMemoriesByTenantSemanticAndBundle(appID, externalUse
 		}
 		dbQuery = s.applyOptionalFilters(dbQuery, filters)
 	}
-	err = dbQuery.Find(&allMemories).Error
-	if err != nil {
+	if err := dbQuery.Find(&allMemories).Error; err != nil {
 		return nil, err
 	}
 
 	// Berechne Similarity für jedes Memory
-	type memoryWithSimilarity struct {
-		memory     models.Memory
-		similarity float64
-	}
-
-	results := make([]memoryWithSimilarity, 0, len(allMemories))
+	results := make([]RankedMemory, 0, len(allMemories))
 	for _, mem := range allMemories {
-		if mem.Embedding == "" {
-			// Skip Memories ohne Embedding (können später generiert werden)
+		if !mem.EmbeddingCompatible(queryModel, len(queryEmbedding)) {
+			// Skip Memories ohne (passendes) Embedding (können später generiert bzw. per Re-Embed migriert werden)
 			continue
 		}
 
@@ -265,28 +362,19 @@ func (s *CortexStore) SearchMemoriesByTenantSemanticAndBundle(appID, externalUse
 		}
 
 		similarity := embeddings.CosineSimilarity(queryEmbedding, memEmbedding)
-		results = append(results, memoryWithSimilarity{
-			memory:     mem,
-			similarity: similarity,
-		})
+		results = append(results, RankedMemory{Memory: mem, Score: similarity, Similarity: similarity})
 	}
 
 	// Sortiere nach Similarity (höchste zuerst)
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].similarity > results[j].similarity
+		return results[i].Similarity > results[j].Similarity
 	})
 
 	// Limitiere Ergebnisse
-	if limit > len(results) {
-		limit = len(results)
-	}
-
-	memories := make([]models.Memory, limit)
-	for i := 0; i < limit; i++ {
-		memories[i] = results[i].memory
+	if limit < len(results) {
+		results = results[:limit]
 	}
-
-	return memories, nil
+	return results, nil
 }
 
 // GenerateEmbeddingForMemory generiert ein Embedding für ein Memory
@@ -303,16 +391,17 @@ func (s *CortexStore) GenerateEmbeddingForMemory(mem *models.Memory) error {
 		return err
 	}
 
-	// Speichere Embedding
-	embeddingJSON, err := embeddings.EncodeVector(embedding)
-	if err != nil {
+	// Speichere Embedding (mit Modell-ID und Dimension)
+	if err := setMemoryEmbedding(mem, embedding, embeddings.ModelIDOf(embeddingService)); err != nil {
 		return err
 	}
-
-	mem.Embedding = embeddingJSON
 	mem.ContentType = contentType
 
-	return s.db.Save(mem).Error
+	if err := s.db.Save(mem).Error; err != nil {
+		return err
+	}
+	s.indexMemory(mem)
+	return nil
 }
 
 // BatchGenerateEmbeddings generiert Embeddings für alle Memories ohne Embedding
@@ -322,7 +411,7 @@ func (s *CortexStore) BatchGenerateEmbeddings(batchSize int) error {
 	}
 
 	var memories []models.Memory
-	err := s.db.Where("embedding = '' OR embedding IS NULL").
+	err := s.db.Where("embedding IS NULL OR length(embedding) = 0").
 		Limit(batchSize).
 		Find(&memories).Error
 	if err != nil {
@@ -352,21 +441,42 @@ func (s *CortexStore) GetMemoryByIDAndTenant(id int64, appID, externalUserID str
 }
 
 func (s *CortexStore) DeleteMemory(mem *models.Memory) error {
-	return s.db.Delete(mem).Error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(mem).Error; err != nil {
+			return err
+		}
+		return logMemoryChange(tx, models.ChangeOpDelete, mem)
+	})
+	if err != nil {
+		return err
+	}
+	s.unindexMemory(mem.ID)
+	return nil
 }
 
 // UpdateMemory updates a memory (tenant must match). Before update, a snapshot is written to memory_versions.
 // changedBy can be "api", "merge", "import", etc.
 func (s *CortexStore) UpdateMemory(mem *models.Memory, changedBy string) error {
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return s.updateMemory(tx, mem, changedBy)
+	}); err != nil {
+		return err
+	}
+	s.indexMemory(mem)
+	return nil
+}
+
+// updateMemory is UpdateMemory within tx (without updating the vector index).
+func (s *CortexStore) updateMemory(tx *gorm.DB, mem *models.Memory, changedBy string) error {
 	var existing models.Memory
-	err := s.applyTenantFilter(s.db.Model(&models.Memory{}), mem.AppID, mem.ExternalUserID).
+	err := s.applyTenantFilter(tx.Model(&models.Memory{}), mem.AppID, mem.ExternalUserID).
 		Where("id = ?", mem.ID).First(&existing).Error
 	if err != nil {
 		return err
 	}
 	// Next version number
 	var maxVersion int
-	s.db.Model(&models.MemoryVersion{}).Where("memory_id = ?", mem.ID).Select("COALESCE(MAX(version), 0)").Scan(&maxVersion)
+	tx.Model(&models.MemoryVersion{}).Where("memory_id = ?", mem.ID).Select("COALESCE(MAX(version), 0)").Scan(&maxVersion)
 	nextVersion := maxVersion + 1
 	// Snapshot current state into memory_versions
 	ver := models.MemoryVersion{
@@ -380,12 +490,15 @@ func (s *CortexStore) UpdateMemory(mem *models.Memory, changedBy string) error {
 		Type:      existing.Type,
 		ChangedBy: changedBy,
 	}
-	if err := s.db.Create(&ver).Error; err != nil {
+	if err := tx.Create(&ver).Error; err != nil {
 		return err
 	}
 	now := time.Now()
 	mem.UpdatedAt = &now
-	return s.db.Save(mem).Error
+	if err := tx.Save(mem).Error; err != nil {
+		return err
+	}
+	return logMemoryChange(tx, models.ChangeOpUpdate, mem)
 }
 
 // ListMemoryVersions returns version history for a memory (tenant-scoped).
@@ -404,20 +517,96 @@ func (s *CortexStore) ListMemoryVersions(memoryID int64, appID, externalUserID s
 // ArchiveMemoriesByExpiry sets status to archived for all active memories where expires_at <= until.
 // Returns the number of rows updated.
 func (s *CortexStore) ArchiveMemoriesByExpiry(until time.Time) (int64, error) {
-	res := s.db.Model(&models.Memory{}).
-		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.MemoryStatusActive, until).
-		Update("status", models.MemoryStatusArchived)
-	return res.RowsAffected, res.Error
+	archived, err := s.ArchiveExpiredMemories(until)
+	return int64(len(archived)), err
+}
+
+// ArchiveExpiredMemories archives all active memories where expires_at <= until and returns them
+// (with the new status, without embedding).
+func (s *CortexStore) ArchiveExpiredMemories(until time.Time) ([]models.Memory, error) {
+	return s.archiveMemoriesWhere("expires_at IS NOT NULL AND expires_at <= ?", until)
+}
+
+// ArchiveLowImportanceMemories archives all active memories with importance below threshold and returns them.
+func (s *CortexStore) ArchiveLowImportanceMemories(threshold int) ([]models.Memory, error) {
+	return s.archiveMemoriesWhere("importance < ?", threshold)
+}
+
+// archiveMemoriesWhere archives the active memories matching the condition in one transaction.
+func (s *CortexStore) archiveMemoriesWhere(query string, args ...any) ([]models.Memory, error) {
+	var archived []models.Memory
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Memory{}).Omit("embedding").
+			Where("status = ?", models.MemoryStatusActive).Where(query, args...).
+			Order("id").Find(&archived).Error; err != nil {
+			return err
+		}
+		if len(archived) == 0 {
+			return nil
+		}
+		ids := make([]int64, len(archived))
+		for i := range archived {
+			ids[i] = archived[i].ID
+			archived[i].Status = models.MemoryStatusArchived
+		}
+		if err := tx.Model(&models.Memory{}).Where("id IN ?", ids).Update("status", models.MemoryStatusArchived).Error; err != nil {
+			return err
+		}
+		for i := range archived {
+			if err := logMemoryChange(tx, models.ChangeOpUpdate, &archived[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return archived, nil
 }
 
 // DeleteArchivedOlderThan permanently deletes archived memories whose updated_at (or created_at) is before cutoff.
 // Uses created_at when updated_at is NULL. Returns the number of rows deleted.
 func (s *CortexStore) DeleteArchivedOlderThan(cutoff time.Time) (int64, error) {
-	// SQLite: delete where status=archived and (updated_at < cutoff or (updated_at is null and created_at < cutoff))
-	res := s.db.Where("status = ?", models.MemoryStatusArchived).
-		Where("(updated_at IS NOT NULL AND updated_at < ?) OR (updated_at IS NULL AND created_at < ?)", cutoff, cutoff).
-		Delete(&models.Memory{})
-	return res.RowsAffected, res.Error
+	deleted, err := s.DeleteArchivedMemoriesOlderThan(cutoff)
+	return int64(len(deleted)), err
+}
+
+// DeleteArchivedMemoriesOlderThan is DeleteArchivedOlderThan returning the deleted memories (without embedding).
+func (s *CortexStore) DeleteArchivedMemoriesOlderThan(cutoff time.Time) ([]models.Memory, error) {
+	var deleted []models.Memory
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// SQLite: delete where status=archived and (updated_at < cutoff or (updated_at is null and created_at < cutoff))
+		if err := tx.Model(&models.Memory{}).Omit("embedding").
+			Where("status = ?", models.MemoryStatusArchived).
+			Where("(updated_at IS NOT NULL AND updated_at < ?) OR (updated_at IS NULL AND created_at < ?)", cutoff, cutoff).
+			Order("id").Find(&deleted).Error; err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+		ids := make([]int64, len(deleted))
+		for i := range deleted {
+			ids[i] = deleted[i].ID
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&models.Memory{}).Error; err != nil {
+			return err
+		}
+		for i := range deleted {
+			if err := logMemoryChange(tx, models.ChangeOpDelete, &deleted[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(deleted) > 0 {
+		s.invalidateVectorIndex()
+	}
+	return deleted, nil
 }
 
 // FindSimilarMemoryPairs returns pairs of memory IDs (keepID, mergeID) that have similarity >= minSimilarity.
@@ -425,7 +614,7 @@ func (s *CortexStore) DeleteArchivedOlderThan(cutoff time.Time) (int64, error) {
 func (s *CortexStore) FindSimilarMemoryPairs(appID, externalUserID string, bundleID *int64, minSimilarity float64, limit int) ([][2]int64, error) {
 	var memories []models.Memory
 	dbQuery := s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID).
-		Where("status = ? AND embedding != '' AND embedding IS NOT NULL", models.MemoryStatusActive)
+		Where("status = ? AND length(embedding) > 0", models.MemoryStatusActive)
 	if bundleID != nil {
 		dbQuery = dbQuery.Where("bundle_id = ?", *bundleID)
 	}
@@ -440,6 +629,10 @@ func (s *CortexStore) FindSimilarMemoryPairs(appID, externalUserID string, bundl
 			if limit > 0 && len(result) >= limit {
 				return result, nil
 			}
+			// Nur Vektoren desselben Modells sind vergleichbar
+			if !memories[j].EmbeddingCompatible(memories[i].EmbeddingModel, memories[i].EmbeddingDim) {
+				continue
+			}
 			embA, _ := embeddings.DecodeVector(memories[i].Embedding)
 			embB, _ := embeddings.DecodeVector(memories[j].Embedding)
 			if embA == nil || embB == nil {
@@ -516,13 +709,6 @@ func (s *CortexStore) MergeMemories(keepID, mergeID int64, appID, externalUserID
 	if merge.Importance > keep.Importance {
 		keep.Importance = merge.Importance
 	}
-	if err := s.UpdateMemory(keep, "merge"); err != nil {
-		return err
-	}
-	if err := s.GenerateEmbeddingForMemory(keep); err != nil {
-		// non-fatal
-		_ = err
-	}
 	// Archive merged memory and set merged_into in metadata
 	mergeMeta := helpers.UnmarshalMetadata(merge.Metadata)
 	mergeMeta["merged_into"] = float64(keepID) // JSON numbers are float64
@@ -530,42 +716,118 @@ func (s *CortexStore) MergeMemories(keepID, mergeID int64, appID, externalUserID
 	merge.Status = models.MemoryStatusArchived
 	now := time.Now()
 	merge.UpdatedAt = &now
-	return s.db.Save(merge).Error
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.updateMemory(tx, keep, "merge"); err != nil {
+			return err
+		}
+		if err := tx.Save(merge).Error; err != nil {
+			return err
+		}
+		if err := logMemoryChange(tx, models.ChangeOpUpdate, merge); err != nil {
+			return err
+		}
+		// Entity-Verknüpfungen des zusammengeführten Memories übernehmen
+		res := tx.Exec(
+			"INSERT OR IGNORE INTO memory_entities (memory_id, entity_id, created_at) SELECT ?, entity_id, created_at FROM memory_entities WHERE memory_id = ?",
+			keepID, mergeID,
+		)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return logMemoryEntitiesChange(tx, keep)
+	})
+	if err != nil {
+		return err
+	}
+	s.indexMemory(merge)
+	if err := s.GenerateEmbeddingForMemory(keep); err != nil {
+		// non-fatal; keep stays indexed with its previous embedding
+		s.indexMemory(keep)
+	}
+	return nil
 }
 
 // Entity Operations
 
-func (s *CortexStore) GetEntity(name string) (*models.Entity, error) {
+func (s *CortexStore) GetEntity(appID, externalUserID, name string) (*models.Entity, error) {
 	var ent models.Entity
-	err := s.db.Where("name = ?", name).First(&ent).Error
+	err := s.applyTenantFilter(s.db, appID, externalUserID).Where("name = ?", name).First(&ent).Error
 	if err != nil {
 		return nil, err
 	}
 	return &ent, nil
 }
 
-func (s *CortexStore) ListEntities() ([]models.Entity, error) {
+func (s *CortexStore) ListEntities(appID, externalUserID string) ([]models.Entity, error) {
 	var entities []models.Entity
-	err := s.db.Order("updated_at DESC").Find(&entities).Error
+	err := s.applyTenantFilter(s.db, appID, externalUserID).Order("updated_at DESC").Find(&entities).Error
 	return entities, err
 }
 
+// CreateOrUpdateEntity upserts an entity by (tenant, name); ent.AppID/ExternalUserID select the tenant.
 func (s *CortexStore) CreateOrUpdateEntity(ent *models.Entity) error {
 	ent.UpdatedAt = time.Now()
-	
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return s.upsertEntity(tx, ent)
+	})
+}
+
+// SetEntityFact sets one fact (key/value in the entity data) of the named entity in the tenant,
+// creating the entity if needed. Returns the stored entity.
+func (s *CortexStore) SetEntityFact(appID, externalUserID, name, key string, value any) (*models.Entity, error) {
+	ent := models.Entity{AppID: appID, ExternalUserID: externalUserID, Name: name}
+	// Transaction prevents lost updates when facts of the same entity are set concurrently
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Entity
+		err := s.applyTenantFilter(tx, appID, externalUserID).Where("name = ?", name).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		data := helpers.UnmarshalEntityData(existing.Data)
+		data[key] = value
+		ent.Data = helpers.MarshalEntityData(data)
+		ent.UpdatedAt = time.Now()
+		return s.upsertEntity(tx, &ent)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ent, nil
+}
+
+// upsertEntity inserts or updates ent by (tenant, name) within tx and logs the change; ent is reloaded
+// with the stored row.
+func (s *CortexStore) upsertEntity(tx *gorm.DB, ent *models.Entity) error {
+	var existing int64
+	if err := s.applyTenantFilter(tx.Model(&models.Entity{}), ent.AppID, ent.ExternalUserID).
+		Where("name = ?", ent.Name).Count(&existing).Error; err != nil {
+		return err
+	}
 	// Use ON CONFLICT to handle race conditions atomically
 	// This ensures that concurrent requests don't cause UNIQUE constraint errors
-	return s.db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "name"}},
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "app_id"}, {Name: "external_user_id"}, {Name: "name"}},
 		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
-	}).Create(ent).Error
+	}).Create(ent).Error; err != nil {
+		return err
+	}
+	if err := s.applyTenantFilter(tx, ent.AppID, ent.ExternalUserID).Where("name = ?", ent.Name).First(ent).Error; err != nil {
+		return err
+	}
+	op := models.ChangeOpCreate
+	if existing > 0 {
+		op = models.ChangeOpUpdate
+	}
+	return logChange(tx, models.ChangeKindEntity, op, ent.ID, ent.AppID, ent.ExternalUserID, ent)
 }
 
 // Relation Operations
 
-func (s *CortexStore) GetRelations(entity string) ([]models.Relation, error) {
+func (s *CortexStore) GetRelations(appID, externalUserID, entity string) ([]models.Relation, error) {
 	var relations []models.Relation
-	dbQuery := s.db.Model(&models.Relation{})
+	dbQuery := s.applyTenantFilter(s.db.Model(&models.Relation{}), appID, externalUserID)
 
 	filters := map[string]interface{}{
 		"entity": entity,
@@ -576,20 +838,132 @@ func (s *CortexStore) GetRelations(entity string) ([]models.Relation, error) {
 	return relations, err
 }
 
+// GetRelationsAt returns the relations of a tenant (touching entity, if given) that are valid at t.
+func (s *CortexStore) GetRelationsAt(appID, externalUserID, entity string, t time.Time) ([]models.Relation, error) {
+	relations, err := s.GetRelations(appID, externalUserID, entity)
+	if err != nil {
+		return nil, err
+	}
+	valid := relations[:0]
+	for _, rel := range relations {
+		if rel.ValidAt(t) {
+			valid = append(valid, rel)
+		}
+	}
+	return valid, nil
+}
+
+// CreateOrUpdateRelation records a relation in rel's tenant. If a relation with the same from/to/type
+// overlaps the validity of rel, that interval is extended (earlier ValidFrom, new ValidTo); otherwise a new
+// interval is created, so closed intervals stay as history. rel.ID is set to the stored row.
 func (s *CortexStore) CreateOrUpdateRelation(rel *models.Relation) error {
-	var existing models.Relation
-	result := s.db.Where("from_entity = ? AND to_entity = ? AND type = ?", rel.From, rel.To, rel.Type).First(&existing)
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return s.upsertRelation(tx, rel)
+	})
+}
+
+// SupersedeRelation closes all relations of rel's tenant with the same from and type but another target
+// that are still valid when rel starts (default: now), then records rel, e.g. "works_at" after a job change.
+// Returns the number of closed relations.
+func (s *CortexStore) SupersedeRelation(rel *models.Relation) (int, error) {
+	if rel.ValidFrom == nil {
+		now := time.Now().UTC()
+		rel.ValidFrom = &now
+	}
+	closed := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var others []models.Relation
+		if err := s.applyTenantFilter(tx, rel.AppID, rel.ExternalUserID).
+			Where("from_entity = ? AND type = ? AND to_entity != ?", rel.From, rel.Type, rel.To).
+			Find(&others).Error; err != nil {
+			return err
+		}
+		for _, other := range others {
+			if !other.ValidAt(*rel.ValidFrom) || (other.ValidFrom != nil && !other.ValidFrom.Before(*rel.ValidFrom)) {
+				continue
+			}
+			if err := tx.Model(&models.Relation{}).Where("id = ?", other.ID).Update("valid_to", *rel.ValidFrom).Error; err != nil {
+				return err
+			}
+			other.ValidTo = rel.ValidFrom
+			if err := logChange(tx, models.ChangeKindRelation, models.ChangeOpUpdate, other.ID, other.AppID, other.ExternalUserID, &other); err != nil {
+				return err
+			}
+			closed++
+		}
+		return s.upsertRelation(tx, rel)
+	})
+	return closed, err
+}
 
-	if result.Error == gorm.ErrRecordNotFound {
-		return s.db.Create(rel).Error
-	} else if result.Error != nil {
-		return result.Error
+func (s *CortexStore) upsertRelation(tx *gorm.DB, rel *models.Relation) error {
+	var existing []models.Relation
+	if err := s.applyTenantFilter(tx, rel.AppID, rel.ExternalUserID).
+		Where("from_entity = ? AND to_entity = ? AND type = ?", rel.From, rel.To, rel.Type).
+		Order("id").Find(&existing).Error; err != nil {
+		return err
 	}
 
-	existing.From = rel.From
-	existing.To = rel.To
-	existing.Type = rel.Type
-	return s.db.Save(&existing).Error
+	// Ohne ValidFrom zählt für die Überlappung "ab jetzt", damit ein geschlossenes Intervall nicht wieder geöffnet wird
+	from := rel.ValidFrom
+	if from == nil && len(existing) > 0 {
+		now := time.Now().UTC()
+		from = &now
+	}
+	for i := range existing {
+		cur := &existing[i]
+		if !cur.Overlaps(from, rel.ValidTo) {
+			continue
+		}
+		if rel.ValidFrom != nil && cur.ValidFrom != nil && rel.ValidFrom.Before(*cur.ValidFrom) {
+			cur.ValidFrom = rel.ValidFrom
+		}
+		if rel.ValidTo != nil {
+			cur.ValidTo = rel.ValidTo
+		}
+		if err := tx.Save(cur).Error; err != nil {
+			return err
+		}
+		*rel = *cur
+		return logChange(tx, models.ChangeKindRelation, models.ChangeOpUpdate, rel.ID, rel.AppID, rel.ExternalUserID, rel)
+	}
+
+	// Neues Intervall; gibt es schon Historie, beginnt es frühestens jetzt
+	rel.ID = 0
+	rel.ValidFrom = from
+	if err := tx.Create(rel).Error; err != nil {
+		return err
+	}
+	return logChange(tx, models.ChangeKindRelation, models.ChangeOpCreate, rel.ID, rel.AppID, rel.ExternalUserID, rel)
+}
+
+// CloseRelation ends the open validity interval of a relation at the given time.
+// Returns gorm.ErrRecordNotFound if no interval is valid at that time.
+func (s *CortexStore) CloseRelation(appID, externalUserID, from, to, relType string, at time.Time) (*models.Relation, error) {
+	var candidates []models.Relation
+	if err := s.applyTenantFilter(s.db, appID, externalUserID).
+		Where("from_entity = ? AND to_entity = ? AND type = ?", from, to, relType).
+		Order("id DESC").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		rel := &candidates[i]
+		if !rel.ValidAt(at) || (rel.ValidFrom != nil && !rel.ValidFrom.Before(at)) {
+			continue
+		}
+		rel.ValidTo = &at
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Relation{}).Where("id = ?", rel.ID).Update("valid_to", at).Error; err != nil {
+				return err
+			}
+			return logChange(tx, models.ChangeKindRelation, models.ChangeOpUpdate, rel.ID, rel.AppID, rel.ExternalUserID, rel)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return rel, nil
+	}
+	return nil, gorm.ErrRecordNotFound
 }
 
 // Stats
@@ -623,7 +997,12 @@ func (s *CortexStore) GetStats() (*models.Stats, error) {
 // Bundle Operations
 
 func (s *CortexStore) CreateBundle(bundle *models.Bundle) error {
-	return s.db.Create(bundle).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(bundle).Error; err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindBundle, models.ChangeOpCreate, bundle.ID, bundle.AppID, bundle.ExternalUserID, bundle)
+	})
 }
 
 func (s *CortexStore) GetBundle(id int64, appID, externalUserID string) (*models.Bundle, error) {
@@ -646,23 +1025,59 @@ func (s *CortexStore) ListBundles(appID, externalUserID string) ([]models.Bundle
 }
 
 func (s *CortexStore) DeleteBundle(id int64, appID, externalUserID string) error {
-	// Setze bundle_id auf NULL für alle Memories in diesem Bundle
-	if err := s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID).
-		Where("bundle_id = ?", id).
-		Update("bundle_id", nil).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Setze bundle_id auf NULL für alle Memories in diesem Bundle
+		var moved []models.Memory
+		if err := s.applyTenantFilter(tx.Model(&models.Memory{}), appID, externalUserID).Omit("embedding").
+			Where("bundle_id = ?", id).Order("id").Find(&moved).Error; err != nil {
+			return err
+		}
+		if err := s.applyTenantFilter(tx.Model(&models.Memory{}), appID, externalUserID).
+			Where("bundle_id = ?", id).
+			Update("bundle_id", nil).Error; err != nil {
+			return err
+		}
+		for i := range moved {
+			moved[i].BundleID = nil
+			if err := logMemoryChange(tx, models.ChangeOpUpdate, &moved[i]); err != nil {
+				return err
+			}
+		}
+
+		// Lösche das Bundle
+		var bundles []models.Bundle
+		if err := s.applyTenantFilter(tx.Model(&models.Bundle{}), appID, externalUserID).
+			Where("id = ?", id).Find(&bundles).Error; err != nil {
+			return err
+		}
+		for i := range bundles {
+			if err := tx.Delete(&bundles[i]).Error; err != nil {
+				return err
+			}
+			if err := logChange(tx, models.ChangeKindBundle, models.ChangeOpDelete, bundles[i].ID, appID, externalUserID, &bundles[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	// Lösche das Bundle
-	return s.applyTenantFilter(s.db.Model(&models.Bundle{}), appID, externalUserID).
-		Where("id = ?", id).
-		Delete(&models.Bundle{}).Error
+	// Memories wandern in den Index ohne Bundle → Tenant-Index neu aufbauen lassen
+	s.invalidateVectorIndex()
+	return nil
 }
 
 // Webhook Operations
 
 func (s *CortexStore) CreateWebhook(webhook *models.Webhook) error {
-	return s.db.Create(webhook).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(webhook).Error; err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindWebhook, models.ChangeOpCreate, webhook.ID, webhook.AppID, "", webhook)
+	})
 }
 
 func (s *CortexStore) GetWebhook(id int64) (*models.Webhook, error) {
@@ -689,11 +1104,25 @@ func (s *CortexStore) ListWebhooks(appID string) ([]models.Webhook, error) {
 
 func (s *CortexStore) UpdateWebhook(webhook *models.Webhook) error {
 	webhook.UpdatedAt = time.Now()
-	return s.db.Save(webhook).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(webhook).Error; err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindWebhook, models.ChangeOpUpdate, webhook.ID, webhook.AppID, "", webhook)
+	})
 }
 
 func (s *CortexStore) DeleteWebhook(id int64) error {
-	return s.db.Delete(&models.Webhook{}, id).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var webhook models.Webhook
+		if err := tx.Where("id = ?", id).Limit(1).Find(&webhook).Error; err != nil || webhook.ID == 0 {
+			return err
+		}
+		if err := tx.Delete(&webhook).Error; err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindWebhook, models.ChangeOpDelete, webhook.ID, webhook.AppID, "", &webhook)
+	})
 }
 
 // GetWebhookByIDAndApp returns a webhook only if it belongs to the given app (tenant isolation).
@@ -709,7 +1138,12 @@ func (s *CortexStore) GetWebhookByIDAndApp(id int64, appID string) (*models.Webh
 // Agent Contexts (Neutron-compatible)
 
 func (s *CortexStore) CreateAgentContext(ctx *models.AgentContext) error {
-	return s.db.Create(ctx).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(ctx).Error; err != nil {
+			return err
+		}
+		return logChange(tx, models.ChangeKindAgentContext, models.ChangeOpCreate, ctx.ID, ctx.AppID, ctx.ExternalUserID, ctx)
+	})
 }
 
 func (s *CortexStore) ListAgentContexts(appID, externalUserID, agentID, memoryType, tagsFilter string) ([]models.AgentContext, error) {
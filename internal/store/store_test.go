@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"gorm.io/gorm"
+
 	"cortex/internal/embeddings"
 	"cortex/internal/helpers"
 	"cortex/internal/models"
@@ -377,8 +379,10 @@ func TestEntityOperations(t *testing.T) {
 
 	// Test CreateOrUpdateEntity
 	ent := &models.Entity{
-		Name: "user:test",
-		Data: `{"key1":"value1"}`,
+		AppID:          "app1",
+		ExternalUserID: "user1",
+		Name:           "user:test",
+		Data:           `{"key1":"value1"}`,
 	}
 
 	err := store.CreateOrUpdateEntity(ent)
@@ -391,7 +395,7 @@ func TestEntityOperations(t *testing.T) {
 	}
 
 	// Test GetEntity
-	retrieved, err := store.GetEntity("user:test")
+	retrieved, err := store.GetEntity("app1", "user1", "user:test")
 	if err != nil {
 		t.Fatalf("GetEntity failed: %v", err)
 	}
@@ -407,13 +411,13 @@ func TestEntityOperations(t *testing.T) {
 		t.Fatalf("UpdateEntity failed: %v", err)
 	}
 
-	retrieved, _ = store.GetEntity("user:test")
+	retrieved, _ = store.GetEntity("app1", "user1", "user:test")
 	if retrieved.Data != ent.Data {
 		t.Errorf("entity not updated correctly")
 	}
 
 	// Test ListEntities
-	entities, err := store.ListEntities()
+	entities, err := store.ListEntities("app1", "user1")
 	if err != nil {
 		t.Fatalf("ListEntities failed: %v", err)
 	}
@@ -428,9 +432,11 @@ func TestRelationOperations(t *testing.T) {
 	defer store.Close()
 
 	rel := &models.Relation{
-		From: "user:alice",
-		To:   "user:bob",
-		Type: "friend",
+		AppID:          "app1",
+		ExternalUserID: "user1",
+		From:           "user:alice",
+		To:             "user:bob",
+		Type:           "friend",
 	}
 
 	err := store.CreateOrUpdateRelation(rel)
@@ -443,7 +449,7 @@ func TestRelationOperations(t *testing.T) {
 	}
 
 	// Test GetRelations
-	relations, err := store.GetRelations("user:alice")
+	relations, err := store.GetRelations("app1", "user1", "user:alice")
 	if err != nil {
 		t.Fatalf("GetRelations failed: %v", err)
 	}
@@ -457,6 +463,127 @@ func TestRelationOperations(t *testing.T) {
 	}
 }
 
+func TestRelationValidityHistory(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jul := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	newRel := func(to string, from *time.Time) *models.Relation {
+		return &models.Relation{AppID: "app1", ExternalUserID: "user1", From: "alice", To: to, Type: "works_at", ValidFrom: from}
+	}
+
+	if err := store.CreateOrUpdateRelation(newRel("acme", &jan)); err != nil {
+		t.Fatalf("CreateOrUpdateRelation failed: %v", err)
+	}
+	// Gleiche Relation erneut: verlängert das offene Intervall statt ein neues anzulegen
+	if err := store.CreateOrUpdateRelation(newRel("acme", nil)); err != nil {
+		t.Fatalf("CreateOrUpdateRelation (repeat) failed: %v", err)
+	}
+	if all, _ := store.GetRelations("app1", "user1", "alice"); len(all) != 1 {
+		t.Fatalf("expected repeat to reuse the open interval, got %d rows", len(all))
+	}
+
+	// Jobwechsel
+	closed, err := store.SupersedeRelation(newRel("globex", &jul))
+	if err != nil {
+		t.Fatalf("SupersedeRelation failed: %v", err)
+	}
+	if closed != 1 {
+		t.Errorf("expected 1 closed relation, got %d", closed)
+	}
+
+	at := func(ts time.Time) []string {
+		rels, err := store.GetRelationsAt("app1", "user1", "alice", ts)
+		if err != nil {
+			t.Fatalf("GetRelationsAt failed: %v", err)
+		}
+		var to []string
+		for _, r := range rels {
+			to = append(to, r.To)
+		}
+		return to
+	}
+	if got := at(jan.AddDate(0, 3, 0)); len(got) != 1 || got[0] != "acme" {
+		t.Errorf("expected acme in April, got %v", got)
+	}
+	if got := at(jul.AddDate(0, 1, 0)); len(got) != 1 || got[0] != "globex" {
+		t.Errorf("expected globex in August, got %v", got)
+	}
+	if got := at(jan.AddDate(-1, 0, 0)); len(got) != 0 {
+		t.Errorf("expected no relation before January, got %v", got)
+	}
+
+	// Schließen und wieder öffnen: Historie bleibt, neues Intervall
+	closeAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rel, err := store.CloseRelation("app1", "user1", "alice", "globex", "works_at", closeAt)
+	if err != nil {
+		t.Fatalf("CloseRelation failed: %v", err)
+	}
+	if rel.ValidTo == nil || !rel.ValidTo.Equal(closeAt) {
+		t.Errorf("expected valid_to %v, got %v", closeAt, rel.ValidTo)
+	}
+	if _, err := store.CloseRelation("app1", "user1", "alice", "globex", "works_at", closeAt); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound when closing twice, got %v", err)
+	}
+	if err := store.CreateOrUpdateRelation(newRel("globex", nil)); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	all, _ := store.GetRelations("app1", "user1", "alice")
+	if len(all) != 3 {
+		t.Fatalf("expected 3 intervals in history, got %d", len(all))
+	}
+	if got := at(time.Now()); len(got) != 1 || got[0] != "globex" {
+		t.Errorf("expected reopened globex now, got %v", got)
+	}
+	if got := at(closeAt.AddDate(0, 0, 1)); len(got) != 0 {
+		t.Errorf("expected gap after closing, got %v", got)
+	}
+}
+
+func TestEntitiesAndRelationsAreTenantScoped(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	for _, tenant := range [][2]string{{"app1", "alice"}, {"app1", "bob"}} {
+		ent := &models.Entity{AppID: tenant[0], ExternalUserID: tenant[1], Name: "user:me", Data: `{"owner":"` + tenant[1] + `"}`}
+		if err := store.CreateOrUpdateEntity(ent); err != nil {
+			t.Fatalf("CreateOrUpdateEntity(%v) failed: %v", tenant, err)
+		}
+		rel := &models.Relation{AppID: tenant[0], ExternalUserID: tenant[1], From: "user:me", To: "project:x", Type: "works_on"}
+		if err := store.CreateOrUpdateRelation(rel); err != nil {
+			t.Fatalf("CreateOrUpdateRelation(%v) failed: %v", tenant, err)
+		}
+	}
+
+	ent, err := store.GetEntity("app1", "alice", "user:me")
+	if err != nil {
+		t.Fatalf("GetEntity failed: %v", err)
+	}
+	if ent.Data != `{"owner":"alice"}` {
+		t.Errorf("expected alice's entity, got data %s", ent.Data)
+	}
+	if _, err := store.GetEntity("app2", "alice", "user:me"); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound for other app, got %v", err)
+	}
+
+	entities, _ := store.ListEntities("app1", "bob")
+	if len(entities) != 1 || entities[0].ExternalUserID != "bob" {
+		t.Errorf("expected only bob's entity, got %+v", entities)
+	}
+
+	relations, _ := store.GetRelations("app1", "alice", "user:me")
+	if len(relations) != 1 {
+		t.Fatalf("expected 1 relation for alice, got %d", len(relations))
+	}
+	if relations[0].ExternalUserID != "alice" {
+		t.Errorf("expected alice's relation, got tenant %s", relations[0].ExternalUserID)
+	}
+	if relations, _ := store.GetRelations("app1", "carol", ""); len(relations) != 0 {
+		t.Errorf("expected no relations for carol, got %d", len(relations))
+	}
+}
+
 func TestGetStats(t *testing.T) {
 	store := setupTestDB(t)
 	defer store.Close()
@@ -464,8 +591,8 @@ func TestGetStats(t *testing.T) {
 	// Create test data
 	store.CreateMemory(&models.Memory{Type: "semantic", Content: "Test", Importance: 5})
 	store.CreateMemory(&models.Memory{Type: "semantic", Content: "Test2", Importance: 5})
-	store.CreateOrUpdateEntity(&models.Entity{Name: "entity1", Data: "{}"})
-	store.CreateOrUpdateRelation(&models.Relation{From: "a", To: "b", Type: "test"})
+	store.CreateOrUpdateEntity(&models.Entity{AppID: "app1", ExternalUserID: "user1", Name: "entity1", Data: "{}"})
+	store.CreateOrUpdateRelation(&models.Relation{AppID: "app1", ExternalUserID: "user1", From: "a", To: "b", Type: "test"})
 
 	stats, err := store.GetStats()
 	if err != nil {
@@ -627,7 +754,7 @@ func TestGenerateEmbeddingForMemory(t *testing.T) {
 	}
 
 	// Verify embedding was generated
-	if mem.Embedding == "" {
+	if len(mem.Embedding) == 0 {
 		t.Error("embedding was not generated")
 	}
 
@@ -645,7 +772,7 @@ func TestGenerateEmbeddingForMemory(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetMemoryByIDAndTenant failed: %v", err)
 	}
-	if retrieved.Embedding == "" {
+	if len(retrieved.Embedding) == 0 {
 		t.Error("embedding was not saved to database")
 	}
 }
@@ -722,7 +849,7 @@ func TestSearchMemoriesByTenantSemanticAndBundle(t *testing.T) {
 	}
 	store.CreateBundle(bundle)
 	mem1.BundleID = &bundle.ID
-	store.db.Save(mem1)
+	store.UpdateMemory(mem1, "api")
 
 	bundleMemories, err := store.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "coffee", &bundle.ID, 10, nil, nil, false)
 	if err != nil {
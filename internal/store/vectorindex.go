@@ -0,0 +1,258 @@
+package store
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+
+	"cortex/internal/embeddings"
+	"cortex/internal/models"
+)
+
+// annMaxCandidates caps the number of ANN candidates fetched per query (SQLite "id IN ?" parameter budget).
+const annMaxCandidates = 8192
+
+// vectorIndexKey identifies one ANN index: a tenant plus a bundle (0 = memories without bundle) and the
+// embedding model, so vectors of different models never end up in the same graph.
+type vectorIndexKey struct {
+	appID          string
+	externalUserID string
+	bundleID       int64
+	model          string
+}
+
+type tenantKey struct {
+	appID          string
+	externalUserID string
+}
+
+// vectorIndexRegistry keeps one HNSW index per tenant and bundle. Tenants are loaded lazily from the
+// stored embeddings on first query and afterwards kept in sync by the CortexStore mutations.
+type vectorIndexRegistry struct {
+	mu      sync.Mutex
+	enabled bool
+	indexes map[vectorIndexKey]*embeddings.HNSWIndex
+	loaded  map[tenantKey]bool
+	members map[int64]vectorIndexKey // memory ID -> index it currently lives in
+}
+
+// newVectorIndexRegistry creates the registry. CORTEX_VECTOR_INDEX=off (or false/0) disables the ANN index
+// and keeps the exhaustive cosine scan.
+func newVectorIndexRegistry() *vectorIndexRegistry {
+	enabled := true
+	switch os.Getenv("CORTEX_VECTOR_INDEX") {
+	case "off", "false", "0":
+		enabled = false
+	}
+	return &vectorIndexRegistry{
+		enabled: enabled,
+		indexes: make(map[vectorIndexKey]*embeddings.HNSWIndex),
+		loaded:  make(map[tenantKey]bool),
+		members: make(map[int64]vectorIndexKey),
+	}
+}
+
+func indexKeyForMemory(mem *models.Memory) vectorIndexKey {
+	key := vectorIndexKey{appID: mem.AppID, externalUserID: mem.ExternalUserID, model: mem.EmbeddingModel}
+	if mem.BundleID != nil {
+		key.bundleID = *mem.BundleID
+	}
+	return key
+}
+
+// ensureTenantLoaded builds all bundle indexes of a tenant from the database (once).
+func (s *CortexStore) ensureTenantLoaded(appID, externalUserID string) error {
+	reg := s.vectorIndex
+	tk := tenantKey{appID, externalUserID}
+	if reg.loaded[tk] {
+		return nil
+	}
+	var rows []models.Memory
+	err := s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID).
+		Select("id", "app_id", "external_user_id", "bundle_id", "embedding", "embedding_model", "embedding_dim").
+		Where("length(embedding) > 0").
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		reg.addLocked(&rows[i])
+	}
+	reg.loaded[tk] = true
+	slog.Debug("vector index built", "appId", appID, "userId", externalUserID, "vectors", len(rows))
+	return nil
+}
+
+// addLocked (re)indexes a memory; memories without decodable embedding are only removed. Caller holds reg.mu.
+func (reg *vectorIndexRegistry) addLocked(mem *models.Memory) {
+	reg.removeLocked(mem.ID)
+	if len(mem.Embedding) == 0 {
+		return
+	}
+	vec, err := embeddings.DecodeVector(mem.Embedding)
+	if err != nil || len(vec) == 0 {
+		return
+	}
+	key := indexKeyForMemory(mem)
+	idx, ok := reg.indexes[key]
+	if !ok {
+		idx = embeddings.NewHNSWIndex(embeddings.DefaultHNSWConfig())
+		reg.indexes[key] = idx
+	}
+	if err := idx.Add(mem.ID, vec); err != nil {
+		slog.Warn("vector index: skipping memory", "memoryId", mem.ID, "error", err)
+		return
+	}
+	reg.members[mem.ID] = key
+}
+
+// removeLocked drops a memory from whichever index holds it. Caller holds reg.mu.
+func (reg *vectorIndexRegistry) removeLocked(id int64) {
+	key, ok := reg.members[id]
+	if !ok {
+		return
+	}
+	if idx := reg.indexes[key]; idx != nil {
+		idx.Remove(id)
+		if idx.Len() == 0 {
+			delete(reg.indexes, key)
+		}
+	}
+	delete(reg.members, id)
+}
+
+// indexMemory keeps the ANN index in sync after a memory was written. Tenants that were never
+// queried are skipped; they are built from the database on first use.
+func (s *CortexStore) indexMemory(mem *models.Memory) {
+	reg := s.vectorIndex
+	if !reg.enabled {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if !reg.loaded[tenantKey{mem.AppID, mem.ExternalUserID}] {
+		reg.removeLocked(mem.ID)
+		return
+	}
+	reg.addLocked(mem)
+}
+
+// unindexMemory removes a memory from the ANN index (e.g. after delete).
+func (s *CortexStore) unindexMemory(id int64) {
+	reg := s.vectorIndex
+	if !reg.enabled {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.removeLocked(id)
+}
+
+// invalidateVectorIndex drops all indexes so they are rebuilt lazily (used after bulk writes).
+func (s *CortexStore) invalidateVectorIndex() {
+	reg := s.vectorIndex
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.indexes = make(map[vectorIndexKey]*embeddings.HNSWIndex)
+	reg.loaded = make(map[tenantKey]bool)
+	reg.members = make(map[int64]vectorIndexKey)
+}
+
+// annCandidates returns up to k nearest memory IDs of a tenant (optionally restricted to a bundle),
+// merged across the tenant's bundle indexes. Only indexes of the query model (or of unknown model with
+// matching dimension) are searched. total is the number of indexed vectors searched.
+func (s *CortexStore) annCandidates(appID, externalUserID, model string, bundleID *int64, query []float32, k int) (hits []embeddings.SearchResult, total int, err error) {
+	reg := s.vectorIndex
+	reg.mu.Lock()
+	if err := s.ensureTenantLoaded(appID, externalUserID); err != nil {
+		reg.mu.Unlock()
+		return nil, 0, err
+	}
+	var targets []*embeddings.HNSWIndex
+	for key, idx := range reg.indexes {
+		if key.appID != appID || key.externalUserID != externalUserID {
+			continue
+		}
+		if bundleID != nil && key.bundleID != *bundleID {
+			continue
+		}
+		if (key.model != "" && model != "" && key.model != model) || idx.Dimension() != len(query) {
+			continue
+		}
+		targets = append(targets, idx)
+	}
+	reg.mu.Unlock()
+
+	for _, idx := range targets {
+		res, err := idx.Search(query, k)
+		if err != nil {
+			return nil, 0, err
+		}
+		hits = append(hits, res...)
+		total += idx.Len()
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Similarity > hits[j].Similarity })
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits, total, nil
+}
+
+// searchMemoriesANN performs top-k retrieval via the ANN index and applies status and metadata filters
+// on the candidates. The candidate pool is widened until limit results survive the filters or the
+// index is exhausted. ok=false means the caller should fall back to the exhaustive scan.
+func (s *CortexStore) searchMemoriesANN(appID, externalUserID string, query []float32, model string, bundleID *int64, limit int, metadataFilter map[string]any, includeArchived bool) (ranked []RankedMemory, ok bool, err error) {
+	if !s.vectorIndex.enabled || limit <= 0 {
+		return nil, false, nil
+	}
+	k := max(limit*4, 32)
+	for {
+		hits, total, err := s.annCandidates(appID, externalUserID, model, bundleID, query, k)
+		if err == embeddings.ErrDimensionMismatch {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if len(hits) == 0 {
+			return []RankedMemory{}, true, nil
+		}
+
+		ids := make([]int64, len(hits))
+		for i, h := range hits {
+			ids[i] = h.ID
+		}
+		var rows []models.Memory
+		dbQuery := s.applyTenantFilter(s.db.Model(&models.Memory{}), appID, externalUserID).Where("id IN ?", ids)
+		dbQuery = s.memoryStatusFilter(dbQuery, includeArchived)
+		if len(metadataFilter) > 0 {
+			dbQuery = s.applyOptionalFilters(dbQuery, map[string]interface{}{"metadataFilter": metadataFilter})
+		}
+		if err := dbQuery.Find(&rows).Error; err != nil {
+			return nil, false, err
+		}
+
+		byID := make(map[int64]models.Memory, len(rows))
+		for _, m := range rows {
+			byID[m.ID] = m
+		}
+		ranked = ranked[:0]
+		for _, h := range hits {
+			if m, found := byID[h.ID]; found {
+				ranked = append(ranked, RankedMemory{Memory: m, Score: h.Similarity, Similarity: h.Similarity})
+				if len(ranked) == limit {
+					return ranked, true, nil
+				}
+			}
+		}
+		if len(hits) >= total || k >= total {
+			return ranked, true, nil
+		}
+		if k >= annMaxCandidates {
+			// Filters are too selective for the candidate budget: use the exact scan instead.
+			return nil, false, nil
+		}
+		k = min(k*4, annMaxCandidates)
+	}
+}
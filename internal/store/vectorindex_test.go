@@ -0,0 +1,112 @@
+package store
+
+import (
+	"testing"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+func createMemoryWithEmbedding(t *testing.T, s *CortexStore, content string, bundleID *int64, metadata map[string]any) *models.Memory {
+	t.Helper()
+	mem := &models.Memory{
+		Type: "semantic", Content: content, AppID: "app1", ExternalUserID: "user1",
+		Importance: 5, BundleID: bundleID, Metadata: helpers.MarshalMetadata(metadata),
+	}
+	if err := s.CreateMemory(mem); err != nil {
+		t.Fatalf("CreateMemory failed: %v", err)
+	}
+	if err := s.GenerateEmbeddingForMemory(mem); err != nil {
+		t.Fatalf("GenerateEmbeddingForMemory failed: %v", err)
+	}
+	return mem
+}
+
+func containsMemory(memories []models.Memory, id int64) bool {
+	for _, m := range memories {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVectorIndexStaysInSync(t *testing.T) {
+	s := setupTestDB(t)
+	defer s.Close()
+
+	bundle := &models.Bundle{Name: "Getränke", AppID: "app1", ExternalUserID: "user1"}
+	s.CreateBundle(bundle)
+
+	coffee := createMemoryWithEmbedding(t, s, "User likes coffee and espresso", nil, map[string]any{"typ": "präferenz"})
+	tea := createMemoryWithEmbedding(t, s, "User prefers green tea", &bundle.ID, map[string]any{"typ": "notiz"})
+	createMemoryWithEmbedding(t, s, "Meeting with the team on Monday", nil, map[string]any{"typ": "arbeit"})
+
+	// First query builds the index from the database
+	res, err := s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "coffee", nil, 10, nil, nil, false)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(res) != 3 || res[0].ID != coffee.ID {
+		t.Fatalf("expected 3 results with coffee first, got %d", len(res))
+	}
+	if s.vectorIndex.enabled && !s.vectorIndex.loaded[tenantKey{"app1", "user1"}] {
+		t.Error("expected tenant index to be loaded after query")
+	}
+
+	// Create after load is picked up
+	latte := createMemoryWithEmbedding(t, s, "Oat milk latte every morning", &bundle.ID, map[string]any{"typ": "notiz"})
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "latte", &bundle.ID, 10, nil, nil, false)
+	if !containsMemory(res, latte.ID) || !containsMemory(res, tea.ID) || len(res) != 2 {
+		t.Errorf("bundle search: expected tea and latte, got %d results", len(res))
+	}
+
+	// Metadata filter is applied on top of ANN candidates
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "coffee", nil, 10, nil, map[string]any{"typ": "präferenz"}, false)
+	if len(res) != 1 || res[0].ID != coffee.ID {
+		t.Errorf("metadata filter: expected only coffee memory, got %d results", len(res))
+	}
+
+	// Moving a memory into the bundle via UpdateMemory updates the index
+	coffee.BundleID = &bundle.ID
+	if err := s.UpdateMemory(coffee, "api"); err != nil {
+		t.Fatalf("UpdateMemory failed: %v", err)
+	}
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "coffee", &bundle.ID, 10, nil, nil, false)
+	if !containsMemory(res, coffee.ID) {
+		t.Error("updated memory not found in bundle index")
+	}
+
+	// Delete removes it
+	if err := s.DeleteMemory(tea); err != nil {
+		t.Fatalf("DeleteMemory failed: %v", err)
+	}
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "tea", nil, 10, nil, nil, false)
+	if containsMemory(res, tea.ID) {
+		t.Error("deleted memory still returned")
+	}
+	if s.vectorIndex.enabled {
+		if _, ok := s.vectorIndex.members[tea.ID]; ok {
+			t.Error("deleted memory still in vector index")
+		}
+	}
+
+	// Merge archives the source: excluded by status filter, included with includeArchived
+	if err := s.MergeMemories(coffee.ID, latte.ID, "app1", "user1"); err != nil {
+		t.Fatalf("MergeMemories failed: %v", err)
+	}
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "latte", nil, 10, nil, nil, false)
+	if containsMemory(res, latte.ID) {
+		t.Error("archived (merged) memory returned without includeArchived")
+	}
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app1", "user1", "latte", nil, 10, nil, nil, true)
+	if !containsMemory(res, latte.ID) {
+		t.Error("archived (merged) memory missing with includeArchived")
+	}
+
+	// Other tenants never see these memories
+	res, _ = s.SearchMemoriesByTenantSemanticAndBundle("app2", "user2", "coffee", nil, 10, nil, nil, false)
+	if len(res) != 0 {
+		t.Errorf("expected no results for other tenant, got %d", len(res))
+	}
+}
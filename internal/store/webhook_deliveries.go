@@ -0,0 +1,115 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"cortex/internal/models"
+)
+
+// EnqueueWebhookDelivery stores a new pending delivery that is due immediately.
+func (s *CortexStore) EnqueueWebhookDelivery(d *models.WebhookDelivery) error {
+	now := time.Now()
+	d.Status = models.DeliveryStatusPending
+	if d.NextAttemptAt == nil {
+		d.NextAttemptAt = &now
+	}
+	return s.db.Create(d).Error
+}
+
+// ListDueWebhookDeliveries returns pending deliveries whose next attempt is due at now, oldest first.
+// Deliveries survive restarts: anything still pending (including attempts interrupted by a restart)
+// is picked up again by the next poll.
+func (s *CortexStore) ListDueWebhookDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := s.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryStatusPending, now).
+		Order("next_attempt_at ASC, id ASC").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// RecordWebhookDeliveryAttempt stores an attempt and the resulting delivery state (status, attempts,
+// next attempt, last error) in one transaction.
+func (s *CortexStore) RecordWebhookDeliveryAttempt(d *models.WebhookDelivery, attempt *models.WebhookDeliveryAttempt) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		attempt.DeliveryID = d.ID
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+		d.UpdatedAt = time.Now()
+		return tx.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).
+			Select("status", "attempts", "max_attempts", "next_attempt_at", "last_status_code", "last_error", "delivered_at", "updated_at").
+			Updates(d).Error
+	})
+}
+
+// PruneWebhookDeliveries deletes delivered and dead deliveries last updated before cutoff, together
+// with their attempts. Pending deliveries are kept. Returns the number of deleted deliveries.
+func (s *CortexStore) PruneWebhookDeliveries(cutoff time.Time) (int64, error) {
+	res := s.db.Where("status <> ? AND updated_at < ?", models.DeliveryStatusPending, cutoff).Delete(&models.WebhookDelivery{})
+	return res.RowsAffected, res.Error
+}
+
+// GetWebhookDelivery returns a delivery of the given webhook together with its attempt history.
+func (s *CortexStore) GetWebhookDelivery(webhookID, id int64) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	if err := s.db.Where("id = ? AND webhook_id = ?", id, webhookID).First(&d).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("delivery_id = ?", d.ID).Order("attempt ASC").Find(&d.History).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ListWebhookDeliveries returns the deliveries of a webhook (newest first, optionally only one status)
+// with their attempt history.
+func (s *CortexStore) ListWebhookDeliveries(webhookID int64, status string, limit int) ([]models.WebhookDelivery, error) {
+	deliveries := make([]models.WebhookDelivery, 0)
+	dbQuery := s.db.Where("webhook_id = ?", webhookID)
+	if status != "" {
+		dbQuery = dbQuery.Where("status = ?", status)
+	}
+	if err := dbQuery.Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	ids := make([]int64, len(deliveries))
+	byID := make(map[int64]*models.WebhookDelivery, len(deliveries))
+	for i := range deliveries {
+		ids[i] = deliveries[i].ID
+		byID[deliveries[i].ID] = &deliveries[i]
+	}
+	var attempts []models.WebhookDeliveryAttempt
+	if err := s.db.Where("delivery_id IN ?", ids).Order("delivery_id, attempt ASC").Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range attempts {
+		d := byID[a.DeliveryID]
+		d.History = append(d.History, a)
+	}
+	return deliveries, nil
+}
+
+// ResetWebhookDelivery makes a delivery (delivered or dead) pending again and due immediately.
+// extraAttempts is added to the attempts already made, so a dead letter gets a fresh retry budget;
+// the attempt history is kept.
+func (s *CortexStore) ResetWebhookDelivery(d *models.WebhookDelivery, extraAttempts int) error {
+	now := time.Now()
+	d.Status = models.DeliveryStatusPending
+	d.MaxAttempts = d.Attempts + extraAttempts
+	d.NextAttemptAt = &now
+	d.UpdatedAt = now
+	return s.db.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).
+		Updates(map[string]any{
+			"status":          d.Status,
+			"max_attempts":    d.MaxAttempts,
+			"next_attempt_at": d.NextAttemptAt,
+			"updated_at":      d.UpdatedAt,
+		}).Error
+}
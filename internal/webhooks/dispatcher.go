@@ -0,0 +1,348 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+// Queue defaults
+const (
+	DefaultMaxAttempts  = 8
+	DefaultBackoffBase  = 30 * time.Second
+	DefaultBackoffMax   = time.Hour
+	DefaultPollInterval = 5 * time.Second
+	DefaultTimeout      = 10 * time.Second
+	DefaultBatchSize    = 20
+	DefaultRetention    = 7 * 24 * time.Hour
+)
+
+// pruneInterval is how often finished deliveries older than Config.Retention are deleted.
+const pruneInterval = time.Hour
+
+// ErrNotRedeliverable is returned when a delivery is still pending (queued or retrying).
+var ErrNotRedeliverable = errors.New("delivery is still pending")
+
+// Config controls retries and polling of the delivery queue.
+type Config struct {
+	MaxAttempts  int           // attempts per delivery before it becomes a dead letter
+	BackoffBase  time.Duration // delay after the first failed attempt, doubled per further failure
+	BackoffMax   time.Duration // upper bound for the delay
+	PollInterval time.Duration // how often due deliveries are looked up
+	Timeout      time.Duration // HTTP timeout per attempt
+	BatchSize    int           // due deliveries sent per poll (in parallel)
+	Retention    time.Duration // how long delivered and dead deliveries are kept (0 = forever)
+}
+
+// DefaultConfig returns the default queue configuration.
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:  DefaultMaxAttempts,
+		BackoffBase:  DefaultBackoffBase,
+		BackoffMax:   DefaultBackoffMax,
+		PollInterval: DefaultPollInterval,
+		Timeout:      DefaultTimeout,
+		BatchSize:    DefaultBatchSize,
+		Retention:    DefaultRetention,
+	}
+}
+
+// ConfigFromEnv returns Config from environment variables.
+// CORTEX_WEBHOOK_MAX_ATTEMPTS=8, CORTEX_WEBHOOK_BACKOFF_BASE=30s, CORTEX_WEBHOOK_BACKOFF_MAX=1h,
+// CORTEX_WEBHOOK_POLL_INTERVAL=5s, CORTEX_WEBHOOK_TIMEOUT=10s, CORTEX_WEBHOOK_DELIVERY_RETENTION=168h
+func ConfigFromEnv() Config {
+	c := DefaultConfig()
+	if v := os.Getenv("CORTEX_WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			c.MaxAttempts = n
+		} else {
+			slog.Warn("invalid CORTEX_WEBHOOK_MAX_ATTEMPTS, using default", "value", v, "default", c.MaxAttempts)
+		}
+	}
+	for name, target := range map[string]*time.Duration{
+		"CORTEX_WEBHOOK_BACKOFF_BASE":  &c.BackoffBase,
+		"CORTEX_WEBHOOK_BACKOFF_MAX":   &c.BackoffMax,
+		"CORTEX_WEBHOOK_POLL_INTERVAL": &c.PollInterval,
+		"CORTEX_WEBHOOK_TIMEOUT":       &c.Timeout,
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			*target = d
+		} else {
+			slog.Warn("invalid webhook queue duration, using default", "env", name, "value", v, "default", *target)
+		}
+	}
+	// 0 behält abgeschlossene Zustellungen unbegrenzt
+	if v := os.Getenv("CORTEX_WEBHOOK_DELIVERY_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			c.Retention = d
+		} else {
+			slog.Warn("invalid CORTEX_WEBHOOK_DELIVERY_RETENTION, using default", "value", v, "default", c.Retention)
+		}
+	}
+	return c
+}
+
+// Backoff returns the delay before the next attempt after `attempt` failed attempts:
+// BackoffBase * 2^(attempt-1), capped at BackoffMax.
+func (c Config) Backoff(attempt int) time.Duration {
+	d := c.BackoffBase
+	for i := 1; i < attempt && d < c.BackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, c.BackoffMax)
+}
+
+// Dispatcher persists webhook deliveries and sends them with retries. Every event is stored as one
+// delivery per subscribed webhook before it is sent, so nothing is lost on restart; failed attempts are
+// retried with exponential backoff until MaxAttempts, then the delivery is kept as a dead letter.
+type Dispatcher struct {
+	store  *store.CortexStore
+	config Config
+	client *http.Client
+	now    func() time.Time
+	wake   chan struct{}
+}
+
+// NewDispatcher creates a dispatcher for the store. Call Run to start sending.
+func NewDispatcher(s *store.CortexStore, config Config) *Dispatcher {
+	return &Dispatcher{
+		store:  s,
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Config returns the queue configuration.
+func (d *Dispatcher) Config() Config {
+	return d.config
+}
+
+// Subscribed reports whether a webhook with the comma-separated event list (types or patterns like
+// memory.* and *) receives event.
+func Subscribed(events string, event EventType) bool {
+	for _, e := range strings.Split(events, ",") {
+		if matches(strings.TrimSpace(e), event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue stores a delivery of event for every active webhook subscribed to it and wakes the worker.
+func (d *Dispatcher) Enqueue(hooks []models.Webhook, event EventType, data map[string]interface{}) ([]models.WebhookDelivery, error) {
+	payloadJSON, err := json.Marshal(WebhookPayload{
+		Event:     string(event),
+		Timestamp: d.now().UTC().Format(time.RFC3339),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+	appID, _ := data["app_id"].(string)
+	externalUserID, _ := data["external_user_id"].(string)
+	return d.enqueue(hooks, event, appID, externalUserID, string(payloadJSON), 0)
+}
+
+// EnqueueEvent queues a logged event (see events.Bus) for every subscribed webhook; the delivery sends
+// the payload of the event log entry and references it by ID.
+func (d *Dispatcher) EnqueueEvent(hooks []models.Webhook, ev *models.Event) ([]models.WebhookDelivery, error) {
+	return d.enqueue(hooks, EventType(ev.Type), ev.AppID, ev.ExternalUserID, ev.Payload, ev.ID)
+}
+
+// enqueue stores the deliveries under the tenant of the event (appID, externalUserID), whose data key
+// seals the payload with field encryption.
+func (d *Dispatcher) enqueue(hooks []models.Webhook, event EventType, appID, externalUserID, payload string, eventID int64) ([]models.WebhookDelivery, error) {
+	var queued []models.WebhookDelivery
+	for _, wh := range hooks {
+		if !wh.Active || !Subscribed(wh.Events, event) {
+			continue
+		}
+		now := d.now()
+		delivery := models.WebhookDelivery{
+			WebhookID:      wh.ID,
+			EventID:        eventID,
+			AppID:          appID,
+			ExternalUserID: externalUserID,
+			Event:          string(event),
+			Payload:        payload,
+			MaxAttempts:    d.config.MaxAttempts,
+			NextAttemptAt:  &now,
+		}
+		if err := d.store.EnqueueWebhookDelivery(&delivery); err != nil {
+			return queued, err
+		}
+		queued = append(queued, delivery)
+	}
+	if len(queued) > 0 {
+		d.notify()
+	}
+	return queued, nil
+}
+
+// Redeliver queues a delivered or dead delivery again with a fresh retry budget.
+func (d *Dispatcher) Redeliver(webhookID, deliveryID int64) (*models.WebhookDelivery, error) {
+	delivery, err := d.store.GetWebhookDelivery(webhookID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.Status == models.DeliveryStatusPending {
+		return nil, ErrNotRedeliverable
+	}
+	if err := d.store.ResetWebhookDelivery(delivery, d.config.MaxAttempts); err != nil {
+		return nil, err
+	}
+	d.notify()
+	return delivery, nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries every PollInterval (and right after Enqueue/Redeliver) until ctx is done.
+// Once per pruneInterval it deletes finished deliveries older than Retention.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		if _, err := d.ProcessDue(ctx); err != nil {
+			slog.Error("webhook queue: failed to process deliveries", "error", err)
+		}
+		if d.config.Retention > 0 && d.now().Sub(lastPrune) >= pruneInterval {
+			if n, err := d.prune(); err != nil {
+				slog.Error("webhook queue: prune failed", "error", err)
+			} else if n > 0 {
+				slog.Info("webhook queue: pruned finished deliveries", "count", n, "retention", d.config.Retention)
+			}
+			lastPrune = d.now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// prune deletes delivered and dead deliveries (with their attempts) finished more than Retention ago.
+func (d *Dispatcher) prune() (int64, error) {
+	return d.store.PruneWebhookDeliveries(d.now().Add(-d.config.Retention))
+}
+
+// ProcessDue sends all deliveries due now (in batches of BatchSize) and returns how many were attempted.
+func (d *Dispatcher) ProcessDue(ctx context.Context) (int, error) {
+	processed := 0
+	seen := make(map[int64]bool)
+	for ctx.Err() == nil {
+		due, err := d.store.ListDueWebhookDeliveries(d.now(), d.config.BatchSize)
+		if err != nil {
+			return processed, err
+		}
+		// Bereits in diesem Lauf versuchte Zustellungen (z.B. Speichern fehlgeschlagen) erst beim nächsten Poll
+		fresh := due[:0]
+		for _, delivery := range due {
+			if !seen[delivery.ID] {
+				seen[delivery.ID] = true
+				fresh = append(fresh, delivery)
+			}
+		}
+		due = fresh
+		if len(due) == 0 {
+			break
+		}
+		var wg sync.WaitGroup
+		for i := range due {
+			wg.Add(1)
+			go func(delivery *models.WebhookDelivery) {
+				defer wg.Done()
+				d.attempt(ctx, delivery)
+			}(&due[i])
+		}
+		wg.Wait()
+		processed += len(due)
+	}
+	return processed, nil
+}
+
+// attempt sends one delivery once and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	record := models.WebhookDeliveryAttempt{Attempt: delivery.Attempts + 1}
+	start := d.now()
+
+	wh, err := d.store.GetWebhook(delivery.WebhookID)
+	switch {
+	case helpers.IsNotFoundError(err):
+		err = errors.New("webhook no longer exists")
+	case err == nil && !wh.Active:
+		err = errors.New("webhook is inactive")
+	case err == nil:
+		header := http.Header{}
+		header.Set("X-Cortex-Event", delivery.Event)
+		header.Set("X-Cortex-Delivery", strconv.FormatInt(delivery.ID, 10))
+		if delivery.EventID > 0 {
+			header.Set("X-Cortex-Event-Id", strconv.FormatInt(delivery.EventID, 10))
+		}
+		record.StatusCode, err = post(ctx, d.client, wh.URL, wh.Secret, []byte(delivery.Payload), header)
+	}
+	if ctx.Err() != nil && err != nil {
+		// Shutdown während des Versuchs: nicht als Fehlversuch zählen, bleibt fällig
+		return
+	}
+	record.DurationMs = d.now().Sub(start).Milliseconds()
+
+	now := d.now()
+	delivery.Attempts = record.Attempt
+	delivery.LastStatusCode = record.StatusCode
+	if err == nil {
+		delivery.Status = models.DeliveryStatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+	} else {
+		record.Error = err.Error()
+		delivery.LastError = record.Error
+		if delivery.Attempts >= delivery.MaxAttempts {
+			delivery.Status = models.DeliveryStatusDead
+			delivery.NextAttemptAt = nil
+		} else {
+			next := now.Add(d.config.Backoff(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+		}
+	}
+	if err := d.store.RecordWebhookDeliveryAttempt(delivery, &record); err != nil {
+		slog.Error("webhook queue: failed to record attempt", "deliveryId", delivery.ID, "error", err)
+		return
+	}
+
+	switch delivery.Status {
+	case models.DeliveryStatusDelivered:
+		slog.Debug("webhook delivered", "deliveryId", delivery.ID, "webhookId", delivery.WebhookID, "event", delivery.Event, "attempt", record.Attempt)
+	case models.DeliveryStatusDead:
+		slog.Warn("webhook delivery dead-lettered", "deliveryId", delivery.ID, "webhookId", delivery.WebhookID, "event", delivery.Event, "attempts", delivery.Attempts, "error", record.Error)
+	default:
+		slog.Warn("webhook delivery failed, retrying", "deliveryId", delivery.ID, "webhookId", delivery.WebhookID, "event", delivery.Event, "attempt", record.Attempt, "nextAttemptAt", delivery.NextAttemptAt, "error", record.Error)
+	}
+}
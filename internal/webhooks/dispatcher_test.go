@@ -0,0 +1,239 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cortex/internal/models"
+	"cortex/internal/store"
+)
+
+func setupDispatcher(t *testing.T, config Config) (*Dispatcher, *store.CortexStore, *time.Time) {
+	t.Helper()
+	s, err := store.NewCortexStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCortexStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	d := NewDispatcher(s, config)
+	clock := time.Now()
+	d.now = func() time.Time { return clock }
+	return d, s, &clock
+}
+
+func createHook(t *testing.T, s *store.CortexStore, url string) models.Webhook {
+	t.Helper()
+	wh := models.Webhook{URL: url, Events: "memory.created, memory.deleted", Secret: "s3cret", AppID: "app", Active: true}
+	if err := s.CreateWebhook(&wh); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	return wh
+}
+
+func TestBackoff(t *testing.T) {
+	c := Config{BackoffBase: time.Second, BackoffMax: 10 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 40: 10 * time.Second} {
+		if got := c.Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestDispatcherRetriesUntilDelivered(t *testing.T) {
+	var calls atomic.Int32
+	var lastBody []byte
+	var lastSignature, lastDelivery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lastBody, lastSignature, lastDelivery = body, r.Header.Get("X-Cortex-Signature"), r.Header.Get("X-Cortex-Delivery")
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	d, s, clock := setupDispatcher(t, Config{MaxAttempts: 5, BackoffBase: time.Minute, BackoffMax: time.Hour, BatchSize: 10, Timeout: time.Second})
+	wh := createHook(t, s, server.URL)
+	other := models.Webhook{URL: server.URL, Events: "bundle.created", AppID: "app", Active: true}
+	if err := s.CreateWebhook(&other); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	queued, err := d.Enqueue([]models.Webhook{wh, other}, EventMemoryCreated, map[string]interface{}{"id": 1})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if len(queued) != 1 {
+		t.Fatalf("expected 1 delivery (only subscribed hook), got %d", len(queued))
+	}
+	ctx := context.Background()
+
+	// 1. Versuch schlägt fehl, Retry erst nach Backoff fällig
+	if n, err := d.ProcessDue(ctx); err != nil || n != 1 {
+		t.Fatalf("ProcessDue = %d, %v", n, err)
+	}
+	if n, _ := d.ProcessDue(ctx); n != 0 {
+		t.Fatalf("retry must wait for backoff, processed %d", n)
+	}
+	got, err := s.GetWebhookDelivery(wh.ID, queued[0].ID)
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %v", err)
+	}
+	if got.Status != models.DeliveryStatusPending || got.Attempts != 1 || got.LastStatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected delivery after first attempt: %+v", got)
+	}
+	if want := clock.Add(time.Minute); got.NextAttemptAt == nil || !got.NextAttemptAt.Equal(want) {
+		t.Fatalf("next attempt = %v, want %v", got.NextAttemptAt, want)
+	}
+
+	// 2. Versuch (nach 1m) schlägt fehl, 3. (nach weiteren 2m) klappt
+	*clock = clock.Add(time.Minute)
+	d.ProcessDue(ctx)
+	*clock = clock.Add(2 * time.Minute)
+	d.ProcessDue(ctx)
+
+	got, _ = s.GetWebhookDelivery(wh.ID, queued[0].ID)
+	if got.Status != models.DeliveryStatusDelivered || got.Attempts != 3 || got.DeliveredAt == nil || got.LastError != "" {
+		t.Fatalf("expected delivered after 3 attempts, got %+v", got)
+	}
+	if len(got.History) != 3 || got.History[0].Error == "" || got.History[2].StatusCode != http.StatusOK {
+		t.Fatalf("unexpected attempt history: %+v", got.History)
+	}
+	if string(lastBody) != queued[0].Payload || !VerifySignature("s3cret", lastBody, lastSignature) {
+		t.Error("retry must send the stored payload with a valid signature")
+	}
+	if lastDelivery == "" {
+		t.Error("X-Cortex-Delivery header missing")
+	}
+}
+
+func TestDispatcherDeadLetterAndRedeliver(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d, s, clock := setupDispatcher(t, Config{MaxAttempts: 2, BackoffBase: time.Second, BackoffMax: time.Second, BatchSize: 10, Timeout: time.Second})
+	wh := createHook(t, s, server.URL)
+	queued, err := d.Enqueue([]models.Webhook{wh}, EventMemoryDeleted, map[string]interface{}{"id": 7})
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("Enqueue: %v (%d)", err, len(queued))
+	}
+	ctx := context.Background()
+	d.ProcessDue(ctx)
+	*clock = clock.Add(time.Second)
+	d.ProcessDue(ctx)
+
+	dead, err := s.ListWebhookDeliveries(wh.ID, models.DeliveryStatusDead, 10)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].NextAttemptAt != nil || len(dead[0].History) != 2 {
+		t.Fatalf("expected one dead letter with 2 attempts, got %+v", dead)
+	}
+	*clock = clock.Add(time.Hour)
+	if n, _ := d.ProcessDue(ctx); n != 0 {
+		t.Fatalf("dead letters must not be retried, processed %d", n)
+	}
+
+	if _, err := d.Redeliver(wh.ID, queued[0].ID+100); err == nil {
+		t.Error("expected error for unknown delivery")
+	}
+	healthy.Store(true)
+	if _, err := d.Redeliver(wh.ID, queued[0].ID); err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	if _, err := d.Redeliver(wh.ID, queued[0].ID); err != ErrNotRedeliverable {
+		t.Fatalf("expected ErrNotRedeliverable for pending delivery, got %v", err)
+	}
+	d.ProcessDue(ctx)
+
+	got, _ := s.GetWebhookDelivery(wh.ID, queued[0].ID)
+	if got.Status != models.DeliveryStatusDelivered || got.Attempts != 3 || got.MaxAttempts != 4 || len(got.History) != 3 {
+		t.Fatalf("expected redelivered delivery, got %+v", got)
+	}
+
+	// Webhook löschen entfernt Zustellungen samt Historie
+	if err := s.DeleteWebhook(wh.ID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if list, _ := s.ListWebhookDeliveries(wh.ID, "", 10); len(list) != 0 {
+		t.Fatalf("deliveries of deleted webhook still present: %d", len(list))
+	}
+}
+
+func TestDispatcherPrunesFinishedDeliveries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Cortex-Event") == string(EventMemoryDeleted) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d, s, clock := setupDispatcher(t, Config{MaxAttempts: 1, BackoffBase: time.Second, BackoffMax: time.Second, BatchSize: 10, Timeout: time.Second, Retention: time.Hour})
+	wh := createHook(t, s, server.URL)
+	d.Enqueue([]models.Webhook{wh}, EventMemoryCreated, map[string]interface{}{"id": 1, "content": "geheim"})
+	d.Enqueue([]models.Webhook{wh}, EventMemoryDeleted, map[string]interface{}{"id": 2})
+	ctx := context.Background()
+	d.ProcessDue(ctx)
+	// Noch offen: wird nie gelöscht
+	pending, _ := d.Enqueue([]models.Webhook{wh}, EventMemoryCreated, map[string]interface{}{"id": 3})
+
+	if n, err := d.prune(); err != nil || n != 0 {
+		t.Fatalf("deliveries within the retention must be kept, pruned %d (%v)", n, err)
+	}
+	*clock = clock.Add(2 * time.Hour)
+	if n, err := d.prune(); err != nil || n != 2 {
+		t.Fatalf("expected delivered and dead delivery to be pruned, got %d (%v)", n, err)
+	}
+	list, _ := s.ListWebhookDeliveries(wh.ID, "", 10)
+	if len(list) != 1 || list[0].ID != pending[0].ID {
+		t.Fatalf("only the pending delivery should remain, got %+v", list)
+	}
+}
+
+func TestDispatcherSurvivesRestart(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Cortex-Event")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	d, s, _ := setupDispatcher(t, DefaultConfig())
+	wh := createHook(t, s, server.URL)
+	if _, err := d.Enqueue([]models.Webhook{wh}, EventMemoryCreated, nil); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	// Neuer Dispatcher (wie nach Neustart) stellt die noch offene Zustellung zu
+	restarted := NewDispatcher(s, Config{MaxAttempts: 3, BackoffBase: time.Second, BackoffMax: time.Second, PollInterval: time.Hour, BatchSize: 5, Timeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go restarted.Run(ctx)
+
+	select {
+	case event := <-received:
+		if event != string(EventMemoryCreated) {
+			t.Errorf("X-Cortex-Event = %q", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pending delivery was not sent after restart")
+	}
+}
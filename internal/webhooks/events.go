@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"cortex/internal/events"
+	"cortex/internal/helpers"
+	"cortex/internal/models"
+)
+
+// EventInfo describes one entry of the event catalogue.
+type EventInfo struct {
+	Type        EventType `json:"type"`
+	Description string    `json:"description"`
+}
+
+// Catalogue lists every event a webhook can subscribe to.
+var Catalogue = []EventInfo{
+	{EventMemoryCreated, "Memory wurde erstellt"},
+	{EventMemoryUpdated, "Memory wurde geändert (changes: before/after je Feld)"},
+	{EventMemoryDeleted, "Memory wurde gelöscht (API oder Cleanup)"},
+	{EventMemoryMerged, "Memories wurden zusammengeführt (Ziel mit changes, sourceIds)"},
+	{EventMemoryArchived, "Memory wurde archiviert (reason: merged, low_importance)"},
+	{EventMemoryExpired, "Memory hat sein TTL erreicht und wurde archiviert"},
+	{EventBundleCreated, "Bundle wurde erstellt"},
+	{EventBundleDeleted, "Bundle wurde gelöscht"},
+	{EventContextCreated, "Agent-Context wurde erstellt"},
+	{EventImportCompleted, "Import eines Tenants ist abgeschlossen (Anzahlen)"},
+	{EventBackupRestored, "Datenbank wurde aus einem Backup wiederhergestellt"},
+}
+
+// Archive reasons (data.reason of memory.archived)
+const (
+	ArchiveReasonMerged        = "merged"
+	ArchiveReasonLowImportance = "low_importance"
+)
+
+// matches reports whether a subscription pattern selects event (see events.Match).
+func matches(pattern string, event EventType) bool {
+	return events.Match(pattern, string(event))
+}
+
+// ValidateEvents normalises subscription patterns and rejects patterns that match no catalogue event.
+func ValidateEvents(patterns []string) ([]string, error) {
+	out := make([]string, 0, len(patterns))
+	for _, e := range patterns {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		known := false
+		for _, info := range Catalogue {
+			if matches(e, info.Type) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q (see GET /webhooks/events)", e)
+		}
+		out = append(out, e)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("events are required")
+	}
+	return out, nil
+}
+
+// MemoryChanges returns the fields that differ between two states of a memory as
+// {field: {"before": ..., "after": ...}}. Metadata is compared and reported decoded.
+func MemoryChanges(before, after *models.Memory) map[string]any {
+	changes := make(map[string]any)
+	add := func(field string, b, a any) {
+		if !reflect.DeepEqual(b, a) {
+			changes[field] = map[string]any{"before": b, "after": a}
+		}
+	}
+	add("content", before.Content, after.Content)
+	add("type", before.Type, after.Type)
+	add("entity", before.Entity, after.Entity)
+	add("tags", before.Tags, after.Tags)
+	add("importance", before.Importance, after.Importance)
+	add("status", before.Status, after.Status)
+	add("metadata", helpers.UnmarshalMetadata(before.Metadata), helpers.UnmarshalMetadata(after.Metadata))
+	add("bundle_id", derefInt64(before.BundleID), derefInt64(after.BundleID))
+	return changes
+}
+
+func derefInt64(p *int64) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"testing"
+
+	"cortex/internal/models"
+)
+
+func TestSubscribedPatterns(t *testing.T) {
+	tests := []struct {
+		events string
+		event  EventType
+		want   bool
+	}{
+		{"memory.created,memory.deleted", EventMemoryDeleted, true},
+		{"memory.created", EventMemoryUpdated, false},
+		{"memory.*", EventMemoryMerged, true},
+		{"memory.*", EventContextCreated, false},
+		{" bundle.created , *", EventBackupRestored, true},
+	}
+	for _, tt := range tests {
+		if got := Subscribed(tt.events, tt.event); got != tt.want {
+			t.Errorf("Subscribed(%q, %s) = %v, want %v", tt.events, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	got, err := ValidateEvents([]string{" memory.updated", "context.*", "", "*"})
+	if err != nil {
+		t.Fatalf("ValidateEvents: %v", err)
+	}
+	if len(got) != 3 || got[0] != "memory.updated" {
+		t.Errorf("unexpected normalised events: %v", got)
+	}
+	for _, bad := range [][]string{{"memory.exploded"}, {"foo.*"}, {""}, nil} {
+		if _, err := ValidateEvents(bad); err == nil {
+			t.Errorf("ValidateEvents(%v) should fail", bad)
+		}
+	}
+	for _, info := range Catalogue {
+		if _, err := ValidateEvents([]string{string(info.Type)}); err != nil {
+			t.Errorf("catalogue event %s rejected: %v", info.Type, err)
+		}
+	}
+}
+
+func TestMemoryChanges(t *testing.T) {
+	bundle := int64(3)
+	before := &models.Memory{Content: "alt", Importance: 5, Tags: "a", Metadata: `{"k":"v"}`}
+	after := &models.Memory{Content: "neu", Importance: 5, Tags: "a", Metadata: `{"k":"w"}`, BundleID: &bundle}
+
+	changes := MemoryChanges(before, after)
+	if len(changes) != 3 {
+		t.Fatalf("expected content, metadata and bundle_id changes, got %v", changes)
+	}
+	content := changes["content"].(map[string]any)
+	if content["before"] != "alt" || content["after"] != "neu" {
+		t.Errorf("unexpected content diff: %v", content)
+	}
+	meta := changes["metadata"].(map[string]any)
+	if meta["after"].(map[string]any)["k"] != "w" {
+		t.Errorf("metadata diff should be decoded: %v", meta)
+	}
+	if _, ok := changes["importance"]; ok {
+		t.Error("unchanged importance must not be reported")
+	}
+	if len(MemoryChanges(after, after)) != 0 {
+		t.Error("identical memories must have no changes")
+	}
+}
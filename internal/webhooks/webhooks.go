@@ -2,6 +2,7 @@ package webhooks
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -17,10 +18,17 @@ import (
 type EventType string
 
 const (
-	EventMemoryCreated EventType = "memory.created"
-	EventMemoryDeleted EventType = "memory.deleted"
-	EventBundleCreated EventType = "bundle.created"
-	EventBundleDeleted EventType = "bundle.deleted"
+	EventMemoryCreated   EventType = "memory.created"
+	EventMemoryUpdated   EventType = "memory.updated"
+	EventMemoryDeleted   EventType = "memory.deleted"
+	EventMemoryMerged    EventType = "memory.merged"
+	EventMemoryArchived  EventType = "memory.archived"
+	EventMemoryExpired   EventType = "memory.expired"
+	EventBundleCreated   EventType = "bundle.created"
+	EventBundleDeleted   EventType = "bundle.deleted"
+	EventContextCreated  EventType = "context.created"
+	EventImportCompleted EventType = "import.completed"
+	EventBackupRestored  EventType = "backup.restored"
 )
 
 // WebhookPayload represents a webhook payload
@@ -50,29 +58,40 @@ func DeliverWebhook(config WebhookConfig, event EventType, data map[string]inter
 		return fmt.Errorf("failed to marshal webhook payload: %w", err)
 	}
 
-	// Create request
-	req, err := http.NewRequest("POST", config.URL, bytes.NewBuffer(payloadJSON))
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	status, err := post(context.Background(), client, config.URL, config.Secret, payloadJSON, nil)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("webhook delivered", "url", config.URL, "event", event, "status", status)
+	return nil
+}
+
+// post sends a signed JSON body to url. Returns the HTTP status (0 if no response was received)
+// and an error for transport failures and non-2xx answers.
+func post(ctx context.Context, client *http.Client, url, secret string, body []byte, header http.Header) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create webhook request: %w", err)
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "Cortex-Webhook/1.0")
-
-	// Sign payload if secret is provided
-	if config.Secret != "" {
-		signature := signPayload(config.Secret, payloadJSON)
-		req.Header.Set("X-Cortex-Signature", signature)
+	for k, v := range header {
+		req.Header[k] = v
 	}
 
-	// Send request with timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	// Sign payload if secret is provided
+	if secret != "" {
+		req.Header.Set("X-Cortex-Signature", signPayload(secret, body))
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to deliver webhook: %w", err)
+		return 0, fmt.Errorf("failed to deliver webhook: %w", err)
 	}
 	defer func() {
 		_, _ = io.Copy(io.Discard, resp.Body)
@@ -80,11 +99,9 @@ func DeliverWebhook(config WebhookConfig, event EventType, data map[string]inter
 	}()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("webhook delivery failed with status %d", resp.StatusCode)
+		return resp.StatusCode, fmt.Errorf("webhook delivery failed with status %d", resp.StatusCode)
 	}
-
-	slog.Debug("webhook delivered", "url", config.URL, "event", event, "status", resp.StatusCode)
-	return nil
+	return resp.StatusCode, nil
 }
 
 // signPayload creates HMAC-SHA256 signature of the payload
@@ -100,13 +117,14 @@ func VerifySignature(secret string, payload []byte, signature string) bool {
 	return hmac.Equal([]byte(signature), []byte(expectedSignature))
 }
 
-// DeliverWebhooksAsync delivers webhooks asynchronously
+// DeliverWebhooksAsync delivers webhooks asynchronously (one attempt, fire and forget).
+// The server uses the persistent Dispatcher queue instead.
 func DeliverWebhooksAsync(configs []WebhookConfig, event EventType, data map[string]interface{}) {
 	for _, config := range configs {
 		// Check if event is subscribed
 		subscribed := false
 		for _, e := range config.Events {
-			if e == event {
+			if matches(string(e), event) {
 				subscribed = true
 				break
 			}